@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hellais/ech/echclient"
+)
+
+// benchDomains is the sample of domains queried when none is given, mixing
+// hosts publishing ECH configs with popular ones that do not.
+var benchDomains = []string{
+	"crypto.cloudflare.com",
+	"cloudflare-ech.com",
+	"defo.ie",
+	"tls-ech.dev",
+	"google.com",
+	"wikipedia.org",
+	"github.com",
+	"example.com",
+}
+
+// runBench compares the latency, error rate and ECH answers of resolvers.
+func runBench(ctx context.Context, args []string) {
+	var (
+		pf      probeFlags
+		output  string
+		rounds  int
+		domains string
+	)
+	fs := flag.NewFlagSet("ech bench-resolvers", flag.ExitOnError)
+	pf.register(fs)
+	// --resolver lists the resolvers to benchmark, all the known ones by
+	// default.
+	resolverFlag := fs.Lookup("resolver")
+	resolverFlag.DefValue = strings.Join(echclient.KnownResolverNames(), ",")
+	resolverFlag.Value.Set(resolverFlag.DefValue)
+	fs.IntVar(&rounds, "rounds", 3, "number of times each domain is queried")
+	fs.StringVar(&domains, "domains", "", "file with one domain per line to query instead of the built-in sample")
+	fs.StringVar(&output, "output", "text", "output format: text or json")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: ech bench-resolvers [flags] [domain...]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if output != "text" && output != "json" {
+		fatal("invalid output format", "output", output)
+	}
+
+	sample := fs.Args()
+	if domains != "" {
+		var err error
+		if sample, err = readDomains(domains); err != nil {
+			fatal("failed to read domains", "file", domains, "error", err)
+		}
+	}
+	if len(sample) == 0 {
+		sample = benchDomains
+	}
+
+	cfg := pf.config()
+	results, err := cfg.Benchmark(ctx, sample, strings.Split(pf.resolver, ","), rounds)
+	if err != nil {
+		fatal("benchmark failed", "error", err)
+	}
+	if output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			fatal("failed to write output", "error", err)
+		}
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "RESOLVER\tQUERIES\tERRORS\tP50\tP90\tP99\tECH\tKEMS")
+	for _, r := range results {
+		kems := make([]string, 0, len(r.KEMs))
+		for _, kem := range slices.Sorted(maps.Keys(r.KEMs)) {
+			kems = append(kems, fmt.Sprintf("%s:%d", kem, r.KEMs[kem]))
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%.1f%%\t%s\t%s\t%s\t%d/%d\t%s\n", r.Resolver, r.Queries, 100*r.ErrorRate(),
+			r.P50.Round(time.Millisecond/10), r.P90.Round(time.Millisecond/10), r.P99.Round(time.Millisecond/10),
+			len(r.ECHDomains), len(sample), strings.Join(kems, " "))
+	}
+	tw.Flush()
+}
+
+// readDomains reads one domain per line from path, skipping blank lines and
+// # comments.
+func readDomains(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var domains []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		domains = append(domains, line)
+	}
+	return domains, scanner.Err()
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/hellais/ech/echclient"
+)
+
+// runConvert re-encodes an ECHConfigList between the formats of the TLS
+// stacks and tools listed in echclient.ECHConfigFormats, to move configs
+// between them when debugging interop.
+func runConvert(ctx context.Context, args []string) {
+	var (
+		from     string
+		to       string
+		configID int
+		inFile   string
+		outFile  string
+	)
+	formats := make([]string, len(echclient.ECHConfigFormats))
+	for i, f := range echclient.ECHConfigFormats {
+		formats[i] = string(f)
+	}
+	fs := flag.NewFlagSet("ech convert", flag.ExitOnError)
+	fs.StringVar(&from, "from", "auto", "input format: auto, "+strings.Join(formats, ", "))
+	fs.StringVar(&to, "to", "base64", "output format: "+strings.Join(formats, ", "))
+	fs.IntVar(&configID, "config-id", -1, "keep only the config with this config_id, as the boringssl format requires for lists of several")
+	fs.StringVar(&inFile, "in", "", "read the input from this file instead of the arguments or stdin")
+	fs.StringVar(&outFile, "out", "", "write the output to this file instead of stdout")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: ech convert [flags] [ECHConfigList]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	raw, err := echclient.DecodeECHConfigList([]byte(readInput(fs.Args(), inFile)), echclient.ECHConfigFormat(from))
+	if err != nil {
+		fatal("invalid ECHConfigList encoding", "format", from, "error", err)
+	}
+	if configID > 255 {
+		fatal("config_id must be between 0 and 255", "config_id", configID)
+	} else if configID >= 0 {
+		if raw, err = echclient.SelectECHConfig(raw, uint8(configID)); err != nil {
+			fatal("failed to select config", "error", err)
+		}
+	}
+	out, err := echclient.EncodeECHConfigList(raw, echclient.ECHConfigFormat(to))
+	if err != nil {
+		fatal("failed to encode ECHConfigList", "format", to, "error", err)
+	}
+	if outFile == "" {
+		os.Stdout.Write(out)
+		return
+	}
+	if err := os.WriteFile(outFile, out, 0o644); err != nil {
+		fatal("failed to write output", "error", err)
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/hellais/ech/echclient"
+)
+
+// decoded is the JSON view of a record printed by runDecode, using the
+// field names of echclient.ProbeResult.
+type decoded struct {
+	Name          string                    `json:"name,omitempty"`
+	HTTPSRecord   *echclient.HttpsRecord    `json:"https_record"`
+	ECHConfigs    []echclient.ECHConfigInfo `json:"ech_configs,omitempty"`
+	ParseWarnings []string                  `json:"parse_warnings,omitempty"`
+}
+
+// decodeFormats are the input formats accepted by runDecode.
+var decodeFormats = []string{"auto", "presentation", "generic", "hex", "base64"}
+
+// runDecode parses HTTPS records, or with --ech-config-list an
+// ECHConfigList, given as arguments, in a file or on stdin and prints their
+// structured view, without any network access.
+func runDecode(ctx context.Context, args []string) {
+	var (
+		presentation bool
+		configList   bool
+		jsonOutput   bool
+		format       string
+		inFile       string
+		strict       bool
+	)
+	fs := flag.NewFlagSet("ech decode", flag.ExitOnError)
+	fs.StringVar(&format, "format", "auto", "input format: "+strings.Join(decodeFormats, ", ")+`; auto also accepts dig output lines`)
+	fs.BoolVar(&presentation, "presentation", false, `same as --format=presentation, e.g. 1 . alpn="h2" ech=AEX...`)
+	fs.BoolVar(&strict, "strict", false, "fail on any specification violation instead of reporting it in parse_warnings")
+	fs.BoolVar(&configList, "ech-config-list", false, "decode an ECHConfigList in base64, hex or PEM instead of HTTPS records")
+	fs.BoolVar(&jsonOutput, "json", false, "print the ECHConfigList as JSON; records are always printed as JSON")
+	fs.StringVar(&inFile, "in", "", "read the input from this file instead of the arguments or stdin")
+	fs.Parse(args)
+	if presentation {
+		format = "presentation"
+	}
+
+	input := readInput(fs.Args(), inFile)
+	if configList {
+		decodeConfigList(input, jsonOutput, strict)
+		return
+	}
+	records, err := decodeInput(input, format)
+	if err != nil {
+		fatal("failed to parse record", "error", err)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	for _, r := range records {
+		r.ParseWarnings = r.HTTPSRecord.Violations()
+		if raw := r.HTTPSRecord.ECHConfigList(); len(raw) > 0 {
+			configs, err := echclient.ParseECHConfigList(raw)
+			if err != nil {
+				fatal("failed to parse ECHConfigList", "name", r.Name, "error", err)
+			}
+			r.ParseWarnings = append(r.ParseWarnings, configs.Violations()...)
+			for i := range configs {
+				r.ECHConfigs = append(r.ECHConfigs, echclient.NewECHConfigInfo(&configs[i]))
+			}
+		}
+		if strict && len(r.ParseWarnings) > 0 {
+			fatal("record violates the specification", "name", r.Name, "violations", strings.Join(r.ParseWarnings, "; "))
+		}
+		if err := enc.Encode(r); err != nil {
+			fatal("failed to write output", "error", err)
+		}
+	}
+}
+
+// readInput returns the contents of inFile if set, else the arguments
+// joined by spaces or, without any, stdin.
+func readInput(args []string, inFile string) string {
+	if inFile != "" {
+		data, err := os.ReadFile(inFile)
+		if err != nil {
+			fatal("failed to read input", "error", err)
+		}
+		return string(data)
+	}
+	if len(args) > 0 {
+		return strings.Join(args, " ")
+	}
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		fatal("failed to read stdin", "error", err)
+	}
+	return string(data)
+}
+
+// decodeInput parses the records of input. In the auto format, the HTTPS
+// and SVCB lines of dig output or a zone file are decoded one by one;
+// anything else is taken as the RDATA of a single record.
+func decodeInput(input, format string) ([]decoded, error) {
+	if format == "auto" {
+		var records []decoded
+		for _, line := range strings.Split(input, "\n") {
+			name, rdata, ok := rrLine(line)
+			if !ok {
+				continue
+			}
+			record, err := decodeRData(rdata, format)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			records = append(records, decoded{Name: name, HTTPSRecord: record})
+		}
+		if len(records) > 0 {
+			return records, nil
+		}
+	}
+	record, err := decodeRData(input, format)
+	if err != nil {
+		return nil, err
+	}
+	return []decoded{{HTTPSRecord: record}}, nil
+}
+
+// rrLine splits a line such as "example.com. 300 IN HTTPS 1 . alpn=h2" into
+// the owner name and the RDATA. The types may also be given in their RFC 3597
+// TYPE65 and TYPE64 forms.
+func rrLine(line string) (name, rdata string, ok bool) {
+	line, _, _ = strings.Cut(line, ";")
+	fields := strings.Fields(line)
+	for i := 1; i < len(fields) && i <= 3; i++ {
+		switch fields[i] {
+		case "HTTPS", "SVCB", "TYPE65", "TYPE64":
+			return fields[0], strings.Join(fields[i+1:], " "), true
+		}
+	}
+	return "", "", false
+}
+
+// decodeRData parses the RDATA of an HTTPS or SVCB record in the given
+// format, guessing it in the auto format.
+func decodeRData(s, format string) (*echclient.HttpsRecord, error) {
+	s = strings.TrimSpace(s)
+	compact := strings.Join(strings.Fields(s), "")
+	if format == "auto" {
+		_, hexErr := hex.DecodeString(compact)
+		switch {
+		case strings.HasPrefix(s, `\#`):
+			format = "generic"
+		case hexErr == nil:
+			format = "hex"
+		case len(strings.Fields(s)) > 1:
+			format = "presentation"
+		default:
+			format = "base64"
+		}
+	}
+	var (
+		rdata []byte
+		err   error
+	)
+	switch format {
+	case "presentation":
+		return echclient.ParseHttpsPresentation(s)
+	case "generic":
+		fields := strings.Fields(s)
+		if len(fields) < 2 || fields[0] != `\#` {
+			return nil, fmt.Errorf("expected \\# len hex, got %q", s)
+		}
+		rdata, err = hex.DecodeString(strings.Join(fields[2:], ""))
+		if err == nil && strconv.Itoa(len(rdata)) != fields[1] {
+			err = fmt.Errorf("length %s does not match %d bytes of data", fields[1], len(rdata))
+		}
+	case "hex":
+		rdata, err = hex.DecodeString(compact)
+	case "base64":
+		rdata, err = base64.StdEncoding.DecodeString(compact)
+		if err != nil {
+			rdata, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(compact, "="))
+		}
+	default:
+		return nil, errors.New("invalid format " + format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return echclient.ParseHttpsRecord(rdata)
+}
+
+// decodeConfigList prints the ECHConfigs of the ECHConfigList in input,
+// given in base64, as Firefox and DNS tools show it, hex or as the
+// ECHCONFIG PEM block of a key file.
+func decodeConfigList(input string, jsonOutput, strict bool) {
+	raw, err := configListBytes(input)
+	if err != nil {
+		fatal("invalid ECHConfigList encoding", "error", err)
+	}
+	configs, err := echclient.ParseECHConfigList(raw)
+	if err != nil {
+		fatal("failed to parse ECHConfigList", "error", err)
+	}
+	warnings := configs.Violations()
+	if strict && len(warnings) > 0 {
+		fatal("ECHConfigList violates the specification", "violations", strings.Join(warnings, "; "))
+	}
+	infos := make([]echclient.ECHConfigInfo, len(configs))
+	for i := range configs {
+		infos[i] = echclient.NewECHConfigInfo(&configs[i])
+	}
+	if jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err := enc.Encode(struct {
+			ECHConfigs    []echclient.ECHConfigInfo `json:"ech_configs"`
+			ParseWarnings []string                  `json:"parse_warnings,omitempty"`
+		}{infos, warnings})
+		if err != nil {
+			fatal("failed to write output", "error", err)
+		}
+		return
+	}
+	for i, info := range infos {
+		if i > 0 {
+			fmt.Println()
+		}
+		printECHConfig(info)
+	}
+	for _, w := range warnings {
+		fmt.Printf("warning: %s\n", w)
+	}
+}
+
+// configListBytes decodes an ECHConfigList given in any of the formats
+// detected by echclient.DecodeECHConfigList.
+func configListBytes(input string) ([]byte, error) {
+	return echclient.DecodeECHConfigList([]byte(input), echclient.ECHConfigFormatAuto)
+}
+
+// printECHConfig prints the fields of an ECHConfig one per line, with the
+// names of its HPKE algorithms.
+func printECHConfig(info echclient.ECHConfigInfo) {
+	suites := make([]string, len(info.CipherSuites))
+	for i, cs := range info.CipherSuites {
+		suites[i] = cs.String()
+	}
+	fmt.Printf("version:             0x%04x (%s)\n", info.Version, info.Draft)
+	fmt.Printf("config_id:           %d\n", info.ConfigID)
+	if info.PostQuantum {
+		fmt.Printf("kem:                 %s (0x%04x, post-quantum)\n", info.KEM, info.KemID)
+	} else {
+		fmt.Printf("kem:                 %s (0x%04x)\n", info.KEM, info.KemID)
+	}
+	fmt.Printf("public_key:          %x\n", info.PublicKey)
+	fmt.Printf("cipher_suites:       %s\n", strings.Join(suites, ", "))
+	fmt.Printf("maximum_name_length: %d\n", info.MaxNameLength)
+	fmt.Printf("public_name:         %s\n", info.PublicName)
+	if len(info.Extensions) == 0 {
+		fmt.Printf("extensions:          none\n")
+	}
+	for _, ext := range info.Extensions {
+		// The high bit marks extensions the client must support.
+		mandatory := ""
+		if ext.Type&0x8000 != 0 {
+			mandatory = " mandatory"
+		}
+		fmt.Printf("extension:           0x%04x%s %x\n", ext.Type, mandatory, ext.Data)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"time"
+
+	"github.com/hellais/ech/echclient"
+)
+
+// logHooks returns hooks that report every probe step to the default logger.
+func logHooks() *echclient.Hooks {
+	return &echclient.Hooks{
+		OnDNSLookup: func(name string, qtype echclient.RRType, resp *echclient.DNSResponse, err error, rtt time.Duration) {
+			if err != nil {
+				slog.Warn("dns lookup failed", "name", name, "type", qtype, "rtt", rtt, "error", err)
+				return
+			}
+			slog.Debug("dns lookup", "name", name, "type", qtype, "rtt", rtt, "answers", len(resp.Answer),
+				"resolver", resp.Resolver, "transport", echclient.ResolverTransport(resp.Resolver))
+		},
+		OnHTTPSRecordParsed: func(name string, record *echclient.HttpsRecord) {
+			params := make([]string, len(record.Params))
+			for i, p := range record.Params {
+				params[i] = p.String()
+			}
+			slog.Info("https record", "name", name, "priority", record.Priority, "target", record.TargetName, "params", params)
+		},
+		OnECHConfigSelected: func(name string, config *echclient.ECHConfig) {
+			slog.Info("selected ech config", "name", name, "config_id", config.ConfigID, "public_name", string(config.PublicName))
+		},
+		OnTLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			if err != nil {
+				slog.Warn("tls handshake failed", "error", err)
+				return
+			}
+			slog.Info("tls handshake done",
+				"ech_accepted", state.ECHAccepted,
+				"version", tls.VersionName(state.Version),
+				"cipher_suite", tls.CipherSuiteName(state.CipherSuite),
+				"alpn", state.NegotiatedProtocol)
+		},
+		OnECHRejected: func(err *tls.ECHRejectionError) {
+			slog.Warn("server rejected ech", "retry_config_list_len", len(err.RetryConfigList))
+		},
+	}
+}
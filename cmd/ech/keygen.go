@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/hellais/ech/echclient"
+)
+
+// runKeygen generates an ECH key pair and prints the private key and the
+// ECHConfigList to publish.
+func runKeygen(ctx context.Context, args []string) {
+	var (
+		publicName string
+		configID   int
+		kem        string
+		format     string
+		outFile    string
+	)
+	fs := flag.NewFlagSet("ech keygen", flag.ExitOnError)
+	fs.StringVar(&publicName, "public-name", "", "public_name of the client-facing server (required)")
+	fs.IntVar(&configID, "config-id", -1, "config_id to use (default: random)")
+	fs.StringVar(&kem, "kem", "x25519", "HPKE KEM: x25519, p256, p384 or p521")
+	fs.StringVar(&format, "format", "pem", "output format: pem or raw")
+	fs.StringVar(&outFile, "out", "", "write the PEM key file here instead of stdout")
+	fs.Parse(args)
+
+	if publicName == "" {
+		fatal("--public-name is required")
+	}
+	kemID, err := echclient.ParseKEM(kem)
+	if err != nil {
+		fatal("invalid KEM", "error", err)
+	}
+	if configID < 0 {
+		var b [1]byte
+		if _, err := rand.Read(b[:]); err != nil {
+			fatal("failed to generate config_id", "error", err)
+		}
+		configID = int(b[0])
+	} else if configID > 255 {
+		fatal("config_id must be between 0 and 255", "config_id", configID)
+	}
+
+	key, err := echclient.GenerateECHKey(uint8(configID), kemID, publicName)
+	if err != nil {
+		fatal("failed to generate key", "error", err)
+	}
+	configList, err := echclient.ECHConfigList{key.Config}.Marshal()
+	if err != nil {
+		fatal("failed to encode ECHConfigList", "error", err)
+	}
+
+	switch format {
+	case "raw":
+		fmt.Printf("private_key=%s\n", base64.StdEncoding.EncodeToString(key.PrivateKey.Bytes()))
+		fmt.Printf("ech_config_list=%s\n", base64.StdEncoding.EncodeToString(configList))
+	case "pem":
+		der, err := x509.MarshalPKCS8PrivateKey(key.PrivateKey)
+		if err != nil {
+			fatal("failed to encode private key", "error", err)
+		}
+		// Same layout as the ECH key files used by OpenSSL.
+		out := append(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}),
+			pem.EncodeToMemory(&pem.Block{Type: "ECHCONFIG", Bytes: configList})...)
+		if outFile == "" {
+			os.Stdout.Write(out)
+			return
+		}
+		if err := os.WriteFile(outFile, out, 0o600); err != nil {
+			fatal("failed to write key file", "error", err)
+		}
+		fmt.Printf("%s\n", base64.StdEncoding.EncodeToString(configList))
+	default:
+		fatal("invalid format", "format", format)
+	}
+}
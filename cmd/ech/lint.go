@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+
+	"github.com/hellais/ech/echclient"
+)
+
+// runLint checks an ECHConfigList given in base64, hex or PEM and prints
+// what should be fixed before publishing it, including with
+// --check-public-name the reachability and certificate of the client-facing
+// servers. It exits with status 1 when any finding is an error, so that it
+// can gate deployments.
+func runLint(ctx context.Context, args []string) {
+	var (
+		pf         probeFlags
+		jsonOutput bool
+		inFile     string
+		checkNames bool
+	)
+	fs := flag.NewFlagSet("ech lint", flag.ExitOnError)
+	pf.register(fs)
+	fs.BoolVar(&checkNames, "check-public-name", false, "check that each public_name resolves and that its server presents a valid certificate for it")
+	fs.BoolVar(&jsonOutput, "json", false, "print the findings as JSON")
+	fs.StringVar(&inFile, "in", "", "read the ECHConfigList from this file instead of the arguments or stdin")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: ech lint [flags] [ECHConfigList]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	raw, err := configListBytes(readInput(fs.Args(), inFile))
+	if err != nil {
+		fatal("invalid ECHConfigList encoding", "error", err)
+	}
+	findings, err := echclient.LintECHConfigList(raw)
+	if err != nil {
+		fatal("failed to parse ECHConfigList", "error", err)
+	}
+	if checkNames {
+		cfg := pf.config()
+		online, err := cfg.LintPublicNames(ctx, raw)
+		pf.saveState(cfg)
+		if err != nil {
+			fatal("failed to check the public names", "error", err)
+		}
+		findings = append(findings, online...)
+	}
+	if jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(struct {
+			Findings []echclient.LintFinding `json:"findings"`
+		}{findings}); err != nil {
+			fatal("failed to write output", "error", err)
+		}
+	} else {
+		for _, f := range findings {
+			fmt.Println(f)
+		}
+		if len(findings) == 0 {
+			slog.Info("no problems found")
+		}
+	}
+	if slices.ContainsFunc(findings, func(f echclient.LintFinding) bool {
+		return f.Severity == echclient.LintError
+	}) {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+)
+
+// commands maps subcommand names to their entry points. Without a known
+// subcommand the arguments are handled by runProbe.
+var commands = map[string]func(ctx context.Context, args []string){
+	"bench-resolvers": runBench,
+	"convert":         runConvert,
+	"decode":          runDecode,
+	"keygen":          runKeygen,
+	"lint":            runLint,
+	"monitor":         runMonitor,
+	"publish":         runPublish,
+	"query":           runQuery,
+	"suites":          runSuites,
+	"xcheck":          runXCheck,
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			cmd(ctx, os.Args[2:])
+			return
+		}
+	}
+	runProbe(ctx, os.Args[1:])
+}
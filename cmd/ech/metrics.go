@@ -0,0 +1,26 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/hellais/ech/echclient"
+	"github.com/hellais/ech/metrics/prommetrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// serveMetrics exposes Prometheus metrics on addr under /metrics and returns
+// the echclient.Metrics feeding them.
+func serveMetrics(addr string) echclient.Metrics {
+	reg := prometheus.NewRegistry()
+	m := prommetrics.New(reg)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			slog.Error("metrics server failed", "addr", addr, "error", err)
+		}
+	}()
+	return m
+}
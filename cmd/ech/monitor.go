@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hellais/ech/echclient"
+)
+
+// runMonitor probes a host periodically and reports the rotations of its
+// ECHConfigList and the handshakes that stop accepting ECH.
+func runMonitor(ctx context.Context, args []string) {
+	var (
+		pf       probeFlags
+		interval time.Duration
+		output   string
+	)
+	fs := flag.NewFlagSet("ech monitor", flag.ExitOnError)
+	pf.register(fs)
+	fs.DurationVar(&interval, "interval", 10*time.Minute, "time between probes")
+	fs.StringVar(&output, "output", "text", "output format: text, or json for one event per line")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: ech monitor [flags] host|url\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 || interval <= 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if output != "text" && output != "json" {
+		fatal("invalid output format", "output", output)
+	}
+	targetURL := fs.Arg(0)
+	if !strings.Contains(targetURL, "://") {
+		targetURL = "https://" + targetURL + "/"
+	}
+
+	cfg := pf.config()
+	enc := json.NewEncoder(os.Stdout)
+	cfg.Monitor(ctx, targetURL, interval, func(e echclient.MonitorEvent) {
+		pf.saveState(cfg)
+		if output == "json" {
+			if err := enc.Encode(e); err != nil {
+				fatal("failed to write output", "error", err)
+			}
+			return
+		}
+		logMonitorEvent(e)
+	})
+	pf.saveState(cfg)
+}
+
+// logMonitorEvent logs e, as a warning or an error when it calls for
+// attention.
+func logMonitorEvent(e echclient.MonitorEvent) {
+	args := []any{"url", e.URL, "config_ids", e.ConfigIDs}
+	if e.Error != "" {
+		args = append(args, "error", e.Error)
+	}
+	switch e.Kind {
+	case echclient.MonitorStarted:
+		slog.Info("monitoring", append(args, "ech_accepted", e.ECHAccepted)...)
+	case echclient.MonitorChanged:
+		slog.Warn("ECHConfigList changed", append(args, "changes", e.Changes)...)
+	case echclient.MonitorDisappeared:
+		slog.Error("ECHConfigList disappeared", args...)
+	case echclient.MonitorAppeared:
+		slog.Info("ECHConfigList appeared", args...)
+	case echclient.MonitorRejected:
+		slog.Error("ECH is no longer accepted", args...)
+	case echclient.MonitorAccepted:
+		slog.Info("ECH is accepted again", args...)
+	}
+}
@@ -0,0 +1,568 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/hellais/ech/echclient"
+	"github.com/hellais/ech/sink"
+)
+
+// tlsEngines are the TLS engines selected by --fingerprint, registered by
+// the files built with optional dependencies.
+var tlsEngines = map[string]echclient.TLSEngine{}
+
+// probeFlags are the flags configuring an echclient.ProbeConfig, shared by
+// every subcommand performing lookups or probes.
+type probeFlags struct {
+	resolver    string
+	raceDNS     bool
+	odohTarget  string
+	odohProxy   string
+	odohConfig  string
+	dohMethod   string
+	proxyUrl    string
+	timeout     time.Duration
+	dnsTimeout  time.Duration
+	dnsRetries  int
+	dnsBackoff  time.Duration
+	insecure    bool
+	clientCert  string
+	clientKey   string
+	pins        [][]byte
+	tlsMin      string
+	tlsMax      string
+	ciphers     string
+	verbose     bool
+	logLevel    string
+	logFormat   string
+	metricsAddr string
+	echMode     string
+	echConfig   string
+	fallback    string
+	endpoint    int
+	shuffle     bool
+	aliasDepth  int
+	source      string
+	noECHRetry  bool
+	eachConfig  bool
+	padding     bool
+	handshake   bool
+	dumpHello   bool
+	fingerprint string
+	alpn        string
+	strict      bool
+	mergeECH    bool
+	preferKEM   string
+	preferAEAD  string
+	dnssec      bool
+	dns0x20     bool
+	resolveIPs  bool
+	useHints    bool
+	port        uint
+	connectTo   string
+	compareAuth bool
+	noCache     bool
+	cacheFile   string
+	resume      bool
+	earlyData   bool
+	sessionFile string
+	pinFile     string
+	pinWarn     bool
+}
+
+func (f *probeFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.resolver, "resolver", echclient.DefaultResolverURL,
+		"comma separated resolvers (https://, tls://, quic://, udp:// or tcp:// URLs) used for HTTPS RR lookups, tried in order; short names: "+strings.Join(echclient.KnownResolverNames(), ", ")+", system for the OS resolvers or ddr for their designated encrypted resolvers")
+	fs.BoolVar(&f.raceDNS, "race-resolvers", false, "query all the resolvers at once and use the first answer")
+	fs.StringVar(&f.odohTarget, "odoh-target", "", "use Oblivious DoH with this target host or URL instead of --resolver")
+	fs.StringVar(&f.odohProxy, "odoh-proxy", "", "oblivious proxy URL relaying the --odoh-target queries")
+	fs.StringVar(&f.odohConfig, "odoh-config", "", "file holding the ObliviousDoHConfigs of --odoh-target, fetched beforehand from its "+echclient.ODoHConfigPath+", so that the target never sees the client address")
+	fs.StringVar(&f.dohMethod, "doh-method", "", "DoH encoding: json, post or get (RFC 8484 wire format); defaults to json or the known resolver's")
+	fs.StringVar(&f.proxyUrl, "proxy", "", "proxy URL used for DoH queries and the probe request")
+	fs.DurationVar(&f.timeout, "timeout", 30*time.Second, "timeout for the probe request")
+	fs.DurationVar(&f.dnsTimeout, "dns-timeout", 10*time.Second, "timeout for each DNS query attempt")
+	fs.IntVar(&f.dnsRetries, "dns-retries", 0, "retry DNS queries failing with a transport error or SERVFAIL this many times")
+	fs.DurationVar(&f.dnsBackoff, "dns-backoff", echclient.DefaultDNSBackoff, "delay before the first DNS retry, doubled for each following one")
+	fs.BoolVar(&f.insecure, "insecure", false, "skip verification of the server certificate")
+	fs.StringVar(&f.clientCert, "client-cert", "", "PEM file of the client certificate chain presented to servers requesting one (mutual TLS)")
+	fs.StringVar(&f.clientKey, "client-key", "", "PEM file of the private key of --client-cert; defaults to --client-cert")
+	fs.Func("pin-sha256", "base64 SHA-256 digest of a SubjectPublicKeyInfo a certificate of the chain must have, as shown by the probe output; repeatable, any pin matching is enough", func(s string) error {
+		pin, err := base64.StdEncoding.DecodeString(s)
+		if err != nil || len(pin) != sha256.Size {
+			return errors.New("not a base64 SHA-256 digest")
+		}
+		f.pins = append(f.pins, pin)
+		return nil
+	})
+	fs.StringVar(&f.tlsMin, "tls-min", "", "minimum TLS version offered: 1.0, 1.1, 1.2 or 1.3; ECH requires 1.3")
+	fs.StringVar(&f.tlsMax, "tls-max", "", "maximum TLS version offered: 1.0, 1.1, 1.2 or 1.3")
+	fs.StringVar(&f.ciphers, "ciphers", "", "comma separated TLS 1.0-1.2 cipher suites offered, by IANA name (e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256) or 0x hex id; crypto/tls does not allow choosing TLS 1.3 suites")
+	fs.BoolVar(&f.verbose, "v", false, "log intermediate lookup results (same as --log-level debug)")
+	fs.StringVar(&f.logLevel, "log-level", "info", "log level: debug, info, warn or error")
+	fs.StringVar(&f.logFormat, "log-format", "text", "log format: text or json")
+	fs.StringVar(&f.echMode, "ech-mode", "dns", "where the offered ECHConfigList comes from: dns, grease or static")
+	fs.StringVar(&f.echConfig, "ech-config", "", "offer this ECHConfigList instead of looking it up: base64, hex:..., or @file holding it in base64, hex or PEM")
+	fs.IntVar(&f.aliasDepth, "max-alias-depth", echclient.DefaultMaxAliasDepth, "maximum number of AliasMode HTTPS records followed")
+	fs.IntVar(&f.endpoint, "endpoint-index", 0, "use the HTTPS record at this 1-based position in SvcPriority order instead of the lowest priority one")
+	fs.BoolVar(&f.shuffle, "shuffle-endpoints", false, "pick randomly among the HTTPS records of the lowest SvcPriority")
+	fs.StringVar(&f.fallback, "ech-fallback", "none", "what to offer when no ECHConfigList is found (e.g. NXDOMAIN): none, grease or plain")
+	fs.StringVar(&f.source, "config-source", "dns", "where ECHConfigLists are fetched from: dns, wellknown or both")
+	fs.StringVar(&f.preferKEM, "prefer-kem", "", "comma-separated HPKE KEMs, e.g. x25519, choosing the ECHConfig offered when there are several")
+	fs.StringVar(&f.preferAEAD, "prefer-aead", "", "comma-separated HPKE AEADs, e.g. chacha20poly1305, choosing the ECHConfig offered when there are several")
+	fs.BoolVar(&f.mergeECH, "merge-ech-configs", false, "offer the distinct ECHConfigs of all the HTTPS records of the host instead of those of the selected one")
+	fs.BoolVar(&f.strict, "strict", false, "fail on any specification violation in the HTTPS records or ECHConfigList instead of reporting it as a warning")
+	fs.BoolVar(&f.noECHRetry, "no-ech-retry", false, "do not retry with the server supplied configs when ECH is rejected")
+	fs.BoolVar(&f.eachConfig, "probe-each-config", false, "repeat the handshake offering each config of the ECHConfigList on its own and report which config_ids the server accepts")
+	fs.BoolVar(&f.padding, "report-padding", false, "report the length of the padded ClientHelloInner for the host and for names of other lengths")
+	fs.BoolVar(&f.dumpHello, "dump-clienthello", false, "capture the ClientHello sent on the wire, the ClientHelloOuter with ECH, and print it decoded and as a hex dump")
+	fs.StringVar(&f.alpn, "alpn", "", "comma separated ALPN protocols offered instead of those derived from the HTTPS record, e.g. h2 or http/1.1; h3 alone uses HTTP/3, and --handshake-only accepts any protocol")
+	fs.StringVar(&f.fingerprint, "fingerprint", "go", "ClientHello fingerprint: go (crypto/tls), or chrome or firefox in builds with -tags utls, which offer only http/1.1")
+	fs.BoolVar(&f.handshake, "handshake-only", false, "stop after the TLS handshake, without an HTTP request, and report the certificate chain; works for non-HTTP TLS services given a URL with a port, such as tls://mail.example.com:993")
+	fs.BoolVar(&f.resume, "resume", false, "repeat the probe on a new connection and check that the TLS session is resumed with ECH still accepted")
+	fs.BoolVar(&f.earlyData, "early-data", false, "like --resume, sending the repeated request as 0-RTT early data and reporting whether the server accepts it; HTTP/3 only (see --alpn h3), crypto/tls does not send early data over TCP")
+	fs.StringVar(&f.sessionFile, "session-cache", "", "persist TLS sessions in this JSON file across invocations, to resume them; it holds resumption secrets")
+	fs.BoolVar(&f.noCache, "no-cache", false, "do not cache DNS answers for their TTL")
+	fs.StringVar(&f.cacheFile, "cache-file", "", "persist the DNS cache in this JSON file across invocations")
+	fs.StringVar(&f.pinFile, "pin-ech-config", "", "pin the first ECHConfigList fetched for each name in this JSON file and fail when DNS later serves another one")
+	fs.BoolVar(&f.pinWarn, "pin-warn-only", false, "only warn when the ECHConfigList differs from the pinned one")
+	fs.BoolVar(&f.resolveIPs, "resolve-addrs", false, "resolve the host's A/AAAA records with --resolver and connect to them directly instead of using the system resolver")
+	fs.BoolVar(&f.useHints, "use-hints", false, "connect to the ipv4hint/ipv6hint addresses of the HTTPS record and compare with the resolved addresses")
+	fs.UintVar(&f.port, "connect-port", 0, "connect to this port instead of the one of the URL or the port SvcParam of the HTTPS record")
+	fs.StringVar(&f.connectTo, "connect-to", "", "connect to this ip[:port] (IPv6 in brackets with a port) instead of the host of the URL, still fetching the ECHConfigList and sending the server name of the URL; ignores --use-hints and --resolve-addrs")
+	fs.BoolVar(&f.compareAuth, "authoritative", false, "also query the zone's authoritative nameservers directly and report differences from the resolver's answer")
+	fs.BoolVar(&f.dns0x20, "dns-0x20", false, "randomize the source port and query name case of udp:// and tcp:// lookups and discard answers that do not echo them")
+	fs.BoolVar(&f.dnssec, "dnssec", false, "validate the HTTPS RRset with DNSSEC and report secure, insecure or bogus")
+	fs.StringVar(&f.metricsAddr, "metrics-addr", "", "serve Prometheus metrics on this address (e.g. :9090)")
+}
+
+// config sets up logging and returns the ProbeConfig described by the flags,
+// exiting on invalid values.
+func (f *probeFlags) config() *echclient.ProbeConfig {
+	if f.verbose {
+		f.logLevel = "debug"
+	}
+	logger, err := newLogger(os.Stderr, f.logFormat, f.logLevel)
+	if err != nil {
+		fatal(err.Error())
+	}
+	slog.SetDefault(logger)
+
+	cfg := &echclient.ProbeConfig{
+		ResolverURL: f.resolver,
+		DoHMethod:   echclient.DoHMethod(f.dohMethod),
+		DNSTimeout:  f.dnsTimeout,
+		DNSRetries:  f.dnsRetries,
+		DNSBackoff:  f.dnsBackoff,
+		Timeout:     f.timeout,
+		TLSConfig: &tls.Config{
+			InsecureSkipVerify: f.insecure,
+		},
+		ECHMode:              echclient.ECHMode(f.echMode),
+		ECHFallback:          echclient.ECHFallback(f.fallback),
+		EndpointIndex:        f.endpoint,
+		EndpointPolicy:       echclient.Policy{Shuffle: f.shuffle},
+		MaxAliasDepth:        f.aliasDepth,
+		ConfigSource:         echclient.ConfigSource(f.source),
+		DisableECHRetry:      f.noECHRetry,
+		ProbeEachConfig:      f.eachConfig,
+		ReportPadding:        f.padding,
+		HandshakeOnly:        f.handshake,
+		Resume:               f.resume,
+		EarlyData:            f.earlyData,
+		CaptureClientHello:   f.dumpHello,
+		Strict:               f.strict,
+		MergeECHConfigs:      f.mergeECH,
+		ValidateDNSSEC:       f.dnssec,
+		RandomizeDo53:        f.dns0x20,
+		ResolveAddrs:         f.resolveIPs,
+		UseHints:             f.useHints,
+		Port:                 uint16(f.port),
+		CompareAuthoritative: f.compareAuth,
+		Logger:               logger,
+		Hooks:                logHooks(),
+	}
+	if cfg.PreferKEMs, err = parseHPKEList(f.preferKEM, echclient.ParseKEM); err != nil {
+		fatal("invalid --prefer-kem", "error", err)
+	}
+	if cfg.PreferAEADs, err = parseHPKEList(f.preferAEAD, echclient.ParseAEAD); err != nil {
+		fatal("invalid --prefer-aead", "error", err)
+	}
+	if f.clientCert != "" {
+		if f.clientKey == "" {
+			f.clientKey = f.clientCert
+		}
+		cert, err := tls.LoadX509KeyPair(f.clientCert, f.clientKey)
+		if err != nil {
+			fatal("failed to load the client certificate", "error", err)
+		}
+		cfg.TLSConfig.Certificates = []tls.Certificate{cert}
+	} else if f.clientKey != "" {
+		fatal("--client-key requires --client-cert")
+	}
+	cfg.PinnedSPKI = f.pins
+	if cfg.TLSConfig.MinVersion, err = parseTLSVersion(f.tlsMin); err != nil {
+		fatal("invalid --tls-min", "error", err)
+	}
+	if cfg.TLSConfig.MaxVersion, err = parseTLSVersion(f.tlsMax); err != nil {
+		fatal("invalid --tls-max", "error", err)
+	}
+	if minVersion, maxVersion := cfg.TLSConfig.MinVersion, cfg.TLSConfig.MaxVersion; minVersion != 0 && maxVersion != 0 && minVersion > maxVersion {
+		fatal("--tls-min is above --tls-max", "tls_min", f.tlsMin, "tls_max", f.tlsMax)
+	}
+	if minVersion, maxVersion := cfg.TLSConfig.MinVersion, cfg.TLSConfig.MaxVersion; minVersion != 0 && minVersion < tls.VersionTLS13 ||
+		maxVersion != 0 && maxVersion < tls.VersionTLS13 {
+		logger.Warn("crypto/tls refuses to offer ECH when --tls-min or --tls-max is below TLS 1.3",
+			"tls_min", f.tlsMin, "tls_max", f.tlsMax)
+	}
+	if cfg.TLSConfig.CipherSuites, err = parseCipherSuites(f.ciphers); err != nil {
+		fatal("invalid --ciphers", "error", err)
+	}
+	if f.ciphers != "" && cfg.TLSConfig.CipherSuites == nil {
+		fatal("--ciphers only names TLS 1.3 suites, which crypto/tls does not allow choosing", "ciphers", f.ciphers)
+	}
+	if f.alpn != "" {
+		for _, proto := range strings.Split(f.alpn, ",") {
+			if proto = strings.TrimSpace(proto); proto == "" || len(proto) > 255 {
+				fatal("invalid --alpn", "alpn", f.alpn)
+			}
+			cfg.ALPN = append(cfg.ALPN, proto)
+		}
+	}
+	if f.fingerprint != "go" {
+		engine, ok := tlsEngines[f.fingerprint]
+		if !ok {
+			fatal("unknown fingerprint; chrome and firefox require a build with -tags utls", "fingerprint", f.fingerprint)
+		}
+		if f.sessionFile != "" {
+			fatal("--session-cache cannot hold the TLS sessions of a --fingerprint other than go", "fingerprint", f.fingerprint)
+		}
+		cfg.TLSEngine = engine
+	}
+	if f.port > 65535 {
+		fatal("invalid port", "connect_port", f.port)
+	}
+	if f.connectTo != "" {
+		var port uint16
+		if cfg.ConnectIP, port, err = parseConnectTo(f.connectTo); err != nil {
+			fatal("invalid --connect-to", "error", err)
+		}
+		if port != 0 {
+			if f.port != 0 && f.port != uint(port) {
+				fatal("--connect-to and --connect-port name different ports", "connect_to", f.connectTo, "connect_port", f.port)
+			}
+			cfg.Port = port
+		}
+		if f.useHints || f.resolveIPs {
+			logger.Warn("--use-hints and --resolve-addrs are ignored with --connect-to")
+		}
+	}
+	if f.dnsRetries < 0 {
+		fatal("invalid DNS retries", "dns_retries", f.dnsRetries)
+	}
+	if f.echConfig != "" {
+		if cfg.ECHMode != echclient.ECHModeDNS && cfg.ECHMode != echclient.ECHModeStatic {
+			fatal("--ech-config requires the static ech mode", "ech_mode", f.echMode)
+		}
+		cfg.ECHMode = echclient.ECHModeStatic
+		if cfg.ECHConfigList, err = parseECHConfigFlag(f.echConfig); err != nil {
+			fatal("invalid --ech-config", "error", err)
+		}
+	}
+	switch cfg.ECHMode {
+	case echclient.ECHModeDNS, echclient.ECHModeGREASE:
+	case echclient.ECHModeStatic:
+		if f.echConfig == "" {
+			fatal("the static ech mode requires --ech-config")
+		}
+	default:
+		fatal("invalid ech mode", "ech_mode", f.echMode)
+	}
+	switch cfg.ECHFallback {
+	case echclient.ECHFallbackNone, echclient.ECHFallbackGREASE, echclient.ECHFallbackPlain:
+	default:
+		fatal("invalid ECH fallback", "ech_fallback", f.fallback)
+	}
+	switch cfg.DoHMethod {
+	case "", echclient.DoHJSON, echclient.DoHPost, echclient.DoHGet:
+	default:
+		fatal("invalid DoH method", "doh_method", f.dohMethod)
+	}
+	switch cfg.ConfigSource {
+	case echclient.ConfigSourceDNS, echclient.ConfigSourceWellKnown, echclient.ConfigSourceBoth:
+	default:
+		fatal("invalid config source", "config_source", f.source)
+	}
+	// Build the resolver once so that state such as the DDR discovery
+	// and DoQ sessions is shared by every query.
+	resolvers := strings.Split(f.resolver, ",")
+	switch {
+	case len(resolvers) > 1 && f.raceDNS:
+		cfg.Resolver, err = cfg.NewRaceResolver(resolvers...)
+	case len(resolvers) > 1:
+		cfg.Resolver, err = cfg.NewFallbackResolver(resolvers...)
+	default:
+		cfg.Resolver, err = cfg.NewResolver(f.resolver)
+	}
+	if err != nil {
+		fatal("invalid resolver", "resolver", f.resolver, "error", err)
+	}
+	if f.odohTarget != "" {
+		r, err := cfg.NewODoHResolver(f.odohTarget, f.odohProxy)
+		if err != nil {
+			fatal("invalid ODoH resolver", "error", err)
+		}
+		if f.odohConfig != "" {
+			if r.Configs, err = os.ReadFile(f.odohConfig); err != nil {
+				fatal("failed to read the ODoH config", "error", err)
+			}
+		}
+		cfg.Resolver = r
+	} else if f.odohProxy != "" || f.odohConfig != "" {
+		fatal("--odoh-proxy and --odoh-config require --odoh-target")
+	}
+	switch {
+	case f.noCache:
+	case f.cacheFile != "":
+		cfg.Cache, err = echclient.LoadDNSCache(f.cacheFile)
+		if err != nil {
+			fatal("failed to load DNS cache", "error", err)
+		}
+	default:
+		cfg.Cache = echclient.NewDNSCache()
+	}
+	if f.sessionFile != "" {
+		if cfg.TLSConfig.ClientSessionCache, err = echclient.LoadSessionCache(f.sessionFile); err != nil {
+			fatal("failed to load TLS session cache", "error", err)
+		}
+	}
+	if f.pinFile != "" {
+		if cfg.ECHConfigPins, err = echclient.LoadECHConfigPins(f.pinFile); err != nil {
+			fatal("failed to load ECHConfig pins", "error", err)
+		}
+		cfg.PinWarnOnly = f.pinWarn
+	}
+	if f.metricsAddr != "" {
+		cfg.Metrics = serveMetrics(f.metricsAddr)
+	}
+	if f.proxyUrl != "" {
+		pu, err := url.Parse(f.proxyUrl)
+		if err != nil {
+			fatal("invalid proxy URL", "error", err)
+		}
+		cfg.Proxy = http.ProxyURL(pu)
+	}
+	return cfg
+}
+
+// saveState writes the DNS cache of cfg to --cache-file, its ECHConfig
+// pins to --pin-ech-config and its TLS sessions to --session-cache, if set.
+func (f *probeFlags) saveState(cfg *echclient.ProbeConfig) {
+	if f.cacheFile != "" && cfg.Cache != nil {
+		if err := cfg.Cache.Save(f.cacheFile); err != nil {
+			slog.Warn("failed to save DNS cache", "file", f.cacheFile, "error", err)
+		}
+	}
+	if f.pinFile != "" && cfg.ECHConfigPins != nil {
+		if err := cfg.ECHConfigPins.Save(f.pinFile); err != nil {
+			slog.Warn("failed to save ECHConfig pins", "file", f.pinFile, "error", err)
+		}
+	}
+	if cache, ok := cfg.TLSConfig.ClientSessionCache.(*echclient.SessionCache); ok && f.sessionFile != "" {
+		if err := cache.Save(f.sessionFile); err != nil {
+			slog.Warn("failed to save TLS session cache", "file", f.sessionFile, "error", err)
+		}
+	}
+}
+
+// runProbe measures a single URL. It is the default command.
+func runProbe(ctx context.Context, args []string) {
+	var (
+		pf         probeFlags
+		targetUrl  string
+		output     string
+		outputFile string
+		requireECH bool
+		requireKey string
+		printECH   bool
+	)
+	fs := flag.NewFlagSet("ech", flag.ExitOnError)
+	pf.register(fs)
+	fs.StringVar(&targetUrl, "url", "https://cloudflare-ech.com/cdn-cgi/trace", "url to measure")
+	fs.StringVar(&output, "output-format", "text", "output format: text, "+strings.Join(sink.Formats, ", "))
+	fs.StringVar(&output, "output", "text", "alias for --output-format")
+	fs.StringVar(&outputFile, "output-file", "", "write results to this file instead of stdout")
+	fs.BoolVar(&requireECH, "require-ech-accepted", false, "exit with status 2 if the server did not accept ECH")
+	fs.StringVar(&requireKey, "require-param", "", "comma separated SvcParamKeys (e.g. ech,alpn or key65000) the HTTPS record must carry, else exit with status 2")
+	fs.BoolVar(&printECH, "print-ech-config", false, "print the fetched ECHConfigList in standard base64 instead of the text report")
+	fs.Parse(args)
+	if printECH && output != "text" {
+		fatal("--print-ech-config replaces the text output", "output", output)
+	}
+
+	var requiredKeys []uint16
+	if requireKey != "" {
+		for _, name := range strings.Split(requireKey, ",") {
+			key, ok := echclient.ParseSvcParamKey(strings.TrimSpace(name))
+			if !ok {
+				fatal("invalid SvcParamKey", "require_param", name)
+			}
+			requiredKeys = append(requiredKeys, key)
+		}
+	}
+
+	cfg := pf.config()
+
+	var out sink.OutputSink
+	if output != "text" {
+		var err error
+		out, err = sink.Open(output, outputFile)
+		if err != nil {
+			fatal("failed to open output", "error", err)
+		}
+	}
+
+	result, err := cfg.ProbeURL(ctx, targetUrl)
+	pf.saveState(cfg)
+	if out != nil {
+		if err := out.WriteResult(result); err != nil {
+			fatal("failed to write result", "error", err)
+		}
+		if err := out.Close(); err != nil {
+			fatal("failed to close output", "error", err)
+		}
+		if err != nil {
+			os.Exit(1)
+		}
+	} else if printECH {
+		printECHConfigList(result.ECHConfigList, err)
+	} else {
+		printText(result, err)
+	}
+	if requireECH && !result.Final().ECHAccepted {
+		slog.Error("ECH was not accepted", "url", targetUrl)
+		os.Exit(2)
+	}
+	for _, key := range requiredKeys {
+		if result.HTTPSRecord == nil {
+			slog.Error("no HTTPS record to check the required SvcParams", "url", targetUrl)
+			os.Exit(2)
+		}
+		if _, ok := result.HTTPSRecord.Param(key); !ok {
+			slog.Error("HTTPS record lacks a required SvcParam", "url", targetUrl, "param", echclient.SvcParamKeyName(key))
+			os.Exit(2)
+		}
+	}
+}
+
+// parseECHConfigFlag decodes the value of --ech-config: an ECHConfigList in
+// base64, in hex after "hex:", or read from the file named after "@" in any
+// of the encodings ech decode accepts.
+func parseECHConfigFlag(value string) ([]byte, error) {
+	if name, ok := strings.CutPrefix(value, "@"); ok {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		value = string(data)
+	} else if s, ok := strings.CutPrefix(value, "hex:"); ok {
+		return hex.DecodeString(s)
+	}
+	return configListBytes(value)
+}
+
+// parseHPKEList parses a comma-separated list of HPKE algorithm names.
+func parseHPKEList(list string, parse func(string) (uint16, error)) ([]uint16, error) {
+	if list == "" {
+		return nil, nil
+	}
+	var ids []uint16
+	for _, name := range strings.Split(list, ",") {
+		id, err := parse(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// tlsVersions maps the values of --tls-min and --tls-max to versions.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// parseTLSVersion parses a TLS version such as "1.3" or "tls1.3", returning
+// 0, the crypto/tls default, for an empty string.
+func parseTLSVersion(s string) (uint16, error) {
+	if s == "" {
+		return 0, nil
+	}
+	name := strings.TrimPrefix(strings.ToLower(s), "tls")
+	if v, ok := tlsVersions[strings.TrimSpace(name)]; ok {
+		return v, nil
+	}
+	return 0, fmt.Errorf("unknown TLS version %q", s)
+}
+
+// parseConnectTo parses the value of --connect-to, an IP address optionally
+// followed by a port, returning 0 when there is none.
+func parseConnectTo(s string) (netip.Addr, uint16, error) {
+	if addrPort, err := netip.ParseAddrPort(s); err == nil {
+		if addrPort.Port() == 0 {
+			return netip.Addr{}, 0, fmt.Errorf("invalid port in %q", s)
+		}
+		return addrPort.Addr(), addrPort.Port(), nil
+	}
+	addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(s, "["), "]"))
+	if err != nil {
+		return netip.Addr{}, 0, fmt.Errorf("%q is not an IP address with an optional port", s)
+	}
+	return addr, 0, nil
+}
+
+// parseCipherSuites parses a comma separated list of cipher suites, named
+// as by tls.CipherSuiteName or given as 0x hex ids. TLS 1.3 suites are
+// dropped, since crypto/tls always offers them all.
+func parseCipherSuites(list string) ([]uint16, error) {
+	if list == "" {
+		return nil, nil
+	}
+	var ids []uint16
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		var suite *tls.CipherSuite
+		for _, s := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+			if strings.EqualFold(s.Name, name) || strings.EqualFold(fmt.Sprintf("0x%04x", s.ID), name) {
+				suite = s
+				break
+			}
+		}
+		if suite == nil {
+			return nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+		if slices.Equal(suite.SupportedVersions, []uint16{tls.VersionTLS13}) {
+			slog.Warn("ignoring TLS 1.3 cipher suite, crypto/tls offers them all", "cipher_suite", suite.Name)
+			continue
+		}
+		ids = append(ids, suite.ID)
+	}
+	return ids, nil
+}
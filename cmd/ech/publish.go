@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/hellais/ech/dnsprovider"
+	"github.com/hellais/ech/echclient"
+)
+
+// runPublish prints the HTTPS record publishing an ECHConfigList, both as a
+// zone file line and in the RFC 3597 generic form, or, with --provider,
+// replaces the HTTPS RRset of the name through the API of a DNS provider.
+func runPublish(ctx context.Context, args []string) {
+	var (
+		name     string
+		ttl      uint
+		priority uint
+		target   string
+		alpn     string
+		port     uint
+		ech      string
+		keyFile  string
+		provider string
+		opts     dnsprovider.Options
+		dryRun   bool
+	)
+	fs := flag.NewFlagSet("ech publish", flag.ExitOnError)
+	fs.StringVar(&name, "name", "", "owner name of the record (required)")
+	fs.UintVar(&ttl, "ttl", 300, "TTL of the record")
+	fs.UintVar(&priority, "priority", 1, "SvcPriority of the record")
+	fs.StringVar(&target, "target", ".", "TargetName of the record, . for the owner name")
+	fs.StringVar(&alpn, "alpn", "h2", "comma separated protocols of the alpn SvcParam, empty for none")
+	fs.UintVar(&port, "port", 0, "port SvcParam, 0 for none")
+	fs.StringVar(&ech, "ech", "", "base64 ECHConfigList to publish")
+	fs.StringVar(&keyFile, "key-file", "", "read the ECHConfigList from this key file written by ech keygen")
+	fs.StringVar(&provider, "provider", "", "publish through this DNS provider: "+strings.Join(dnsprovider.Names, ", ")+"; empty to only print the record")
+	fs.StringVar(&opts.Zone, "zone", "", "zone of the name: its name, or the hosted zone ID for route53")
+	fs.StringVar(&opts.Server, "server", "", "host:port of the primary nameserver for rfc2136")
+	fs.StringVar(&opts.TSIGKey, "tsig-key", "", "[algorithm:]name:base64secret TSIG key for rfc2136, defaults to $TSIG_KEY")
+	fs.BoolVar(&dryRun, "dry-run", false, "with --provider, print the changes without applying them")
+	fs.Parse(args)
+
+	if name == "" {
+		fatal("--name is required")
+	}
+	if priority == 0 || priority > 65535 {
+		fatal("invalid SvcPriority, ServiceMode records need 1 to 65535", "priority", priority)
+	}
+	if port > 65535 {
+		fatal("invalid port", "port", port)
+	}
+	if ttl > 1<<31-1 {
+		fatal("invalid TTL", "ttl", ttl)
+	}
+	configList := readConfigList(ech, keyFile)
+	if _, err := echclient.ParseECHConfigList(configList); err != nil {
+		fatal("invalid ECHConfigList", "error", err)
+	}
+	name, err := echclient.ToASCIIHost(name)
+	if err != nil {
+		fatal("invalid name", "error", err)
+	}
+
+	record := &echclient.HttpsRecord{Priority: uint16(priority), TargetName: target}
+	if alpn != "" {
+		record.Params = append(record.Params, echclient.ALPNParam(strings.Split(alpn, ",")...))
+	}
+	if port != 0 {
+		record.Params = append(record.Params, echclient.PortParam(uint16(port)))
+	}
+	record.Params = append(record.Params, echclient.ECHParam(configList))
+	rdata, err := record.Marshal()
+	if err != nil {
+		fatal("failed to encode record", "error", err)
+	}
+	if provider == "" {
+		fmt.Println(echclient.FormatRR(name, uint32(ttl), echclient.TypeHTTPS, record))
+		fmt.Println(echclient.FormatGenericRR(name, uint32(ttl), echclient.TypeHTTPS, rdata))
+		return
+	}
+
+	p, err := dnsprovider.Open(provider, opts)
+	if err != nil {
+		fatal("failed to configure the DNS provider", "error", err)
+	}
+	current, err := p.Records(ctx, name)
+	if err != nil {
+		fatal("failed to read the HTTPS records", "provider", p, "name", name, "error", err)
+	}
+	desired := []*echclient.HttpsRecord{record}
+	changes := dnsprovider.Diff(name, uint32(ttl), current, desired)
+	if len(changes) == 0 {
+		slog.Info("HTTPS record is up to date", "provider", p, "name", name)
+		return
+	}
+	for _, line := range changes {
+		fmt.Println(line)
+	}
+	if dryRun {
+		slog.Info("dry run, not publishing", "provider", p, "name", name)
+		return
+	}
+	if err := p.Replace(ctx, name, uint32(ttl), desired); err != nil {
+		fatal("failed to publish the HTTPS record", "provider", p, "name", name, "error", err)
+	}
+	slog.Info("published HTTPS record", "provider", p, "name", name)
+}
+
+// readConfigList returns the ECHConfigList given in base64 or, if empty, in
+// the ECHCONFIG block of keyFile.
+func readConfigList(ech, keyFile string) []byte {
+	switch {
+	case ech != "" && keyFile != "":
+		fatal("--ech and --key-file are mutually exclusive")
+	case ech != "":
+		configList, err := base64.StdEncoding.DecodeString(ech)
+		if err != nil {
+			fatal("invalid base64 ECHConfigList", "error", err)
+		}
+		return configList
+	case keyFile != "":
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			fatal("failed to read key file", "error", err)
+		}
+		for {
+			var block *pem.Block
+			block, data = pem.Decode(data)
+			if block == nil {
+				break
+			}
+			if block.Type == "ECHCONFIG" {
+				return block.Bytes
+			}
+		}
+		fatal("no ECHCONFIG block in key file", "file", keyFile)
+	}
+	fatal("--ech or --key-file is required")
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/hellais/ech/echclient"
+)
+
+// runQuery looks up the HTTPS or SVCB RRset of a name and prints the answer
+// section the way dig does, so that it can be compared with dig or pasted
+// into a zone file.
+func runQuery(ctx context.Context, args []string) {
+	var (
+		pf      probeFlags
+		qtype   string
+		echOnly bool
+	)
+	fs := flag.NewFlagSet("ech query", flag.ExitOnError)
+	pf.register(fs)
+	fs.StringVar(&qtype, "type", "HTTPS", "record type: HTTPS or SVCB")
+	fs.BoolVar(&echOnly, "ech-only", false, "print only the ECHConfigList of the selected HTTPS record, in standard base64")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: ech query [flags] name\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	t, err := echclient.ParseRRType(qtype)
+	if err != nil || t != echclient.TypeHTTPS && t != echclient.TypeSVCB {
+		fatal("invalid record type", "type", qtype)
+	}
+	name, err := echclient.ToASCIIHost(fs.Arg(0))
+	if err != nil {
+		fatal("invalid name", "name", fs.Arg(0), "error", err)
+	}
+
+	cfg := pf.config()
+	if echOnly {
+		if t != echclient.TypeHTTPS {
+			fatal("--ech-only requires HTTPS records", "type", t)
+		}
+		parsed, err := cfg.FetchECHConfigList(ctx, name)
+		pf.saveState(cfg)
+		var list []byte
+		if parsed != nil {
+			list = parsed.Raw
+		}
+		printECHConfigList(list, err)
+		return
+	}
+	resp, err := cfg.Query(ctx, strings.TrimSuffix(name, ".")+".", t)
+	pf.saveState(cfg)
+	if err != nil {
+		fatal("query failed", "name", name, "type", t, "error", err)
+	}
+	for _, answer := range resp.Answer {
+		line, err := echclient.FormatAnswer(answer)
+		if err != nil {
+			fatal("failed to decode record", "name", answer.Name, "error", err)
+		}
+		fmt.Println(line)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// runSuites enumerates the HPKE cipher suites the ECH deployment of a host
+// accepts.
+func runSuites(ctx context.Context, args []string) {
+	var (
+		pf     probeFlags
+		output string
+	)
+	fs := flag.NewFlagSet("ech suites", flag.ExitOnError)
+	pf.register(fs)
+	fs.StringVar(&output, "output", "text", "output format: text or json")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: ech suites [flags] host|url\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	targetURL := fs.Arg(0)
+	if !strings.Contains(targetURL, "://") {
+		targetURL = "https://" + targetURL + "/"
+	}
+
+	cfg := pf.config()
+	scan, err := cfg.ScanECHSuites(ctx, targetURL)
+	pf.saveState(cfg)
+	if err != nil {
+		fatal("cipher suite scan failed", "url", targetURL, "error", err)
+	}
+	switch output {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(scan); err != nil {
+			fatal("failed to write output", "error", err)
+		}
+	case "text":
+		fmt.Printf("%s (%s)\n", scan.URL, scan.RemoteAddr)
+		for _, p := range scan.Probes {
+			outcome := "rejected"
+			switch {
+			case p.Error != "":
+				outcome = "error: " + p.Error
+			case p.Accepted:
+				outcome = "accepted"
+			}
+			var note string
+			switch {
+			case p.Advertised && !p.Accepted:
+				note = " but advertised"
+			case !p.Advertised && p.Accepted:
+				note = " but not advertised"
+			}
+			fmt.Printf("  config_id %d %s %-34s %s%s\n", p.ConfigID, p.KEM, p.Cipher, outcome, note)
+		}
+	default:
+		fatal("invalid output format", "output", output)
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hellais/ech/echclient"
+)
+
+// printECHConfigList prints echConfigList in standard base64 alone on a
+// line, as openssl s_client -ech_config_list and Firefox's
+// network.dns.echconfig take it, exiting with err when there is none.
+func printECHConfigList(echConfigList []byte, err error) {
+	if len(echConfigList) == 0 {
+		fatal("no ECHConfigList found", "error", err)
+	}
+	fmt.Println(base64.StdEncoding.EncodeToString(echConfigList))
+}
+
+// printText prints the human readable outcome of a probe, exiting on error.
+func printText(result *echclient.ProbeResult, err error) {
+	if err != nil && result.ECHConfigList == nil && result.Fallback == "" {
+		fatal("failed to get ech config", "error", err)
+	}
+
+	for _, ech := range result.ECHConfigs {
+		slog.Debug("ech config",
+			"public_name", ech.PublicName,
+			"pk", hex.EncodeToString(ech.PublicKey),
+			"kemid", ech.KemID,
+			"extensions", ech.Extensions,
+			"version", ech.Version,
+			"cipher_suite", ech.CipherSuites)
+	}
+	if result.UnicodeHost != "" {
+		slog.Info("internationalized host name", "host", result.UnicodeHost, "query_name", result.QueryName)
+	}
+	if result.AnsweredBy != "" && result.AnsweredBy != result.Resolver {
+		slog.Info("HTTPS record answered by", "resolver", result.AnsweredBy)
+	}
+	for _, reason := range result.SkippedECHConfigs {
+		slog.Warn("skipped unusable ECHConfig", "reason", reason)
+	}
+	if result.NameLengthWarning != "" {
+		slog.Warn("the ClientHelloInner reveals the length of the host", "warning", result.NameLengthWarning)
+	}
+	if p := result.Padding; p != nil {
+		lengths := make([]string, len(p.Samples))
+		for i, s := range p.Samples {
+			lengths[i] = fmt.Sprintf("%d:%d", s.NameLength, s.PayloadLength)
+		}
+		args := []any{"config_id", p.ConfigID, "max_name_length", p.MaxNameLength, "name_length", p.NameLength,
+			"payload_length", p.PayloadLength, "samples", strings.Join(lengths, " ")}
+		if p.HidesNameLength {
+			slog.Info("ClientHelloInner padding hides name lengths up to maximum_name_length", args...)
+		} else {
+			slog.Warn("ClientHelloInner padding does not hide name lengths", args...)
+		}
+	}
+	for _, w := range result.ConfigIDWarnings {
+		slog.Warn("suspicious config_id", "warning", w)
+	}
+	for _, s := range result.SkippedRecords {
+		slog.Warn("skipped HTTPS record", "priority", s.Record.Priority, "target", s.Record.TargetName, "reason", s.Reason)
+	}
+	if len(result.HTTPSRecords) > 1 {
+		slog.Info("HTTPS RRset has several endpoints", "count", len(result.HTTPSRecords),
+			"selected_priority", result.HTTPSRecord.Priority, "selected_target", result.HTTPSRecord.TargetName)
+	}
+	if len(result.AliasChain) > 0 {
+		slog.Info("HTTPS record found through AliasMode", "chain", result.AliasChain)
+	}
+	if len(result.CNAMEChain) > 0 {
+		slog.Info("HTTPS record found through CNAME", "chain", result.CNAMEChain)
+	}
+	if result.Fallback != "" {
+		slog.Warn("no ECHConfigList found, fell back", "fallback", result.Fallback,
+			"dns_status", result.DNSStatus, "reason", result.FallbackReason)
+	}
+	if len(result.ECHConfigLists) > 1 {
+		slog.Info("HTTPS records publish several ECHConfigLists", "count", len(result.ECHConfigLists),
+			"merged", result.ECHConfigsMerged)
+	}
+	for _, w := range result.ParseWarnings {
+		slog.Warn("specification violation, use --strict to make it an error", "violation", w)
+	}
+	if result.DNSTruncated {
+		slog.Info("HTTPS answer was truncated and fetched again over TCP or DoH POST")
+	}
+	if result.DNSStale {
+		slog.Warn("the resolver failed, the HTTPS answer is an expired cached one")
+	}
+	if result.DNSSuspiciousReplies > 0 {
+		slog.Warn("discarded DNS answers that did not match the query, possibly injected",
+			"count", result.DNSSuspiciousReplies)
+	}
+	switch result.DNSSEC {
+	case echclient.DNSSECBogus:
+		slog.Warn("HTTPS record failed DNSSEC validation", "error", result.DNSSECError)
+	case echclient.DNSSECSecure, echclient.DNSSECInsecure:
+		slog.Info("HTTPS record DNSSEC status", "dnssec", result.DNSSEC)
+	}
+	switch {
+	case result.AuthoritativeDiffers:
+		slog.Warn("authoritative nameservers disagree with the resolver",
+			"answered_by", result.AuthoritativeAnsweredBy, "records", len(result.AuthoritativeRecords),
+			"error", result.AuthoritativeError)
+	case result.AuthoritativeError != "":
+		slog.Info("authoritative lookup failed", "error", result.AuthoritativeError)
+	case result.AuthoritativeAnsweredBy != "":
+		slog.Info("authoritative nameservers agree with the resolver", "answered_by", result.AuthoritativeAnsweredBy)
+	}
+	if u, err := url.Parse(result.URL); err == nil && result.Target != "" && !strings.EqualFold(strings.TrimSuffix(result.Target, "."), u.Hostname()) {
+		slog.Info("HTTPS record points to another endpoint", "target", result.Target)
+	}
+	if result.AdvertisedPort != 0 {
+		slog.Info("HTTPS record advertises a port", "advertised_port", result.AdvertisedPort, "port", result.Port)
+	}
+	if result.Resolved != nil {
+		slog.Info("connected to the address hints", "addr", result.RemoteAddr,
+			"resolved_addr", result.Resolved.RemoteAddr, "differ", result.HintsDiffer,
+			"resolved_ech_accepted", result.Resolved.ECHAccepted, "resolved_error", result.Resolved.Error)
+	}
+	for _, p := range result.ConfigProbes {
+		args := []any{"config_id", p.ConfigID, "public_name", p.PublicName, "ech_accepted", p.ECHAccepted}
+		if p.Error != "" {
+			args = append(args, "error_class", p.ErrorClass, "error", p.Error)
+		}
+		slog.Info("probed single ECH config", args...)
+	}
+	if len(result.ConfigProbes) > 0 {
+		slog.Info("config_ids accepted by the server", "accepted", result.AcceptedConfigIDs(), "offered", len(result.ConfigProbes))
+	}
+	if len(result.RetryConfigDrift) > 0 {
+		slog.Warn("server retry configs differ from the published ECHConfigList, DNS may serve stale keys",
+			"drift", result.RetryConfigDrift)
+	}
+	if result.Retry != nil {
+		slog.Info("retried with server supplied ECH configs",
+			"differs", result.RetryConfigDiffers,
+			"ech_accepted", result.Retry.ECHAccepted)
+	}
+	printClientHello("ClientHello", result.ClientHello)
+	if result.Retry != nil {
+		printClientHello("Retry ClientHello", result.Retry.ClientHello)
+	}
+	if final := result.Final(); final.ClientCertRequested && !final.ClientCertSent {
+		slog.Warn("the server requested a client certificate and none suited it; see --client-cert")
+	}
+	if err != nil {
+		printCertificates(result.Final())
+		fatal("failed to perform request", "url", result.URL, "error", err)
+	}
+	result = result.Final()
+	if result.TLSVersion != "" {
+		fmt.Printf("ECH accepted: %v\n", result.ECHAccepted)
+		fmt.Printf("TLS version: %s\n", result.TLSVersion)
+		fmt.Printf("Cipher suite: %s\n", result.CipherSuite)
+		fmt.Printf("ALPN: %s (offered %s)\n", result.ALPN, strings.Join(result.OfferedALPN, ", "))
+		if result.ClientCertRequested {
+			fmt.Printf("Client certificate requested: sent=%v\n", result.ClientCertSent)
+		}
+		if result.TLSEngine != "" {
+			fmt.Printf("TLS engine: %s\n", result.TLSEngine)
+		}
+		if ec := result.SelectedECHConfig; ec != nil && ec.CipherSuite != nil {
+			fmt.Printf("ECH config: config_id=%d kem=%s cipher_suite=%s\n", ec.ConfigID, ec.KEM, ec.CipherSuite)
+		}
+		if result.ALPNMismatch != "" {
+			slog.Warn("negotiated protocol not advertised in the HTTPS record", "mismatch", result.ALPNMismatch)
+		}
+	}
+	if result.RemoteAddr != "" {
+		fmt.Printf("Remote address: %s\n", result.RemoteAddr)
+	}
+	if res := result.Resumption; res != nil {
+		switch {
+		case res.Error != "":
+			slog.Warn("resumption request failed", "error_class", res.ErrorClass, "error", res.Error)
+		case !res.DidResume:
+			slog.Warn("the server did not resume the TLS session")
+		case !res.ECHAccepted && result.ECHAccepted:
+			slog.Warn("ECH was not accepted on the resumed handshake")
+		}
+		fmt.Printf("Session resumed: %v (ECH accepted: %v, TLS handshake: %s)\n", res.DidResume, res.ECHAccepted, res.Timings.TLSHandshake)
+		if res.EarlyData {
+			fmt.Printf("Early data accepted: %v\n", res.EarlyDataAccepted)
+		}
+	}
+	if result.ECHRejected {
+		fmt.Printf("ECH rejected by server: retry_config_list len=%d\n", len(result.RetryConfigList))
+		return
+	}
+	printCertificates(result)
+	if result.HandshakeOnly {
+		fmt.Printf("TLS handshake: %s\n", result.Timings.TLSHandshake)
+		return
+	}
+	if result.WebSocket {
+		fmt.Printf("WebSocket upgrade: status %d\n", result.StatusCode)
+		return
+	}
+	fmt.Printf("Received reply: len=%d\n", result.BodyLength)
+	fmt.Printf("%s\n", string(result.Body))
+}
+
+// printCertificates prints the chain presented by the server and how it
+// verifies, warning when the leaf does not cover the host.
+func printCertificates(result *echclient.ProbeResult) {
+	for i, cert := range result.PeerCertificates {
+		fmt.Printf("Certificate %d: subject=%q issuer=%q key=%s not_after=%s sha256=%s\n", i, cert.Subject, cert.Issuer,
+			cert.KeyType, cert.NotAfter.Format(time.RFC3339), cert.SHA256)
+		fmt.Printf("  SPKI sha256: %s\n", cert.SPKISHA256)
+		if len(cert.DNSNames) > 0 {
+			fmt.Printf("  DNS names: %s\n", strings.Join(cert.DNSNames, ", "))
+		}
+	}
+	check := result.CertificateCheck
+	if check == nil {
+		return
+	}
+	if check.Verified {
+		fmt.Printf("Chain verified: true\n")
+	} else {
+		fmt.Printf("Chain verified: false (%s)\n", check.VerifyError)
+	}
+	switch {
+	case check.CoversHost:
+	case check.CoversPublicName:
+		slog.Warn("the certificate covers the public_name but not the host: the client-facing server answered, split mode may route to the wrong backend",
+			"public_name", check.PublicName)
+	default:
+		slog.Warn("the certificate does not cover the host")
+	}
+}
+
+// printClientHello prints the fields of a captured ClientHello that matter
+// for ECH, then the whole message as a hex dump.
+func printClientHello(label string, hello *echclient.ClientHelloInfo) {
+	if hello == nil {
+		return
+	}
+	fmt.Printf("%s: len=%d server_name=%q alpn=%s\n", label, len(hello.Raw), hello.ServerName, strings.Join(hello.ALPN, ","))
+	if ech := hello.ECH; ech != nil {
+		fmt.Printf("  encrypted_client_hello: config_id=%d cipher_suite=%s enc_len=%d payload_len=%d\n",
+			ech.ConfigID, ech.CipherSuite, ech.EncLength, ech.PayloadLength)
+	} else {
+		fmt.Printf("  encrypted_client_hello: absent\n")
+	}
+	if hello.PaddingLength > 0 {
+		fmt.Printf("  padding: %d bytes\n", hello.PaddingLength)
+	}
+	names := make([]string, len(hello.Extensions))
+	for i, ext := range hello.Extensions {
+		names[i] = fmt.Sprintf("%s(%d)", ext.Name, ext.Length)
+	}
+	fmt.Printf("  extensions: %s\n", strings.Join(names, " "))
+	fmt.Print(hex.Dump(hello.Raw))
+}
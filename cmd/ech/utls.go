@@ -0,0 +1,161 @@
+//go:build utls
+
+// This file links github.com/refraction-networking/utls, pinned in go.mod,
+// into the binary only when built with the utls tag:
+//
+//	go build -tags utls ./cmd/ech
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"slices"
+	"sync"
+
+	utls "github.com/refraction-networking/utls"
+)
+
+func init() {
+	tlsEngines["chrome"] = &utlsEngine{name: "chrome", id: utls.HelloChrome_Auto}
+	tlsEngines["firefox"] = &utlsEngine{name: "firefox", id: utls.HelloFirefox_Auto}
+}
+
+// utlsEngine is an echclient.TLSEngine parroting the ClientHello of a
+// browser with uTLS while offering the ECHConfigList of the probe. Whether
+// the uTLS release in use sends a real encrypted_client_hello extension in
+// place of the GREASE one of the browser preset is shown by
+// --dump-clienthello.
+type utlsEngine struct {
+	name string
+	id   utls.ClientHelloID
+
+	// sessions maps the crypto/tls ClientSessionCache of the probe to the
+	// uTLS one standing for it, as their session states differ.
+	sessions sync.Map
+}
+
+func (e *utlsEngine) String() string { return "utls-" + e.name }
+
+func (e *utlsEngine) Handshake(ctx context.Context, conn net.Conn, config *tls.Config) (net.Conn, tls.ConnectionState, error) {
+	spec, err := utls.UTLSIdToSpec(e.id)
+	if err != nil {
+		return nil, tls.ConnectionState{}, err
+	}
+	// Offer the protocols and versions the probe allows rather than the
+	// browser's.
+	for _, ext := range spec.Extensions {
+		switch ext := ext.(type) {
+		case *utls.ALPNExtension:
+			if config.NextProtos != nil {
+				ext.AlpnProtocols = config.NextProtos
+			}
+		case *utls.SupportedVersionsExtension:
+			ext.Versions = slices.DeleteFunc(ext.Versions, func(v uint16) bool {
+				return v != utls.GREASE_PLACEHOLDER && (config.MinVersion != 0 && v < config.MinVersion ||
+					config.MaxVersion != 0 && v > config.MaxVersion)
+			})
+		}
+	}
+	// uTLS takes the version range from the spec, not the config.
+	if config.MinVersion != 0 && spec.TLSVersMin != 0 {
+		spec.TLSVersMin = max(spec.TLSVersMin, config.MinVersion)
+	}
+	if config.MaxVersion != 0 && spec.TLSVersMax != 0 {
+		spec.TLSVersMax = min(spec.TLSVersMax, config.MaxVersion)
+	}
+	// As with crypto/tls, CipherSuites replaces the TLS 1.0-1.2 suites only.
+	if config.CipherSuites != nil {
+		var suites []uint16
+		for _, suite := range spec.CipherSuites {
+			switch suite {
+			case utls.GREASE_PLACEHOLDER, tls.TLS_AES_128_GCM_SHA256, tls.TLS_AES_256_GCM_SHA384, tls.TLS_CHACHA20_POLY1305_SHA256:
+				suites = append(suites, suite)
+			}
+		}
+		spec.CipherSuites = append(suites, config.CipherSuites...)
+	}
+	uconfig := &utls.Config{
+		ServerName:                     config.ServerName,
+		RootCAs:                        config.RootCAs,
+		InsecureSkipVerify:             config.InsecureSkipVerify,
+		NextProtos:                     config.NextProtos,
+		MinVersion:                     config.MinVersion,
+		MaxVersion:                     config.MaxVersion,
+		CipherSuites:                   config.CipherSuites,
+		EncryptedClientHelloConfigList: config.EncryptedClientHelloConfigList,
+	}
+	for _, cert := range config.Certificates {
+		uconfig.Certificates = append(uconfig.Certificates, utlsCertificate(&cert))
+	}
+	if config.GetClientCertificate != nil {
+		uconfig.GetClientCertificate = func(info *utls.CertificateRequestInfo) (*utls.Certificate, error) {
+			req := &tls.CertificateRequestInfo{AcceptableCAs: info.AcceptableCAs, Version: info.Version}
+			for _, scheme := range info.SignatureSchemes {
+				req.SignatureSchemes = append(req.SignatureSchemes, tls.SignatureScheme(scheme))
+			}
+			cert, err := config.GetClientCertificate(req)
+			if err != nil {
+				return nil, err
+			}
+			ucert := utlsCertificate(cert)
+			return &ucert, nil
+		}
+	}
+	if config.ClientSessionCache != nil {
+		cache, _ := e.sessions.LoadOrStore(config.ClientSessionCache, utls.NewLRUClientSessionCache(0))
+		uconfig.ClientSessionCache = cache.(utls.ClientSessionCache)
+		// uTLS resumes TLS 1.3 sessions only with a pre_shared_key
+		// extension, which browsers send last when they have a ticket
+		// and omit otherwise.
+		uconfig.OmitEmptyPsk = true
+		if !slices.ContainsFunc(spec.Extensions, func(ext utls.TLSExtension) bool {
+			_, ok := ext.(utls.PreSharedKeyExtension)
+			return ok
+		}) {
+			spec.Extensions = append(spec.Extensions, &utls.UtlsPreSharedKeyExtension{})
+		}
+	}
+	uconn := utls.UClient(conn, uconfig, utls.HelloCustom)
+	if err := uconn.ApplyPreset(&spec); err != nil {
+		return nil, tls.ConnectionState{}, err
+	}
+	err = uconn.HandshakeContext(ctx)
+	var echErr *utls.ECHRejectionError
+	if errors.As(err, &echErr) {
+		err = &tls.ECHRejectionError{RetryConfigList: echErr.RetryConfigList}
+	}
+	state := uconn.ConnectionState()
+	cs := tls.ConnectionState{
+		Version:            state.Version,
+		HandshakeComplete:  state.HandshakeComplete,
+		CipherSuite:        state.CipherSuite,
+		NegotiatedProtocol: state.NegotiatedProtocol,
+		DidResume:          state.DidResume,
+		ServerName:         state.ServerName,
+		PeerCertificates:   state.PeerCertificates,
+		VerifiedChains:     state.VerifiedChains,
+		ECHAccepted:        state.ECHAccepted,
+	}
+	if err == nil && config.VerifyConnection != nil {
+		err = config.VerifyConnection(cs)
+	}
+	return uconn, cs, err
+}
+
+// utlsCertificate converts a crypto/tls certificate to uTLS.
+func utlsCertificate(cert *tls.Certificate) utls.Certificate {
+	ucert := utls.Certificate{
+		Certificate:                 cert.Certificate,
+		PrivateKey:                  cert.PrivateKey,
+		OCSPStaple:                  cert.OCSPStaple,
+		SignedCertificateTimestamps: cert.SignedCertificateTimestamps,
+		Leaf:                        cert.Leaf,
+	}
+	for _, scheme := range cert.SupportedSignatureAlgorithms {
+		ucert.SupportedSignatureAlgorithms = append(ucert.SupportedSignatureAlgorithms, utls.SignatureScheme(scheme))
+	}
+	return ucert
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/hellais/ech/echclient"
+)
+
+// runXCheck compares the HTTPS RRset of a host across resolvers, exiting
+// with status 2 when they disagree.
+func runXCheck(ctx context.Context, args []string) {
+	var (
+		pf     probeFlags
+		output string
+	)
+	fs := flag.NewFlagSet("ech xcheck", flag.ExitOnError)
+	pf.register(fs)
+	// --resolver lists the resolvers to compare, all the known ones by
+	// default.
+	resolverFlag := fs.Lookup("resolver")
+	resolverFlag.DefValue = strings.Join(echclient.KnownResolverNames(), ",")
+	resolverFlag.Value.Set(resolverFlag.DefValue)
+	fs.StringVar(&output, "output", "text", "output format: text or json")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: ech xcheck [flags] host\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	host := fs.Arg(0)
+
+	cfg := pf.config()
+	result, err := cfg.CrossCheck(ctx, host, strings.Split(pf.resolver, ","))
+	if err != nil {
+		fatal("cross-check failed", "error", err)
+	}
+	switch output {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(result); err != nil {
+			fatal("failed to write output", "error", err)
+		}
+	case "text":
+		for _, a := range result.Answers {
+			if a.Error != "" {
+				fmt.Printf("%s: error: %s\n", a.Resolver, a.Error)
+				continue
+			}
+			fmt.Printf("%s: %d records\n", a.Resolver, len(a.HTTPSRecords))
+			for _, r := range a.HTTPSRecords {
+				params := make([]string, len(r.Params))
+				for i, p := range r.Params {
+					params[i] = p.String()
+				}
+				fmt.Printf("  %d %s %s\n", r.Priority, r.TargetName, strings.Join(params, " "))
+			}
+		}
+		for _, inc := range result.Inconsistencies {
+			fmt.Printf("inconsistent %s\n", inc)
+		}
+		if result.Consistent() {
+			fmt.Println("all resolvers agree")
+		}
+	default:
+		fatal("invalid output format", "output", output)
+	}
+	if !result.Consistent() {
+		os.Exit(2)
+	}
+}
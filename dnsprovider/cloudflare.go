@@ -0,0 +1,205 @@
+package dnsprovider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/hellais/ech/echclient"
+)
+
+// CloudflareAPI is the base URL of the Cloudflare API.
+const CloudflareAPI = "https://api.cloudflare.com/client/v4"
+
+// Cloudflare publishes records through the Cloudflare API with an API
+// token allowed to edit the DNS of the zone.
+type Cloudflare struct {
+	Token string
+
+	// Zone is the name of the zone. If empty, the longest suffix of the
+	// record name that is a zone of the account is used.
+	Zone string
+
+	// BaseURL overrides CloudflareAPI.
+	BaseURL string
+
+	Client *http.Client
+}
+
+func (p *Cloudflare) String() string {
+	return "cloudflare"
+}
+
+// cloudflareRecord is a DNS record as the API encodes it.
+type cloudflareRecord struct {
+	ID   string `json:"id,omitempty"`
+	Type string `json:"type"`
+	Name string `json:"name"`
+	TTL  uint32 `json:"ttl"`
+	Data struct {
+		Priority uint16 `json:"priority"`
+		Target   string `json:"target"`
+		Value    string `json:"value"`
+	} `json:"data"`
+}
+
+// do sends an API request and decodes the result of the response envelope
+// into result, if not nil.
+func (p *Cloudflare) do(ctx context.Context, method, path string, body, result any) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+	base := p.BaseURL
+	if base == "" {
+		base = CloudflareAPI
+	}
+	req, err := http.NewRequestWithContext(ctx, method, base+path, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.Token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var envelope struct {
+		Success bool `json:"success"`
+		Errors  []struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return fmt.Errorf("cloudflare: %s %s: HTTP %d: %w", method, path, resp.StatusCode, err)
+	}
+	if !envelope.Success {
+		msgs := make([]string, len(envelope.Errors))
+		for i, e := range envelope.Errors {
+			msgs[i] = fmt.Sprintf("%d %s", e.Code, e.Message)
+		}
+		return fmt.Errorf("cloudflare: %s %s: HTTP %d: %s", method, path, resp.StatusCode, strings.Join(msgs, "; "))
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(envelope.Result, result)
+}
+
+// zoneID returns the ID of the zone of name.
+func (p *Cloudflare) zoneID(ctx context.Context, name string) (string, error) {
+	candidates := []string{p.Zone}
+	if p.Zone == "" {
+		candidates = nil
+		labels := strings.Split(strings.TrimSuffix(name, "."), ".")
+		for i := range len(labels) - 1 {
+			candidates = append(candidates, strings.Join(labels[i:], "."))
+		}
+	}
+	for _, zone := range candidates {
+		var zones []struct {
+			ID string `json:"id"`
+		}
+		if err := p.do(ctx, http.MethodGet, "/zones?name="+url.QueryEscape(strings.TrimSuffix(zone, ".")), nil, &zones); err != nil {
+			return "", err
+		}
+		if len(zones) > 0 {
+			return zones[0].ID, nil
+		}
+	}
+	return "", fmt.Errorf("cloudflare: no zone found for %s", name)
+}
+
+// list returns the HTTPS records of name in the zone zoneID.
+func (p *Cloudflare) list(ctx context.Context, zoneID, name string) ([]cloudflareRecord, error) {
+	var records []cloudflareRecord
+	path := fmt.Sprintf("/zones/%s/dns_records?type=HTTPS&name=%s", zoneID, url.QueryEscape(strings.TrimSuffix(name, ".")))
+	err := p.do(ctx, http.MethodGet, path, nil, &records)
+	return records, err
+}
+
+func (r *cloudflareRecord) record() (*echclient.HttpsRecord, error) {
+	target := r.Data.Target
+	if !strings.HasSuffix(target, ".") {
+		target += "."
+	}
+	return echclient.ParseHttpsPresentation(fmt.Sprintf("%d %s %s", r.Data.Priority, target, r.Data.Value))
+}
+
+// Records implements Provider.
+func (p *Cloudflare) Records(ctx context.Context, name string) ([]*echclient.HttpsRecord, error) {
+	zoneID, err := p.zoneID(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	listed, err := p.list(ctx, zoneID, name)
+	if err != nil {
+		return nil, err
+	}
+	var records []*echclient.HttpsRecord
+	for _, r := range listed {
+		record, err := r.record()
+		if err != nil {
+			return nil, fmt.Errorf("cloudflare: record %s: %w", r.ID, err)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// Replace implements Provider. The new records are created before the old
+// ones are deleted, so that the name never goes without an HTTPS record.
+func (p *Cloudflare) Replace(ctx context.Context, name string, ttl uint32, records []*echclient.HttpsRecord) error {
+	zoneID, err := p.zoneID(ctx, name)
+	if err != nil {
+		return err
+	}
+	listed, err := p.list(ctx, zoneID, name)
+	if err != nil {
+		return err
+	}
+	var stale []cloudflareRecord
+	for _, r := range listed {
+		if record, err := r.record(); err != nil || r.TTL != ttl || !containsRecord(records, record) {
+			stale = append(stale, r)
+		}
+	}
+	for _, record := range records {
+		if slices.ContainsFunc(listed, func(r cloudflareRecord) bool {
+			existing, err := r.record()
+			return err == nil && r.TTL == ttl && containsRecord([]*echclient.HttpsRecord{existing}, record)
+		}) {
+			continue
+		}
+		var r cloudflareRecord
+		r.Type, r.Name, r.TTL = "HTTPS", strings.TrimSuffix(name, "."), ttl
+		r.Data.Priority, r.Data.Target = record.Priority, record.TargetName
+		params := make([]string, len(record.Params))
+		for i, param := range record.Params {
+			params[i] = param.String()
+		}
+		r.Data.Value = strings.Join(params, " ")
+		if err := p.do(ctx, http.MethodPost, "/zones/"+zoneID+"/dns_records", r, nil); err != nil {
+			return err
+		}
+	}
+	for _, r := range stale {
+		if err := p.do(ctx, http.MethodDelete, "/zones/"+zoneID+"/dns_records/"+r.ID, nil, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
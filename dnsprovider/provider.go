@@ -0,0 +1,135 @@
+// Package dnsprovider publishes HTTPS records through the APIs of DNS
+// providers, so that a freshly generated ECHConfigList reaches the zone
+// without editing it by hand.
+package dnsprovider
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+
+	"github.com/hellais/ech/echclient"
+)
+
+// Provider reads and replaces the HTTPS RRset of a name.
+type Provider interface {
+	// Records returns the HTTPS records of name.
+	Records(ctx context.Context, name string) ([]*echclient.HttpsRecord, error)
+
+	// Replace replaces the HTTPS RRset of name with records, published
+	// with ttl. An empty records removes the RRset.
+	Replace(ctx context.Context, name string, ttl uint32, records []*echclient.HttpsRecord) error
+
+	// String names the provider in logs.
+	String() string
+}
+
+// Names lists the providers accepted by Open.
+var Names = []string{"cloudflare", "route53", "rfc2136"}
+
+// Options configures the provider returned by Open. Credentials are read
+// from the environment: CLOUDFLARE_API_TOKEN for Cloudflare,
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN for
+// Route53, and TSIG_KEY for RFC 2136 when TSIGKey is empty.
+type Options struct {
+	// Zone is the zone holding the name: its name for Cloudflare and RFC
+	// 2136, its hosted zone ID for Route53. Cloudflare finds the zone of
+	// the name when it is empty.
+	Zone string
+
+	// Server is the host:port of the primary nameserver receiving RFC
+	// 2136 updates.
+	Server string
+
+	// TSIGKey signs RFC 2136 updates, as [algorithm:]name:base64secret
+	// like nsupdate -y; the algorithm defaults to hmac-sha256.
+	TSIGKey string
+
+	// HTTPClient sends the API requests. If nil, http.DefaultClient is
+	// used.
+	HTTPClient *http.Client
+}
+
+func (o Options) httpClient() *http.Client {
+	if o.HTTPClient == nil {
+		return http.DefaultClient
+	}
+	return o.HTTPClient
+}
+
+// Open returns the provider called name, one of Names.
+func Open(name string, opts Options) (Provider, error) {
+	switch name {
+	case "cloudflare":
+		token := os.Getenv("CLOUDFLARE_API_TOKEN")
+		if token == "" {
+			return nil, fmt.Errorf("cloudflare: CLOUDFLARE_API_TOKEN is not set")
+		}
+		return &Cloudflare{Token: token, Zone: opts.Zone, Client: opts.httpClient()}, nil
+	case "route53":
+		p := &Route53{
+			HostedZoneID:    opts.Zone,
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+			Client:          opts.httpClient(),
+		}
+		if p.HostedZoneID == "" {
+			return nil, fmt.Errorf("route53: the hosted zone ID is required")
+		}
+		if p.AccessKeyID == "" || p.SecretAccessKey == "" {
+			return nil, fmt.Errorf("route53: AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are not set")
+		}
+		return p, nil
+	case "rfc2136":
+		if opts.Server == "" || opts.Zone == "" {
+			return nil, fmt.Errorf("rfc2136: the server and the zone are required")
+		}
+		p := &RFC2136{Server: opts.Server, Zone: opts.Zone}
+		key := opts.TSIGKey
+		if key == "" {
+			key = os.Getenv("TSIG_KEY")
+		}
+		if key != "" {
+			var err error
+			if p.TSIG, err = ParseTSIGKey(key); err != nil {
+				return nil, err
+			}
+		}
+		return p, nil
+	}
+	return nil, fmt.Errorf("unknown DNS provider: %s", name)
+}
+
+// Diff returns the changes turning the HTTPS RRset current of name into
+// desired, as zone file lines prefixed by "-" for the records removed and
+// "+" for those added. Records are compared by RDATA, so reordered
+// SvcParams count as a change.
+func Diff(name string, ttl uint32, current, desired []*echclient.HttpsRecord) []string {
+	var lines []string
+	for _, r := range current {
+		if !containsRecord(desired, r) {
+			lines = append(lines, "- "+echclient.FormatRR(name, ttl, echclient.TypeHTTPS, r))
+		}
+	}
+	for _, r := range desired {
+		if !containsRecord(current, r) {
+			lines = append(lines, "+ "+echclient.FormatRR(name, ttl, echclient.TypeHTTPS, r))
+		}
+	}
+	return lines
+}
+
+func containsRecord(records []*echclient.HttpsRecord, r *echclient.HttpsRecord) bool {
+	rdata, err := r.Marshal()
+	if err != nil {
+		return false
+	}
+	return slices.ContainsFunc(records, func(other *echclient.HttpsRecord) bool {
+		b, err := other.Marshal()
+		return err == nil && bytes.Equal(b, rdata)
+	})
+}
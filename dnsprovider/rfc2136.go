@@ -0,0 +1,475 @@
+package dnsprovider
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/hellais/ech/echclient"
+	"golang.org/x/crypto/cryptobyte"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// RFC2136 publishes records with DNS UPDATE (RFC 2136) messages sent over
+// TCP to the primary nameserver of the zone, signed with TSIG (RFC 8945)
+// when a key is set.
+type RFC2136 struct {
+	// Server is the host:port of the primary nameserver.
+	Server string
+	Zone   string
+
+	// TSIG signs the updates, and the responses are then required to be
+	// signed with it too.
+	TSIG *TSIGKey
+}
+
+func (p *RFC2136) String() string {
+	return "rfc2136 " + p.Server
+}
+
+// TSIGKey is a shared secret signing DNS messages.
+type TSIGKey struct {
+	// Algorithm is hmac-sha1, hmac-sha256 or hmac-sha512.
+	Algorithm string
+	Name      string
+	Secret    []byte
+}
+
+var tsigAlgorithms = map[string]func() hash.Hash{
+	"hmac-sha1":   sha1.New,
+	"hmac-sha256": sha256.New,
+	"hmac-sha512": sha512.New,
+}
+
+// ParseTSIGKey parses a key given as [algorithm:]name:base64secret, the
+// format of nsupdate -y. The algorithm defaults to hmac-sha256.
+func ParseTSIGKey(s string) (*TSIGKey, error) {
+	parts := strings.Split(s, ":")
+	key := &TSIGKey{Algorithm: "hmac-sha256"}
+	switch len(parts) {
+	case 2:
+	case 3:
+		key.Algorithm = strings.ToLower(parts[0])
+		parts = parts[1:]
+	default:
+		return nil, fmt.Errorf("rfc2136: invalid TSIG key, want [algorithm:]name:secret")
+	}
+	if _, ok := tsigAlgorithms[key.Algorithm]; !ok {
+		return nil, fmt.Errorf("rfc2136: unsupported TSIG algorithm %s", key.Algorithm)
+	}
+	key.Name = parts[0]
+	secret, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("rfc2136: invalid TSIG secret: %w", err)
+	}
+	key.Secret = secret
+	return key, nil
+}
+
+// Records implements Provider by querying the server.
+func (p *RFC2136) Records(ctx context.Context, name string) ([]*echclient.HttpsRecord, error) {
+	qname, err := dnsmessage.NewName(fqdn(name))
+	if err != nil {
+		return nil, err
+	}
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: randomID()})
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(dnsmessage.Question{Name: qname, Type: dnsmessage.Type(echclient.TypeHTTPS), Class: dnsmessage.ClassINET}); err != nil {
+		return nil, err
+	}
+	msg, err := b.Finish()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.exchange(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+	var parser dnsmessage.Parser
+	hdr, err := parser.Start(resp)
+	if err != nil {
+		return nil, fmt.Errorf("rfc2136: %w", err)
+	}
+	if hdr.RCode != dnsmessage.RCodeSuccess && hdr.RCode != dnsmessage.RCodeNameError {
+		return nil, fmt.Errorf("rfc2136: query for %s: %s", name, hdr.RCode)
+	}
+	if err := parser.SkipAllQuestions(); err != nil {
+		return nil, fmt.Errorf("rfc2136: %w", err)
+	}
+	answers, err := parser.AllAnswers()
+	if err != nil {
+		return nil, fmt.Errorf("rfc2136: %w", err)
+	}
+	var records []*echclient.HttpsRecord
+	for _, rr := range answers {
+		u, ok := rr.Body.(*dnsmessage.UnknownResource)
+		if !ok || rr.Header.Type != dnsmessage.Type(echclient.TypeHTTPS) || !strings.EqualFold(rr.Header.Name.String(), fqdn(name)) {
+			continue
+		}
+		record, err := echclient.ParseHttpsRecord(u.Data)
+		if err != nil {
+			return nil, fmt.Errorf("rfc2136: %w", err)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// Replace implements Provider with a single UPDATE message deleting the
+// RRset and adding records, which the server applies atomically.
+func (p *RFC2136) Replace(ctx context.Context, name string, ttl uint32, records []*echclient.HttpsRecord) error {
+	msg, err := p.updateMessage(randomID(), name, ttl, records)
+	if err != nil {
+		return err
+	}
+	var mac []byte
+	if p.TSIG != nil {
+		if msg, mac, err = p.TSIG.sign(msg, nil, time.Now()); err != nil {
+			return err
+		}
+	}
+	resp, err := p.exchange(ctx, msg)
+	if err != nil {
+		return err
+	}
+	if p.TSIG != nil {
+		if err := p.TSIG.verify(resp, mac, time.Now()); err != nil {
+			return err
+		}
+	}
+	var parser dnsmessage.Parser
+	hdr, err := parser.Start(resp)
+	if err != nil {
+		return fmt.Errorf("rfc2136: %w", err)
+	}
+	if hdr.RCode != dnsmessage.RCodeSuccess {
+		return fmt.Errorf("rfc2136: update of %s refused: %s", name, hdr.RCode)
+	}
+	return nil
+}
+
+// updateMessage builds the UPDATE message with the given ID replacing the
+// HTTPS RRset of name with records.
+func (p *RFC2136) updateMessage(id uint16, name string, ttl uint32, records []*echclient.HttpsRecord) ([]byte, error) {
+	zone, err := dnsmessage.NewName(fqdn(p.Zone))
+	if err != nil {
+		return nil, err
+	}
+	owner, err := dnsmessage.NewName(fqdn(name))
+	if err != nil {
+		return nil, err
+	}
+	const opcodeUpdate = 5
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, OpCode: opcodeUpdate})
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	// The zone section.
+	if err := b.Question(dnsmessage.Question{Name: zone, Type: dnsmessage.TypeSOA, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, err
+	}
+	// The update section, in the authority section of the message.
+	if err := b.StartAuthorities(); err != nil {
+		return nil, err
+	}
+	if err := b.UnknownResource(dnsmessage.ResourceHeader{
+		Name:  owner,
+		Type:  dnsmessage.Type(echclient.TypeHTTPS),
+		Class: dnsmessage.ClassANY,
+	}, dnsmessage.UnknownResource{Type: dnsmessage.Type(echclient.TypeHTTPS)}); err != nil {
+		return nil, err
+	}
+	for _, r := range records {
+		rdata, err := r.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		if err := b.UnknownResource(dnsmessage.ResourceHeader{
+			Name:  owner,
+			Type:  dnsmessage.Type(echclient.TypeHTTPS),
+			Class: dnsmessage.ClassINET,
+			TTL:   ttl,
+		}, dnsmessage.UnknownResource{Type: dnsmessage.Type(echclient.TypeHTTPS), Data: rdata}); err != nil {
+			return nil, err
+		}
+	}
+	return b.Finish()
+}
+
+// exchange sends msg to the server over TCP and returns the response.
+func (p *RFC2136) exchange(ctx context.Context, msg []byte) ([]byte, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", p.Server)
+	if err != nil {
+		return nil, fmt.Errorf("rfc2136: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if _, err := conn.Write(binary.BigEndian.AppendUint16(nil, uint16(len(msg)))); err != nil {
+		return nil, fmt.Errorf("rfc2136: %w", err)
+	}
+	if _, err := conn.Write(msg); err != nil {
+		return nil, fmt.Errorf("rfc2136: %w", err)
+	}
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, fmt.Errorf("rfc2136: %w", err)
+	}
+	resp := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return nil, fmt.Errorf("rfc2136: %w", err)
+	}
+	if len(resp) < 2 || resp[0] != msg[0] || resp[1] != msg[1] {
+		return nil, fmt.Errorf("rfc2136: response ID mismatch")
+	}
+	return resp, nil
+}
+
+const typeTSIG = 250
+
+// tsigErrors names the TSIG error codes of RFC 8945, section 3.
+var tsigErrors = map[uint16]string{
+	16: "BADSIG",
+	17: "BADKEY",
+	18: "BADTIME",
+	22: "BADTRUNC",
+}
+
+// sign appends a TSIG record to msg, as in RFC 8945, section 4.3, and
+// returns the signed message and its MAC. A response is signed with the MAC
+// of the request, requestMAC.
+func (k *TSIGKey) sign(msg, requestMAC []byte, now time.Time) ([]byte, []byte, error) {
+	name, err := canonicalName(k.Name)
+	if err != nil {
+		return nil, nil, err
+	}
+	alg, err := canonicalName(k.Algorithm)
+	if err != nil {
+		return nil, nil, err
+	}
+	const fudge = 300
+	var timers []byte
+	timers = binary.BigEndian.AppendUint16(timers, uint16(now.Unix()>>32))
+	timers = binary.BigEndian.AppendUint32(timers, uint32(now.Unix()))
+	timers = binary.BigEndian.AppendUint16(timers, fudge)
+	sum, err := k.mac(requestMAC, msg, timers, 0, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var rdata []byte
+	rdata = append(rdata, alg...)
+	rdata = append(rdata, timers...)
+	rdata = binary.BigEndian.AppendUint16(rdata, uint16(len(sum)))
+	rdata = append(rdata, sum...)
+	rdata = append(rdata, msg[0], msg[1]) // original ID
+	rdata = append(rdata, 0, 0, 0, 0)     // error, other len
+
+	signed := append([]byte(nil), msg...)
+	signed = append(signed, name...)
+	signed = binary.BigEndian.AppendUint16(signed, typeTSIG)
+	signed = append(signed, 0, 255, 0, 0, 0, 0)
+	signed = binary.BigEndian.AppendUint16(signed, uint16(len(rdata)))
+	signed = append(signed, rdata...)
+	binary.BigEndian.PutUint16(signed[10:], binary.BigEndian.Uint16(signed[10:])+1)
+	return signed, sum, nil
+}
+
+// verify checks the TSIG record closing resp, the response to a request
+// whose MAC was requestMAC, as in RFC 8945, section 5.3.
+func (k *TSIGKey) verify(resp, requestMAC []byte, now time.Time) error {
+	start, owner, rdata, ok := tsigRecord(resp)
+	if !ok {
+		return fmt.Errorf("rfc2136: response is not signed with TSIG")
+	}
+	name, err := canonicalName(k.Name)
+	if err != nil {
+		return err
+	}
+	alg, err := canonicalName(k.Algorithm)
+	if err != nil {
+		return err
+	}
+	respAlg, ok := readName(rdata, 0)
+	if !ok || !bytes.Equal(owner, name) || !bytes.Equal(respAlg, alg) {
+		return fmt.Errorf("rfc2136: response is not signed with key %s", k.Name)
+	}
+	s := cryptobyte.String(rdata[len(respAlg):])
+	var timers []byte
+	var timeHigh, fudge, id, tsigErr uint16
+	var timeLow uint32
+	var mac, other cryptobyte.String
+	if !s.ReadBytes(&timers, 8) {
+		return fmt.Errorf("rfc2136: malformed response TSIG")
+	}
+	t := cryptobyte.String(timers)
+	if !t.ReadUint16(&timeHigh) || !t.ReadUint32(&timeLow) || !t.ReadUint16(&fudge) ||
+		!s.ReadUint16LengthPrefixed(&mac) || !s.ReadUint16(&id) || !s.ReadUint16(&tsigErr) ||
+		!s.ReadUint16LengthPrefixed(&other) || !s.Empty() {
+		return fmt.Errorf("rfc2136: malformed response TSIG")
+	}
+	if tsigErr != 0 {
+		return fmt.Errorf("rfc2136: response TSIG error %s (%d)", tsigErrors[tsigErr], tsigErr)
+	}
+	if signed := int64(timeHigh)<<32 | int64(timeLow); now.Unix() < signed-int64(fudge) || now.Unix() > signed+int64(fudge) {
+		return fmt.Errorf("rfc2136: response TSIG signed at %v, outside the fudge of %ds", time.Unix(signed, 0), fudge)
+	}
+	// The MAC covers the response as it was before the TSIG record was
+	// added.
+	unsigned := append([]byte(nil), resp[:start]...)
+	binary.BigEndian.PutUint16(unsigned, id)
+	binary.BigEndian.PutUint16(unsigned[10:], binary.BigEndian.Uint16(unsigned[10:])-1)
+	want, err := k.mac(requestMAC, unsigned, timers, tsigErr, other)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(mac, want) {
+		return fmt.Errorf("rfc2136: response TSIG does not verify")
+	}
+	return nil
+}
+
+// mac returns the MAC of msg and of the TSIG variables of RFC 8945, section
+// 4.3.3. The MAC of a response starts with that of the request, requestMAC.
+func (k *TSIGKey) mac(requestMAC, msg, timers []byte, tsigErr uint16, other []byte) ([]byte, error) {
+	newHash, ok := tsigAlgorithms[k.Algorithm]
+	if !ok {
+		return nil, fmt.Errorf("rfc2136: unsupported TSIG algorithm %s", k.Algorithm)
+	}
+	name, err := canonicalName(k.Name)
+	if err != nil {
+		return nil, err
+	}
+	alg, err := canonicalName(k.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(newHash, k.Secret)
+	if requestMAC != nil {
+		mac.Write(binary.BigEndian.AppendUint16(nil, uint16(len(requestMAC))))
+		mac.Write(requestMAC)
+	}
+	mac.Write(msg)
+	mac.Write(name)
+	mac.Write([]byte{0, 255, 0, 0, 0, 0}) // class ANY, TTL 0
+	mac.Write(alg)
+	mac.Write(timers)
+	mac.Write(binary.BigEndian.AppendUint16(nil, tsigErr))
+	mac.Write(binary.BigEndian.AppendUint16(nil, uint16(len(other))))
+	mac.Write(other)
+	return mac.Sum(nil), nil
+}
+
+// tsigRecord returns the offset at which the TSIG record closing msg
+// starts, and the canonical wire form of its owner name and its RDATA.
+func tsigRecord(msg []byte) (int, []byte, []byte, bool) {
+	if len(msg) < 12 || binary.BigEndian.Uint16(msg[10:]) == 0 {
+		return 0, nil, nil, false
+	}
+	off := 12
+	for range binary.BigEndian.Uint16(msg[4:]) {
+		var ok bool
+		if off, ok = skipName(msg, off); !ok || off+4 > len(msg) {
+			return 0, nil, nil, false
+		}
+		off += 4
+	}
+	count := int(binary.BigEndian.Uint16(msg[6:])) + int(binary.BigEndian.Uint16(msg[8:])) + int(binary.BigEndian.Uint16(msg[10:]))
+	for i := range count {
+		start := off
+		var ok bool
+		if off, ok = skipName(msg, off); !ok || off+10 > len(msg) {
+			return 0, nil, nil, false
+		}
+		typ, length := binary.BigEndian.Uint16(msg[off:]), int(binary.BigEndian.Uint16(msg[off+8:]))
+		off += 10
+		if off+length > len(msg) {
+			return 0, nil, nil, false
+		}
+		rdata := msg[off : off+length]
+		off += length
+		if i == count-1 {
+			owner, ok := readName(msg, start)
+			return start, owner, rdata, ok && typ == typeTSIG && off == len(msg)
+		}
+	}
+	return 0, nil, nil, false
+}
+
+// skipName returns the offset following the possibly compressed name at off
+// in msg.
+func skipName(msg []byte, off int) (int, bool) {
+	for off < len(msg) {
+		switch l := int(msg[off]); {
+		case l == 0:
+			return off + 1, true
+		case l&0xc0 == 0xc0:
+			return off + 2, off+2 <= len(msg)
+		case l&0xc0 != 0:
+			return 0, false
+		default:
+			off += 1 + l
+		}
+	}
+	return 0, false
+}
+
+// readName returns the lowercase, uncompressed wire form of the name at off
+// in msg.
+func readName(msg []byte, off int) ([]byte, bool) {
+	var name []byte
+	for pointers := 0; off < len(msg); {
+		switch l := int(msg[off]); {
+		case l == 0:
+			return append(name, 0), true
+		case l&0xc0 == 0xc0:
+			pointers++
+			if off+2 > len(msg) || pointers > 16 {
+				return nil, false
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3fff)
+		case l&0xc0 != 0 || off+1+l > len(msg):
+			return nil, false
+		default:
+			name = append(name, byte(l))
+			name = append(name, bytes.ToLower(msg[off+1:off+1+l])...)
+			off += 1 + l
+		}
+	}
+	return nil, false
+}
+
+// canonicalName returns the uncompressed, lowercase wire form of name.
+func canonicalName(name string) ([]byte, error) {
+	var b []byte
+	for _, label := range strings.Split(strings.TrimSuffix(strings.ToLower(name), "."), ".") {
+		if label == "" || len(label) > 63 {
+			return nil, fmt.Errorf("rfc2136: invalid name %q", name)
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0), nil
+}
+
+func randomID() uint16 {
+	var b [2]byte
+	rand.Read(b[:])
+	return binary.BigEndian.Uint16(b[:])
+}
@@ -0,0 +1,258 @@
+package dnsprovider
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hellais/ech/echclient"
+	"golang.org/x/crypto/cryptobyte"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// testTSIGKey is "secret key for testing", named update-key.
+const testTSIGKey = "update-key:c2VjcmV0IGtleSBmb3IgdGVzdGluZw=="
+
+// The messages below were signed by the TsigGenerate function of
+// github.com/miekg/dns with testTSIGKey: an HTTPS query for www.example.com
+// with ID 0x1234 at 1700000000, and with hmac-sha256 its empty answer at
+// 1700000100.
+const (
+	testQuery = "12340100000100000000000003777777076578616d706c6503636f6d0000410001"
+
+	testQuerySHA1 = "12340100000100000000000103777777076578616d706c6503636f6d0000410001" +
+		"0a7570646174652d6b65790000fa00ff00000000002f09686d61632d736861310000006553f100012c0014" +
+		"8c14b62273fa624639c22da1587ce70e3ba6e87e123400000000"
+	testQuerySHA256 = "12340100000100000000000103777777076578616d706c6503636f6d0000410001" +
+		"0a7570646174652d6b65790000fa00ff00000000003d0b686d61632d7368613235360000006553f100012c0020" +
+		"dd65ef848d885620444368c703b95df74dd7d358bd2c011e37f40d20f491fc24123400000000"
+	testQuerySHA512 = "12340100000100000000000103777777076578616d706c6503636f6d0000410001" +
+		"0a7570646174652d6b65790000fa00ff00000000005d0b686d61632d7368613531320000006553f100012c0040" +
+		"241bf10d6ecb431725ebc73c3bb3f56892cecb04a2739ec55c5c10940dc38bf2b787768a0d41dcaa11d9bb265a" +
+		"d379fa7380a8e695d30d2395cb4ab171d1ea53123400000000"
+	testResponseSHA256 = "12348100000100000000000103777777076578616d706c6503636f6d0000410001" +
+		"0a7570646174652d6b65790000fa00ff00000000003d0b686d61632d7368613235360000006553f164012c0020" +
+		"005f6424fbdd0628482ed69d3ff69844204a5b884ae56a01b8c808fdc492d79e123400000000"
+	testQueryMACSHA256 = "dd65ef848d885620444368c703b95df74dd7d358bd2c011e37f40d20f491fc24"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func testKey(t *testing.T, algorithm string) *TSIGKey {
+	t.Helper()
+	key, err := ParseTSIGKey(algorithm + ":" + testTSIGKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestTSIGSign(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		signed    string
+	}{
+		{"hmac-sha1", testQuerySHA1},
+		{"hmac-sha256", testQuerySHA256},
+		{"hmac-sha512", testQuerySHA512},
+	}
+	for _, tt := range tests {
+		signed, mac, err := testKey(t, tt.algorithm).sign(mustHex(t, testQuery), nil, time.Unix(1700000000, 0))
+		if err != nil {
+			t.Errorf("%s: sign() error = %v", tt.algorithm, err)
+			continue
+		}
+		if got := hex.EncodeToString(signed); got != tt.signed {
+			t.Errorf("%s: sign() = %s, want %s", tt.algorithm, got, tt.signed)
+		}
+		if !strings.Contains(tt.signed, hex.EncodeToString(mac)) {
+			t.Errorf("%s: sign() MAC = %x, not the one of the signed message", tt.algorithm, mac)
+		}
+	}
+}
+
+func TestTSIGVerify(t *testing.T) {
+	resp := mustHex(t, testResponseSHA256)
+	tampered := bytes.Clone(resp)
+	tampered[3] |= 0x80
+	other := testKey(t, "hmac-sha256")
+	other.Name = "other-key"
+	tests := []struct {
+		name       string
+		key        *TSIGKey
+		resp       []byte
+		requestMAC string
+		now        int64
+		wantErr    bool
+	}{
+		{"valid", testKey(t, "hmac-sha256"), resp, testQueryMACSHA256, 1700000100, false},
+		{"within the fudge", testKey(t, "hmac-sha256"), resp, testQueryMACSHA256, 1700000400, false},
+		{"outside the fudge", testKey(t, "hmac-sha256"), resp, testQueryMACSHA256, 1700000401, true},
+		{"other request", testKey(t, "hmac-sha256"), resp, testQueryMACSHA256[:62] + "00", 1700000100, true},
+		{"tampered", testKey(t, "hmac-sha256"), tampered, testQueryMACSHA256, 1700000100, true},
+		{"other key", other, resp, testQueryMACSHA256, 1700000100, true},
+		{"other algorithm", testKey(t, "hmac-sha512"), resp, testQueryMACSHA256, 1700000100, true},
+		{"unsigned", testKey(t, "hmac-sha256"), mustHex(t, testQuery), testQueryMACSHA256, 1700000100, true},
+	}
+	for _, tt := range tests {
+		err := tt.key.verify(tt.resp, mustHex(t, tt.requestMAC), time.Unix(tt.now, 0))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: verify() error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+// serveUpdate reads one UPDATE message from a connection accepted from ln,
+// passes it to check, and answers it with rcode, signed with key if it is
+// not nil.
+func serveUpdate(ln net.Listener, key *TSIGKey, rcode dnsmessage.RCode, check func([]byte) error) error {
+	conn, err := ln.Accept()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return err
+	}
+	req := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, req); err != nil {
+		return err
+	}
+	if err := check(req); err != nil {
+		return err
+	}
+	var p dnsmessage.Parser
+	h, err := p.Start(req)
+	if err != nil {
+		return err
+	}
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: h.ID, Response: true, OpCode: h.OpCode, RCode: rcode})
+	resp, err := b.Finish()
+	if err != nil {
+		return err
+	}
+	if key != nil {
+		_, _, rdata, ok := tsigRecord(req)
+		alg, _ := readName(rdata, 0)
+		s := cryptobyte.String(rdata[len(alg):])
+		var mac cryptobyte.String
+		if !ok || !s.Skip(8) || !s.ReadUint16LengthPrefixed(&mac) {
+			return fmt.Errorf("request is not signed")
+		}
+		if resp, _, err = key.sign(resp, mac, time.Now()); err != nil {
+			return err
+		}
+	}
+	_, err = conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(resp))), resp...))
+	return err
+}
+
+func TestRFC2136Replace(t *testing.T) {
+	var records []*echclient.HttpsRecord
+	for _, rdata := range [][]byte{
+		{0, 1, 0, 0, 1, 0, 3, 2, 'h', '2'},
+		{0, 2, 0, 0, 1, 0, 3, 2, 'h', '3'},
+	} {
+		r, err := echclient.ParseHttpsRecord(rdata)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, r)
+	}
+	// checkUpdate checks that the update deletes the HTTPS RRset of
+	// www.example.com before adding records to it.
+	checkUpdate := func(req []byte) error {
+		var p dnsmessage.Parser
+		h, err := p.Start(req)
+		if err != nil {
+			return err
+		}
+		if h.OpCode != 5 {
+			return fmt.Errorf("opcode = %d, want UPDATE", h.OpCode)
+		}
+		zone, err := p.AllQuestions()
+		if err != nil {
+			return err
+		}
+		if len(zone) != 1 || zone[0].Name.String() != "example.com." || zone[0].Type != dnsmessage.TypeSOA {
+			return fmt.Errorf("zone section = %v, want example.com. SOA", zone)
+		}
+		if err := p.SkipAllAnswers(); err != nil {
+			return err
+		}
+		updates, err := p.AllAuthorities()
+		if err != nil {
+			return err
+		}
+		if len(updates) != 1+len(records) {
+			return fmt.Errorf("update section has %d records, want %d", len(updates), 1+len(records))
+		}
+		for i, rr := range updates {
+			body, _ := rr.Body.(*dnsmessage.UnknownResource)
+			if rr.Header.Name.String() != "www.example.com." || rr.Header.Type != dnsmessage.Type(echclient.TypeHTTPS) || body == nil {
+				return fmt.Errorf("update %d = %v, want an HTTPS record of www.example.com.", i, rr)
+			}
+			if i == 0 {
+				if rr.Header.Class != dnsmessage.ClassANY || rr.Header.TTL != 0 || len(body.Data) != 0 {
+					return fmt.Errorf("update 0 = %v, want the deletion of the RRset", rr)
+				}
+				continue
+			}
+			want, err := records[i-1].Marshal()
+			if err != nil {
+				return err
+			}
+			if rr.Header.Class != dnsmessage.ClassINET || rr.Header.TTL != 300 || !bytes.Equal(body.Data, want) {
+				return fmt.Errorf("update %d = %v, want the addition of %x with TTL 300", i, rr, want)
+			}
+		}
+		return nil
+	}
+
+	key := testKey(t, "hmac-sha256")
+	tests := []struct {
+		name      string
+		clientKey *TSIGKey
+		serverKey *TSIGKey
+		rcode     dnsmessage.RCode
+		wantErr   bool
+	}{
+		{"unsigned", nil, nil, dnsmessage.RCodeSuccess, false},
+		{"signed", key, key, dnsmessage.RCodeSuccess, false},
+		{"unsigned response", key, nil, dnsmessage.RCodeSuccess, true},
+		{"refused", key, key, dnsmessage.RCodeRefused, true},
+	}
+	for _, tt := range tests {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		errc := make(chan error, 1)
+		go func() { errc <- serveUpdate(ln, tt.serverKey, tt.rcode, checkUpdate) }()
+		p := &RFC2136{Server: ln.Addr().String(), Zone: "example.com", TSIG: tt.clientKey}
+		err = p.Replace(context.Background(), "www.example.com", 300, records)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: Replace() error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if err := <-errc; err != nil {
+			t.Errorf("%s: server: %v", tt.name, err)
+		}
+		ln.Close()
+	}
+}
@@ -0,0 +1,248 @@
+package dnsprovider
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hellais/ech/echclient"
+)
+
+// Route53API is the endpoint of the Route53 API.
+const Route53API = "https://route53.amazonaws.com"
+
+// Route53 publishes records in a Route53 hosted zone, signing the requests
+// with AWS Signature Version 4.
+type Route53 struct {
+	HostedZoneID string
+
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+
+	// BaseURL overrides Route53API.
+	BaseURL string
+
+	Client *http.Client
+}
+
+func (p *Route53) String() string {
+	return "route53"
+}
+
+const route53Namespace = "https://route53.amazonaws.com/doc/2013-04-01/"
+
+type route53RRset struct {
+	Name            string   `xml:"Name"`
+	Type            string   `xml:"Type"`
+	TTL             uint32   `xml:"TTL"`
+	ResourceRecords []string `xml:"ResourceRecords>ResourceRecord>Value"`
+}
+
+type route53Change struct {
+	XMLName xml.Name `xml:"ChangeResourceRecordSetsRequest"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Changes []struct {
+		Action string       `xml:"Action"`
+		RRset  route53RRset `xml:"ResourceRecordSet"`
+	} `xml:"ChangeBatch>Changes>Change"`
+}
+
+// rrset returns the HTTPS RRset of name, or nil if there is none.
+func (p *Route53) rrset(ctx context.Context, name string) (*route53RRset, error) {
+	query := url.Values{"name": {fqdn(name)}, "type": {"HTTPS"}, "maxitems": {"1"}}
+	var resp struct {
+		RRsets []route53RRset `xml:"ResourceRecordSets>ResourceRecordSet"`
+	}
+	if err := p.do(ctx, http.MethodGet, "/rrset", query, nil, &resp); err != nil {
+		return nil, err
+	}
+	// The listing starts at the name, whatever it holds.
+	for _, rrset := range resp.RRsets {
+		if rrset.Type == "HTTPS" && strings.EqualFold(unescapeRoute53Name(rrset.Name), fqdn(name)) {
+			return &rrset, nil
+		}
+	}
+	return nil, nil
+}
+
+// unescapeRoute53Name undoes the octal escaping of the names Route53
+// returns, as in \052 for *.
+func unescapeRoute53Name(name string) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] == '\\' && i+3 < len(name) {
+			var c byte
+			if _, err := fmt.Sscanf(name[i+1:i+4], "%03o", &c); err == nil {
+				b.WriteByte(c)
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(name[i])
+	}
+	return b.String()
+}
+
+// Records implements Provider.
+func (p *Route53) Records(ctx context.Context, name string) ([]*echclient.HttpsRecord, error) {
+	rrset, err := p.rrset(ctx, name)
+	if err != nil || rrset == nil {
+		return nil, err
+	}
+	var records []*echclient.HttpsRecord
+	for _, value := range rrset.ResourceRecords {
+		record, err := echclient.ParseHttpsPresentation(value)
+		if err != nil {
+			return nil, fmt.Errorf("route53: %q: %w", value, err)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// Replace implements Provider with a single UPSERT, or a DELETE of the
+// current RRset when records is empty, which Route53 applies atomically.
+func (p *Route53) Replace(ctx context.Context, name string, ttl uint32, records []*echclient.HttpsRecord) error {
+	change := route53Change{Xmlns: route53Namespace}
+	change.Changes = make([]struct {
+		Action string       `xml:"Action"`
+		RRset  route53RRset `xml:"ResourceRecordSet"`
+	}, 1)
+	c := &change.Changes[0]
+	if len(records) == 0 {
+		current, err := p.rrset(ctx, name)
+		if err != nil || current == nil {
+			return err
+		}
+		c.Action, c.RRset = "DELETE", *current
+	} else {
+		c.Action = "UPSERT"
+		c.RRset = route53RRset{Name: fqdn(name), Type: "HTTPS", TTL: ttl}
+		for _, r := range records {
+			c.RRset.ResourceRecords = append(c.RRset.ResourceRecords, r.String())
+		}
+	}
+	body, err := xml.Marshal(change)
+	if err != nil {
+		return err
+	}
+	return p.do(ctx, http.MethodPost, "/rrset/", nil, body, nil)
+}
+
+// do sends a request for path under the hosted zone and decodes the XML
+// response into result, if not nil.
+func (p *Route53) do(ctx context.Context, method, path string, query url.Values, body []byte, result any) error {
+	base := p.BaseURL
+	if base == "" {
+		base = Route53API
+	}
+	u, err := url.Parse(base + "/2013-04-01/hostedzone/" + strings.TrimPrefix(p.HostedZoneID, "/hostedzone/") + path)
+	if err != nil {
+		return err
+	}
+	u.RawQuery = strings.ReplaceAll(query.Encode(), "+", "%20")
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/xml")
+	}
+	signV4(req, body, time.Now(), "us-east-1", "route53", p.AccessKeyID, p.SecretAccessKey, p.SessionToken)
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Code    string `xml:"Error>Code"`
+			Message string `xml:"Error>Message"`
+		}
+		xml.Unmarshal(data, &e)
+		return fmt.Errorf("route53: %s %s: HTTP %d: %s %s", method, path, resp.StatusCode, e.Code, e.Message)
+	}
+	if result == nil {
+		return nil
+	}
+	return xml.Unmarshal(data, result)
+}
+
+// signV4 adds the AWS Signature Version 4 headers to req for service in
+// region. Route53, a global service, is signed for us-east-1.
+func signV4(req *http.Request, body []byte, now time.Time, region, service, accessKeyID, secretAccessKey, sessionToken string) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	if sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(req.Header.Get(name))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, headers[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method, path, req.URL.RawQuery, canonicalHeaders.String(), signedHeaders, payloadHash,
+	}, "\n")
+	scope := strings.Join([]string{date, region, service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := []byte("AWS4" + secretAccessKey)
+	for _, s := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, s)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKeyID, scope, signedHeaders, signature))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// fqdn returns name with a trailing dot.
+func fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
@@ -0,0 +1,76 @@
+package echclient
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// DefaultALPN is the protocol supported by every HTTPS endpoint whose record
+// lacks the no-default-alpn SvcParam (RFC 9460, section 7.1.2).
+const DefaultALPN = "http/1.1"
+
+// EffectiveALPN returns the protocols supported by the endpoint: those of
+// the alpn SvcParam followed by DefaultALPN unless no-default-alpn is set
+// (RFC 9460, section 7.1.1).
+func (r *HttpsRecord) EffectiveALPN() []string {
+	protos := r.ALPN()
+	if !r.NoDefaultALPN() && !slices.Contains(protos, DefaultALPN) {
+		protos = append(protos, DefaultALPN)
+	}
+	return protos
+}
+
+// tcpProtocols are the protocols the probe speaks over TLS over TCP, in
+// order of preference.
+var tcpProtocols = []string{"h2", "http/1.1"}
+
+// selectProtocols returns the ALPN protocols offered to an endpoint
+// advertising the given ones: h2 and http/1.1 if it supports either, h3 if
+// that is all it supports, and both TCP ones when it advertises nothing
+// the probe speaks or is not known from an HTTPS record.
+func selectProtocols(advertised []string) []string {
+	var protos []string
+	for _, p := range tcpProtocols {
+		if slices.Contains(advertised, p) {
+			protos = append(protos, p)
+		}
+	}
+	switch {
+	case len(protos) > 0:
+		return protos
+	case slices.Contains(advertised, "h3"):
+		return []string{"h3"}
+	}
+	return tcpProtocols
+}
+
+// checkHTTPProtocols returns an error unless protos can be spoken by HTTP
+// probes: h3 alone, or any of the TCP protocols.
+func checkHTTPProtocols(protos []string) error {
+	if slices.Equal(protos, []string{"h3"}) {
+		return nil
+	}
+	for _, p := range protos {
+		if !slices.Contains(tcpProtocols, p) {
+			return fmt.Errorf("cannot offer ALPN %q to HTTP probes: h3 must be offered alone, otherwise only %s are spoken", p, strings.Join(tcpProtocols, " and "))
+		}
+	}
+	return nil
+}
+
+// alpnMismatch describes how the negotiated protocol disagrees with the
+// advertised ones, or returns an empty string if it does not.
+func alpnMismatch(advertised []string, negotiated string) string {
+	if advertised == nil {
+		return ""
+	}
+	if negotiated == "" {
+		// Without ALPN the server speaks HTTP/1.1.
+		negotiated = DefaultALPN
+	}
+	if slices.Contains(advertised, negotiated) {
+		return ""
+	}
+	return fmt.Sprintf("negotiated %s, advertised %s", negotiated, strings.Join(advertised, ","))
+}
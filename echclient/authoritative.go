@@ -0,0 +1,150 @@
+package echclient
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"sync"
+	"time"
+)
+
+// AuthoritativeResolver is a Resolver sending each query straight to the
+// authoritative nameservers of the zone the name belongs to, bypassing
+// recursive resolvers. The zones and their nameservers are discovered with
+// Config.
+type AuthoritativeResolver struct {
+	// Config performs the discovery of zones, NS sets and nameserver
+	// addresses.
+	Config *ProbeConfig
+
+	// Timeout bounds the query to each nameserver. Zero means no timeout
+	// beyond that of ctx.
+	Timeout time.Duration
+
+	// Logger receives the discovered nameservers. If nil, nothing is
+	// logged.
+	Logger *slog.Logger
+
+	mu    sync.Mutex
+	zones map[string]*FallbackResolver
+}
+
+func (r *AuthoritativeResolver) String() string {
+	return "authoritative"
+}
+
+// Query implements Resolver.
+func (r *AuthoritativeResolver) Query(ctx context.Context, name string, qtype RRType) (*DNSResponse, error) {
+	zone, err := r.Config.enclosingZone(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	servers, err := r.nameservers(ctx, zone)
+	if err != nil {
+		return nil, err
+	}
+	return servers.Query(ctx, name, qtype)
+}
+
+// nameservers returns a FallbackResolver over the authoritative
+// nameservers of zone.
+func (r *AuthoritativeResolver) nameservers(ctx context.Context, zone string) (*FallbackResolver, error) {
+	r.mu.Lock()
+	servers, ok := r.zones[zone]
+	r.mu.Unlock()
+	if ok {
+		return servers, nil
+	}
+
+	resp, err := r.Config.Query(ctx, zone, TypeNS)
+	if err != nil {
+		return nil, fmt.Errorf("%w for %s: %w", ErrNoAuthoritative, zone, err)
+	}
+	servers = &FallbackResolver{Timeout: r.Timeout, Logger: r.Logger}
+	for _, a := range resp.Answer {
+		if RRType(a.Type) != TypeNS || canonicalName(a.Name) != zone {
+			continue
+		}
+		addrs, err := r.Config.LookupAddrs(ctx, a.Data)
+		if err != nil {
+			if r.Logger != nil {
+				r.Logger.Debug("failed to resolve nameserver", "zone", zone, "ns", a.Data, "error", err)
+			}
+			continue
+		}
+		for _, addr := range addrs {
+			servers.Resolvers = append(servers.Resolvers, &Do53Resolver{
+				Addr:      net.JoinHostPort(addr.String(), Do53Port),
+				Timeout:   r.Timeout,
+				Logger:    r.Logger,
+				Randomize: r.Config.randomizeDo53(),
+			})
+		}
+	}
+	if len(servers.Resolvers) == 0 {
+		return nil, fmt.Errorf("%w for %s", ErrNoAuthoritative, zone)
+	}
+	if r.Logger != nil {
+		r.Logger.Debug("authoritative nameservers", "zone", zone, "servers", servers)
+	}
+
+	r.mu.Lock()
+	if r.zones == nil {
+		r.zones = make(map[string]*FallbackResolver)
+	}
+	r.zones[zone] = servers
+	r.mu.Unlock()
+	return servers, nil
+}
+
+// compareAuthoritative fetches the HTTPS RRset of r.QueryName from the
+// authoritative nameservers and records in r whether it differs from the
+// recursive answer, which failed with err if not nil.
+func (c *ProbeConfig) compareAuthoritative(ctx context.Context, r *ProbeResult, err error) {
+	auth := *c
+	auth.Resolver = &AuthoritativeResolver{
+		Config:  c,
+		Timeout: c.dnsTimeout(),
+		Logger:  c.logger(),
+	}
+	// The cache is keyed by name only and would return the recursive
+	// answers.
+	auth.Cache = nil
+	auth.ValidateDNSSEC = false
+	auth.Hooks = nil
+	parsed, authErr := auth.FetchECHConfigList(ctx, r.QueryName)
+	if parsed != nil {
+		r.AuthoritativeRecords = parsed.Endpoints
+		r.AuthoritativeAnsweredBy = parsed.Resolver
+	}
+	if authErr != nil {
+		r.AuthoritativeError = authErr.Error()
+		if !noECHInDNS(authErr) {
+			// The authoritative lookup itself failed; there is nothing
+			// to compare.
+			return
+		}
+	}
+	r.AuthoritativeDiffers = ClassifyError(authErr) != ClassifyError(err) ||
+		!sameRecords(r.HTTPSRecords, r.AuthoritativeRecords)
+	c.logger().Debug("compared with the authoritative answer", "name", r.QueryName,
+		"answered_by", r.AuthoritativeAnsweredBy, "differs", r.AuthoritativeDiffers)
+}
+
+// sameRecords reports whether a and b hold the same records, in any order.
+func sameRecords(a, b []*HttpsRecord) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	keys := func(records []*HttpsRecord) []string {
+		s := make([]string, len(records))
+		for i, r := range records {
+			s[i] = fmt.Sprint(r.Priority, r.TargetName, r.Params)
+		}
+		slices.Sort(s)
+		return s
+	}
+	return slices.Equal(keys(a), keys(b))
+}
@@ -0,0 +1,152 @@
+package echclient
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+)
+
+// BenchResult summarizes the queries Benchmark sent to one resolver.
+type BenchResult struct {
+	Resolver string `json:"resolver"`
+	Queries  int    `json:"queries"`
+	Errors   int    `json:"errors"`
+
+	// P50, P90 and P99 are percentiles of the round-trip time of the
+	// queries that were answered.
+	P50 time.Duration `json:"p50"`
+	P90 time.Duration `json:"p90"`
+	P99 time.Duration `json:"p99"`
+
+	// ECHDomains lists the domains whose HTTPS answer carried an ech
+	// SvcParam.
+	ECHDomains []string `json:"ech_domains,omitempty"`
+
+	// KEMs counts, by KEM name, the ECHDomains publishing a config with
+	// each KEM, including those crypto/tls cannot use.
+	KEMs map[string]int `json:"kems,omitempty"`
+}
+
+// ErrorRate returns the fraction of the queries that failed.
+func (r *BenchResult) ErrorRate() float64 {
+	if r.Queries == 0 {
+		return 0
+	}
+	return float64(r.Errors) / float64(r.Queries)
+}
+
+// benchTypes are the types queried for every domain by Benchmark.
+var benchTypes = []RRType{TypeHTTPS, TypeA, TypeAAAA}
+
+// Benchmark sends HTTPS, A and AAAA queries for each of domains, rounds
+// times, to each of resolverURLs and reports their latency and error rate.
+// The resolvers are benchmarked concurrently, each receiving one query at a
+// time. NXDOMAIN answers are not counted as errors.
+func (c *ProbeConfig) Benchmark(ctx context.Context, domains, resolverURLs []string, rounds int) ([]*BenchResult, error) {
+	resolvers, err := c.newResolvers(resolverURLs)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(domains))
+	for i, domain := range domains {
+		if names[i], err = ToASCIIHost(domain); err != nil {
+			return nil, err
+		}
+	}
+	results := make([]*BenchResult, len(resolvers))
+	var wg sync.WaitGroup
+	for i, resolver := range resolvers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var cfg ProbeConfig
+			if c != nil {
+				cfg = *c
+			}
+			cfg.Resolver = resolver
+			// Measure every query as sent, without caching or retries.
+			cfg.Cache = nil
+			cfg.Hooks = nil
+			cfg.DNSRetries = 0
+			results[i] = cfg.benchmark(ctx, names, rounds)
+		}()
+	}
+	wg.Wait()
+	return results, ctx.Err()
+}
+
+func (c *ProbeConfig) benchmark(ctx context.Context, domains []string, rounds int) *BenchResult {
+	result := &BenchResult{Resolver: c.Resolver.String()}
+	var rtts []time.Duration
+	ech := map[string]bool{}
+	kems := map[string]map[string]bool{}
+	for range max(rounds, 1) {
+		for _, domain := range domains {
+			for _, qtype := range benchTypes {
+				if ctx.Err() != nil {
+					break
+				}
+				start := time.Now()
+				resp, err := c.Query(ctx, canonicalName(domain), qtype)
+				rtt := time.Since(start)
+				result.Queries++
+				if err != nil && !errors.Is(err, ErrNXDomain) {
+					result.Errors++
+					c.logger().Debug("benchmark query failed", "resolver", result.Resolver, "name", domain, "type", qtype, "error", err)
+					continue
+				}
+				rtts = append(rtts, rtt)
+				if qtype == TypeHTTPS && resp != nil && !ech[domain] {
+					records, _ := parseSVCBRRset(resp, "", TypeHTTPS)
+					for _, r := range records {
+						if len(r.ECHConfigList()) == 0 {
+							continue
+						}
+						ech[domain] = true
+						configs, _ := ParseECHConfigList(r.ECHConfigList())
+						for _, ec := range configs {
+							if ec.KemID == 0 {
+								// A config of a draft whose layout is unknown.
+								continue
+							}
+							if kems[domain] == nil {
+								kems[domain] = map[string]bool{}
+							}
+							kems[domain][KEMName(ec.KemID)] = true
+						}
+					}
+				}
+			}
+		}
+	}
+	for _, domain := range domains {
+		if ech[domain] {
+			result.ECHDomains = append(result.ECHDomains, domain)
+			delete(ech, domain)
+		}
+		for kem := range kems[domain] {
+			if result.KEMs == nil {
+				result.KEMs = map[string]int{}
+			}
+			result.KEMs[kem]++
+		}
+		delete(kems, domain)
+	}
+	slices.Sort(rtts)
+	result.P50 = percentile(rtts, 50)
+	result.P90 = percentile(rtts, 90)
+	result.P99 = percentile(rtts, 99)
+	return result
+}
+
+// percentile returns the p-th percentile of the sorted durations, using the
+// nearest-rank method.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[max(rank, 1)-1]
+}
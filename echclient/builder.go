@@ -0,0 +1,119 @@
+package echclient
+
+import (
+	"errors"
+	"fmt"
+)
+
+// kemPublicKeySize is the size of an encoded public key (Npk) for each KEM.
+var kemPublicKeySize = map[uint16]int{
+	P256:   65,
+	P384:   97,
+	P521:   133,
+	X25519: 32,
+	X448:   56,
+
+	X25519Kyber768Draft00: 32 + 1184,
+	MLKEM512:              800,
+	MLKEM768:              1184,
+	MLKEM1024:             1568,
+	MLKEM768P256:          1184 + 65,
+	MLKEM1024P384:         1568 + 97,
+	XWing:                 1184 + 32,
+}
+
+// ECHConfigBuilder incrementally builds an ECHConfig. Setters return the
+// builder so calls can be chained; constraints are checked by Build.
+type ECHConfigBuilder struct {
+	config ECHConfig
+}
+
+// NewECHConfig returns a builder for a draft-ietf-tls-esni-18 ECHConfig.
+func NewECHConfig() *ECHConfigBuilder {
+	return &ECHConfigBuilder{
+		config: ECHConfig{Version: extensionEncryptedClientHello},
+	}
+}
+
+func (b *ECHConfigBuilder) ConfigID(id uint8) *ECHConfigBuilder {
+	b.config.ConfigID = id
+	return b
+}
+
+func (b *ECHConfigBuilder) KEM(kemID uint16) *ECHConfigBuilder {
+	b.config.KemID = kemID
+	return b
+}
+
+func (b *ECHConfigBuilder) PublicKey(pk []byte) *ECHConfigBuilder {
+	b.config.PublicKey = pk
+	return b
+}
+
+func (b *ECHConfigBuilder) AddCipher(kdfID, aeadID uint16) *ECHConfigBuilder {
+	b.config.SymmetricCipherSuite = append(b.config.SymmetricCipherSuite, ECHCipher{KDFID: kdfID, AEADID: aeadID})
+	return b
+}
+
+func (b *ECHConfigBuilder) MaxNameLength(n uint8) *ECHConfigBuilder {
+	b.config.MaxNameLength = n
+	return b
+}
+
+func (b *ECHConfigBuilder) PublicName(name string) *ECHConfigBuilder {
+	b.config.PublicName = []byte(name)
+	return b
+}
+
+func (b *ECHConfigBuilder) AddExtension(typ uint16, data []byte) *ECHConfigBuilder {
+	b.config.Extensions = append(b.config.Extensions, ECHExtension{Type: typ, Data: data})
+	return b
+}
+
+// Build validates the config and returns it.
+func (b *ECHConfigBuilder) Build() (ECHConfig, error) {
+	c := b.config
+	if c.KemID == 0 {
+		return ECHConfig{}, errors.New("echclient: ECHConfig has no KEM")
+	}
+	if size, ok := kemPublicKeySize[c.KemID]; ok && len(c.PublicKey) != size {
+		return ECHConfig{}, fmt.Errorf("echclient: public key for KEM %s must be %d bytes, got %d", KEMName(c.KemID), size, len(c.PublicKey))
+	}
+	if len(c.PublicKey) == 0 {
+		return ECHConfig{}, errors.New("echclient: ECHConfig has no public key")
+	}
+	if len(c.SymmetricCipherSuite) == 0 {
+		return ECHConfig{}, errors.New("echclient: ECHConfig has no cipher suites")
+	}
+	if !validDNSName(string(c.PublicName)) {
+		return ECHConfig{}, fmt.Errorf("echclient: invalid public_name %q", c.PublicName)
+	}
+	// No DNS name is longer than 253 octets.
+	if c.MaxNameLength > 253 {
+		return ECHConfig{}, fmt.Errorf("echclient: maximum_name_length %d exceeds the longest DNS name", c.MaxNameLength)
+	}
+	seen := make(map[uint16]bool)
+	for _, e := range c.Extensions {
+		if seen[e.Type] {
+			return ECHConfig{}, fmt.Errorf("echclient: duplicate extension 0x%04x", e.Type)
+		}
+		seen[e.Type] = true
+	}
+	raw, err := c.Marshal()
+	if err != nil {
+		return ECHConfig{}, err
+	}
+	c.raw = raw
+	c.Length = uint16(len(raw) - 4)
+	return c, nil
+}
+
+// BuildList builds the config and wraps it in a single element
+// ECHConfigList.
+func (b *ECHConfigBuilder) BuildList() (ECHConfigList, error) {
+	c, err := b.Build()
+	if err != nil {
+		return nil, err
+	}
+	return ECHConfigList{c}, nil
+}
@@ -0,0 +1,259 @@
+package echclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DNSCache is an in-memory cache of DNS responses keyed by name and type
+// that honours the TTLs of the answers. Expired responses are kept for
+// previousRetention so that the next answer can be compared with them (see
+// Previous). It is safe for concurrent use.
+type DNSCache struct {
+	mu      sync.Mutex
+	entries map[cacheKey]cacheEntry
+}
+
+type cacheKey struct {
+	name  string
+	qtype RRType
+}
+
+type cacheEntry struct {
+	resp    *DNSResponse
+	stored  time.Time
+	expires time.Time
+
+	// previous is the entry this one replaced, without its own previous.
+	previous *cacheEntry
+}
+
+// previousRetention is how long an expired response is kept to compare the
+// next answer with.
+const previousRetention = 30 * 24 * time.Hour
+
+// NewDNSCache returns an empty DNSCache.
+func NewDNSCache() *DNSCache {
+	return &DNSCache{entries: map[cacheKey]cacheEntry{}}
+}
+
+// Get returns a copy of the cached response for name and qtype with its
+// TTLs decremented by the time spent in the cache. Expired responses are
+// only returned by Stale.
+func (c *DNSCache) Get(name string, qtype RRType) (*DNSResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := cacheKey{canonicalName(name), qtype}
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	now := time.Now()
+	if !now.Before(entry.expires) {
+		if !now.Before(entry.expires.Add(previousRetention)) {
+			delete(c.entries, key)
+		}
+		return nil, false
+	}
+	age := int(now.Sub(entry.stored) / time.Second)
+	resp := *entry.resp
+	resp.Answer = slices.Clone(resp.Answer)
+	for i := range resp.Answer {
+		resp.Answer[i].TTL = max(resp.Answer[i].TTL-age, 0)
+	}
+	resp.Authority = slices.Clone(resp.Authority)
+	return &resp, true
+}
+
+// Stale returns a copy of the expired response for name and qtype that is
+// still retained, with TTLs of zero and Stale set, for callers to fall back
+// on when the resolver cannot be reached (RFC 8767).
+func (c *DNSCache) Stale(name string, qtype RRType) (*DNSResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[cacheKey{canonicalName(name), qtype}]
+	now := time.Now()
+	if !ok || now.Before(entry.expires) || !now.Before(entry.expires.Add(previousRetention)) {
+		return nil, false
+	}
+	resp := *entry.resp
+	resp.Answer = slices.Clone(resp.Answer)
+	for i := range resp.Answer {
+		resp.Answer[i].TTL = 0
+	}
+	resp.Authority = slices.Clone(resp.Authority)
+	resp.Stale = true
+	return &resp, true
+}
+
+// Put stores resp for the smallest TTL of its answers. Responses without
+// answers are cached for the TTL of the SOA record in their authority
+// section, if any, as negative answers (RFC 2308).
+func (c *DNSCache) Put(name string, qtype RRType, resp *DNSResponse) {
+	ttl, ok := responseTTL(resp)
+	if !ok || ttl <= 0 {
+		return
+	}
+	now := time.Now()
+	key := cacheKey{canonicalName(name), qtype}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := cacheEntry{
+		resp:    resp,
+		stored:  now,
+		expires: now.Add(time.Duration(ttl) * time.Second),
+	}
+	if old, ok := c.entries[key]; ok {
+		old.previous = nil
+		entry.previous = &old
+	}
+	c.entries[key] = entry
+}
+
+// Previous returns the response for name and qtype that the current one
+// replaced in the cache, expired or not, and when it was stored, so that
+// callers can tell what changed between the last two fetches.
+func (c *DNSCache) Previous(name string, qtype RRType) (*DNSResponse, time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[cacheKey{canonicalName(name), qtype}]
+	if !ok || entry.previous == nil {
+		return nil, time.Time{}, false
+	}
+	return entry.previous.resp, entry.previous.stored, true
+}
+
+func responseTTL(resp *DNSResponse) (int, bool) {
+	section := resp.Answer
+	if len(section) == 0 {
+		section = slices.DeleteFunc(slices.Clone(resp.Authority), func(a DNSAnswer) bool {
+			return RRType(a.Type) != TypeSOA
+		})
+	}
+	if len(section) == 0 {
+		return 0, false
+	}
+	ttl := section[0].TTL
+	for _, a := range section[1:] {
+		ttl = min(ttl, a.TTL)
+	}
+	return ttl, true
+}
+
+// cacheFileEntry is the JSON encoding of a cache entry in a cache file.
+type cacheFileEntry struct {
+	Name     string       `json:"name,omitempty"`
+	Type     RRType       `json:"type,omitempty"`
+	Resolver string       `json:"resolver,omitempty"`
+	Stored   time.Time    `json:"stored"`
+	Expires  time.Time    `json:"expires"`
+	Response *DNSResponse `json:"response"`
+
+	// Previous is the response this one replaced.
+	Previous *cacheFileEntry `json:"previous,omitempty"`
+}
+
+// LoadDNSCache returns a DNSCache holding the entries of the JSON file at
+// path, as written by Save, that have not been expired for longer than
+// previousRetention. A missing file yields an empty cache.
+func LoadDNSCache(path string) (*DNSCache, error) {
+	c := NewDNSCache()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []cacheFileEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("invalid DNS cache file %s: %w", path, err)
+	}
+	now := time.Now()
+	for _, e := range entries {
+		if e.Response == nil || !now.Before(e.Expires.Add(previousRetention)) {
+			continue
+		}
+		entry := e.entry()
+		if p := e.Previous; p != nil && p.Response != nil {
+			prev := p.entry()
+			entry.previous = &prev
+		}
+		c.entries[cacheKey{canonicalName(e.Name), e.Type}] = entry
+	}
+	return c, nil
+}
+
+func (e *cacheFileEntry) entry() cacheEntry {
+	e.Response.Resolver = e.Resolver
+	return cacheEntry{resp: e.Response, stored: e.Stored, expires: e.Expires}
+}
+
+// Save writes the entries of c that have not been expired for longer than
+// previousRetention to the JSON file at path, replacing it atomically.
+func (c *DNSCache) Save(path string) error {
+	now := time.Now()
+	c.mu.Lock()
+	entries := make([]cacheFileEntry, 0, len(c.entries))
+	for key, e := range c.entries {
+		if !now.Before(e.expires.Add(previousRetention)) {
+			continue
+		}
+		entry := cacheFileEntry{
+			Name:     key.name,
+			Type:     key.qtype,
+			Resolver: e.resp.Resolver,
+			Stored:   e.stored,
+			Expires:  e.expires,
+			Response: e.resp,
+		}
+		if p := e.previous; p != nil {
+			entry.Previous = &cacheFileEntry{
+				Resolver: p.resp.Resolver,
+				Stored:   p.stored,
+				Expires:  p.expires,
+				Response: p.resp,
+			}
+		}
+		entries = append(entries, entry)
+	}
+	c.mu.Unlock()
+	slices.SortFunc(entries, func(a, b cacheFileEntry) int {
+		if a.Name != b.Name {
+			return strings.Compare(a.Name, b.Name)
+		}
+		return int(a.Type) - int(b.Type)
+	})
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// writeFileAtomic replaces the file at path with data through a temporary
+// file in the same directory, so that readers never see it half written.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
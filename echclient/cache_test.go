@@ -0,0 +1,241 @@
+package echclient
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// ageCacheEntry moves the entry for name and qtype d into the past, as if
+// it had been stored d earlier.
+func ageCacheEntry(c *DNSCache, name string, qtype RRType, d time.Duration) {
+	key := cacheKey{canonicalName(name), qtype}
+	entry, ok := c.entries[key]
+	if !ok {
+		return
+	}
+	entry.stored = entry.stored.Add(-d)
+	entry.expires = entry.expires.Add(-d)
+	c.entries[key] = entry
+}
+
+func TestDNSCache(t *testing.T) {
+	soa := DNSAnswer{Name: "example.com.", Type: int(TypeSOA), TTL: 60, Data: "ns.example.com. hostmaster.example.com. 1 7200 3600 1209600 300"}
+	tests := []struct {
+		name     string
+		resp     *DNSResponse
+		age      time.Duration
+		wantHit  bool
+		wantTTLs []int
+	}{
+		{
+			name:     "fresh",
+			resp:     &DNSResponse{Answer: []DNSAnswer{{Name: "example.com.", Type: int(TypeHTTPS), TTL: 300, Data: "1 ."}}},
+			wantHit:  true,
+			wantTTLs: []int{300},
+		},
+		{
+			name: "decremented",
+			resp: &DNSResponse{Answer: []DNSAnswer{
+				{Name: "example.com.", Type: int(TypeHTTPS), TTL: 300, Data: "1 ."},
+				{Name: "example.com.", Type: int(TypeHTTPS), TTL: 600, Data: "2 ."},
+			}},
+			age:      100 * time.Second,
+			wantHit:  true,
+			wantTTLs: []int{200, 500},
+		},
+		{
+			name: "expired with the smallest TTL",
+			resp: &DNSResponse{Answer: []DNSAnswer{
+				{Name: "example.com.", Type: int(TypeHTTPS), TTL: 600, Data: "1 ."},
+				{Name: "example.com.", Type: int(TypeHTTPS), TTL: 30, Data: "2 ."},
+			}},
+			age: 30 * time.Second,
+		},
+		{
+			name:     "negative",
+			resp:     &DNSResponse{Authority: []DNSAnswer{soa}},
+			age:      59 * time.Second,
+			wantHit:  true,
+			wantTTLs: []int{},
+		},
+		{
+			name: "negative expired",
+			resp: &DNSResponse{Authority: []DNSAnswer{soa}},
+			age:  60 * time.Second,
+		},
+		{
+			name: "negative without SOA",
+			resp: &DNSResponse{Authority: []DNSAnswer{{Name: "example.com.", Type: int(TypeNS), TTL: 60, Data: "ns.example.com."}}},
+		},
+		{
+			name: "zero TTL",
+			resp: &DNSResponse{Answer: []DNSAnswer{{Name: "example.com.", Type: int(TypeHTTPS), TTL: 0, Data: "1 ."}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ttls []int
+			for _, a := range tt.resp.Answer {
+				ttls = append(ttls, a.TTL)
+			}
+			c := NewDNSCache()
+			c.Put("Example.COM", TypeHTTPS, tt.resp)
+			ageCacheEntry(c, "example.com", TypeHTTPS, tt.age)
+			got, ok := c.Get("example.com.", TypeHTTPS)
+			if ok != tt.wantHit {
+				t.Fatalf("Get() hit = %v, want %v", ok, tt.wantHit)
+			}
+			if !ok {
+				return
+			}
+			if len(got.Answer) != len(tt.wantTTLs) {
+				t.Fatalf("Get() = %d answers, want %d", len(got.Answer), len(tt.wantTTLs))
+			}
+			for i, a := range got.Answer {
+				if a.TTL != tt.wantTTLs[i] {
+					t.Errorf("Get() answer %d TTL = %d, want %d", i, a.TTL, tt.wantTTLs[i])
+				}
+			}
+			for i, a := range tt.resp.Answer {
+				if a.TTL != ttls[i] {
+					t.Errorf("Get() modified the TTLs of the stored response")
+				}
+			}
+			if _, ok := c.Get("example.com.", TypeSVCB); ok {
+				t.Errorf("Get() hit for another type")
+			}
+		})
+	}
+}
+
+func TestDNSCachePrevious(t *testing.T) {
+	c := NewDNSCache()
+	first := &DNSResponse{Answer: []DNSAnswer{{Name: "example.com.", Type: int(TypeHTTPS), TTL: 60, Data: "1 . alpn=h2"}}}
+	second := &DNSResponse{Answer: []DNSAnswer{{Name: "example.com.", Type: int(TypeHTTPS), TTL: 60, Data: "1 . alpn=h3"}}}
+	third := &DNSResponse{Answer: []DNSAnswer{{Name: "example.com.", Type: int(TypeHTTPS), TTL: 60, Data: "1 . alpn=h2,h3"}}}
+
+	c.Put("example.com", TypeHTTPS, first)
+	if _, _, ok := c.Previous("example.com", TypeHTTPS); ok {
+		t.Fatal("Previous() found a response before any replacement")
+	}
+	ageCacheEntry(c, "example.com", TypeHTTPS, time.Hour)
+	stored := c.entries[cacheKey{"example.com.", TypeHTTPS}].stored
+	c.Put("example.com", TypeHTTPS, second)
+	prev, when, ok := c.Previous("example.com", TypeHTTPS)
+	if !ok || prev != first || !when.Equal(stored) {
+		t.Fatalf("Previous() = %v, %v, %v, want the first response stored at %v", prev, when, ok, stored)
+	}
+
+	// Only the last replaced response is kept, and it survives the expiry
+	// of the current one.
+	c.Put("example.com", TypeHTTPS, third)
+	ageCacheEntry(c, "example.com", TypeHTTPS, time.Hour)
+	if _, ok := c.Get("example.com", TypeHTTPS); ok {
+		t.Fatal("Get() hit for an expired response")
+	}
+	if prev, _, ok := c.Previous("example.com", TypeHTTPS); !ok || prev != second {
+		t.Errorf("Previous() = %v, %v, want the second response", prev, ok)
+	}
+
+	ageCacheEntry(c, "example.com", TypeHTTPS, previousRetention)
+	c.Get("example.com", TypeHTTPS)
+	if _, _, ok := c.Previous("example.com", TypeHTTPS); ok {
+		t.Error("Previous() found a response expired for longer than previousRetention")
+	}
+}
+
+func TestDNSCacheSaveLoad(t *testing.T) {
+	c := NewDNSCache()
+	old := &DNSResponse{Answer: []DNSAnswer{{Name: "example.com.", Type: int(TypeHTTPS), TTL: 60, Data: "1 . alpn=h2"}}, Resolver: "https://dns.example/dns-query"}
+	resp := &DNSResponse{Answer: []DNSAnswer{{Name: "example.com.", Type: int(TypeHTTPS), TTL: 300, Data: "1 . alpn=h3"}}, Resolver: "https://dns.example/dns-query"}
+	negative := &DNSResponse{Status: 3, Authority: []DNSAnswer{{Name: "com.", Type: int(TypeSOA), TTL: 900, Data: "a.gtld-servers.net. nstld.verisign-grs.com. 1 1800 900 604800 900"}}}
+	c.Put("example.com", TypeHTTPS, old)
+	c.Put("example.com", TypeHTTPS, resp)
+	c.Put("missing.example.com", TypeHTTPS, negative)
+	c.Put("gone.example.com", TypeHTTPS, old)
+	ageCacheEntry(c, "gone.example.com", TypeHTTPS, previousRetention+time.Hour)
+
+	path := filepath.Join(t.TempDir(), "cache.json")
+	if err := c.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadDNSCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.entries) != 2 {
+		t.Errorf("LoadDNSCache() = %d entries, want 2", len(loaded.entries))
+	}
+	for name, want := range map[string]*DNSResponse{"example.com": resp, "missing.example.com": negative} {
+		got, ok := loaded.Get(name, TypeHTTPS)
+		if !ok {
+			t.Errorf("Get(%q) missed after LoadDNSCache()", name)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Get(%q) = %+v, want %+v", name, got, want)
+		}
+	}
+	prev, _, ok := loaded.Previous("example.com", TypeHTTPS)
+	if !ok || !reflect.DeepEqual(prev, old) {
+		t.Errorf("Previous() = %+v, %v, want %+v", prev, ok, old)
+	}
+
+	loaded, err = LoadDNSCache(filepath.Join(t.TempDir(), "missing.json"))
+	if err != nil || len(loaded.entries) != 0 {
+		t.Errorf("LoadDNSCache(missing file) = %d entries, %v, want an empty cache", len(loaded.entries), err)
+	}
+}
+
+// failingResolver fails every query with err.
+type failingResolver struct{ err error }
+
+func (r failingResolver) Query(context.Context, string, RRType) (*DNSResponse, error) {
+	return nil, r.err
+}
+
+func (r failingResolver) String() string { return "failing" }
+
+func TestQueryStale(t *testing.T) {
+	resp := &DNSResponse{Answer: []DNSAnswer{{Name: "example.com.", Type: int(TypeHTTPS), TTL: 60, Data: "1 . alpn=h2"}}}
+	tests := []struct {
+		name      string
+		err       error
+		age       time.Duration
+		wantStale bool
+	}{
+		{"SERVFAIL", &DNSStatusError{Name: "example.com.", Status: 2}, time.Hour, true},
+		{"timeout", context.DeadlineExceeded, time.Hour, true},
+		{"NXDOMAIN", &DNSStatusError{Name: "example.com.", Status: 3}, time.Hour, false},
+		{"canceled", context.Canceled, time.Hour, false},
+		{"too old", context.DeadlineExceeded, previousRetention + time.Hour, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := NewDNSCache()
+			cache.Put("example.com", TypeHTTPS, resp)
+			ageCacheEntry(cache, "example.com", TypeHTTPS, tt.age)
+			c := &ProbeConfig{Resolver: failingResolver{tt.err}, Cache: cache}
+			got, err := c.Query(context.Background(), "example.com", TypeHTTPS)
+			if !tt.wantStale {
+				if !errors.Is(err, tt.err) {
+					t.Errorf("Query() = %v, %v, want error %v", got, err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Query() error = %v, want the stale answer", err)
+			}
+			if !got.Stale || len(got.Answer) != 1 || got.Answer[0].Data != "1 . alpn=h2" || got.Answer[0].TTL != 0 {
+				t.Errorf("Query() = %+v, want the stale answer with a zero TTL", got)
+			}
+			if resp.Stale || resp.Answer[0].TTL != 60 {
+				t.Errorf("Query() modified the cached response")
+			}
+		})
+	}
+}
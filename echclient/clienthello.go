@@ -0,0 +1,224 @@
+package echclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// ClientHelloInfo is the decoded ClientHello a probe sent on the wire,
+// which with ECH is the ClientHelloOuter.
+type ClientHelloInfo struct {
+	// Raw is the handshake message, without the TLS record headers.
+	Raw []byte `json:"raw"`
+
+	// ServerName is the server_name extension, the public_name of the
+	// offered config when ECH is used.
+	ServerName string   `json:"server_name,omitempty"`
+	ALPN       []string `json:"alpn,omitempty"`
+
+	Extensions []ClientHelloExtension `json:"extensions"`
+
+	// ECH is the encrypted_client_hello extension, if present.
+	ECH *ClientHelloECH `json:"ech,omitempty"`
+
+	// PaddingLength is the length of the padding extension, if present.
+	PaddingLength int `json:"padding_length,omitempty"`
+}
+
+// ClientHelloExtension is an extension of a ClientHello, in the order sent.
+type ClientHelloExtension struct {
+	Type   uint16 `json:"type"`
+	Name   string `json:"name"`
+	Length int    `json:"length"`
+}
+
+// ClientHelloECH is the encrypted_client_hello extension of a
+// ClientHelloOuter.
+type ClientHelloECH struct {
+	CipherSuite ECHCipher `json:"cipher_suite"`
+	ConfigID    uint8     `json:"config_id"`
+
+	// EncLength is the length of the HPKE encapsulated key and
+	// PayloadLength that of the encrypted ClientHelloInner, AEAD tag
+	// included.
+	EncLength     int `json:"enc_length"`
+	PayloadLength int `json:"payload_length"`
+}
+
+// extensionNames are the names of the extensions shown by ParseClientHello.
+var extensionNames = map[uint16]string{
+	0:                             "server_name",
+	5:                             "status_request",
+	10:                            "supported_groups",
+	11:                            "ec_point_formats",
+	13:                            "signature_algorithms",
+	16:                            "application_layer_protocol_negotiation",
+	18:                            "signed_certificate_timestamp",
+	21:                            "padding",
+	23:                            "extended_master_secret",
+	27:                            "compress_certificate",
+	35:                            "session_ticket",
+	41:                            "pre_shared_key",
+	42:                            "early_data",
+	43:                            "supported_versions",
+	44:                            "cookie",
+	45:                            "psk_key_exchange_modes",
+	50:                            "signature_algorithms_cert",
+	51:                            "key_share",
+	17513:                         "application_settings",
+	0xff01:                        "renegotiation_info",
+	extensionEncryptedClientHello: "encrypted_client_hello",
+}
+
+// ExtensionName returns the name of a TLS extension, "grease" for the
+// reserved GREASE values (RFC 8701), or its hex identifier if unknown.
+func ExtensionName(typ uint16) string {
+	if name, ok := extensionNames[typ]; ok {
+		return name
+	}
+	if typ&0x0f0f == 0x0a0a && typ>>8 == typ&0xff {
+		return "grease"
+	}
+	return fmt.Sprintf("0x%04x", typ)
+}
+
+// ParseClientHello decodes the ClientHello carried by the TLS records in
+// data, the first flight of a client.
+func ParseClientHello(data []byte) (*ClientHelloInfo, error) {
+	msg, ok := firstHandshakeMessage(data)
+	if !ok {
+		return nil, errors.New("incomplete ClientHello")
+	}
+	info := &ClientHelloInfo{Raw: msg}
+	s := cryptobyte.String(msg)
+	var body, sessionID, suites, compression, extensions cryptobyte.String
+	var typ uint8
+	if !s.ReadUint8(&typ) || typ != 1 || !s.ReadUint24LengthPrefixed(&body) ||
+		!body.Skip(2+32) || !body.ReadUint8LengthPrefixed(&sessionID) ||
+		!body.ReadUint16LengthPrefixed(&suites) || !body.ReadUint8LengthPrefixed(&compression) ||
+		!body.ReadUint16LengthPrefixed(&extensions) {
+		return nil, errors.New("malformed ClientHello")
+	}
+	for !extensions.Empty() {
+		var typ uint16
+		var ext cryptobyte.String
+		if !extensions.ReadUint16(&typ) || !extensions.ReadUint16LengthPrefixed(&ext) {
+			return nil, errors.New("malformed ClientHello")
+		}
+		info.Extensions = append(info.Extensions, ClientHelloExtension{Type: typ, Name: ExtensionName(typ), Length: len(ext)})
+		switch typ {
+		case 0:
+			var list, name cryptobyte.String
+			var nameType uint8
+			if ext.ReadUint16LengthPrefixed(&list) && list.ReadUint8(&nameType) && nameType == 0 && list.ReadUint16LengthPrefixed(&name) {
+				info.ServerName = string(name)
+			}
+		case 16:
+			var list cryptobyte.String
+			if ext.ReadUint16LengthPrefixed(&list) {
+				for !list.Empty() {
+					var proto cryptobyte.String
+					if !list.ReadUint8LengthPrefixed(&proto) {
+						break
+					}
+					info.ALPN = append(info.ALPN, string(proto))
+				}
+			}
+		case 21:
+			info.PaddingLength = len(ext)
+		case extensionEncryptedClientHello:
+			var echType uint8
+			var ech ClientHelloECH
+			var enc, payload cryptobyte.String
+			if !ext.ReadUint8(&echType) || echType != 0 ||
+				!ext.ReadUint16(&ech.CipherSuite.KDFID) || !ext.ReadUint16(&ech.CipherSuite.AEADID) ||
+				!ext.ReadUint8(&ech.ConfigID) || !ext.ReadUint16LengthPrefixed(&enc) ||
+				!ext.ReadUint16LengthPrefixed(&payload) {
+				return nil, errors.New("malformed encrypted_client_hello extension")
+			}
+			ech.EncLength, ech.PayloadLength = len(enc), len(payload)
+			info.ECH = &ech
+		}
+	}
+	return info, nil
+}
+
+// firstHandshakeMessage returns the first handshake message carried by the
+// TLS records in data, and whether it is complete.
+func firstHandshakeMessage(data []byte) ([]byte, bool) {
+	var msg []byte
+	for len(data) >= 5 && data[0] == 22 {
+		n := int(data[3])<<8 | int(data[4])
+		if len(data) < 5+n {
+			return nil, false
+		}
+		msg = append(msg, data[5:5+n]...)
+		data = data[5+n:]
+		if len(msg) >= 4 {
+			if length := 4 + (int(msg[1])<<16 | int(msg[2])<<8 | int(msg[3])); len(msg) >= length {
+				return msg[:length], true
+			}
+		}
+	}
+	return nil, false
+}
+
+// recordClientHello returns a dialFunc connecting with dial, or the
+// default dialer if nil, that records in r the first ClientHello written
+// to the connections. Writes before it, such as a CONNECT request to the
+// proxy, are skipped.
+func (c *ProbeConfig) recordClientHello(r *ProbeResult, dial dialFunc) dialFunc {
+	if dial == nil {
+		var dialer net.Dialer
+		dial = dialer.DialContext
+	}
+	var once sync.Once
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &helloRecordingConn{Conn: conn, done: func(hello []byte) {
+			once.Do(func() {
+				info, err := ParseClientHello(hello)
+				if err != nil {
+					c.logger().Warn("failed to parse the ClientHello sent", "error", err)
+					return
+				}
+				r.ClientHello = info
+			})
+		}}, nil
+	}
+}
+
+// maxClientHelloRecords bounds the bytes helloRecordingConn buffers.
+const maxClientHelloRecords = 1 << 17
+
+// helloRecordingConn is a net.Conn passing the TLS records of the first
+// ClientHello written to it to done.
+type helloRecordingConn struct {
+	net.Conn
+	hello    []byte
+	recorded bool
+	done     func(hello []byte)
+}
+
+func (c *helloRecordingConn) Write(b []byte) (int, error) {
+	if !c.recorded && (len(c.hello) > 0 || len(b) > 0 && b[0] == 22) {
+		c.hello = append(c.hello, b...)
+		if _, ok := firstHandshakeMessage(c.hello); ok {
+			c.recorded = true
+			c.done(c.hello)
+			c.hello = nil
+		} else if len(c.hello) > maxClientHelloRecords {
+			c.recorded = true
+			c.hello = nil
+		}
+	}
+	return c.Conn.Write(b)
+}
@@ -0,0 +1,455 @@
+package echclient
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"time"
+)
+
+// DefaultResolverURL is the DoH JSON endpoint used when none is configured.
+const DefaultResolverURL = "https://cloudflare-dns.com/dns-query"
+
+// DefaultMaxAliasDepth is the number of AliasMode records followed when
+// ProbeConfig.MaxAliasDepth is zero.
+const DefaultMaxAliasDepth = 8
+
+// ProbeConfig configures DNS lookups and probes. A nil *ProbeConfig is valid
+// and uses the defaults.
+type ProbeConfig struct {
+	// ResolverURL is the DoH endpoint queried for HTTPS records, or the
+	// short name of one of KnownResolvers. If empty, DefaultResolverURL
+	// is used. It is ignored when Resolver is set.
+	ResolverURL string
+
+	// DoHMethod selects how ResolverURL is queried. If empty, the method
+	// of the known resolver or DoHJSON is used.
+	DoHMethod DoHMethod
+
+	// Resolver, if set, is used for every DNS query instead of
+	// ResolverURL. If nil, the resolver for ResolverURL is built on first
+	// use and kept for the life of the ProbeConfig; it only picks up a
+	// change of Proxy when another of its settings changes too.
+	Resolver Resolver
+
+	// ECHMode selects where the offered ECHConfigList comes from. If
+	// empty, ECHModeDNS is used.
+	ECHMode ECHMode
+
+	// ECHConfigList is the ECHConfigList offered in ECHModeStatic.
+	ECHConfigList []byte
+
+	// ConfigSource selects where ECHConfigLists are fetched from in
+	// ECHModeDNS. If empty, ConfigSourceDNS is used.
+	ConfigSource ConfigSource
+
+	// MaxAliasDepth bounds the number of AliasMode records followed. If
+	// zero, DefaultMaxAliasDepth is used.
+	MaxAliasDepth int
+
+	// EndpointIndex, if positive, forces the use of the ServiceMode HTTPS
+	// record at this 1-based position in SvcPriority order instead of the
+	// one with the lowest priority.
+	EndpointIndex int
+
+	// EndpointPolicy tunes the selection of the ServiceMode HTTPS records,
+	// for instance to skip those without a protocol the client speaks or
+	// to shuffle those of equal SvcPriority.
+	EndpointPolicy Policy
+
+	// ECHFallback selects what is offered when the lookup finds no
+	// ECHConfigList, for instance on NXDOMAIN or SERVFAIL, or none that
+	// crypto/tls can use. If empty, ECHFallbackNone is used.
+	ECHFallback ECHFallback
+
+	// MergeECHConfigs offers the distinct ECHConfigs published by all the
+	// usable HTTPS records of the host instead of those of the selected
+	// one only.
+	MergeECHConfigs bool
+
+	// PreferKEMs and PreferAEADs, HPKE identifiers in decreasing order of
+	// preference, choose the config offered when the ECHConfigList holds
+	// several that crypto/tls supports; see PreferECHConfig. By default
+	// crypto/tls uses the first one.
+	PreferKEMs  []uint16
+	PreferAEADs []uint16
+
+	// Strict makes any specification violation in the HTTPS records or the
+	// ECHConfigList an error. By default the violations that do not
+	// prevent decoding are only reported as warnings.
+	Strict bool
+
+	// DisableECHRetry disables the second attempt made with the
+	// server supplied retry configs when ECH is rejected.
+	DisableECHRetry bool
+
+	// ProbeEachConfig repeats the handshake offering each config of the
+	// ECHConfigList on its own, to find which config_ids the server
+	// accepts, for instance during a partial key rollout.
+	ProbeEachConfig bool
+
+	// ReportPadding adds to the result how crypto/tls pads the
+	// ClientHelloInner for the host and for names of other lengths.
+	ReportPadding bool
+
+	// HandshakeOnly stops probes after the TLS handshake, without an HTTP
+	// request, and reports the certificate chain of the server. It works
+	// with URLs of any scheme given a port, for TLS services other than
+	// HTTP behind the same client-facing servers, and never goes through
+	// the proxy.
+	HandshakeOnly bool
+
+	// Resume repeats successful probes on a new connection to check that
+	// the server resumes the TLS session and still accepts ECH. Sessions
+	// are cached in TLSConfig.ClientSessionCache, such as a SessionCache,
+	// or in memory for the probe if it is nil.
+	Resume bool
+
+	// EarlyData sends the request repeated by Resume as 0-RTT early data
+	// and reports whether the server accepted it. It implies Resume and
+	// only applies to HTTP/3: crypto/tls does not send early data over
+	// TCP.
+	EarlyData bool
+
+	// ALPN, if set, replaces the ALPN protocols offered, otherwise
+	// derived from the HTTPS record. HTTP probes accept h3 alone or h2 and
+	// http/1.1, offered exactly as given; handshake-only probes accept any
+	// protocol.
+	ALPN []string
+
+	// PinnedSPKI lists SHA-256 digests of SubjectPublicKeyInfo. If set,
+	// the handshakes of probes also fail with an SPKIPinError unless a
+	// certificate of a verified chain matches one, on top of the usual
+	// verification, which InsecureSkipVerify still disables; the
+	// certificates the server sent are then matched instead. The chains
+	// of rejected ECH handshakes, for the public_name, are not checked.
+	PinnedSPKI [][]byte
+
+	// CaptureClientHello records the ClientHello each probe attempt sends
+	// over TCP, the ClientHelloOuter with ECH, and decodes it into the
+	// result.
+	CaptureClientHello bool
+
+	// ECHConfigPins, if set, pins the first ECHConfigList fetched for each
+	// name and fails the probes that fetch another one with
+	// ErrECHConfigPinMismatch, or only logs them when PinWarnOnly is set.
+	ECHConfigPins *ECHConfigPins
+	PinWarnOnly   bool
+
+	// Cache, if set, stores the DNS answers for their TTL so that
+	// repeated lookups do not query the resolver again.
+	Cache *DNSCache
+
+	// ResolveAddrs resolves the A and AAAA records of the probed host
+	// with the configured resolver and connects to the addresses
+	// directly, so that the host name never reaches the system resolver.
+	ResolveAddrs bool
+
+	// Port, if non-zero, is the port the probe connects to, overriding
+	// the port SvcParam of the HTTPS record.
+	Port uint16
+
+	// ConnectIP, if valid, is the address the probe connects to instead
+	// of the host of the URL or the TargetName of the HTTPS record, to
+	// test a given anycast instance or a server not in DNS yet. The
+	// ECHConfigList is still fetched and the server name still sent for
+	// the host of the URL. UseHints and ResolveAddrs are ignored, and so
+	// is ConnectIP for connections through the proxy.
+	ConnectIP netip.Addr
+
+	// CompareAuthoritative also queries the HTTPS RRset from the
+	// authoritative nameservers of the zone and reports whether it
+	// differs from the answer of the resolver.
+	CompareAuthoritative bool
+
+	// UseHints connects to the ipv4hint and ipv6hint addresses of the
+	// HTTPS record, when it has some, and compares the outcome with a
+	// connection to the resolved addresses.
+	UseHints bool
+
+	// RandomizeDo53 randomizes the source port and query name case of
+	// unencrypted DNS queries and discards answers not echoing them, as
+	// described in Do53Resolver.Randomize.
+	RandomizeDo53 bool
+
+	// ValidateDNSSEC requests DNSSEC records with every query and
+	// validates the HTTPS RRset, reporting the outcome in the result.
+	ValidateDNSSEC bool
+
+	// DNSTimeout bounds each DNS query attempt. Zero means no timeout.
+	DNSTimeout time.Duration
+
+	// DNSRetries is the number of times a DNS query failing with a
+	// transport error or SERVFAIL is repeated.
+	DNSRetries int
+
+	// DNSBackoff is the delay before the first retry, doubled for every
+	// following one. If zero, DefaultDNSBackoff is used.
+	DNSBackoff time.Duration
+
+	// Timeout bounds the probe request, including the TLS handshake. Zero
+	// means no timeout.
+	Timeout time.Duration
+
+	// TLSConfig is cloned for probe connections. Its
+	// EncryptedClientHelloConfigList is always replaced.
+	TLSConfig *tls.Config
+
+	// TLSEngine, if set, performs the handshakes of probes instead of
+	// crypto/tls. Requests over its connections only offer HTTP/1.1,
+	// and connections through the proxy still use crypto/tls.
+	TLSEngine TLSEngine
+
+	// Proxy is used for both the DoH queries and the probe request, with
+	// the same semantics as http.Transport.Proxy.
+	Proxy func(*http.Request) (*url.URL, error)
+
+	// Logger receives structured logs of intermediate lookup and probe
+	// results. If nil, nothing is logged.
+	Logger *slog.Logger
+
+	// Hooks receive events as lookups and probes progress.
+	Hooks *Hooks
+
+	// Metrics receives counters and latencies. If nil, nothing is
+	// recorded.
+	Metrics Metrics
+}
+
+func (c *ProbeConfig) resolverURL() string {
+	if c == nil || c.ResolverURL == "" {
+		return DefaultResolverURL
+	}
+	return c.ResolverURL
+}
+
+func (c *ProbeConfig) dohMethod() DoHMethod {
+	if c == nil || c.DoHMethod == "" {
+		return DoHJSON
+	}
+	return c.DoHMethod
+}
+
+func (c *ProbeConfig) echMode() ECHMode {
+	if c == nil || c.ECHMode == "" {
+		return ECHModeDNS
+	}
+	return c.ECHMode
+}
+
+// staticECHConfigList returns ECHConfigList as if it had been fetched.
+func (c *ProbeConfig) staticECHConfigList(hostname string) (*ParsedEchConfig, error) {
+	if c == nil || len(c.ECHConfigList) == 0 {
+		return nil, fmt.Errorf("ECHModeStatic without an ECHConfigList for %s", hostname)
+	}
+	configs, err := ParseECHConfigList(c.ECHConfigList)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse echConfig: %w", err)
+	}
+	ech := &ParsedEchConfig{Raw: c.ECHConfigList, Configs: configs}
+	if ech.Warnings, err = c.checkConfigs(hostname, configs); err != nil {
+		return nil, err
+	}
+	ech.ConfigIDWarnings = configs.ConfigIDCollisions()
+	return ech, nil
+}
+
+func (c *ProbeConfig) configSource() ConfigSource {
+	if c == nil || c.ConfigSource == "" {
+		return ConfigSourceDNS
+	}
+	return c.ConfigSource
+}
+
+func (c *ProbeConfig) maxAliasDepth() int {
+	if c == nil || c.MaxAliasDepth <= 0 {
+		return DefaultMaxAliasDepth
+	}
+	return c.MaxAliasDepth
+}
+
+func (c *ProbeConfig) endpointIndex() int {
+	if c == nil {
+		return 0
+	}
+	return c.EndpointIndex
+}
+
+func (c *ProbeConfig) endpointPolicy() Policy {
+	if c == nil {
+		return Policy{}
+	}
+	return c.EndpointPolicy
+}
+
+func (c *ProbeConfig) echFallback() ECHFallback {
+	if c == nil || c.ECHFallback == "" {
+		return ECHFallbackNone
+	}
+	return c.ECHFallback
+}
+
+func (c *ProbeConfig) mergeECHConfigs() bool {
+	return c != nil && c.MergeECHConfigs
+}
+
+// preferECHConfig returns echConfigList reordered according to PreferKEMs
+// and PreferAEADs.
+func (c *ProbeConfig) preferECHConfig(echConfigList []byte) ([]byte, error) {
+	if c == nil || len(c.PreferKEMs) == 0 && len(c.PreferAEADs) == 0 {
+		return echConfigList, nil
+	}
+	return PreferECHConfig(echConfigList, c.PreferKEMs, c.PreferAEADs)
+}
+
+func (c *ProbeConfig) strict() bool {
+	return c != nil && c.Strict
+}
+
+func (c *ProbeConfig) disableECHRetry() bool {
+	return c != nil && c.DisableECHRetry
+}
+
+func (c *ProbeConfig) probeEachConfig() bool {
+	return c != nil && c.ProbeEachConfig
+}
+
+func (c *ProbeConfig) reportPadding() bool {
+	return c != nil && c.ReportPadding
+}
+
+func (c *ProbeConfig) handshakeOnly() bool {
+	return c != nil && c.HandshakeOnly
+}
+
+func (c *ProbeConfig) tlsEngine() TLSEngine {
+	if c == nil {
+		return nil
+	}
+	return c.TLSEngine
+}
+
+func (c *ProbeConfig) alpn() []string {
+	if c == nil {
+		return nil
+	}
+	return c.ALPN
+}
+
+func (c *ProbeConfig) pinnedSPKI() [][]byte {
+	if c == nil {
+		return nil
+	}
+	return c.PinnedSPKI
+}
+
+// offeredProtocols returns the ALPN protocols offered to an endpoint
+// advertising the given ones.
+func (c *ProbeConfig) offeredProtocols(advertised []string) []string {
+	if alpn := c.alpn(); alpn != nil {
+		return alpn
+	}
+	return selectProtocols(advertised)
+}
+
+func (c *ProbeConfig) resume() bool {
+	return c != nil && (c.Resume || c.EarlyData)
+}
+
+func (c *ProbeConfig) earlyData() bool {
+	return c != nil && c.EarlyData
+}
+
+func (c *ProbeConfig) captureClientHello() bool {
+	return c != nil && c.CaptureClientHello
+}
+
+func (c *ProbeConfig) cache() *DNSCache {
+	if c == nil {
+		return nil
+	}
+	return c.Cache
+}
+
+func (c *ProbeConfig) compareAuthoritativeNS() bool {
+	return c != nil && c.CompareAuthoritative
+}
+
+func (c *ProbeConfig) port() uint16 {
+	if c == nil {
+		return 0
+	}
+	return c.Port
+}
+
+func (c *ProbeConfig) connectIP() netip.Addr {
+	if c == nil {
+		return netip.Addr{}
+	}
+	return c.ConnectIP
+}
+
+func (c *ProbeConfig) useHints() bool {
+	return c != nil && c.UseHints
+}
+
+func (c *ProbeConfig) randomizeDo53() bool {
+	return c != nil && c.RandomizeDo53
+}
+
+func (c *ProbeConfig) validateDNSSEC() bool {
+	return c != nil && c.ValidateDNSSEC
+}
+
+func (c *ProbeConfig) dnsTimeout() time.Duration {
+	if c == nil {
+		return 0
+	}
+	return c.DNSTimeout
+}
+
+func (c *ProbeConfig) dnsRetries() int {
+	if c == nil {
+		return 0
+	}
+	return c.DNSRetries
+}
+
+func (c *ProbeConfig) timeout() time.Duration {
+	if c == nil {
+		return 0
+	}
+	return c.Timeout
+}
+
+func (c *ProbeConfig) proxy() func(*http.Request) (*url.URL, error) {
+	if c == nil {
+		return nil
+	}
+	return c.Proxy
+}
+
+// tlsConfig returns a copy of the configured TLS settings offering
+// echConfigList.
+func (c *ProbeConfig) tlsConfig(echConfigList []byte) *tls.Config {
+	var config *tls.Config
+	if c != nil && c.TLSConfig != nil {
+		config = c.TLSConfig.Clone()
+	} else {
+		config = &tls.Config{}
+	}
+	config.EncryptedClientHelloConfigList = echConfigList
+	return config
+}
+
+func (c *ProbeConfig) logger() *slog.Logger {
+	if c == nil || c.Logger == nil {
+		return discardLogger
+	}
+	return c.Logger
+}
+
+var discardLogger = slog.New(slog.DiscardHandler)
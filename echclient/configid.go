@@ -0,0 +1,127 @@
+package echclient
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConfigIDCollisions returns a warning for every config_id shared by several
+// configs of l. Servers pick the key to decrypt with by config_id, so the
+// ClientHellos encrypted to all but one of them are rejected; this usually
+// means a key rotation reused the config_id of the old key.
+func (l ECHConfigList) ConfigIDCollisions() []string {
+	var (
+		ids    []uint8
+		counts = map[uint8]int{}
+	)
+	for _, ec := range l {
+		if v, ok := LookupECHVersion(ec.Version); !ok || !v.ConfigID {
+			continue
+		}
+		if counts[ec.ConfigID] == 0 {
+			ids = append(ids, ec.ConfigID)
+		}
+		counts[ec.ConfigID]++
+	}
+	var warnings []string
+	for _, id := range ids {
+		if counts[id] > 1 {
+			warnings = append(warnings, fmt.Sprintf("config_id %d is shared by %d configs, servers cannot tell their keys apart", id, counts[id]))
+		}
+	}
+	return warnings
+}
+
+// ConfigIDChanges compares the configs of current with those of previous,
+// as published by an earlier fetch, and returns a warning for every
+// config_id that now carries another public key, which clients holding the
+// previous config get rejected with, and for a rotation that kept none of
+// the previous config_ids, which leaves those clients nothing to fall back
+// on but the retry configs.
+func ConfigIDChanges(previous, current ECHConfigList) []string {
+	keys := map[uint8][]byte{}
+	for _, ec := range previous {
+		if v, ok := LookupECHVersion(ec.Version); ok && v.ConfigID {
+			keys[ec.ConfigID] = ec.PublicKey
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	var (
+		warnings []string
+		ids      []uint8
+		kept     bool
+	)
+	for _, ec := range current {
+		if v, ok := LookupECHVersion(ec.Version); !ok || !v.ConfigID || slices.Contains(ids, ec.ConfigID) {
+			continue
+		}
+		ids = append(ids, ec.ConfigID)
+		key, ok := keys[ec.ConfigID]
+		switch {
+		case !ok:
+		case !bytes.Equal(key, ec.PublicKey):
+			warnings = append(warnings, fmt.Sprintf("config_id %d was reused for a new public key", ec.ConfigID))
+		default:
+			kept = true
+		}
+	}
+	if !kept && len(ids) > 0 && len(warnings) == 0 {
+		previousIDs := make([]uint8, 0, len(keys))
+		for id := range keys {
+			previousIDs = append(previousIDs, id)
+		}
+		slices.Sort(previousIDs)
+		warnings = append(warnings, fmt.Sprintf("config_id changed from %s to %s without overlap", formatConfigIDs(previousIDs), formatConfigIDs(ids)))
+	}
+	return warnings
+}
+
+func formatConfigIDs(ids []uint8) string {
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = strconv.Itoa(int(id))
+	}
+	return strings.Join(s, ", ")
+}
+
+// configIDWarnings returns the config_id collisions of configs and, when the
+// cache holds the HTTPS answer for owner that the current one replaced, the
+// config_id changes of lists since that answer.
+func (c *ProbeConfig) configIDWarnings(owner string, configs ECHConfigList, lists [][]byte) []string {
+	warnings := configs.ConfigIDCollisions()
+	cache := c.cache()
+	if cache == nil {
+		return warnings
+	}
+	resp, stored, ok := cache.Previous(owner, TypeHTTPS)
+	if !ok {
+		return warnings
+	}
+	records, err := parseSVCBRRset(resp, owner, TypeHTTPS)
+	if err != nil {
+		return warnings
+	}
+	previous := parseECHConfigLists(distinctECHConfigLists(records))
+	for _, w := range ConfigIDChanges(previous, parseECHConfigLists(lists)) {
+		warnings = append(warnings, fmt.Sprintf("%s since the answer of %s", w, stored.UTC().Format(time.RFC3339)))
+	}
+	return warnings
+}
+
+// parseECHConfigLists returns the configs of lists, skipping the lists that
+// cannot be parsed.
+func parseECHConfigLists(lists [][]byte) ECHConfigList {
+	var configs ECHConfigList
+	for _, list := range lists {
+		if l, err := ParseECHConfigList(list); err == nil {
+			configs = append(configs, l...)
+		}
+	}
+	return configs
+}
@@ -0,0 +1,248 @@
+package echclient
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// ECHConfigFormat is an encoding of an ECHConfigList used by a TLS stack or
+// tool, converted by EncodeECHConfigList and DecodeECHConfigList.
+type ECHConfigFormat string
+
+const (
+	// ECHConfigFormatAuto detects the encoding when decoding.
+	ECHConfigFormatAuto ECHConfigFormat = "auto"
+
+	// ECHConfigFormatWire is the binary ECHConfigList, as read by
+	// tls.Config.EncryptedClientHelloConfigList and bssl client
+	// -ech-config-list.
+	ECHConfigFormatWire ECHConfigFormat = "wire"
+
+	// ECHConfigFormatBase64 is the ECHConfigList in base64, as in the ech
+	// SvcParam of the presentation format.
+	ECHConfigFormatBase64 ECHConfigFormat = "base64"
+
+	// ECHConfigFormatHex is the ECHConfigList in hex.
+	ECHConfigFormatHex ECHConfigFormat = "hex"
+
+	// ECHConfigFormatPEM is an ECHCONFIG PEM block, as in the ECH key
+	// files of OpenSSL.
+	ECHConfigFormatPEM ECHConfigFormat = "pem"
+
+	// ECHConfigFormatFirefox is the base64 string of Firefox's
+	// network.dns.echconfig pref. Values pasted with their quotes are
+	// accepted.
+	ECHConfigFormatFirefox ECHConfigFormat = "firefox"
+
+	// ECHConfigFormatNSS is the base64 ECHConfigList given to the NSS test
+	// tools, as in tstclnt -N.
+	ECHConfigFormatNSS ECHConfigFormat = "nss"
+
+	// ECHConfigFormatBoringSSL is a single binary ECHConfig without the
+	// list length, as read by bssl server -ech-config.
+	ECHConfigFormatBoringSSL ECHConfigFormat = "boringssl"
+
+	// ECHConfigFormatC is the ECHConfigList as the body of a C byte array,
+	// as embedded in the unit tests of BoringSSL and NSS.
+	ECHConfigFormatC ECHConfigFormat = "c"
+)
+
+// ECHConfigFormats lists the formats EncodeECHConfigList supports.
+var ECHConfigFormats = []ECHConfigFormat{
+	ECHConfigFormatWire,
+	ECHConfigFormatBase64,
+	ECHConfigFormatHex,
+	ECHConfigFormatPEM,
+	ECHConfigFormatFirefox,
+	ECHConfigFormatNSS,
+	ECHConfigFormatBoringSSL,
+	ECHConfigFormatC,
+}
+
+// EncodeECHConfigList encodes the ECHConfigList echConfigList in format.
+// ECHConfigFormatBoringSSL requires a list holding a single config; see
+// SelectECHConfig.
+func EncodeECHConfigList(echConfigList []byte, format ECHConfigFormat) ([]byte, error) {
+	configs, err := ParseECHConfigList(echConfigList)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case ECHConfigFormatWire:
+		return bytes.Clone(echConfigList), nil
+	case ECHConfigFormatBase64, ECHConfigFormatFirefox, ECHConfigFormatNSS:
+		return []byte(base64.StdEncoding.EncodeToString(echConfigList) + "\n"), nil
+	case ECHConfigFormatHex:
+		return []byte(hex.EncodeToString(echConfigList) + "\n"), nil
+	case ECHConfigFormatPEM:
+		return pem.EncodeToMemory(&pem.Block{Type: "ECHCONFIG", Bytes: echConfigList}), nil
+	case ECHConfigFormatBoringSSL:
+		if len(configs) != 1 {
+			return nil, fmt.Errorf("the boringssl format holds a single ECHConfig, the list has %d", len(configs))
+		}
+		return bytes.Clone(configs[0].Raw()), nil
+	case ECHConfigFormatC:
+		var b strings.Builder
+		for i, c := range echConfigList {
+			switch {
+			case i == 0:
+			case i%12 == 0:
+				b.WriteString(",\n")
+			default:
+				b.WriteString(", ")
+			}
+			fmt.Fprintf(&b, "0x%02x", c)
+		}
+		b.WriteString("\n")
+		return []byte(b.String()), nil
+	}
+	return nil, fmt.Errorf("unknown ECHConfig format %q", format)
+}
+
+// DecodeECHConfigList decodes an ECHConfigList encoded in format. In
+// ECHConfigFormatAuto, PEM blocks, C arrays and binary input are told apart
+// from hex and base64 text, and a single ECHConfig is wrapped into a list.
+// The configs themselves are not validated; see ParseECHConfigList.
+func DecodeECHConfigList(data []byte, format ECHConfigFormat) ([]byte, error) {
+	if format == ECHConfigFormatAuto {
+		text := string(data)
+		switch {
+		case strings.Contains(text, "-----BEGIN"):
+			format = ECHConfigFormatPEM
+		case strings.Contains(text, "0x") && strings.Contains(text, ","):
+			format = ECHConfigFormatC
+		case !isText(data):
+			return wrapSingleECHConfig(data), nil
+		default:
+			raw, err := hex.DecodeString(compactText(text))
+			if err != nil {
+				if raw, err = decodeBase64(text); err != nil {
+					return nil, err
+				}
+			}
+			return wrapSingleECHConfig(raw), nil
+		}
+	}
+	switch format {
+	case ECHConfigFormatWire:
+		return bytes.Clone(data), nil
+	case ECHConfigFormatBase64, ECHConfigFormatFirefox, ECHConfigFormatNSS:
+		return decodeBase64(string(data))
+	case ECHConfigFormatHex:
+		return hex.DecodeString(strings.ReplaceAll(compactText(string(data)), ":", ""))
+	case ECHConfigFormatPEM:
+		for rest := data; ; {
+			var block *pem.Block
+			if block, rest = pem.Decode(rest); block == nil {
+				return nil, errors.New("no ECHCONFIG PEM block")
+			}
+			if block.Type == "ECHCONFIG" {
+				return block.Bytes, nil
+			}
+		}
+	case ECHConfigFormatBoringSSL:
+		if configs, err := ParseECHConfigList(wrapECHConfig(data)); err != nil || len(configs) != 1 {
+			return nil, fmt.Errorf("%w: not a single ECHConfig", ErrMalformedECHConfig)
+		}
+		return wrapECHConfig(data), nil
+	case ECHConfigFormatC:
+		var raw []byte
+		for _, field := range strings.FieldsFunc(string(data), func(r rune) bool {
+			return strings.ContainsRune(" \t\r\n,{};", r)
+		}) {
+			digits, ok := strings.CutPrefix(strings.ToLower(field), "0x")
+			if !ok {
+				continue
+			}
+			b, err := strconv.ParseUint(digits, 16, 8)
+			if err != nil {
+				return nil, fmt.Errorf("invalid byte %q", field)
+			}
+			raw = append(raw, byte(b))
+		}
+		if raw == nil {
+			return nil, errors.New("no 0x bytes in C array")
+		}
+		return raw, nil
+	}
+	return nil, fmt.Errorf("unknown ECHConfig format %q", format)
+}
+
+// SelectECHConfig returns an ECHConfigList holding only the config of
+// echConfigList with configID, byte for byte.
+func SelectECHConfig(echConfigList []byte, configID uint8) ([]byte, error) {
+	configs, err := ParseECHConfigList(echConfigList)
+	if err != nil {
+		return nil, err
+	}
+	for i := range configs {
+		if configs[i].Version == extensionEncryptedClientHello && configs[i].ConfigID == configID {
+			return wrapECHConfig(configs[i].Raw()), nil
+		}
+	}
+	return nil, fmt.Errorf("no config with config_id %d", configID)
+}
+
+// wrapSingleECHConfig returns raw prefixed with its length when it is a
+// single ECHConfig rather than a list, and raw otherwise.
+func wrapSingleECHConfig(raw []byte) []byte {
+	if _, err := ParseECHConfigList(raw); err == nil {
+		return raw
+	}
+	if configs, err := ParseECHConfigList(wrapECHConfig(raw)); err == nil && len(configs) == 1 {
+		return wrapECHConfig(raw)
+	}
+	return raw
+}
+
+// wrapECHConfig prefixes the encoded ECHConfigs in raw with their length,
+// returning nil when raw is too long for a list.
+func wrapECHConfig(raw []byte) []byte {
+	var b cryptobyte.Builder
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(raw)
+	})
+	list, err := b.Bytes()
+	if err != nil {
+		return nil
+	}
+	return list
+}
+
+// decodeBase64 decodes s in standard or URL base64, with or without
+// padding, ignoring white space and surrounding quotes.
+func decodeBase64(s string) ([]byte, error) {
+	compact := strings.Trim(compactText(s), `"'`)
+	raw, err := base64.StdEncoding.DecodeString(compact)
+	if err != nil {
+		raw, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(compact, "="))
+	}
+	return raw, err
+}
+
+// compactText removes the white space of s.
+func compactText(s string) string {
+	return strings.Join(strings.Fields(s), "")
+}
+
+// isText reports whether data is printable UTF-8, as the text formats are.
+func isText(data []byte) bool {
+	if !utf8.Valid(data) {
+		return false
+	}
+	for _, r := range string(data) {
+		if r < ' ' && r != '\n' && r != '\r' && r != '\t' {
+			return false
+		}
+	}
+	return true
+}
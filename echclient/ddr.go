@@ -0,0 +1,249 @@
+package echclient
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DDRResolverName selects Discovery of Designated Resolvers wherever a
+// resolver URL is accepted.
+const DDRResolverName = "ddr"
+
+// DDRQueryName is the special use name queried for designated resolvers
+// (RFC 9462 section 4).
+const DDRQueryName = "_dns.resolver.arpa"
+
+// DesignatedResolver is an encrypted resolver advertised by an unencrypted
+// one in a _dns.resolver.arpa SVCB record.
+type DesignatedResolver struct {
+	// Record is the SVCB record advertising the resolver.
+	Record *HttpsRecord
+
+	// URL is the https://, tls:// or quic:// URL of the resolver.
+	URL string
+
+	// Bootstrap is the address of the unencrypted resolver that
+	// advertised it.
+	Bootstrap netip.Addr
+}
+
+// DDRResolver is a Resolver discovering the encrypted equivalent of the
+// system resolvers with DDR (RFC 9462) on first use and sending every query
+// to it. Discovery is verified: the certificate of the designated resolver
+// must cover the address of the unencrypted resolver, unless that address
+// is private, in which case discovery is opportunistic.
+type DDRResolver struct {
+	// Bootstrap lists the unencrypted resolvers asked for designated
+	// resolvers, in order.
+	Bootstrap []*Do53Resolver
+
+	config *ProbeConfig
+
+	mu         sync.Mutex
+	designated *DesignatedResolver
+	resolver   Resolver
+}
+
+// NewDDRResolver returns a DDRResolver bootstrapping from the resolvers of
+// the operating system and building the designated resolver with the
+// settings of c.
+func (c *ProbeConfig) NewDDRResolver() (*DDRResolver, error) {
+	servers, err := SystemNameservers()
+	if err != nil {
+		return nil, err
+	}
+	r := &DDRResolver{config: c}
+	for _, server := range servers {
+		r.Bootstrap = append(r.Bootstrap, &Do53Resolver{
+			Addr:      net.JoinHostPort(server, Do53Port),
+			Timeout:   c.dnsTimeout(),
+			Logger:    c.logger(),
+			Randomize: c.randomizeDo53(),
+		})
+	}
+	return r, nil
+}
+
+func (r *DDRResolver) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.resolver == nil {
+		return DDRResolverName
+	}
+	return DDRResolverName + "(" + r.resolver.String() + ")"
+}
+
+// Designated returns the designated resolver in use, discovering it if
+// needed.
+func (r *DDRResolver) Designated(ctx context.Context) (*DesignatedResolver, error) {
+	if _, err := r.discover(ctx); err != nil {
+		return nil, err
+	}
+	return r.designated, nil
+}
+
+// Query implements Resolver.
+func (r *DDRResolver) Query(ctx context.Context, name string, qtype RRType) (*DNSResponse, error) {
+	resolver, err := r.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := resolver.Query(ctx, name, qtype)
+	if resp != nil && resp.Resolver == "" {
+		resp.Resolver = resolver.String()
+	}
+	return resp, err
+}
+
+// discover returns the Resolver for the first designated resolver that can
+// be used, trying each bootstrap resolver in order.
+func (r *DDRResolver) discover(ctx context.Context) (Resolver, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.resolver != nil {
+		return r.resolver, nil
+	}
+	var errs []error
+	for _, bootstrap := range r.Bootstrap {
+		designated, err := r.config.DiscoverDesignatedResolvers(ctx, bootstrap)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", bootstrap, err))
+			continue
+		}
+		for i := range designated {
+			resolver, err := r.config.newDesignatedResolver(&designated[i])
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", designated[i].URL, err))
+				continue
+			}
+			r.config.logger().Info("discovered designated resolver", "bootstrap", bootstrap.Addr, "resolver", designated[i].URL)
+			r.designated = &designated[i]
+			r.resolver = resolver
+			return resolver, nil
+		}
+	}
+	if len(errs) == 0 {
+		return nil, ErrNoDesignatedResolver
+	}
+	return nil, errors.Join(errs...)
+}
+
+// DiscoverDesignatedResolvers asks bootstrap for its designated resolvers
+// and returns those using a supported protocol, in SvcPriority order.
+func (c *ProbeConfig) DiscoverDesignatedResolvers(ctx context.Context, bootstrap *Do53Resolver) ([]DesignatedResolver, error) {
+	host, _, err := net.SplitHostPort(bootstrap.Addr)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := bootstrap.Query(ctx, DDRQueryName, TypeSVCB)
+	if err != nil {
+		return nil, err
+	}
+	records, err := parseSVCBRRset(resp, "", TypeSVCB)
+	if err != nil {
+		return nil, err
+	}
+	var designated []DesignatedResolver
+	// AliasMode records are not used for DDR.
+	for _, record := range SortEndpoints(records) {
+		for _, u := range designatedURLs(record) {
+			designated = append(designated, DesignatedResolver{Record: record, URL: u, Bootstrap: addr})
+		}
+	}
+	if len(designated) == 0 {
+		return nil, ErrNoDesignatedResolver
+	}
+	return designated, nil
+}
+
+// designatedURLs returns the resolver URLs for the protocols advertised in
+// the alpn of record, preferring DoH.
+func designatedURLs(record *HttpsRecord) []string {
+	host := strings.TrimSuffix(record.TargetName, ".")
+	if host == "" {
+		return nil
+	}
+	withPort := func(defaultPort string) string {
+		if port, ok := record.Port(); ok {
+			return net.JoinHostPort(host, strconv.Itoa(int(port)))
+		}
+		return net.JoinHostPort(host, defaultPort)
+	}
+	alpn := record.ALPN()
+	var urls []string
+	if slices.Contains(alpn, "h2") || slices.Contains(alpn, "h3") || slices.Contains(alpn, "http/1.1") {
+		// RFC 9461 section 5: dohpath is a relative URI template;
+		// without it, the well known /dns-query path is assumed.
+		path := "/dns-query{?dns}"
+		if dohpath, ok := record.DoHPath(); ok && strings.HasPrefix(dohpath, "/") {
+			path = dohpath
+		}
+		urls = append(urls, "https://"+withPort("443")+path)
+	}
+	if slices.Contains(alpn, "dot") {
+		urls = append(urls, "tls://"+withPort(DoTPort))
+	}
+	if slices.Contains(alpn, "doq") {
+		urls = append(urls, "quic://"+withPort(DoQPort))
+	}
+	return urls
+}
+
+// newDesignatedResolver builds the Resolver for d, requiring its
+// certificate to cover the bootstrap address unless that is private.
+func (c *ProbeConfig) newDesignatedResolver(d *DesignatedResolver) (Resolver, error) {
+	var designatedFor netip.Addr
+	if !d.Bootstrap.IsPrivate() && !d.Bootstrap.IsLoopback() {
+		designatedFor = d.Bootstrap
+	}
+	resolver, err := c.NewResolver(d.URL)
+	if err != nil {
+		return nil, err
+	}
+	switch r := resolver.(type) {
+	case *DoHResolver:
+		transport := r.Client.Transport.(*http.Transport)
+		if designatedFor.IsValid() {
+			transport.TLSClientConfig = &tls.Config{
+				VerifyConnection: func(cs tls.ConnectionState) error {
+					return verifyDesignated(cs, designatedFor)
+				},
+			}
+		}
+		// The JSON API is not part of RFC 8484.
+		r.Method = DoHPost
+	case *DoTResolver:
+		r.designatedFor = designatedFor
+	case *DoQResolver:
+		r.designatedFor = designatedFor
+	}
+	return resolver, nil
+}
+
+// verifyDesignated checks that the certificate of a designated resolver
+// covers the address of the unencrypted resolver that advertised it (RFC
+// 9462 section 4.2).
+func verifyDesignated(cs tls.ConnectionState, bootstrap netip.Addr) error {
+	if len(cs.PeerCertificates) == 0 {
+		return ErrDDRVerification
+	}
+	for _, ip := range cs.PeerCertificates[0].IPAddresses {
+		if addr, ok := netip.AddrFromSlice(ip); ok && addr.Unmap() == bootstrap.Unmap() {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: certificate does not cover %s", ErrDDRVerification, bootstrap)
+}
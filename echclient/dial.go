@@ -0,0 +1,173 @@
+package echclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// LookupAddrs resolves the A and AAAA records of host with the configured
+// resolver, IPv4 addresses first. An IP literal is returned as is.
+func (c *ProbeConfig) LookupAddrs(ctx context.Context, host string) ([]netip.Addr, error) {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return []netip.Addr{addr}, nil
+	}
+	host, err := ToASCIIHost(host)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		addrs []netip.Addr
+		errs  []error
+	)
+	for _, qtype := range []RRType{TypeA, TypeAAAA} {
+		resp, err := c.Query(ctx, canonicalName(host), qtype)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		// CNAMEs are followed by the resolver; only the addresses matter.
+		for _, a := range resp.Answer {
+			if RRType(a.Type) != qtype {
+				continue
+			}
+			addr, err := netip.ParseAddr(a.Data)
+			if err != nil {
+				return nil, fmt.Errorf("%w: invalid %s data %q", ErrDNSResponse, qtype, a.Data)
+			}
+			addrs = append(addrs, addr)
+		}
+	}
+	if len(addrs) == 0 {
+		if len(errs) > 0 {
+			return nil, fmt.Errorf("%w for %s: %w", ErrNoAddress, host, errors.Join(errs...))
+		}
+		return nil, fmt.Errorf("%w for %s", ErrNoAddress, host)
+	}
+	return addrs, nil
+}
+
+// DialContext connects to addr like net.Dialer.DialContext, but resolves
+// the host with LookupAddrs so that the name is never sent to the system
+// resolver. The addresses are tried in order until one connects.
+func (c *ProbeConfig) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := c.LookupAddrs(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	return c.dialAddrs(addrs)(ctx, network, addr)
+}
+
+// dialFunc is the type of http.Transport.DialContext.
+type dialFunc = func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// dialContext returns the DialContext of probe connections: DialContext
+// when ResolveAddrs is set without ConnectIP, nil for the default dialer
+// otherwise.
+func (c *ProbeConfig) dialContext() dialFunc {
+	if c == nil || !c.ResolveAddrs || c.ConnectIP.IsValid() {
+		return nil
+	}
+	return c.DialContext
+}
+
+// connectPort returns the port the probe connects to for u: Port if set,
+// the port SvcParam of record if it has one, the port of u otherwise.
+// Connections through the proxy always go to the port of u.
+func (c *ProbeConfig) connectPort(u *url.URL, record *HttpsRecord) uint16 {
+	port, _ := strconv.ParseUint(urlPort(u), 10, 16)
+	override := c.port()
+	if override == 0 && record != nil {
+		override, _ = record.Port()
+	}
+	if override == 0 || override == uint16(port) {
+		return uint16(port)
+	}
+	if c.proxy() != nil {
+		c.logger().Info("ignoring the port of the HTTPS record, connections go through the proxy", "port", override)
+		return uint16(port)
+	}
+	return override
+}
+
+// connectAddr returns the address the probe connects to instead of that of
+// u, or an empty string if none: ConnectIP if set, else the endpoint target
+// when it is not the host of u, at port when not zero. Connections through
+// the proxy always go to the host of u.
+func (c *ProbeConfig) connectAddr(u *url.URL, target string, port uint16) string {
+	host, p := u.Hostname(), urlPort(u)
+	if ip := c.connectIP(); ip.IsValid() {
+		if c.proxy() != nil {
+			c.logger().Info("ignoring the address to connect to, connections go through the proxy", "connect_ip", ip)
+		} else {
+			host = ip.String()
+		}
+	} else if target != "" && !strings.EqualFold(strings.TrimSuffix(target, "."), host) {
+		if c.proxy() != nil {
+			c.logger().Debug("ignoring the TargetName of the HTTPS record, connections go through the proxy", "target", target)
+		} else {
+			host = strings.TrimSuffix(target, ".")
+		}
+	}
+	if port != 0 {
+		p = strconv.Itoa(int(port))
+	}
+	if host == u.Hostname() && p == urlPort(u) {
+		return ""
+	}
+	return net.JoinHostPort(host, p)
+}
+
+// dialAddr returns a dialFunc connecting with dial, or the default dialer
+// if nil, to addr instead of the dialed address.
+func dialAddr(dial dialFunc, addr string) dialFunc {
+	if dial == nil {
+		var dialer net.Dialer
+		dial = dialer.DialContext
+	}
+	return func(ctx context.Context, network, _ string) (net.Conn, error) {
+		return dial(ctx, network, addr)
+	}
+}
+
+// dialAddrs returns a dialFunc connecting to addrs, in order, on the port
+// of the dialed address instead of resolving its host.
+func (c *ProbeConfig) dialAddrs(addrs []netip.Addr) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		var (
+			dialer net.Dialer
+			errs   []error
+		)
+		for _, ip := range addrs {
+			if network == "tcp4" && !ip.Is4() || network == "tcp6" && !ip.Is6() {
+				continue
+			}
+			conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+			if err == nil {
+				c.logger().Debug("connected to address", "host", host, "addr", ip)
+				return conn, nil
+			}
+			errs = append(errs, err)
+			if ctx.Err() != nil {
+				break
+			}
+		}
+		if len(errs) == 0 {
+			return nil, fmt.Errorf("%w for %s over %s", ErrNoAddress, host, network)
+		}
+		return nil, errors.Join(errs...)
+	}
+}
@@ -0,0 +1,71 @@
+package echclient
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+)
+
+// ECHDialer dials TLS connections offering the ECHConfigList published in the
+// HTTPS RR of the destination host.
+type ECHDialer struct {
+	// NetDialer is used to establish the underlying connection. If nil, a
+	// zero net.Dialer is used.
+	NetDialer *net.Dialer
+
+	// Config is the TLS configuration used for every connection. It is
+	// cloned and its EncryptedClientHelloConfigList is replaced with the one
+	// fetched for the destination host. ServerName defaults to the host
+	// part of the dialed address.
+	Config *tls.Config
+
+	// ProbeConfig configures the HTTPS RR lookup. If nil, the defaults are
+	// used.
+	ProbeConfig *ProbeConfig
+}
+
+// DialContext looks up the ECHConfigList for the host in addr, connects to
+// addr and performs an ECH-enabled TLS handshake.
+//
+// The returned Conn, if any, will always be of type *tls.Conn.
+func (d *ECHDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if host, err = ToASCIIHost(host); err != nil {
+		return nil, err
+	}
+	addr = net.JoinHostPort(host, port)
+	parsed, err := d.ProbeConfig.FetchECHConfigList(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var config *tls.Config
+	if d.Config != nil {
+		config = d.Config.Clone()
+	} else {
+		config = &tls.Config{}
+	}
+	if config.ServerName == "" {
+		config.ServerName = host
+	}
+	config.EncryptedClientHelloConfigList = parsed.Raw
+
+	netDialer := d.NetDialer
+	if netDialer == nil {
+		netDialer = &net.Dialer{}
+	}
+	rawConn, err := netDialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	conn := tls.Client(rawConn, config)
+	err = conn.HandshakeContext(ctx)
+	d.ProbeConfig.hooks().tlsHandshakeDone(conn.ConnectionState(), err)
+	if err != nil {
+		rawConn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
@@ -0,0 +1,739 @@
+package echclient
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// DNSSECStatus is the outcome of validating an RRset (RFC 4035 section 4.3).
+type DNSSECStatus string
+
+const (
+	// DNSSECSecure means the RRset is signed by a chain of trust from the
+	// root.
+	DNSSECSecure DNSSECStatus = "secure"
+
+	// DNSSECInsecure means the RRset belongs to an unsigned zone.
+	DNSSECInsecure DNSSECStatus = "insecure"
+
+	// DNSSECBogus means the RRset should be signed but its signatures are
+	// missing or do not validate, as with a forged answer.
+	DNSSECBogus DNSSECStatus = "bogus"
+)
+
+// rootTrustAnchors are the DS records of the root zone KSKs published by
+// IANA at https://data.iana.org/root-anchors/root-anchors.xml.
+var rootTrustAnchors = []dsRecord{
+	{KeyTag: 20326, Algorithm: 8, DigestType: 2, Digest: mustDecodeHex("E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D")},
+	{KeyTag: 38696, Algorithm: 8, DigestType: 2, Digest: mustDecodeHex("683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16")},
+}
+
+func mustDecodeHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// DNSSEC algorithm numbers supported for validation.
+const (
+	dnssecRSASHA256       = 8
+	dnssecRSASHA512       = 10
+	dnssecECDSAP256SHA256 = 13
+	dnssecECDSAP384SHA384 = 14
+	dnssecED25519         = 15
+)
+
+// ValidateRRset validates the qtype RRset owned by name in resp, querying the
+// DNSKEY and DS records of the chain of trust up to the root. resp must have
+// been obtained with DNSSEC records requested. The returned error explains a
+// bogus status.
+//
+// A zone without DS records is only taken to be unsigned if its parent zone
+// is, or if the parent authenticates their absence with NSEC or NSEC3
+// records.
+func (c *ProbeConfig) ValidateRRset(ctx context.Context, resp *DNSResponse, name string, qtype RRType) (DNSSECStatus, error) {
+	return c.newDNSSECValidator(ctx).validateAnswer(resp, name, qtype)
+}
+
+// validateChain validates the CNAME RRset of each link and the qtype RRset
+// owned by name in resp. The chain is only as trustworthy as its weakest
+// link, so the worst status is returned.
+func (c *ProbeConfig) validateChain(ctx context.Context, links []cnameLink, resp *DNSResponse, name string, qtype RRType) (DNSSECStatus, error) {
+	v := c.newDNSSECValidator(ctx)
+	status, err := v.validateAnswer(resp, name, qtype)
+	c.logger().Debug("DNSSEC validation", "name", name, "type", qtype, "status", status, "error", err)
+	for _, link := range links {
+		s, e := v.validateAnswer(link.resp, link.owner, TypeCNAME)
+		c.logger().Debug("DNSSEC validation", "name", link.owner, "type", TypeCNAME, "status", s, "error", e)
+		if dnssecRank[s] < dnssecRank[status] {
+			status, err = s, e
+		}
+	}
+	return status, err
+}
+
+// dnssecRank orders the statuses from the least trustworthy.
+var dnssecRank = map[DNSSECStatus]int{
+	DNSSECBogus:    0,
+	DNSSECInsecure: 1,
+	DNSSECSecure:   2,
+}
+
+func (c *ProbeConfig) newDNSSECValidator(ctx context.Context) *dnssecValidator {
+	return &dnssecValidator{
+		c:    c,
+		ctx:  ctx,
+		now:  time.Now(),
+		keys: map[string]zoneKeys{},
+	}
+}
+
+type rrsig struct {
+	TypeCovered uint16
+	Algorithm   uint8
+	Labels      uint8
+	OrigTTL     uint32
+	Expiration  uint32
+	Inception   uint32
+	KeyTag      uint16
+	SignerName  string
+	Signature   []byte
+}
+
+type dnskey struct {
+	Flags     uint16
+	Protocol  uint8
+	Algorithm uint8
+	PublicKey []byte
+	rdata     []byte
+}
+
+type dsRecord struct {
+	KeyTag     uint16
+	Algorithm  uint8
+	DigestType uint8
+	Digest     []byte
+}
+
+type zoneKeys struct {
+	keys   []dnskey
+	status DNSSECStatus
+	err    error
+}
+
+type dnssecValidator struct {
+	c    *ProbeConfig
+	ctx  context.Context
+	now  time.Time
+	keys map[string]zoneKeys
+}
+
+func (v *dnssecValidator) validateAnswer(resp *DNSResponse, name string, qtype RRType) (DNSSECStatus, error) {
+	rdatas, sigs, err := rrset(resp.Answer, name, qtype)
+	if err != nil {
+		return DNSSECBogus, err
+	}
+	if len(rdatas) == 0 {
+		return DNSSECBogus, fmt.Errorf("%w: no %s RRset for %s", ErrDNSSECBogus, qtype, name)
+	}
+	if len(sigs) == 0 {
+		zone, err := v.c.enclosingZone(v.ctx, name)
+		if err != nil {
+			return DNSSECBogus, err
+		}
+		keys := v.zoneKeys(zone)
+		switch keys.status {
+		case DNSSECSecure:
+			return DNSSECBogus, fmt.Errorf("%w: %s %s is not signed but zone %s is", ErrDNSSECBogus, name, qtype, zone)
+		case DNSSECInsecure:
+			return DNSSECInsecure, nil
+		}
+		return DNSSECBogus, keys.err
+	}
+	return v.verifyRRset(name, qtype, rdatas, sigs)
+}
+
+// verifyRRset checks that one of sigs over the RRset is made by a trusted
+// key of its signer.
+func (v *dnssecValidator) verifyRRset(name string, qtype RRType, rdatas [][]byte, sigs []rrsig) (DNSSECStatus, error) {
+	err := fmt.Errorf("%w: no valid signature over %s %s", ErrDNSSECBogus, name, qtype)
+	for _, sig := range sigs {
+		if !isSubdomain(name, sig.SignerName) {
+			err = fmt.Errorf("%w: signer %s is not an ancestor of %s", ErrDNSSECBogus, sig.SignerName, name)
+			continue
+		}
+		keys := v.zoneKeys(sig.SignerName)
+		if keys.status == DNSSECInsecure {
+			return DNSSECInsecure, nil
+		}
+		if keys.status == DNSSECBogus {
+			err = keys.err
+			continue
+		}
+		for _, key := range keys.keys {
+			if e := v.verifySig(&sig, &key, name, rdatas); e == nil {
+				return DNSSECSecure, nil
+			} else if key.Algorithm == sig.Algorithm && keyTag(key.rdata) == sig.KeyTag {
+				err = e
+			}
+		}
+	}
+	return DNSSECBogus, err
+}
+
+// zoneKeys returns the DNSKEYs of zone once they are authenticated by the
+// DS records of the parent zone, or by the trust anchors for the root.
+func (v *dnssecValidator) zoneKeys(zone string) zoneKeys {
+	zone = canonicalName(zone)
+	if keys, ok := v.keys[zone]; ok {
+		return keys
+	}
+	keys := v.fetchZoneKeys(zone)
+	v.keys[zone] = keys
+	return keys
+}
+
+func (v *dnssecValidator) fetchZoneKeys(zone string) zoneKeys {
+	var ds []dsRecord
+	if zone == "." {
+		ds = rootTrustAnchors
+	} else {
+		var status DNSSECStatus
+		var err error
+		ds, status, err = v.zoneDS(zone)
+		if status != DNSSECSecure {
+			return zoneKeys{status: status, err: err}
+		}
+	}
+	bogus := func(format string, args ...any) zoneKeys {
+		return zoneKeys{status: DNSSECBogus, err: fmt.Errorf("%w: "+format, append([]any{ErrDNSSECBogus}, args...)...)}
+	}
+
+	resp, err := v.c.Query(v.ctx, zone, TypeDNSKEY)
+	if err != nil {
+		return zoneKeys{status: DNSSECBogus, err: fmt.Errorf("%w: DNSKEY query for %s: %w", ErrDNSSECBogus, zone, err)}
+	}
+	rdatas, sigs, err := rrset(resp.Answer, zone, TypeDNSKEY)
+	if err != nil {
+		return zoneKeys{status: DNSSECBogus, err: err}
+	}
+	var keys, trusted []dnskey
+	for _, rdata := range rdatas {
+		key, err := parseDNSKEY(rdata)
+		if err != nil {
+			return bogus("%v", err)
+		}
+		keys = append(keys, key)
+		if slices.ContainsFunc(ds, func(d dsRecord) bool { return d.matches(zone, &key) }) {
+			trusted = append(trusted, key)
+		}
+	}
+	if len(trusted) == 0 {
+		return bogus("no DNSKEY of %s matches its DS records", zone)
+	}
+	for _, sig := range sigs {
+		for _, key := range trusted {
+			if v.verifySig(&sig, &key, zone, rdatas) == nil {
+				return zoneKeys{keys: keys, status: DNSSECSecure}
+			}
+		}
+	}
+	return bogus("DNSKEY RRset of %s is not signed by a trusted key", zone)
+}
+
+// zoneDS returns the authenticated DS records of zone, or DNSSECInsecure if
+// their absence is authenticated by the parent zone.
+func (v *dnssecValidator) zoneDS(zone string) ([]dsRecord, DNSSECStatus, error) {
+	resp, err := v.c.Query(v.ctx, zone, TypeDS)
+	if err != nil {
+		return nil, DNSSECBogus, fmt.Errorf("%w: DS query for %s: %w", ErrDNSSECBogus, zone, err)
+	}
+	rdatas, sigs, err := rrset(resp.Answer, zone, TypeDS)
+	if err != nil {
+		return nil, DNSSECBogus, err
+	}
+	if len(rdatas) == 0 {
+		status, err := v.denyDS(zone, resp)
+		return nil, status, err
+	}
+	status, err := v.verifyRRset(zone, TypeDS, rdatas, sigs)
+	if status != DNSSECSecure {
+		return nil, status, err
+	}
+	var ds []dsRecord
+	for _, rdata := range rdatas {
+		d, err := parseDS(rdata)
+		if err != nil {
+			return nil, DNSSECBogus, fmt.Errorf("%w: %v", ErrDNSSECBogus, err)
+		}
+		ds = append(ds, d)
+	}
+	return ds, DNSSECSecure, nil
+}
+
+// enclosingZone returns the apex of the zone name belongs to, as reported
+// by the SOA record of a SOA query.
+func (c *ProbeConfig) enclosingZone(ctx context.Context, name string) (string, error) {
+	for {
+		resp, err := c.Query(ctx, name, TypeSOA)
+		if err != nil && !errors.Is(err, ErrDNSStatus) {
+			return "", err
+		}
+		if resp != nil {
+			for _, rr := range append(resp.Answer, resp.Authority...) {
+				if RRType(rr.Type) == TypeSOA && isSubdomain(name, rr.Name) {
+					return canonicalName(rr.Name), nil
+				}
+			}
+		}
+		if canonicalName(name) == "." {
+			return ".", nil
+		}
+		_, name, _ = strings.Cut(canonicalName(name), ".")
+		if name == "" {
+			name = "."
+		}
+	}
+}
+
+// verifySig checks sig over the RRset owned by name with key.
+func (v *dnssecValidator) verifySig(sig *rrsig, key *dnskey, name string, rdatas [][]byte) error {
+	if key.Algorithm != sig.Algorithm || keyTag(key.rdata) != sig.KeyTag {
+		return fmt.Errorf("%w: key %d does not match signature", ErrDNSSECBogus, keyTag(key.rdata))
+	}
+	if key.Flags&0x0100 == 0 || key.Protocol != 3 {
+		return fmt.Errorf("%w: key %d is not a zone key", ErrDNSSECBogus, sig.KeyTag)
+	}
+	now := uint32(v.now.Unix())
+	if now < sig.Inception || now > sig.Expiration {
+		return fmt.Errorf("%w: signature by key %d is not currently valid", ErrDNSSECBogus, sig.KeyTag)
+	}
+	data, err := signedData(sig, name, rdatas)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrDNSSECBogus, err)
+	}
+	if err := verifySignature(sig.Algorithm, key.PublicKey, data, sig.Signature); err != nil {
+		return fmt.Errorf("%w: signature by key %d: %v", ErrDNSSECBogus, sig.KeyTag, err)
+	}
+	return nil
+}
+
+// rrset returns the RDATA of the qtype records owned by name in section and
+// the RRSIGs covering them.
+func rrset(section []DNSAnswer, name string, qtype RRType) ([][]byte, []rrsig, error) {
+	var rdatas [][]byte
+	var sigs []rrsig
+	for _, rr := range section {
+		if canonicalName(rr.Name) != canonicalName(name) {
+			continue
+		}
+		switch RRType(rr.Type) {
+		case qtype:
+			rdata, err := answerRData(rr)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%w: %v", ErrDNSSECBogus, err)
+			}
+			if !slices.ContainsFunc(rdatas, func(b []byte) bool { return bytes.Equal(b, rdata) }) {
+				rdatas = append(rdatas, rdata)
+			}
+		case TypeRRSIG:
+			rdata, err := answerRData(rr)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%w: %v", ErrDNSSECBogus, err)
+			}
+			sig, err := parseRRSIG(rdata)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%w: %v", ErrDNSSECBogus, err)
+			}
+			if sig.TypeCovered == uint16(qtype) {
+				sigs = append(sigs, sig)
+			}
+		}
+	}
+	return rdatas, sigs, nil
+}
+
+// answerRData returns the wire-format RDATA of rr, from its RFC 3597 generic
+// encoding or, for CNAME and the DNSSEC and SVCB types, from the
+// presentation format used by DoH JSON APIs.
+func answerRData(rr DNSAnswer) ([]byte, error) {
+	if strings.HasPrefix(rr.Data, `\#`) {
+		return decodeGenericData(rr.Data)
+	}
+	fields := strings.Fields(rr.Data)
+	var b cryptobyte.Builder
+	switch RRType(rr.Type) {
+	case TypeDNSKEY:
+		if len(fields) < 4 {
+			return nil, fmt.Errorf("malformed DNSKEY %q", rr.Data)
+		}
+		flags, err1 := strconv.ParseUint(fields[0], 10, 16)
+		protocol, err2 := strconv.ParseUint(fields[1], 10, 8)
+		algorithm, err3 := strconv.ParseUint(fields[2], 10, 8)
+		key, err4 := base64.StdEncoding.DecodeString(strings.Join(fields[3:], ""))
+		if err := errors.Join(err1, err2, err3, err4); err != nil {
+			return nil, fmt.Errorf("malformed DNSKEY %q: %v", rr.Data, err)
+		}
+		b.AddUint16(uint16(flags))
+		b.AddUint8(uint8(protocol))
+		b.AddUint8(uint8(algorithm))
+		b.AddBytes(key)
+	case TypeDS:
+		if len(fields) < 4 {
+			return nil, fmt.Errorf("malformed DS %q", rr.Data)
+		}
+		tag, err1 := strconv.ParseUint(fields[0], 10, 16)
+		algorithm, err2 := strconv.ParseUint(fields[1], 10, 8)
+		digestType, err3 := strconv.ParseUint(fields[2], 10, 8)
+		digest, err4 := hex.DecodeString(strings.Join(fields[3:], ""))
+		if err := errors.Join(err1, err2, err3, err4); err != nil {
+			return nil, fmt.Errorf("malformed DS %q: %v", rr.Data, err)
+		}
+		b.AddUint16(uint16(tag))
+		b.AddUint8(uint8(algorithm))
+		b.AddUint8(uint8(digestType))
+		b.AddBytes(digest)
+	case TypeRRSIG:
+		if len(fields) < 9 {
+			return nil, fmt.Errorf("malformed RRSIG %q", rr.Data)
+		}
+		covered, err1 := ParseRRType(fields[0])
+		algorithm, err2 := strconv.ParseUint(fields[1], 10, 8)
+		labels, err3 := strconv.ParseUint(fields[2], 10, 8)
+		ttl, err4 := strconv.ParseUint(fields[3], 10, 32)
+		expiration, err5 := parseSigTime(fields[4])
+		inception, err6 := parseSigTime(fields[5])
+		tag, err7 := strconv.ParseUint(fields[6], 10, 16)
+		signature, err8 := base64.StdEncoding.DecodeString(strings.Join(fields[8:], ""))
+		if err := errors.Join(err1, err2, err3, err4, err5, err6, err7, err8); err != nil {
+			return nil, fmt.Errorf("malformed RRSIG %q: %v", rr.Data, err)
+		}
+		b.AddUint16(uint16(covered))
+		b.AddUint8(uint8(algorithm))
+		b.AddUint8(uint8(labels))
+		b.AddUint32(uint32(ttl))
+		b.AddUint32(expiration)
+		b.AddUint32(inception)
+		b.AddUint16(uint16(tag))
+		addWireName(&b, fields[7])
+		b.AddBytes(signature)
+	case TypeCNAME:
+		if len(fields) != 1 {
+			return nil, fmt.Errorf("malformed CNAME %q", rr.Data)
+		}
+		addWireName(&b, fields[0])
+	case TypeNSEC:
+		if len(fields) < 1 {
+			return nil, fmt.Errorf("malformed NSEC %q", rr.Data)
+		}
+		// The next name keeps its case in the canonical form (RFC 6840
+		// section 5.1).
+		addWireLabels(&b, fqdn(fields[0]))
+		if err := addTypeBitmap(&b, fields[1:]); err != nil {
+			return nil, fmt.Errorf("malformed NSEC %q: %v", rr.Data, err)
+		}
+	case TypeNSEC3:
+		if len(fields) < 5 {
+			return nil, fmt.Errorf("malformed NSEC3 %q", rr.Data)
+		}
+		algorithm, err1 := strconv.ParseUint(fields[0], 10, 8)
+		flags, err2 := strconv.ParseUint(fields[1], 10, 8)
+		iterations, err3 := strconv.ParseUint(fields[2], 10, 16)
+		var salt []byte
+		var err4 error
+		if fields[3] != "-" {
+			salt, err4 = hex.DecodeString(fields[3])
+		}
+		next, err5 := nsec3Base32.DecodeString(strings.ToUpper(fields[4]))
+		if err := errors.Join(err1, err2, err3, err4, err5); err != nil {
+			return nil, fmt.Errorf("malformed NSEC3 %q: %v", rr.Data, err)
+		}
+		b.AddUint8(uint8(algorithm))
+		b.AddUint8(uint8(flags))
+		b.AddUint16(uint16(iterations))
+		b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddBytes(salt)
+		})
+		b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddBytes(next)
+		})
+		if err := addTypeBitmap(&b, fields[5:]); err != nil {
+			return nil, fmt.Errorf("malformed NSEC3 %q: %v", rr.Data, err)
+		}
+	case TypeHTTPS, TypeSVCB:
+		record, err := ParseHttpsPresentation(rr.Data)
+		if err != nil {
+			return nil, err
+		}
+		return record.Marshal()
+	default:
+		return nil, fmt.Errorf("cannot decode %s data %q", RRType(rr.Type), rr.Data)
+	}
+	return b.Bytes()
+}
+
+// parseSigTime parses an RRSIG timestamp in YYYYMMDDHHmmSS or decimal form.
+func parseSigTime(s string) (uint32, error) {
+	if len(s) == 14 {
+		t, err := time.Parse("20060102150405", s)
+		if err != nil {
+			return 0, err
+		}
+		return uint32(t.Unix()), nil
+	}
+	n, err := strconv.ParseUint(s, 10, 32)
+	return uint32(n), err
+}
+
+func parseRRSIG(rdata []byte) (rrsig, error) {
+	var sig rrsig
+	s := cryptobyte.String(rdata)
+	if !s.ReadUint16(&sig.TypeCovered) || !s.ReadUint8(&sig.Algorithm) || !s.ReadUint8(&sig.Labels) ||
+		!s.ReadUint32(&sig.OrigTTL) || !s.ReadUint32(&sig.Expiration) || !s.ReadUint32(&sig.Inception) ||
+		!s.ReadUint16(&sig.KeyTag) || !readWireName(&s, &sig.SignerName) {
+		return sig, errors.New("malformed RRSIG")
+	}
+	sig.Signature = s
+	return sig, nil
+}
+
+func parseDNSKEY(rdata []byte) (dnskey, error) {
+	key := dnskey{rdata: rdata}
+	s := cryptobyte.String(rdata)
+	if !s.ReadUint16(&key.Flags) || !s.ReadUint8(&key.Protocol) || !s.ReadUint8(&key.Algorithm) || s.Empty() {
+		return key, errors.New("malformed DNSKEY")
+	}
+	key.PublicKey = s
+	return key, nil
+}
+
+func parseDS(rdata []byte) (dsRecord, error) {
+	var ds dsRecord
+	s := cryptobyte.String(rdata)
+	if !s.ReadUint16(&ds.KeyTag) || !s.ReadUint8(&ds.Algorithm) || !s.ReadUint8(&ds.DigestType) || s.Empty() {
+		return ds, errors.New("malformed DS")
+	}
+	ds.Digest = s
+	return ds, nil
+}
+
+// matches reports whether ds is the digest of key, owned by zone.
+func (ds *dsRecord) matches(zone string, key *dnskey) bool {
+	if ds.Algorithm != key.Algorithm || ds.KeyTag != keyTag(key.rdata) {
+		return false
+	}
+	var b cryptobyte.Builder
+	addWireName(&b, zone)
+	b.AddBytes(key.rdata)
+	data, err := b.Bytes()
+	if err != nil {
+		return false
+	}
+	var digest []byte
+	switch ds.DigestType {
+	case 1:
+		d := sha1.Sum(data)
+		digest = d[:]
+	case 2:
+		d := sha256.Sum256(data)
+		digest = d[:]
+	case 4:
+		d := sha512.Sum384(data)
+		digest = d[:]
+	default:
+		return false
+	}
+	return bytes.Equal(digest, ds.Digest)
+}
+
+// keyTag computes the key tag of a DNSKEY RDATA (RFC 4034 appendix B).
+func keyTag(rdata []byte) uint16 {
+	var ac uint32
+	for i, b := range rdata {
+		if i&1 == 0 {
+			ac += uint32(b) << 8
+		} else {
+			ac += uint32(b)
+		}
+	}
+	ac += ac >> 16 & 0xffff
+	return uint16(ac)
+}
+
+// signedData returns the data covered by sig over the RRset owned by name
+// (RFC 4034 section 3.1.8.1).
+func signedData(sig *rrsig, name string, rdatas [][]byte) ([]byte, error) {
+	owner := canonicalName(name)
+	labels := strings.Split(strings.TrimSuffix(owner, "."), ".")
+	if owner == "." {
+		labels = nil
+	}
+	if int(sig.Labels) < len(labels) {
+		// Wildcard expansion.
+		owner = "*." + strings.Join(labels[len(labels)-int(sig.Labels):], ".") + "."
+	} else if int(sig.Labels) > len(labels) {
+		return nil, errors.New("RRSIG labels exceed owner name")
+	}
+	sorted := slices.Clone(rdatas)
+	slices.SortFunc(sorted, bytes.Compare)
+
+	var b cryptobyte.Builder
+	b.AddUint16(sig.TypeCovered)
+	b.AddUint8(sig.Algorithm)
+	b.AddUint8(sig.Labels)
+	b.AddUint32(sig.OrigTTL)
+	b.AddUint32(sig.Expiration)
+	b.AddUint32(sig.Inception)
+	b.AddUint16(sig.KeyTag)
+	addWireName(&b, sig.SignerName)
+	for _, rdata := range sorted {
+		addWireName(&b, owner)
+		b.AddUint16(sig.TypeCovered)
+		b.AddUint16(1) // IN
+		b.AddUint32(sig.OrigTTL)
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddBytes(rdata)
+		})
+	}
+	return b.Bytes()
+}
+
+func verifySignature(algorithm uint8, key, data, signature []byte) error {
+	switch algorithm {
+	case dnssecRSASHA256, dnssecRSASHA512:
+		pub, err := parseRSAKey(key)
+		if err != nil {
+			return err
+		}
+		if algorithm == dnssecRSASHA256 {
+			digest := sha256.Sum256(data)
+			return rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature)
+		}
+		digest := sha512.Sum512(data)
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA512, digest[:], signature)
+	case dnssecECDSAP256SHA256, dnssecECDSAP384SHA384:
+		curve, size := elliptic.P256(), 32
+		var digest []byte
+		if algorithm == dnssecECDSAP384SHA384 {
+			curve, size = elliptic.P384(), 48
+			d := sha512.Sum384(data)
+			digest = d[:]
+		} else {
+			d := sha256.Sum256(data)
+			digest = d[:]
+		}
+		if len(key) != 2*size || len(signature) != 2*size {
+			return errors.New("bad ECDSA key or signature length")
+		}
+		pub := &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(key[:size]),
+			Y:     new(big.Int).SetBytes(key[size:]),
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return errors.New("invalid ECDSA signature")
+		}
+		return nil
+	case dnssecED25519:
+		if len(key) != ed25519.PublicKeySize {
+			return errors.New("bad Ed25519 key length")
+		}
+		if !ed25519.Verify(key, data, signature) {
+			return errors.New("invalid Ed25519 signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported algorithm %d", algorithm)
+}
+
+// parseRSAKey decodes an RSA public key in the RFC 3110 format.
+func parseRSAKey(key []byte) (*rsa.PublicKey, error) {
+	if len(key) < 3 {
+		return nil, errors.New("bad RSA key")
+	}
+	expLen, key := int(key[0]), key[1:]
+	if expLen == 0 {
+		expLen, key = int(key[0])<<8|int(key[1]), key[2:]
+	}
+	if expLen == 0 || expLen > 4 || len(key) <= expLen {
+		return nil, errors.New("bad RSA key")
+	}
+	e := 0
+	for _, b := range key[:expLen] {
+		e = e<<8 | int(b)
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(key[expLen:]), E: e}, nil
+}
+
+// canonicalName returns name lowercased with a trailing dot.
+func canonicalName(name string) string {
+	return strings.ToLower(fqdn(name))
+}
+
+// isSubdomain reports whether name is zone or below it.
+func isSubdomain(name, zone string) bool {
+	name, zone = canonicalName(name), canonicalName(zone)
+	return zone == "." || name == zone || strings.HasSuffix(name, "."+zone)
+}
+
+// readWireName reads an uncompressed wire-format name in presentation form.
+func readWireName(s *cryptobyte.String, name *string) bool {
+	var labels []string
+	for {
+		var label cryptobyte.String
+		if !s.ReadUint8LengthPrefixed(&label) {
+			return false
+		}
+		if len(label) == 0 {
+			break
+		}
+		labels = append(labels, string(label))
+	}
+	*name = strings.Join(labels, ".") + "."
+	if len(labels) == 0 {
+		*name = "."
+	}
+	return true
+}
+
+// addWireName appends name in canonical (lowercase, uncompressed) wire
+// format.
+func addWireName(b *cryptobyte.Builder, name string) {
+	addWireLabels(b, canonicalName(name))
+}
+
+// addWireLabels appends the fully qualified name in uncompressed wire
+// format, keeping its case.
+func addWireLabels(b *cryptobyte.Builder, name string) {
+	if name != "." {
+		for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+			b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+				b.AddBytes([]byte(label))
+			})
+		}
+	}
+	b.AddUint8(0)
+}
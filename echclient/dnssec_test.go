@@ -0,0 +1,347 @@
+package echclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// testZone is a zone signed at test time with an Ed25519 key.
+type testZone struct {
+	name   string
+	key    ed25519.PrivateKey
+	dnskey []byte
+}
+
+func newTestZone(t *testing.T, name string) *testZone {
+	t.Helper()
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testZone{
+		name:   name,
+		key:    key,
+		dnskey: append([]byte{0x01, 0x01, 3, dnssecED25519}, pub...),
+	}
+}
+
+func (z *testZone) dnskeyRR() DNSAnswer {
+	data := "257 3 15 " + base64.StdEncoding.EncodeToString(z.dnskey[4:])
+	return DNSAnswer{Name: z.name, Type: int(TypeDNSKEY), TTL: 300, Data: data}
+}
+
+func (z *testZone) dsRR() DNSAnswer {
+	var b cryptobyte.Builder
+	addWireName(&b, z.name)
+	b.AddBytes(z.dnskey)
+	digest := sha256.Sum256(b.BytesOrPanic())
+	data := fmt.Sprintf("%d %d 2 %X", keyTag(z.dnskey), dnssecED25519, digest)
+	return DNSAnswer{Name: z.name, Type: int(TypeDS), TTL: 300, Data: data}
+}
+
+// sign returns rrs, an RRset, followed by its RRSIG by the key of z.
+func (z *testZone) sign(t *testing.T, rrs ...DNSAnswer) []DNSAnswer {
+	t.Helper()
+	var rdatas [][]byte
+	for _, rr := range rrs {
+		rdata, err := answerRData(rr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rdatas = append(rdatas, rdata)
+	}
+	owner := canonicalName(rrs[0].Name)
+	now := time.Now()
+	sig := rrsig{
+		TypeCovered: uint16(rrs[0].Type),
+		Algorithm:   dnssecED25519,
+		Labels:      uint8(strings.Count(owner, ".")),
+		OrigTTL:     300,
+		Expiration:  uint32(now.Add(time.Hour).Unix()),
+		Inception:   uint32(now.Add(-time.Hour).Unix()),
+		KeyTag:      keyTag(z.dnskey),
+		SignerName:  z.name,
+	}
+	if owner == "." {
+		sig.Labels = 0
+	}
+	data, err := signedData(&sig, owner, rdatas)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b cryptobyte.Builder
+	b.AddUint16(sig.TypeCovered)
+	b.AddUint8(sig.Algorithm)
+	b.AddUint8(sig.Labels)
+	b.AddUint32(sig.OrigTTL)
+	b.AddUint32(sig.Expiration)
+	b.AddUint32(sig.Inception)
+	b.AddUint16(sig.KeyTag)
+	addWireName(&b, sig.SignerName)
+	b.AddBytes(ed25519.Sign(z.key, data))
+	return append(rrs, DNSAnswer{Name: owner, Type: int(TypeRRSIG), TTL: 300, Data: genericData(b.BytesOrPanic())})
+}
+
+// testDNS answers from a fixed set of responses, and for SOA queries with
+// the SOA record of the closest zone in zones.
+type testDNS struct {
+	zones   []string
+	answers map[string]*DNSResponse
+}
+
+func (d *testDNS) set(name string, qtype RRType, answer, authority []DNSAnswer) {
+	d.answers[canonicalName(name)+" "+qtype.String()] = &DNSResponse{Answer: answer, Authority: authority}
+}
+
+func (d *testDNS) Query(_ context.Context, name string, qtype RRType) (*DNSResponse, error) {
+	if resp, ok := d.answers[canonicalName(name)+" "+qtype.String()]; ok {
+		return resp, nil
+	}
+	resp := &DNSResponse{}
+	if qtype == TypeSOA {
+		zone := "."
+		for _, z := range d.zones {
+			if isSubdomain(name, z) && len(z) > len(zone) {
+				zone = z
+			}
+		}
+		resp.Authority = []DNSAnswer{{Name: zone, Type: int(TypeSOA), TTL: 300, Data: "ns.test. hostmaster.test. 1 7200 3600 1209600 300"}}
+	}
+	return resp, nil
+}
+
+func (d *testDNS) String() string { return "test" }
+
+// newSignedTree serves a signed root, a signed test. TLD with a signed
+// example.test. zone and the unsigned delegations unsigned.test. and
+// evil.test., the latter holding records signed by its own key.
+func newSignedTree(t *testing.T) (*testDNS, map[string]*testZone) {
+	t.Helper()
+	zones := map[string]*testZone{}
+	for _, name := range []string{".", "test.", "example.test.", "evil.test."} {
+		zones[name] = newTestZone(t, name)
+	}
+	anchors := rootTrustAnchors
+	t.Cleanup(func() { rootTrustAnchors = anchors })
+	ds, err := answerRData(zones["."].dsRR())
+	if err != nil {
+		t.Fatal(err)
+	}
+	anchor, err := parseDS(ds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootTrustAnchors = []dsRecord{anchor}
+
+	d := &testDNS{
+		zones:   []string{"test.", "example.test.", "unsigned.test.", "evil.test."},
+		answers: map[string]*DNSResponse{},
+	}
+	for _, z := range zones {
+		d.set(z.name, TypeDNSKEY, z.sign(t, z.dnskeyRR()), nil)
+	}
+	d.set("test.", TypeDS, zones["."].sign(t, zones["test."].dsRR()), nil)
+	d.set("example.test.", TypeDS, zones["test."].sign(t, zones["example.test."].dsRR()), nil)
+	d.set("www.example.test.", TypeHTTPS, zones["example.test."].sign(t, DNSAnswer{
+		Name: "www.example.test.", Type: int(TypeHTTPS), TTL: 300, Data: "1 . alpn=h2",
+	}), nil)
+	return d, zones
+}
+
+func TestValidateRRset(t *testing.T) {
+	d, zones := newSignedTree(t)
+	c := &ProbeConfig{Resolver: d}
+	example := zones["example.test."]
+	https := DNSAnswer{Name: "www.example.test.", Type: int(TypeHTTPS), TTL: 300, Data: "1 . alpn=h2"}
+	unsigned := DNSAnswer{Name: "www.unsigned.test.", Type: int(TypeHTTPS), TTL: 300, Data: "1 . alpn=h2"}
+	nsec := DNSAnswer{Name: "unsigned.test.", Type: int(TypeNSEC), TTL: 300, Data: "zzz.test. NS RRSIG NSEC"}
+	nsecDS := DNSAnswer{Name: "unsigned.test.", Type: int(TypeNSEC), TTL: 300, Data: "zzz.test. NS DS RRSIG NSEC"}
+
+	tests := []struct {
+		name   string
+		answer []DNSAnswer
+		ds     []DNSAnswer // authority section of the unsigned.test. DS answer
+		nsec3  bool
+		want   DNSSECStatus
+	}{
+		{name: "signed", answer: example.sign(t, https), want: DNSSECSecure},
+		{name: "stripped RRSIG", answer: []DNSAnswer{https}, want: DNSSECBogus},
+		{name: "tampered data", answer: append(example.sign(t, https)[1:], DNSAnswer{
+			Name: "www.example.test.", Type: int(TypeHTTPS), TTL: 300, Data: "1 . alpn=h3",
+		}), want: DNSSECBogus},
+		{name: "signed by another zone", answer: zones["evil.test."].sign(t, https), want: DNSSECBogus},
+		{name: "empty DS without denial", answer: []DNSAnswer{unsigned}, want: DNSSECBogus},
+		{name: "empty DS with NSEC", answer: []DNSAnswer{unsigned}, ds: zones["test."].sign(t, nsec), want: DNSSECInsecure},
+		{name: "empty DS with unsigned NSEC", answer: []DNSAnswer{unsigned}, ds: []DNSAnswer{nsec}, want: DNSSECBogus},
+		{name: "empty DS with NSEC listing DS", answer: []DNSAnswer{unsigned}, ds: zones["test."].sign(t, nsecDS), want: DNSSECBogus},
+		{name: "empty DS with NSEC from the child", answer: []DNSAnswer{unsigned}, ds: zones["evil.test."].sign(t, nsec), want: DNSSECBogus},
+		{name: "empty DS with NSEC3", answer: []DNSAnswer{unsigned}, nsec3: true, want: DNSSECInsecure},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ds := tt.ds
+			if tt.nsec3 {
+				ds = zones["test."].sign(t, testNSEC3(t, "unsigned.test.", "test.", 0, "NS"))
+			}
+			d.set("unsigned.test.", TypeDS, nil, ds)
+			status, err := c.ValidateRRset(context.Background(), &DNSResponse{Answer: tt.answer}, tt.answer[0].Name, TypeHTTPS)
+			if status != tt.want {
+				t.Fatalf("ValidateRRset() = %s, %v, want %s", status, err, tt.want)
+			}
+			if status == DNSSECBogus && !errors.Is(err, ErrDNSSECBogus) {
+				t.Errorf("ValidateRRset() error = %v, want ErrDNSSECBogus", err)
+			}
+		})
+	}
+}
+
+// testNSEC3 returns the NSEC3 record of name in zone, with no salt and
+// iterations and the types listed.
+func testNSEC3(t *testing.T, name, zone string, flags uint8, types string) DNSAnswer {
+	t.Helper()
+	r := nsec3Record{algorithm: nsec3HashSHA1}
+	hash, ok := r.hashName(name)
+	if !ok {
+		t.Fatal("hashName failed")
+	}
+	next := bytes.Clone(hash)
+	next[len(next)-1]++
+	return DNSAnswer{
+		Name: nsec3Base32.EncodeToString(hash) + "." + zone,
+		Type: int(TypeNSEC3),
+		TTL:  300,
+		Data: fmt.Sprintf("1 %d 0 - %s %s", flags, nsec3Base32.EncodeToString(next), types),
+	}
+}
+
+// The vectors are those of RFC 5155, appendix A.
+func TestNSEC3Hash(t *testing.T) {
+	r := nsec3Record{algorithm: nsec3HashSHA1, iterations: 12, salt: []byte{0xaa, 0xbb, 0xcc, 0xdd}}
+	for name, want := range map[string]string{
+		"example.":      "0p9mhaveqvm6t7vbl5lop2u3t2rp3tom",
+		"a.example.":    "35mthgpgcu1qg68fab165klnsnk3dpvl",
+		"ai.example.":   "gjeqe526plbf1g8mklp59enfd789njgi",
+		"ns1.example.":  "2t7b4g4vsa5smi47k61mv5bv1a22bojr",
+		"*.w.example.":  "r53bq7cc2uvmubfu5ocmm6pers9tk9en",
+		"xx.example.":   "t644ebqk9bibcna874givr6joj62mlhv",
+		"Y.W.Example.":  "ji6neoaepv8b5o6k4ev33abha8ht9fgc",
+		"x.y.w.example": "2vptu5timamqttgl4luu9kg21e0aor3s",
+	} {
+		hash, ok := r.hashName(name)
+		if got := strings.ToLower(nsec3Base32.EncodeToString(hash)); !ok || got != want {
+			t.Errorf("hashName(%q) = %s, %v, want %s", name, got, ok, want)
+		}
+	}
+}
+
+func TestNSEC3DeniesDS(t *testing.T) {
+	optOut := parseNSEC3Records(t, testNSEC3(t, "test.", "test.", 0, "NS SOA RRSIG DNSKEY NSEC3PARAM"))
+	// The span of cover holds every hash but the lowest and the highest.
+	cover := nsec3Record{
+		zone:      "test.",
+		algorithm: nsec3HashSHA1,
+		flags:     nsec3FlagOptOut,
+		hash:      make([]byte, sha1.Size),
+		next:      bytes.Repeat([]byte{0xff}, sha1.Size),
+	}
+	noOptOut := cover
+	noOptOut.flags = 0
+
+	tests := []struct {
+		name    string
+		records []nsec3Record
+		want    bool
+	}{
+		{"matching", parseNSEC3Records(t, testNSEC3(t, "unsigned.test.", "test.", 0, "NS")), true},
+		{"matching with DS", parseNSEC3Records(t, testNSEC3(t, "unsigned.test.", "test.", 0, "NS DS")), false},
+		{"matching apex", parseNSEC3Records(t, testNSEC3(t, "unsigned.test.", "test.", 0, "NS SOA")), false},
+		{"opt-out", append(optOut, cover), true},
+		{"no opt-out", append(optOut, noOptOut), false},
+		{"no closest encloser", []nsec3Record{cover}, false},
+	}
+	for _, tt := range tests {
+		if got := nsec3DeniesDS("unsigned.test.", tt.records); got != tt.want {
+			t.Errorf("%s: nsec3DeniesDS() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func parseNSEC3Records(t *testing.T, rrs ...DNSAnswer) []nsec3Record {
+	t.Helper()
+	var records []nsec3Record
+	for _, rr := range rrs {
+		rdata, err := answerRData(rr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, ok := parseNSEC3(rr.Name, rdata)
+		if !ok {
+			t.Fatalf("parseNSEC3(%q) failed", rr.Data)
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+func TestFetchECHConfigListDNSSECChain(t *testing.T) {
+	d, zones := newSignedTree(t)
+	key, err := GenerateECHKey(1, X25519, "public.example.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := ECHConfigList{key.Config}.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	example := zones["example.test."]
+	https := example.sign(t, DNSAnswer{
+		Name: "www.example.test.", Type: int(TypeHTTPS), TTL: 300,
+		Data: "1 . alpn=h2 ech=" + base64.StdEncoding.EncodeToString(list),
+	})
+	cname := DNSAnswer{Name: "alias.example.test.", Type: int(TypeCNAME), TTL: 300, Data: "www.example.test."}
+	d.set("www.example.test.", TypeHTTPS, https, nil)
+	// The CNAME is rewritten to point into evil.test. but keeps the
+	// signature over the original target.
+	retargeted := example.sign(t, cname)
+	retargeted[0].Data = "www.evil.test."
+	evil := zones["evil.test."].sign(t, DNSAnswer{
+		Name: "www.evil.test.", Type: int(TypeHTTPS), TTL: 300,
+		Data: "1 . alpn=h2 ech=" + base64.StdEncoding.EncodeToString(list),
+	})
+
+	tests := []struct {
+		name   string
+		answer []DNSAnswer
+		want   DNSSECStatus
+	}{
+		{"signed CNAME", append(example.sign(t, cname), https...), DNSSECSecure},
+		{"unsigned CNAME", append([]DNSAnswer{cname}, https...), DNSSECBogus},
+		{"forged CNAME", append(zones["evil.test."].sign(t, cname), https...), DNSSECBogus},
+		{"forged CNAME target", append(retargeted, evil...), DNSSECBogus},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d.set("alias.example.test.", TypeHTTPS, tt.answer, nil)
+			c := &ProbeConfig{Resolver: d, ValidateDNSSEC: true}
+			ech, err := c.FetchECHConfigList(context.Background(), "alias.example.test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ech.DNSSEC != tt.want {
+				t.Errorf("DNSSEC = %s, %v, want %s", ech.DNSSEC, ech.DNSSECError, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package echclient
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/url"
+	"time"
+)
+
+// Do53Port is the port of classic DNS servers.
+const Do53Port = "53"
+
+// Do53Resolver is an unencrypted DNS Resolver sending queries over UDP and
+// retrying over TCP when the answer is truncated. It is meant as a control
+// when comparing with encrypted DNS.
+type Do53Resolver struct {
+	// Addr is the host:port of the server.
+	Addr string
+
+	// TCP sends every query over TCP.
+	TCP bool
+
+	// Timeout bounds each query, including the TCP fallback. Zero means no
+	// timeout beyond that of ctx.
+	Timeout time.Duration
+
+	// Dialer dials the connections. If nil, a zero net.Dialer is used.
+	Dialer *net.Dialer
+
+	// Logger receives the raw responses at debug level. If nil, nothing
+	// is logged.
+	Logger *slog.Logger
+
+	// DNSSEC requests the RRSIGs needed for local validation.
+	DNSSEC bool
+
+	// Randomize sends UDP queries from a random source port and
+	// randomizes the case of the query name (DNS 0x20), discarding answers
+	// that do not echo it exactly. Discarded answers are counted in
+	// DNSResponse.SuspiciousReplies as potentially injected.
+	Randomize bool
+}
+
+// newDo53Resolver builds a Do53Resolver from a udp://host[:port] or
+// tcp://host[:port] URL.
+func newDo53Resolver(u *url.URL) *Do53Resolver {
+	return &Do53Resolver{
+		Addr: hostPort(u, Do53Port),
+		TCP:  u.Scheme == "tcp",
+	}
+}
+
+func (r *Do53Resolver) String() string {
+	if r.TCP {
+		return "tcp://" + r.Addr
+	}
+	return "udp://" + r.Addr
+}
+
+func (r *Do53Resolver) dialer() *net.Dialer {
+	if r.Dialer == nil {
+		return &net.Dialer{}
+	}
+	return r.Dialer
+}
+
+// Query implements Resolver.
+func (r *Do53Resolver) Query(ctx context.Context, name string, qtype RRType) (*DNSResponse, error) {
+	if r.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.Timeout)
+		defer cancel()
+	}
+	id := newQueryID()
+	var qname string
+	if r.Randomize {
+		qname = randomizeCase(fqdn(name))
+	}
+	msg, err := buildQuery(id, cmp.Or(qname, name), qtype, r.DNSSEC)
+	if err != nil {
+		return nil, err
+	}
+	suspicious := 0
+	if !r.TCP {
+		resp, err := r.exchangeUDP(ctx, msg, id, name, qname)
+		if err != nil || !resp.TC {
+			return resp, err
+		}
+		suspicious = resp.SuspiciousReplies
+		if r.Logger != nil {
+			r.Logger.Debug("truncated UDP response, retrying over TCP", "name", name, "server", r.Addr)
+		}
+	}
+	resp, err := r.exchangeTCP(ctx, msg, id, name, qname)
+	if err == nil && !r.TCP {
+		resp.Truncated = true
+		resp.SuspiciousReplies = suspicious
+	}
+	return resp, err
+}
+
+// dialUDP dials the server, from a random source port if r.Randomize is set
+// and the Dialer has no LocalAddr. If no random port can be bound the
+// operating system picks one.
+func (r *Do53Resolver) dialUDP(ctx context.Context) (net.Conn, error) {
+	if r.Randomize && r.dialer().LocalAddr == nil {
+		d := *r.dialer()
+		for range 3 {
+			d.LocalAddr = &net.UDPAddr{Port: 1024 + rand.IntN(65536-1024)}
+			if conn, err := d.DialContext(ctx, "udp", r.Addr); err == nil {
+				return conn, nil
+			}
+		}
+	}
+	return r.dialer().DialContext(ctx, "udp", r.Addr)
+}
+
+func (r *Do53Resolver) exchangeUDP(ctx context.Context, msg []byte, id uint16, name, qname string) (*DNSResponse, error) {
+	conn, err := r.dialUDP(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("DNS query for %s failed: %w", name, err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	if _, err := conn.Write(msg); err != nil {
+		return nil, fmt.Errorf("DNS query for %s failed: %w", name, contextError(ctx, err))
+	}
+	buf := make([]byte, 65535)
+	suspicious := 0
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			if suspicious > 0 {
+				return nil, fmt.Errorf("%w: all %d answers for %s from %s failed the ID or case check",
+					ErrDNSInjection, suspicious, name, r.Addr)
+			}
+			return nil, fmt.Errorf("DNS query for %s failed: %w", name, contextError(ctx, err))
+		}
+		if r.Logger != nil {
+			r.Logger.Debug("UDP response", "name", name, "server", r.Addr, "length", n)
+		}
+		resp, err := parseEchoedReply(buf[:n], id, name, qname)
+		// Ignore stray or spoofed datagrams not answering our query
+		// and wait for the real answer until the deadline.
+		if errors.Is(err, ErrDNSResponse) {
+			suspicious++
+			if r.Logger != nil {
+				r.Logger.Debug("discarding suspicious UDP response", "name", name, "server", r.Addr, "error", err)
+			}
+			continue
+		}
+		if resp != nil {
+			resp.SuspiciousReplies = suspicious
+		}
+		return resp, err
+	}
+}
+
+func (r *Do53Resolver) exchangeTCP(ctx context.Context, msg []byte, id uint16, name, qname string) (*DNSResponse, error) {
+	conn, err := r.dialer().DialContext(ctx, "tcp", r.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("DNS query for %s failed: %w", name, err)
+	}
+	defer conn.Close()
+	data, err := exchangeStream(ctx, conn, msg)
+	if err != nil {
+		return nil, fmt.Errorf("DNS query for %s failed: %w", name, err)
+	}
+	if r.Logger != nil {
+		r.Logger.Debug("TCP response", "name", name, "server", r.Addr, "length", len(data))
+	}
+	return parseEchoedReply(data, id, name, qname)
+}
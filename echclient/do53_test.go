@@ -0,0 +1,60 @@
+package echclient
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// serveTruncatedUDP answers one query read from conn with an empty
+// truncated response.
+func serveTruncatedUDP(conn net.PacketConn) error {
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 65535)
+	n, addr, err := conn.ReadFrom(buf)
+	if err != nil {
+		return err
+	}
+	reply, err := testReply(buf[:n], true)
+	if err != nil {
+		return err
+	}
+	_, err = conn.WriteTo(reply, addr)
+	return err
+}
+
+func TestDo53ResolverTruncated(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	udp, err := net.ListenPacket("udp", ln.Addr().String())
+	if err != nil {
+		t.Skipf("cannot listen on UDP %s: %v", ln.Addr(), err)
+	}
+	defer udp.Close()
+
+	for _, randomize := range []bool{false, true} {
+		udpErr, tcpErr := make(chan error, 1), make(chan error, 1)
+		go func() { udpErr <- serveTruncatedUDP(udp) }()
+		go func() { tcpErr <- serveStream(ln) }()
+		r := &Do53Resolver{Addr: ln.Addr().String(), Timeout: 5 * time.Second, Randomize: randomize}
+		resp, err := r.Query(context.Background(), "Example.com", TypeA)
+		if err != nil {
+			t.Fatalf("Query() with Randomize %v error = %v", randomize, err)
+		}
+		if !resp.Truncated || len(resp.Answer) != 1 || resp.Answer[0].Data != "192.0.2.1" {
+			t.Errorf("Query() with Randomize %v = truncated %v, answer %+v, want the TCP answer 192.0.2.1",
+				randomize, resp.Truncated, resp.Answer)
+		} else if resp.Answer[0].Name != "Example.com." {
+			t.Errorf("Query() with Randomize %v answer name = %q, want Example.com.", randomize, resp.Answer[0].Name)
+		}
+		for _, errc := range []chan error{udpErr, tcpErr} {
+			if err := <-errc; err != nil {
+				t.Errorf("server: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package echclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+type DNSQuestion struct {
+	Name string `json:"name"`
+	Type int    `json:"type"`
+}
+
+type DNSAnswer struct {
+	Name string `json:"name"`
+	Type int    `json:"type"`
+	TTL  int    `json:"TTL"`
+	Data string `json:"data"`
+}
+
+type DNSResponse struct {
+	Status    int           `json:"Status"`
+	TC        bool          `json:"TC"`
+	RD        bool          `json:"RD"`
+	RA        bool          `json:"RA"`
+	AD        bool          `json:"AD"`
+	CD        bool          `json:"CD"`
+	Question  []DNSQuestion `json:"Question"`
+	Answer    []DNSAnswer   `json:"Answer"`
+	Authority []DNSAnswer   `json:"Authority,omitempty"`
+
+	// Resolver names the resolver that answered. It is set by
+	// ProbeConfig.Query.
+	Resolver string `json:"-"`
+
+	// Truncated records that the first answer had the TC bit set and the
+	// query was repeated over TCP or with DoH POST.
+	Truncated bool `json:"-"`
+
+	// Stale records that the response is an expired cache entry served
+	// because the resolver failed (RFC 8767).
+	Stale bool `json:"-"`
+
+	// SuspiciousReplies counts the UDP answers discarded because their ID
+	// or query name casing did not match the query, which hints at
+	// injection by an on-path or off-path attacker.
+	SuspiciousReplies int `json:"-"`
+}
+
+// DoHMethod selects the encoding used by a DoHResolver.
+type DoHMethod string
+
+const (
+	// DoHJSON uses the application/dns-json API offered by Cloudflare and
+	// Google.
+	DoHJSON DoHMethod = "json"
+
+	// DoHPost sends RFC 8484 wire-format queries in the body of a POST
+	// request.
+	DoHPost DoHMethod = "post"
+
+	// DoHGet sends RFC 8484 wire-format queries base64url encoded in the
+	// dns parameter of a GET request, which HTTP caches can serve.
+	DoHGet DoHMethod = "get"
+)
+
+// DoHResolver is a DNS-over-HTTPS Resolver.
+type DoHResolver struct {
+	// URL is the DoH endpoint, e.g. https://cloudflare-dns.com/dns-query.
+	// It may be an RFC 6570 URI template with a dns variable, such as
+	// https://dns.example/query{?dns}, as advertised by dohpath.
+	URL string
+
+	// Method selects the encoding. If empty, DoHJSON is used.
+	Method DoHMethod
+
+	// Client sends the requests. If nil, http.DefaultClient is used.
+	Client *http.Client
+
+	// Logger receives the raw responses at debug level. If nil, nothing
+	// is logged.
+	Logger *slog.Logger
+
+	// DNSSEC requests the RRSIGs needed for local validation.
+	DNSSEC bool
+}
+
+func (r *DoHResolver) String() string {
+	return r.URL
+}
+
+// Query implements Resolver.
+func (r *DoHResolver) Query(ctx context.Context, name string, qtype RRType) (*DNSResponse, error) {
+	var (
+		dnsResponse *DNSResponse
+		err         error
+	)
+	switch r.Method {
+	case "", DoHJSON:
+		dnsResponse, err = r.queryJSON(ctx, name, qtype)
+		if err == nil && dnsResponse.TC {
+			// The JSON API relays truncated UDP answers from upstream;
+			// the wire format carries the full message.
+			if r.Logger != nil {
+				r.Logger.Debug("truncated DoH JSON response, retrying with POST", "name", name, "url", r.URL)
+			}
+			post := *r
+			post.Method = DoHPost
+			dnsResponse, err = post.queryWire(ctx, name, qtype)
+			if err == nil {
+				dnsResponse.Truncated = true
+			}
+		}
+	case DoHPost, DoHGet:
+		dnsResponse, err = r.queryWire(ctx, name, qtype)
+	default:
+		return nil, fmt.Errorf("unsupported DoH method %q", r.Method)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if dnsResponse.Status != 0 {
+		return nil, &DNSStatusError{Name: name, Status: dnsResponse.Status}
+	}
+	return dnsResponse, nil
+}
+
+// endpoint returns the URL of a request carrying the given dns parameter,
+// expanding URL if it is a template. An empty dns is left out.
+func (r *DoHResolver) endpoint(dns string) (*url.URL, error) {
+	vars := map[string]string{}
+	if dns != "" {
+		vars["dns"] = dns
+	}
+	if !strings.Contains(r.URL, "{") {
+		u, err := url.Parse(r.URL)
+		if err != nil || dns == "" {
+			return u, err
+		}
+		query := u.Query()
+		query.Set("dns", dns)
+		u.RawQuery = query.Encode()
+		return u, nil
+	}
+	expanded, err := expandURITemplate(r.URL, vars)
+	if err != nil {
+		return nil, err
+	}
+	return url.Parse(expanded)
+}
+
+func (r *DoHResolver) queryJSON(ctx context.Context, name string, qtype RRType) (*DNSResponse, error) {
+	url, err := r.endpoint("")
+	if err != nil {
+		return nil, err
+	}
+	query := url.Query()
+	query.Set("name", name)
+	query.Set("type", strconv.Itoa(int(qtype)))
+	if r.DNSSEC {
+		query.Set("do", "1")
+		query.Set("cd", "1")
+	}
+	url.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, "GET", url.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/dns-json")
+	data, _, err := r.do(req, name)
+	if err != nil {
+		return nil, err
+	}
+	dnsResponse := DNSResponse{}
+	err = json.Unmarshal(data, &dnsResponse)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDoHResponse, err)
+	}
+	return &dnsResponse, nil
+}
+
+func (r *DoHResolver) queryWire(ctx context.Context, name string, qtype RRType) (*DNSResponse, error) {
+	// RFC 8484 section 4.1: the DNS ID should be 0 for cache friendliness.
+	msg, err := buildQuery(0, name, qtype, r.DNSSEC)
+	if err != nil {
+		return nil, err
+	}
+	var req *http.Request
+	if r.Method == DoHGet {
+		url, err := r.endpoint(base64.RawURLEncoding.EncodeToString(msg))
+		if err != nil {
+			return nil, err
+		}
+		req, err = http.NewRequestWithContext(ctx, "GET", url.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		url, err := r.endpoint("")
+		if err != nil {
+			return nil, err
+		}
+		req, err = http.NewRequestWithContext(ctx, "POST", url.String(), bytes.NewReader(msg))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/dns-message")
+	}
+	req.Header.Set("Accept", "application/dns-message")
+	data, header, err := r.do(req, name)
+	if err != nil {
+		return nil, err
+	}
+	dnsResponse, _, err := parseResponse(data)
+	if err != nil {
+		return nil, err
+	}
+	applyAge(dnsResponse, header)
+	return dnsResponse, nil
+}
+
+// applyAge decrements the TTLs of a response served from an HTTP cache by
+// the value of its Age header, as required by RFC 8484 section 5.1.
+func applyAge(resp *DNSResponse, header http.Header) {
+	age, err := strconv.Atoi(header.Get("Age"))
+	if err != nil || age <= 0 {
+		return
+	}
+	for i := range resp.Answer {
+		resp.Answer[i].TTL = max(resp.Answer[i].TTL-age, 0)
+	}
+}
+
+// do sends req and returns the response body and headers.
+func (r *DoHResolver) do(req *http.Request, name string) ([]byte, http.Header, error) {
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("DoH query for %s failed: %w", name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("%w: HTTP status %d", ErrDoHResponse, resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read DoH response: %w", err)
+	}
+	if r.Logger != nil {
+		r.Logger.Debug("DoH response", "name", name, "url", r.URL,
+			"cache_control", resp.Header.Get("Cache-Control"), "age", resp.Header.Get("Age"),
+			"body", string(data))
+	}
+	return data, resp.Header, nil
+}
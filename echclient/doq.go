@@ -0,0 +1,123 @@
+package echclient
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/netip"
+	"net/url"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// DoQPort is the default port of DNS-over-QUIC servers.
+const DoQPort = "853"
+
+// doqNoError is the DOQ_NO_ERROR application error code of RFC 9250.
+const doqNoError = 0x0
+
+// DoQResolver is a DNS-over-QUIC (RFC 9250) Resolver. Each query uses a new
+// connection, resuming the previous session with 0-RTT when the server
+// permits it.
+type DoQResolver struct {
+	// Addr is the host:port of the server.
+	Addr string
+
+	// ServerName is used to verify the server certificate. If empty, the
+	// host of Addr is used.
+	ServerName string
+
+	// PinnedSPKI lists SHA-256 digests of acceptable SubjectPublicKeyInfo,
+	// with the same semantics as DoTResolver.PinnedSPKI.
+	PinnedSPKI [][]byte
+
+	// SessionCache stores the sessions resumed by later queries. If nil,
+	// sessions are not resumed and 0-RTT is never used.
+	SessionCache tls.ClientSessionCache
+
+	// Timeout bounds each query, including the handshake. Zero means no
+	// timeout beyond that of ctx.
+	Timeout time.Duration
+
+	// Logger receives the raw responses at debug level. If nil, nothing
+	// is logged.
+	Logger *slog.Logger
+
+	// DNSSEC requests the RRSIGs needed for local validation.
+	DNSSEC bool
+
+	// designatedFor is the address the certificate must also cover when
+	// the resolver was found by DDR.
+	designatedFor netip.Addr
+}
+
+// newDoQResolver builds a DoQResolver from a quic://host[:port] URL, with
+// the same query parameters as tls:// URLs.
+func newDoQResolver(u *url.URL) (*DoQResolver, error) {
+	pins, err := parsePins(u)
+	if err != nil {
+		return nil, err
+	}
+	return &DoQResolver{
+		Addr:         hostPort(u, DoQPort),
+		ServerName:   u.Query().Get("sni"),
+		PinnedSPKI:   pins,
+		SessionCache: tls.NewLRUClientSessionCache(0),
+	}, nil
+}
+
+func (r *DoQResolver) String() string {
+	return "quic://" + r.Addr
+}
+
+// Query implements Resolver.
+func (r *DoQResolver) Query(ctx context.Context, name string, qtype RRType) (*DNSResponse, error) {
+	if r.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.Timeout)
+		defer cancel()
+	}
+	// RFC 9250 section 4.2.1: the DNS ID must be 0.
+	msg, err := buildQuery(0, name, qtype, r.DNSSEC)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := resolverTLSConfig(r.Addr, r.ServerName, r.PinnedSPKI, "doq", r.designatedFor)
+	tlsConfig.ClientSessionCache = r.SessionCache
+	conn, err := quic.DialAddrEarly(ctx, r.Addr, tlsConfig, nil)
+	if err != nil {
+		return nil, fmt.Errorf("DoQ connection to %s failed: %w", r.Addr, err)
+	}
+	defer conn.CloseWithError(doqNoError, "")
+
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("DoQ query for %s failed: %w", name, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetDeadline(deadline)
+	}
+	buf := make([]byte, 2+len(msg))
+	buf[0], buf[1] = byte(len(msg)>>8), byte(len(msg))
+	copy(buf[2:], msg)
+	if _, err := stream.Write(buf); err != nil {
+		return nil, fmt.Errorf("DoQ query for %s failed: %w", name, contextError(ctx, err))
+	}
+	// The client signals the end of the query with a STREAM FIN.
+	stream.Close()
+	data, err := io.ReadAll(stream)
+	if err != nil {
+		return nil, fmt.Errorf("DoQ query for %s failed: %w", name, contextError(ctx, err))
+	}
+	if len(data) < 2 || int(data[0])<<8|int(data[1]) != len(data)-2 {
+		return nil, fmt.Errorf("%w: bad DoQ length prefix", ErrDNSResponse)
+	}
+	if r.Logger != nil {
+		r.Logger.Debug("DoQ response", "name", name, "server", r.Addr, "length", len(data),
+			"used_0rtt", conn.ConnectionState().Used0RTT)
+	}
+	return parseReply(data[2:], 0, name)
+}
@@ -0,0 +1,84 @@
+package echclient
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// serveDoQ answers one query on a stream of a connection accepted from ln,
+// checking its 2-byte length prefix and its zero ID.
+func serveDoQ(ln *quic.Listener) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := ln.Accept(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.CloseWithError(doqNoError, "")
+	stream, err := conn.AcceptStream(ctx)
+	if err != nil {
+		return err
+	}
+	// The client ends the stream after its query.
+	data, err := io.ReadAll(stream)
+	if err != nil {
+		return err
+	}
+	if len(data) < 2+12 || int(binary.BigEndian.Uint16(data)) != len(data)-2 {
+		return fmt.Errorf("query of %d bytes has a bad length prefix", len(data))
+	}
+	if id := binary.BigEndian.Uint16(data[2:]); id != 0 {
+		return fmt.Errorf("query ID = %d, want 0", id)
+	}
+	reply, err := testReply(data[2:], false)
+	if err != nil {
+		return err
+	}
+	if _, err := stream.Write(binary.BigEndian.AppendUint16(nil, uint16(len(reply)))); err != nil {
+		return err
+	}
+	if _, err := stream.Write(reply); err != nil {
+		return err
+	}
+	stream.Close()
+	// Closing the connection first could discard the unread reply.
+	select {
+	case <-conn.Context().Done():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestDoQResolver(t *testing.T) {
+	cert, pin := testCertificate(t)
+	ln, err := quic.ListenAddr("127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"doq"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	errc := make(chan error, 1)
+	go func() { errc <- serveDoQ(ln) }()
+
+	r := &DoQResolver{Addr: ln.Addr().String(), ServerName: "dns.example", PinnedSPKI: [][]byte{pin}, Timeout: 5 * time.Second}
+	resp, err := r.Query(context.Background(), "example.com", TypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Answer) != 1 || resp.Answer[0].Data != "192.0.2.1" {
+		t.Errorf("Query() answer = %+v, want 192.0.2.1", resp.Answer)
+	}
+	if err := <-errc; err != nil {
+		t.Errorf("server: %v", err)
+	}
+}
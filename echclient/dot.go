@@ -0,0 +1,166 @@
+package echclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"net/url"
+	"time"
+)
+
+// DoTPort is the default port of DNS-over-TLS servers.
+const DoTPort = "853"
+
+// DoTResolver is a DNS-over-TLS (RFC 7858) Resolver. Each query uses a new
+// connection.
+type DoTResolver struct {
+	// Addr is the host:port of the server.
+	Addr string
+
+	// ServerName is used to verify the server certificate. If empty, the
+	// host of Addr is used.
+	ServerName string
+
+	// PinnedSPKI lists SHA-256 digests of acceptable SubjectPublicKeyInfo.
+	// When set, the connection is accepted if any certificate of the
+	// chain matches a pin, as in the RFC 7858 out-of-band key-pinned
+	// privacy profile, and the usual certificate validation is skipped.
+	PinnedSPKI [][]byte
+
+	// Timeout bounds each query, including the TLS handshake. Zero means
+	// no timeout beyond that of ctx.
+	Timeout time.Duration
+
+	// Dialer dials the TCP connection. If nil, a zero net.Dialer is used.
+	Dialer *net.Dialer
+
+	// Logger receives the raw responses at debug level. If nil, nothing
+	// is logged.
+	Logger *slog.Logger
+
+	// DNSSEC requests the RRSIGs needed for local validation.
+	DNSSEC bool
+
+	// designatedFor is the address the certificate must also cover when
+	// the resolver was found by DDR.
+	designatedFor netip.Addr
+}
+
+// newDoTResolver builds a DoTResolver from a tls://host[:port] URL. The
+// optional query parameters sni and pin-sha256 (base64, repeatable) set
+// ServerName and PinnedSPKI.
+func newDoTResolver(u *url.URL) (*DoTResolver, error) {
+	pins, err := parsePins(u)
+	if err != nil {
+		return nil, err
+	}
+	return &DoTResolver{
+		Addr:       hostPort(u, DoTPort),
+		ServerName: u.Query().Get("sni"),
+		PinnedSPKI: pins,
+	}, nil
+}
+
+// parsePins decodes the pin-sha256 query parameters of u.
+func parsePins(u *url.URL) ([][]byte, error) {
+	var pins [][]byte
+	for _, pin := range u.Query()["pin-sha256"] {
+		digest, err := base64.StdEncoding.DecodeString(pin)
+		if err != nil || len(digest) != sha256.Size {
+			return nil, fmt.Errorf("invalid pin-sha256 %q", pin)
+		}
+		pins = append(pins, digest)
+	}
+	return pins, nil
+}
+
+// hostPort returns the host:port of u, using defaultPort if u has none.
+func hostPort(u *url.URL, defaultPort string) string {
+	port := u.Port()
+	if port == "" {
+		port = defaultPort
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+func (r *DoTResolver) String() string {
+	return "tls://" + r.Addr
+}
+
+// Query implements Resolver.
+func (r *DoTResolver) Query(ctx context.Context, name string, qtype RRType) (*DNSResponse, error) {
+	if r.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.Timeout)
+		defer cancel()
+	}
+	id := newQueryID()
+	msg, err := buildQuery(id, name, qtype, r.DNSSEC)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &tls.Dialer{
+		NetDialer: r.Dialer,
+		Config:    resolverTLSConfig(r.Addr, r.ServerName, r.PinnedSPKI, "dot", r.designatedFor),
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", r.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("DoT connection to %s failed: %w", r.Addr, err)
+	}
+	defer conn.Close()
+	data, err := exchangeStream(ctx, conn, msg)
+	if err != nil {
+		return nil, fmt.Errorf("DoT query for %s failed: %w", name, err)
+	}
+	if r.Logger != nil {
+		r.Logger.Debug("DoT response", "name", name, "server", r.Addr, "length", len(data))
+	}
+	return parseReply(data, id, name)
+}
+
+// resolverTLSConfig returns the TLS configuration for connecting to an
+// encrypted DNS server at addr, verifying it against serverName (or the host
+// of addr) or, if any, the pinned public keys. If designatedFor is valid, the
+// certificate must also cover it, as required by DDR.
+func resolverTLSConfig(addr, serverName string, pins [][]byte, alpn string, designatedFor netip.Addr) *tls.Config {
+	if serverName == "" {
+		serverName, _, _ = net.SplitHostPort(addr)
+	}
+	config := &tls.Config{
+		ServerName: serverName,
+		NextProtos: []string{alpn},
+	}
+	if designatedFor.IsValid() {
+		config.VerifyConnection = func(cs tls.ConnectionState) error {
+			return verifyDesignated(cs, designatedFor)
+		}
+	}
+	if len(pins) > 0 {
+		config.InsecureSkipVerify = true
+		config.VerifyConnection = func(cs tls.ConnectionState) error {
+			return verifyPins(cs.PeerCertificates, pins)
+		}
+	}
+	return config
+}
+
+// verifyPins returns an SPKIPinError unless a certificate of certs has one
+// of the pinned SubjectPublicKeyInfo digests.
+func verifyPins(certs []*x509.Certificate, pins [][]byte) error {
+	for _, cert := range certs {
+		digest := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		for _, pin := range pins {
+			if bytes.Equal(digest[:], pin) {
+				return nil
+			}
+		}
+	}
+	return &SPKIPinError{Certificates: certs}
+}
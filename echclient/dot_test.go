@@ -0,0 +1,151 @@
+package echclient
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// testCertificate returns a self-signed certificate for dns.example and the
+// SHA-256 digest of its SubjectPublicKeyInfo, to be pinned by the client.
+func testCertificate(t *testing.T) (tls.Certificate, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{"dns.example"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pin := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}, pin[:]
+}
+
+// testReply answers query with the address 192.0.2.1, or with an empty
+// truncated response.
+func testReply(query []byte, truncated bool) ([]byte, error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(query)
+	if err != nil {
+		return nil, err
+	}
+	q, err := p.Question()
+	if err != nil {
+		return nil, err
+	}
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{
+		ID:                 h.ID,
+		Response:           true,
+		RecursionDesired:   h.RecursionDesired,
+		RecursionAvailable: true,
+		Truncated:          truncated,
+	})
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(q); err != nil {
+		return nil, err
+	}
+	if !truncated {
+		if err := b.StartAnswers(); err != nil {
+			return nil, err
+		}
+		rh := dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: 300}
+		if err := b.AResource(rh, dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}}); err != nil {
+			return nil, err
+		}
+	}
+	return b.Finish()
+}
+
+// serveStream answers one query on a connection accepted from ln, checking
+// its 2-byte length prefix. The prefix of the reply is written separately
+// to exercise the reassembly of the client.
+func serveStream(ln net.Listener) error {
+	conn, err := ln.Accept()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return err
+	}
+	query := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, query); err != nil {
+		return err
+	}
+	reply, err := testReply(query, false)
+	if err != nil {
+		return err
+	}
+	if _, err := conn.Write(binary.BigEndian.AppendUint16(nil, uint16(len(reply)))); err != nil {
+		return err
+	}
+	_, err = conn.Write(reply)
+	return err
+}
+
+func TestDoTResolver(t *testing.T) {
+	cert, pin := testCertificate(t)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"dot"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	errc := make(chan error, 1)
+	go func() { errc <- serveStream(ln) }()
+
+	r := &DoTResolver{Addr: ln.Addr().String(), ServerName: "dns.example", PinnedSPKI: [][]byte{pin}, Timeout: 5 * time.Second}
+	resp, err := r.Query(context.Background(), "example.com", TypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Answer) != 1 || resp.Answer[0].Data != "192.0.2.1" {
+		t.Errorf("Query() answer = %+v, want 192.0.2.1", resp.Answer)
+	}
+	if err := <-errc; err != nil {
+		t.Errorf("server: %v", err)
+	}
+
+	// Without a matching pin the handshake fails.
+	go func() {
+		if conn, err := ln.Accept(); err == nil {
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+	r.PinnedSPKI = [][]byte{make([]byte, sha256.Size)}
+	var pinErr *SPKIPinError
+	if _, err := r.Query(context.Background(), "example.com", TypeA); !errors.As(err, &pinErr) {
+		t.Errorf("Query() with another pin error = %v, want an SPKIPinError", err)
+	}
+}
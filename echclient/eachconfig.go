@@ -0,0 +1,64 @@
+package echclient
+
+import "context"
+
+// ConfigProbe is the outcome of a handshake offering a single config of the
+// ECHConfigList.
+type ConfigProbe struct {
+	ConfigID    uint8  `json:"config_id"`
+	PublicName  string `json:"public_name"`
+	ECHAccepted bool   `json:"ech_accepted"`
+	ECHRejected bool   `json:"ech_rejected"`
+	RemoteAddr  string `json:"remote_addr,omitempty"`
+	Error       string `json:"error,omitempty"`
+	ErrorClass  string `json:"error_class,omitempty"`
+}
+
+// AcceptedConfigIDs returns the config_ids the server accepted in
+// ConfigProbes.
+func (r *ProbeResult) AcceptedConfigIDs() []uint8 {
+	var ids []uint8
+	for _, p := range r.ConfigProbes {
+		if p.ECHAccepted {
+			ids = append(ids, p.ConfigID)
+		}
+	}
+	return ids
+}
+
+// probeConfigs repeats the request of r offering each config of
+// echConfigList on its own, over the same dialer, and records the outcomes
+// in r.ConfigProbes. Rejections are not retried: the retry configs say
+// nothing about the config offered.
+func (c *ProbeConfig) probeConfigs(ctx context.Context, r *ProbeResult, targetURL string, echConfigList []byte, dial dialFunc) {
+	configs, err := ParseECHConfigList(echConfigList)
+	if err != nil {
+		return
+	}
+	for i := range configs {
+		ec := &configs[i]
+		p := ConfigProbe{ConfigID: ec.ConfigID, PublicName: string(ec.PublicName)}
+		single, err := ECHConfigList{*ec}.Marshal()
+		if err == nil {
+			attempt := &ProbeResult{
+				URL:            targetURL,
+				Resolver:       r.Resolver,
+				ECHMode:        r.ECHMode,
+				ECHConfigList:  single,
+				AdvertisedALPN: r.AdvertisedALPN,
+				WebSocket:      r.WebSocket,
+				Target:         r.Target,
+				AdvertisedPort: r.AdvertisedPort,
+				Port:           r.Port,
+			}
+			err = c.doRequest(ctx, attempt, targetURL, single, dial)
+			p.ECHAccepted, p.ECHRejected, p.RemoteAddr = attempt.ECHAccepted, attempt.ECHRejected, attempt.RemoteAddr
+		}
+		if err != nil {
+			p.Error, p.ErrorClass = err.Error(), ClassifyError(err)
+		}
+		c.logger().Debug("probed ECH config", "config_id", p.ConfigID, "public_name", p.PublicName,
+			"ech_accepted", p.ECHAccepted, "error", p.Error)
+		r.ConfigProbes = append(r.ConfigProbes, p)
+	}
+}
@@ -0,0 +1,232 @@
+// Vendored from go stdlib
+package echclient
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+type ECHCipher struct {
+	KDFID  uint16 `json:"kdf_id"`
+	AEADID uint16 `json:"aead_id"`
+}
+
+type ECHExtension struct {
+	Type uint16 `json:"type"`
+	Data []byte `json:"data"`
+}
+
+type ECHConfig struct {
+	raw []byte
+
+	Version uint16
+	Length  uint16
+
+	ConfigID             uint8
+	KemID                uint16
+	PublicKey            []byte
+	SymmetricCipherSuite []ECHCipher
+
+	MaxNameLength uint8
+	PublicName    []byte
+	Extensions    []ECHExtension
+
+	// trailing counts the bytes of the contents left after the
+	// extensions.
+	trailing int
+}
+
+const extensionEncryptedClientHello uint16 = 0xfe0d
+
+// ParseECHConfigList parses a draft-ietf-tls-esni-18 ECHConfigList, returning a
+// slice of parsed ECHConfigs, in the same order they were parsed, or an error
+// if the list is malformed. Configs of other versions are kept with their raw
+// bytes, decoded when the layout of their draft is known (see ECHVersion).
+func ParseECHConfigList(data []byte) (ECHConfigList, error) {
+	s := cryptobyte.String(data)
+	// Skip the length prefix
+	var length uint16
+	if !s.ReadUint16(&length) {
+		return nil, ErrMalformedECHConfig
+	}
+	if int(length) != len(data)-2 {
+		return nil, fmt.Errorf("%w: list length %d does not match the %d bytes that follow", ErrMalformedECHConfig, length, len(data)-2)
+	}
+	var configs ECHConfigList
+	for len(s) > 0 {
+		var ec ECHConfig
+		ec.raw = []byte(s)
+		if !s.ReadUint16(&ec.Version) {
+			return nil, ErrMalformedECHConfig
+		}
+		// The contents are bounded by Length so that a config with
+		// bytes after its extensions does not desynchronize the list.
+		var contents cryptobyte.String
+		if !s.ReadUint16LengthPrefixed(&contents) {
+			return nil, ErrMalformedECHConfig
+		}
+		ec.Length = uint16(len(contents))
+		ec.raw = ec.raw[:ec.Length+4]
+		if ec.Version != extensionEncryptedClientHello {
+			parseLegacyContents(&ec, contents)
+			configs = append(configs, ec)
+			continue
+		}
+		if !contents.ReadUint8(&ec.ConfigID) {
+			return nil, ErrMalformedECHConfig
+		}
+		if !contents.ReadUint16(&ec.KemID) {
+			return nil, ErrMalformedECHConfig
+		}
+		if !contents.ReadUint16LengthPrefixed((*cryptobyte.String)(&ec.PublicKey)) {
+			return nil, ErrMalformedECHConfig
+		}
+		var cipherSuites cryptobyte.String
+		if !contents.ReadUint16LengthPrefixed(&cipherSuites) {
+			return nil, ErrMalformedECHConfig
+		}
+		for !cipherSuites.Empty() {
+			var c ECHCipher
+			if !cipherSuites.ReadUint16(&c.KDFID) {
+				return nil, ErrMalformedECHConfig
+			}
+			if !cipherSuites.ReadUint16(&c.AEADID) {
+				return nil, ErrMalformedECHConfig
+			}
+			ec.SymmetricCipherSuite = append(ec.SymmetricCipherSuite, c)
+		}
+		if !contents.ReadUint8(&ec.MaxNameLength) {
+			return nil, ErrMalformedECHConfig
+		}
+		var publicName cryptobyte.String
+		if !contents.ReadUint8LengthPrefixed(&publicName) {
+			return nil, ErrMalformedECHConfig
+		}
+		ec.PublicName = publicName
+		var extensions cryptobyte.String
+		if !contents.ReadUint16LengthPrefixed(&extensions) {
+			return nil, ErrMalformedECHConfig
+		}
+		for !extensions.Empty() {
+			var e ECHExtension
+			if !extensions.ReadUint16(&e.Type) {
+				return nil, ErrMalformedECHConfig
+			}
+			if !extensions.ReadUint16LengthPrefixed((*cryptobyte.String)(&e.Data)) {
+				return nil, ErrMalformedECHConfig
+			}
+			ec.Extensions = append(ec.Extensions, e)
+		}
+		ec.trailing = len(contents)
+
+		configs = append(configs, ec)
+	}
+	return configs, nil
+}
+
+// HPKE algorithms supported by crypto/tls.
+var (
+	supportedKEMs  = map[uint16]bool{X25519: true}
+	supportedKDFs  = map[uint16]bool{HKDFSHA256: true}
+	supportedAEADs = map[uint16]bool{AES128GCM: true, AES256GCM: true, ChaCha20Poly1305: true}
+)
+
+// pickECHConfig returns the first config in list that crypto/tls is able to
+// use, or nil if there is none.
+func pickECHConfig(list []ECHConfig) *ECHConfig {
+	for _, ec := range list {
+		if ec.Version != extensionEncryptedClientHello {
+			continue
+		}
+		if !supportedKEMs[ec.KemID] {
+			continue
+		}
+		var validSCS bool
+		for _, cs := range ec.SymmetricCipherSuite {
+			if !supportedAEADs[cs.AEADID] {
+				continue
+			}
+			if !supportedKDFs[cs.KDFID] {
+				continue
+			}
+			validSCS = true
+			break
+		}
+		if !validSCS {
+			continue
+		}
+		if !validDNSName(string(ec.PublicName)) {
+			continue
+		}
+		var unsupportedExt bool
+		for _, ext := range ec.Extensions {
+			// If high order bit is set to 1 the extension is mandatory.
+			// Since we don't support any extensions, if we see a mandatory
+			// bit, we skip the config.
+			if ext.Type&uint16(1<<15) != 0 {
+				unsupportedExt = true
+			}
+		}
+		if unsupportedExt {
+			continue
+		}
+		return &ec
+	}
+	return nil
+}
+
+func generateOuterECHExt(id uint8, kdfID, aeadID uint16, encodedKey []byte, payload []byte) ([]byte, error) {
+	var b cryptobyte.Builder
+	b.AddUint8(0) // outer
+	b.AddUint16(kdfID)
+	b.AddUint16(aeadID)
+	b.AddUint8(id)
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(encodedKey) })
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(payload) })
+	return b.Bytes()
+}
+
+// validDNSName is a rather rudimentary check for the validity of a DNS name.
+// This is used to check if the public_name in a ECHConfig is valid when we are
+// picking a config. This can be somewhat lax because even if we pick a
+// valid-looking name, the DNS layer will later reject it anyway.
+func validDNSName(name string) bool {
+	if len(name) > 253 {
+		return false
+	}
+	labels := strings.Split(name, ".")
+	if len(labels) <= 1 {
+		return false
+	}
+	for _, l := range labels {
+		labelLen := len(l)
+		if labelLen == 0 {
+			return false
+		}
+		for i, r := range l {
+			if r == '-' && (i == 0 || i == labelLen-1) {
+				return false
+			}
+			if (r < '0' || r > '9') && (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && r != '-' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// ECHRejectionError is the error type returned when ECH is rejected by a remote
+// server. If the server offered a ECHConfigList to use for retries, the
+// RetryConfigList field will contain this list.
+//
+// The client may treat an ECHRejectionError with an empty set of RetryConfigs
+// as a secure signal from the server.
+type ECHRejectionError struct {
+	RetryConfigList []byte
+}
+
+func (e *ECHRejectionError) Error() string {
+	return "tls: server rejected ECH"
+}
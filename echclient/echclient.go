@@ -0,0 +1,422 @@
+// Relevant documentation:
+// https://pkg.go.dev/crypto/tls
+// https://datatracker.ietf.org/doc/html/rfc8484
+// https://datatracker.ietf.org/doc/html/rfc1035
+// https://www.ietf.org/archive/id/draft-ietf-dnsop-svcb-https-07.html
+// https://datatracker.ietf.org/doc/draft-ietf-tls-esni/
+// https://datatracker.ietf.org/doc/html/rfc3597
+// https://test.defo.ie/iframe_tests.html
+
+// Package echclient fetches Encrypted Client Hello configurations over
+// DNS-over-HTTPS and uses them to establish ECH-enabled TLS connections.
+package echclient
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+type ParsedEchConfig struct {
+	// Record is the selected endpoint.
+	Record  *HttpsRecord
+	Configs ECHConfigList
+	Raw     []byte
+
+	// Endpoints lists the usable ServiceMode records of the HTTPS RRset
+	// in SvcPriority order.
+	Endpoints []*HttpsRecord
+
+	// Skipped lists the ServiceMode records ignored because they make
+	// unsupported keys mandatory, with the reason.
+	Skipped []SkippedRecord
+
+	// Owner is the owner name of the ServiceMode records and Target the
+	// name of the endpoint of Record, Owner when its TargetName is ".".
+	Owner  string
+	Target string
+
+	// Resolver names the resolver that answered the HTTPS query.
+	Resolver string
+
+	// CNAMEChain lists the names the queried name is an alias of, in
+	// order; the last one owns the HTTPS RR.
+	CNAMEChain []string
+
+	// AliasChain lists the TargetNames of the AliasMode records followed
+	// to the ServiceMode records.
+	AliasChain []string
+
+	// DNSSEC is the validation status of the HTTPS RRset, set when
+	// ProbeConfig.ValidateDNSSEC is, and DNSSECError explains a bogus
+	// status.
+	DNSSEC      DNSSECStatus
+	DNSSECError error
+
+	// Truncated records that the HTTPS answer was truncated and the query
+	// was repeated over TCP or with DoH POST.
+	Truncated bool
+
+	// Stale records that the HTTPS answer is an expired cache entry, used
+	// because the resolver failed.
+	Stale bool
+
+	// SuspiciousReplies counts the UDP answers discarded because their ID
+	// or 0x20 casing did not match the query.
+	SuspiciousReplies int
+
+	// ConfigLists lists the distinct ECHConfigLists published by the
+	// endpoints, those of Record first. Merged is set when Raw holds their
+	// configs merged, as ProbeConfig.MergeECHConfigs asks.
+	ConfigLists [][]byte
+	Merged      bool
+
+	// Warnings lists the specification violations found in the HTTPS
+	// records and the ECHConfigList when ProbeConfig.Strict is not set.
+	Warnings []string
+
+	// ConfigIDWarnings lists the config_ids shared by several configs and,
+	// when ProbeConfig.Cache holds the HTTPS answer the current one
+	// replaced, the config_ids changed since, the usual signs of a botched
+	// key rotation.
+	ConfigIDWarnings []string
+}
+
+// FetchECHConfigList looks up the HTTPS RR for hostname using the default
+// resolver and returns the ECHConfigList published in its ech SvcParam.
+func FetchECHConfigList(ctx context.Context, hostname string) (*ParsedEchConfig, error) {
+	return (*ProbeConfig)(nil).FetchECHConfigList(ctx, hostname)
+}
+
+// FetchECHConfigList looks up the HTTPS RRset for hostname, following
+// AliasMode records, selects an endpoint and returns the ECHConfigList
+// published in its ech SvcParam.
+func (c *ProbeConfig) FetchECHConfigList(ctx context.Context, hostname string) (*ParsedEchConfig, error) {
+	var ech ParsedEchConfig
+	hostname, err := ToASCIIHost(hostname)
+	if err != nil {
+		return nil, err
+	}
+	name := canonicalName(hostname)
+	seen := map[string]bool{name: true}
+	for {
+		dnsResponse, owner, links, err := c.querySVCB(ctx, name, TypeHTTPS)
+		for _, link := range links {
+			ech.CNAMEChain = append(ech.CNAMEChain, link.target)
+		}
+		if err != nil {
+			return nil, err
+		}
+		ech.Owner = owner
+		ech.Resolver = dnsResponse.Resolver
+		ech.Truncated = ech.Truncated || dnsResponse.Truncated
+		ech.Stale = ech.Stale || dnsResponse.Stale
+		ech.SuspiciousReplies += dnsResponse.SuspiciousReplies
+		records, err := c.parseHTTPSRRset(hostname, dnsResponse, owner)
+		if err != nil {
+			return nil, err
+		}
+		warnings, err := c.checkRecords(hostname, records)
+		if err != nil {
+			return nil, err
+		}
+		ech.Warnings = append(ech.Warnings, warnings...)
+		if c.validateDNSSEC() {
+			status, err := c.validateChain(ctx, links, dnsResponse, owner, TypeHTTPS)
+			// The chain is only as trustworthy as its weakest link.
+			if ech.DNSSEC == "" || ech.DNSSEC == DNSSECSecure || status == DNSSECBogus && ech.DNSSEC != DNSSECBogus {
+				ech.DNSSEC, ech.DNSSECError = status, err
+			}
+		}
+
+		// RFC 9460, section 2.4.2: ServiceMode records are ignored when
+		// an AliasMode record is present.
+		i := slices.IndexFunc(records, func(r *HttpsRecord) bool {
+			return r.Priority == 0
+		})
+		if i < 0 {
+			ech.Endpoints, ech.Skipped = SelectEndpoint(records, c.endpointPolicy())
+			for _, s := range ech.Skipped {
+				c.logger().Debug("skipping HTTPS record", "name", hostname, "priority", s.Record.Priority,
+					"target", s.Record.TargetName, "reason", s.Reason)
+			}
+			break
+		}
+		alias := records[i]
+		if alias.TargetName == "." {
+			ech.Record = alias
+			return &ech, fmt.Errorf("%w for %s: the AliasMode record marks the service as unavailable", ErrNoHTTPSRecord, hostname)
+		}
+		name = canonicalName(alias.TargetName)
+		ech.AliasChain = append(ech.AliasChain, name)
+		c.logger().Debug("following AliasMode record", "name", hostname, "target", name)
+		if seen[name] || len(ech.AliasChain) > c.maxAliasDepth() {
+			return &ech, fmt.Errorf("%w for %s: %s", ErrAliasChain, hostname, strings.Join(ech.AliasChain, " -> "))
+		}
+		seen[name] = true
+	}
+
+	if len(ech.Endpoints) == 0 && len(ech.Skipped) > 0 {
+		return &ech, fmt.Errorf("%w for %s: all %d records skipped: %s", ErrNoHTTPSRecord, hostname,
+			len(ech.Skipped), ech.Skipped[0].Reason)
+	}
+	record, err := c.selectEndpoint(hostname, ech.Endpoints)
+	if err != nil {
+		return &ech, err
+	}
+	ech.Record = record
+	ech.Target = record.Endpoint(ech.Owner)
+	ech.Raw = record.ECHConfigList()
+	ech.ConfigLists = distinctECHConfigLists(append([]*HttpsRecord{record}, ech.Endpoints...))
+	if len(ech.ConfigLists) > 1 {
+		c.logger().Debug("HTTPS records publish several ECHConfigLists", "name", hostname, "count", len(ech.ConfigLists))
+	}
+	if c.mergeECHConfigs() && (len(ech.ConfigLists) > 1 || len(ech.Raw) == 0 && len(ech.ConfigLists) > 0) {
+		merged, n, err := MergeECHConfigLists(ech.ConfigLists)
+		if err != nil {
+			return &ech, fmt.Errorf("failed to merge ECHConfigLists: %w", err)
+		}
+		c.logger().Debug("merged ECHConfigLists", "name", hostname, "lists", len(ech.ConfigLists), "configs", n)
+		ech.Raw, ech.Merged = merged, true
+	}
+	if len(ech.Raw) == 0 {
+		return &ech, fmt.Errorf("%w for %s", ErrNoECHConfig, hostname)
+	}
+	p, err := ParseECHConfigList(ech.Raw)
+	if err != nil {
+		return &ech, fmt.Errorf("failed to parse echConfig: %w", err)
+	}
+	warnings, err := c.checkConfigs(hostname, p)
+	if err != nil {
+		return &ech, err
+	}
+	ech.Warnings = append(ech.Warnings, warnings...)
+	ech.Configs = p
+	ech.ConfigIDWarnings = c.configIDWarnings(ech.Owner, p, ech.ConfigLists)
+	for _, w := range ech.ConfigIDWarnings {
+		c.logger().Debug("suspicious config_id", "name", hostname, "warning", w)
+	}
+	return &ech, nil
+}
+
+// parseHTTPSRRset parses the HTTPS records owned by owner in resp.
+func (c *ProbeConfig) parseHTTPSRRset(hostname string, resp *DNSResponse, owner string) ([]*HttpsRecord, error) {
+	records, err := parseSVCBRRset(resp, owner, TypeHTTPS)
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		c.logger().Debug("HTTPS record", "name", hostname, "priority", record.Priority,
+			"target", record.TargetName, "params", record.Params)
+		c.hooks().httpsRecordParsed(hostname, record)
+	}
+	return records, nil
+}
+
+// parseSVCBRRset parses the records of type qtype, SVCB or HTTPS, owned by
+// owner in resp. An empty owner matches every name.
+func parseSVCBRRset(resp *DNSResponse, owner string, qtype RRType) ([]*HttpsRecord, error) {
+	var records []*HttpsRecord
+	for _, answer := range resp.Answer {
+		if RRType(answer.Type) != qtype || owner != "" && canonicalName(answer.Name) != owner {
+			continue
+		}
+		record, err := decodeSVCBData(answer.Data)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// LookupSVCB looks up the SVCB RRset of name using the default resolver.
+func LookupSVCB(ctx context.Context, name string, qtype RRType) ([]*SVCBRecord, error) {
+	return (*ProbeConfig)(nil).LookupSVCB(ctx, name, qtype)
+}
+
+// LookupSVCB looks up the RRset of type qtype, TypeSVCB or TypeHTTPS, of
+// name, following CNAMEs, and returns its records in answer order. AliasMode
+// records are returned as is; SortEndpoints orders the ServiceMode ones.
+func (c *ProbeConfig) LookupSVCB(ctx context.Context, name string, qtype RRType) ([]*SVCBRecord, error) {
+	if qtype != TypeSVCB && qtype != TypeHTTPS {
+		return nil, fmt.Errorf("LookupSVCB: unsupported type %s", qtype)
+	}
+	resp, owner, _, err := c.querySVCB(ctx, name, qtype)
+	if err != nil {
+		return nil, err
+	}
+	return parseSVCBRRset(resp, owner, qtype)
+}
+
+// maxCNAMEHops bounds the length of the CNAME chains followed to the HTTPS
+// RR.
+const maxCNAMEHops = 8
+
+// cnameLink is a CNAME followed to the HTTPS or SVCB RRset, with the
+// response holding it.
+type cnameLink struct {
+	owner, target string
+	resp          *DNSResponse
+}
+
+// querySVCB queries the RRset of type qtype, HTTPS or SVCB, of hostname,
+// following the CNAME chain to the canonical name. It returns the response
+// holding the RRset, its owner name and the chain of aliases. Resolvers
+// usually follow the chain in a single answer; when they stop short, the
+// last target is queried again.
+func (c *ProbeConfig) querySVCB(ctx context.Context, hostname string, qtype RRType) (*DNSResponse, string, []cnameLink, error) {
+	name := canonicalName(hostname)
+	seen := map[string]bool{name: true}
+	var chain []cnameLink
+	for {
+		queried := name
+		resp, err := c.Query(ctx, queried, qtype)
+		if err != nil {
+			return nil, "", chain, err
+		}
+		for {
+			// The answer may also carry RRSIGs when DNSSEC records are
+			// requested.
+			if slices.ContainsFunc(resp.Answer, func(a DNSAnswer) bool {
+				return RRType(a.Type) == qtype && canonicalName(a.Name) == name
+			}) {
+				return resp, name, chain, nil
+			}
+			i := slices.IndexFunc(resp.Answer, func(a DNSAnswer) bool {
+				return RRType(a.Type) == TypeCNAME && canonicalName(a.Name) == name
+			})
+			if i < 0 {
+				break
+			}
+			link := cnameLink{owner: name, target: canonicalName(resp.Answer[i].Data), resp: resp}
+			name = link.target
+			chain = append(chain, link)
+			c.logger().Debug("following CNAME", "name", hostname, "target", name)
+			if seen[name] || len(chain) > maxCNAMEHops {
+				targets := make([]string, len(chain))
+				for i, link := range chain {
+					targets[i] = link.target
+				}
+				return nil, "", chain, fmt.Errorf("%w for %s: %s", ErrCNAMEChain, hostname, strings.Join(targets, " -> "))
+			}
+			seen[name] = true
+		}
+		if name == queried {
+			return nil, "", chain, fmt.Errorf("%w for %s", ErrNoHTTPSRecord, hostname)
+		}
+	}
+}
+
+// decodeSVCBData parses the data of an HTTPS or SVCB answer, which is in the
+// RFC 3597 form for wire-format responses and Cloudflare's JSON API
+// ("\# 58 0001..."), and in presentation format for Google's ("1 . alpn=h2").
+func decodeSVCBData(data string) (*HttpsRecord, error) {
+	if !strings.HasPrefix(data, `\#`) {
+		return ParseHttpsPresentation(data)
+	}
+	rdata, err := decodeGenericData(data)
+	if err != nil {
+		return nil, err
+	}
+	return ParseHttpsRecord(rdata)
+}
+
+// decodeGenericData decodes answer data in the RFC 3597 "\# len hex" form.
+func decodeGenericData(data string) ([]byte, error) {
+	dataParts := strings.Split(data, " ")
+	if len(dataParts) < 3 || dataParts[0] != `\#` {
+		return nil, fmt.Errorf("%w: unexpected data field %q", ErrMalformedRR, data)
+	}
+	dataBytes, err := hex.DecodeString(strings.Join(dataParts[2:], ""))
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to decode data: %v", ErrMalformedRR, err)
+	}
+	dataLen, err := strconv.Atoi(dataParts[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to parse length field: %v", ErrMalformedRR, err)
+	}
+	if dataLen != len(dataBytes) {
+		return nil, fmt.Errorf("%w: inconsistent length %d != %d", ErrMalformedRR, dataLen, len(dataBytes))
+	}
+	return dataBytes, nil
+}
+
+// NewHTTPClient returns an http.Client whose connections offer the given
+// ECHConfigList.
+func NewHTTPClient(echConfigList []byte) *http.Client {
+	return (*ProbeConfig)(nil).NewHTTPClient(echConfigList)
+}
+
+// NewHTTPClient returns an http.Client configured according to c whose
+// connections offer the given ECHConfigList.
+func (c *ProbeConfig) NewHTTPClient(echConfigList []byte) *http.Client {
+	return c.newHTTPClient(echConfigList, c.dialContext(), nil, "")
+}
+
+// newHTTPClient is NewHTTPClient connecting with dial and offering the
+// given ALPN protocols. Offering only h3 makes it use HTTP/3. A non-empty
+// addr replaces the address of the URLs when connecting.
+func (c *ProbeConfig) newHTTPClient(echConfigList []byte, dial dialFunc, protos []string, addr string) *http.Client {
+	config := c.tlsConfig(echConfigList)
+	c.pinSPKI(config)
+	if protos != nil {
+		config.NextProtos = protos
+	}
+	if slices.Equal(protos, []string{"h3"}) {
+		transport := &http3.Transport{TLSClientConfig: config}
+		if addr != "" {
+			transport.Dial = func(ctx context.Context, _ string, tlsConf *tls.Config, conf *quic.Config) (quic.EarlyConnection, error) {
+				return quic.DialAddrEarly(ctx, addr, tlsConf, conf)
+			}
+		}
+		return &http.Client{
+			Timeout:   c.timeout(),
+			Transport: transport,
+		}
+	}
+	if addr != "" {
+		dial = dialAddr(dial, addr)
+	}
+	return &http.Client{
+		Timeout: c.timeout(),
+		Transport: &http.Transport{
+			Proxy:             c.proxy(),
+			DialContext:       dial,
+			TLSClientConfig:   config,
+			ForceAttemptHTTP2: slices.Contains(protos, "h2"),
+		},
+	}
+}
+
+// Probe performs a GET request for targetURL offering echConfigList and
+// returns the response body.
+func Probe(ctx context.Context, targetURL string, echConfigList []byte) ([]byte, error) {
+	return (*ProbeConfig)(nil).Probe(ctx, targetURL, echConfigList)
+}
+
+// Probe performs a GET request for targetURL offering echConfigList and
+// returns the response body.
+func (c *ProbeConfig) Probe(ctx context.Context, targetURL string, echConfigList []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", targetURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := c.NewHTTPClient(echConfigList)
+	defer client.CloseIdleConnections()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
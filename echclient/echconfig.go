@@ -0,0 +1,280 @@
+package echclient
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// ECHConfigList is a parsed ECHConfigList.
+type ECHConfigList []ECHConfig
+
+// Marshal serializes l into the wire format accepted by
+// tls.Config.EncryptedClientHelloConfigList.
+func (l ECHConfigList) Marshal() ([]byte, error) {
+	var b cryptobyte.Builder
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		for i := range l {
+			l[i].marshal(b)
+		}
+	})
+	return b.Bytes()
+}
+
+// MergeECHConfigLists returns a single ECHConfigList holding the distinct
+// ECHConfigs of lists, in order, and the number of configs it holds. The
+// configs are copied byte for byte, including those of unknown versions.
+func MergeECHConfigLists(lists [][]byte) ([]byte, int, error) {
+	seen := map[string]bool{}
+	var b cryptobyte.Builder
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		for _, list := range lists {
+			s := cryptobyte.String(list)
+			var configs cryptobyte.String
+			if !s.ReadUint16LengthPrefixed(&configs) || !s.Empty() {
+				b.SetError(fmt.Errorf("%w: list length does not match its contents", ErrMalformedECHConfig))
+				return
+			}
+			for !configs.Empty() {
+				var version uint16
+				var contents cryptobyte.String
+				raw := configs
+				if !configs.ReadUint16(&version) || !configs.ReadUint16LengthPrefixed(&contents) {
+					b.SetError(fmt.Errorf("%w: truncated ECHConfig", ErrMalformedECHConfig))
+					return
+				}
+				raw = raw[:4+len(contents)]
+				if seen[string(raw)] {
+					continue
+				}
+				seen[string(raw)] = true
+				b.AddBytes(raw)
+			}
+		}
+	})
+	merged, err := b.Bytes()
+	return merged, len(seen), err
+}
+
+// Marshal serializes a single ECHConfig. The Length field is ignored and
+// computed from the contents.
+func (c *ECHConfig) Marshal() ([]byte, error) {
+	var b cryptobyte.Builder
+	c.marshal(&b)
+	return b.Bytes()
+}
+
+func (c *ECHConfig) marshal(b *cryptobyte.Builder) {
+	if c.Version != extensionEncryptedClientHello && c.raw != nil {
+		// The layout of other versions is not encoded from the fields.
+		b.AddBytes(c.raw)
+		return
+	}
+	b.AddUint16(c.Version)
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddUint8(c.ConfigID)
+		b.AddUint16(c.KemID)
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddBytes(c.PublicKey)
+		})
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			for _, cs := range c.SymmetricCipherSuite {
+				b.AddUint16(cs.KDFID)
+				b.AddUint16(cs.AEADID)
+			}
+		})
+		b.AddUint8(c.MaxNameLength)
+		b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddBytes(c.PublicName)
+		})
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			for _, e := range c.Extensions {
+				b.AddUint16(e.Type)
+				b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+					b.AddBytes(e.Data)
+				})
+			}
+		})
+	})
+}
+
+// echCipherSuite returns the cipher suite crypto/tls uses with ec, the
+// first one it supports, and reports whether there is one.
+func echCipherSuite(ec *ECHConfig) (ECHCipher, bool) {
+	for _, cs := range ec.SymmetricCipherSuite {
+		if supportedKDFs[cs.KDFID] && supportedAEADs[cs.AEADID] {
+			return cs, true
+		}
+	}
+	return ECHCipher{}, false
+}
+
+// PreferECHConfig returns the ECHConfigList data reordered so that
+// crypto/tls, which uses the first config it supports, picks the usable
+// config whose KEM comes first in kems and, among those, whose cipher
+// suite has the AEAD that comes first in aeads. Algorithms missing from
+// kems or aeads rank after the listed ones. The cipher suites within a
+// config cannot be reordered, as the config is bound to the handshake
+// byte for byte. data is returned as is when the preferred config already
+// comes first.
+func PreferECHConfig(data []byte, kems, aeads []uint16) ([]byte, error) {
+	configs, err := ParseECHConfigList(data)
+	if err != nil {
+		return nil, err
+	}
+	best, bestRank := -1, [2]int{}
+	for i := range configs {
+		cs, ok := echCipherSuite(&configs[i])
+		if !ok || pickECHConfig(configs[i:i+1]) == nil {
+			continue
+		}
+		rank := [2]int{preferenceRank(kems, configs[i].KemID), preferenceRank(aeads, cs.AEADID)}
+		if best < 0 || rank[0] < bestRank[0] || rank[0] == bestRank[0] && rank[1] < bestRank[1] {
+			best, bestRank = i, rank
+		}
+	}
+	if best < 0 {
+		return data, nil
+	}
+	if first := pickECHConfig(configs); first != nil && bytes.Equal(first.raw, configs[best].raw) {
+		return data, nil
+	}
+	var b cryptobyte.Builder
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(configs[best].raw)
+		for i := range configs {
+			if i != best {
+				b.AddBytes(configs[i].raw)
+			}
+		}
+	})
+	return b.Bytes()
+}
+
+// preferenceRank returns the position of id in preferred, or its length if
+// id is not listed.
+func preferenceRank(preferred []uint16, id uint16) int {
+	if i := slices.Index(preferred, id); i >= 0 {
+		return i
+	}
+	return len(preferred)
+}
+
+// FilterECHConfigList returns the ECHConfigList data reduced to the configs
+// crypto/tls can use: version 0xfe0d, a supported KEM, at least one
+// supported cipher suite and no mandatory extension it does not
+// understand, and why each of the others was left out. data is
+// returned as is when every config is usable. When none is, the error
+// matches ErrNoUsableECHConfig and lists what was published against what
+// crypto/tls supports, rather than leaving the handshake to fail.
+func FilterECHConfigList(data []byte) ([]byte, []string, error) {
+	s := cryptobyte.String(data)
+	var list cryptobyte.String
+	if !s.ReadUint16LengthPrefixed(&list) || !s.Empty() {
+		return nil, nil, fmt.Errorf("%w: list length does not match its contents", ErrMalformedECHConfig)
+	}
+	var usable, published, skipped []string
+	for !list.Empty() {
+		var (
+			version  uint16
+			contents cryptobyte.String
+		)
+		raw := list
+		if !list.ReadUint16(&version) || !list.ReadUint16LengthPrefixed(&contents) {
+			return nil, nil, fmt.Errorf("%w: truncated ECHConfig", ErrMalformedECHConfig)
+		}
+		raw = raw[:4+len(contents)]
+		desc, reason, err := checkSupported(version, raw)
+		if err != nil {
+			return nil, nil, err
+		}
+		published = append(published, desc)
+		if reason != "" {
+			skipped = append(skipped, reason)
+			continue
+		}
+		usable = append(usable, string(raw))
+	}
+	if len(usable) == 0 {
+		return nil, skipped, fmt.Errorf("%w: published %s; crypto/tls supports %s", ErrNoUsableECHConfig,
+			strings.Join(published, "; "), supportedAlgorithms())
+	}
+	if len(skipped) == 0 {
+		return data, nil, nil
+	}
+	var b cryptobyte.Builder
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		for _, raw := range usable {
+			b.AddBytes([]byte(raw))
+		}
+	})
+	filtered, err := b.Bytes()
+	return filtered, skipped, err
+}
+
+// checkSupported describes the ECHConfig raw of the given version and
+// returns why crypto/tls cannot use it, or an empty reason if it can.
+func checkSupported(version uint16, raw []byte) (desc, reason string, err error) {
+	if version != extensionEncryptedClientHello {
+		desc = "version " + versionLabel(version)
+		return desc, desc + " is not supported", nil
+	}
+	var b cryptobyte.Builder
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(raw) })
+	configs, err := ParseECHConfigList(b.BytesOrPanic())
+	if err != nil {
+		return "", "", err
+	}
+	ec := &configs[0]
+	suites := make([]string, len(ec.SymmetricCipherSuite))
+	for i, cs := range ec.SymmetricCipherSuite {
+		suites[i] = cs.String()
+	}
+	desc = fmt.Sprintf("config_id %d with KEM %s and cipher suites %s", ec.ConfigID, KEMName(ec.KemID), strings.Join(suites, ", "))
+	switch _, ok := echCipherSuite(ec); {
+	case !supportedKEMs[ec.KemID]:
+		reason = fmt.Sprintf("config_id %d: KEM %s is not supported", ec.ConfigID, KEMName(ec.KemID))
+	case !ok:
+		reason = fmt.Sprintf("config_id %d: none of the cipher suites %s is supported", ec.ConfigID, strings.Join(suites, ", "))
+	default:
+		if ext, ok := unsupportedMandatoryExtension(ec); ok {
+			reason = fmt.Sprintf("config_id %d: mandatory extension 0x%04x is not supported", ec.ConfigID, ext)
+		}
+	}
+	return desc, reason, nil
+}
+
+// supportedECHExtensions are the ECHConfig extensions crypto/tls
+// understands: none so far.
+var supportedECHExtensions = map[uint16]bool{}
+
+// unsupportedMandatoryExtension returns the first extension of ec that is
+// mandatory, its type having the high bit set, but not understood, which
+// makes clients skip the config (draft-ietf-tls-esni-18, section 4.2).
+func unsupportedMandatoryExtension(ec *ECHConfig) (uint16, bool) {
+	for _, ext := range ec.Extensions {
+		if ext.Type&0x8000 != 0 && !supportedECHExtensions[ext.Type] {
+			return ext.Type, true
+		}
+	}
+	return 0, false
+}
+
+// supportedAlgorithms describes the ECH version and HPKE algorithms
+// crypto/tls supports.
+func supportedAlgorithms() string {
+	names := func(supported map[uint16]bool, name func(uint16) string) string {
+		ids := slices.Sorted(maps.Keys(supported))
+		s := make([]string, len(ids))
+		for i, id := range ids {
+			s[i] = name(id)
+		}
+		return strings.Join(s, ", ")
+	}
+	return fmt.Sprintf("version 0x%04x, KEM %s, KDF %s and AEADs %s", extensionEncryptedClientHello,
+		names(supportedKEMs, KEMName), names(supportedKDFs, KDFName), names(supportedAEADs, AEADName))
+}
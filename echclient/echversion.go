@@ -0,0 +1,121 @@
+package echclient
+
+import (
+	"fmt"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// ECHVersion describes an ECHConfig version defined by a draft of the ECH
+// specification, so that surveys can tell what is deployed even when it
+// cannot be used for a handshake.
+type ECHVersion struct {
+	Version uint16 `json:"version"`
+	Draft   string `json:"draft"`
+
+	// ConfigID reports whether the configs carry a config_id, introduced
+	// in draft 10; earlier drafts identify configs by a hash of them.
+	ConfigID bool `json:"config_id"`
+
+	// Supported reports whether crypto/tls can use the configs.
+	Supported bool `json:"supported"`
+}
+
+// echVersions are the ECHConfig versions whose layout is known; configs of
+// other versions are kept undecoded. 0xfe0d, introduced in draft 13, is
+// the version of every later draft.
+var echVersions = map[uint16]ECHVersion{
+	0xfe08:                        {0xfe08, "draft-ietf-tls-esni-08", false, false},
+	0xfe09:                        {0xfe09, "draft-ietf-tls-esni-09", false, false},
+	0xfe0a:                        {0xfe0a, "draft-ietf-tls-esni-10", true, false},
+	extensionEncryptedClientHello: {extensionEncryptedClientHello, "draft-ietf-tls-esni-13", true, true},
+}
+
+// LookupECHVersion returns the description of an ECHConfig version and
+// reports whether it is known.
+func LookupECHVersion(version uint16) (ECHVersion, bool) {
+	v, ok := echVersions[version]
+	return v, ok
+}
+
+// ECHVersionName returns the draft defining version, as in
+// "draft-ietf-tls-esni-10", or its hex value if it is not known.
+func ECHVersionName(version uint16) string {
+	if v, ok := echVersions[version]; ok {
+		return v.Draft
+	}
+	return fmt.Sprintf("0x%04x", version)
+}
+
+// versionLabel returns the hex value of version followed by its draft if
+// known, as in "0xfe0a (draft-ietf-tls-esni-10)".
+func versionLabel(version uint16) string {
+	if v, ok := echVersions[version]; ok {
+		return fmt.Sprintf("0x%04x (%s)", version, v.Draft)
+	}
+	return fmt.Sprintf("0x%04x", version)
+}
+
+// Raw returns the encoding of ec, version and length included, as it was
+// parsed.
+func (ec *ECHConfig) Raw() []byte {
+	return ec.raw
+}
+
+// parseLegacyContents decodes into ec the contents of a config of an older
+// draft when their layout is known, leaving the fields unset otherwise.
+// crypto/tls cannot use these configs; they are only reported.
+func parseLegacyContents(ec *ECHConfig, contents cryptobyte.String) {
+	var (
+		parsed        ECHConfig
+		publicName    cryptobyte.String
+		cipherSuites  cryptobyte.String
+		maxNameLength uint16
+		ok            bool
+	)
+	switch ec.Version {
+	case 0xfe08, 0xfe09:
+		ok = contents.ReadUint16LengthPrefixed(&publicName) &&
+			contents.ReadUint16LengthPrefixed((*cryptobyte.String)(&parsed.PublicKey)) &&
+			contents.ReadUint16(&parsed.KemID) &&
+			contents.ReadUint16LengthPrefixed(&cipherSuites) &&
+			contents.ReadUint16(&maxNameLength)
+	case 0xfe0a:
+		ok = contents.ReadUint8(&parsed.ConfigID) &&
+			contents.ReadUint16(&parsed.KemID) &&
+			contents.ReadUint16LengthPrefixed((*cryptobyte.String)(&parsed.PublicKey)) &&
+			contents.ReadUint16LengthPrefixed(&cipherSuites) &&
+			contents.ReadUint16(&maxNameLength) &&
+			contents.ReadUint16LengthPrefixed(&publicName)
+	}
+	if !ok {
+		return
+	}
+	for !cipherSuites.Empty() {
+		var c ECHCipher
+		if !cipherSuites.ReadUint16(&c.KDFID) || !cipherSuites.ReadUint16(&c.AEADID) {
+			return
+		}
+		parsed.SymmetricCipherSuite = append(parsed.SymmetricCipherSuite, c)
+	}
+	var extensions cryptobyte.String
+	if !contents.ReadUint16LengthPrefixed(&extensions) {
+		return
+	}
+	for !extensions.Empty() {
+		var e ECHExtension
+		if !extensions.ReadUint16(&e.Type) || !extensions.ReadUint16LengthPrefixed((*cryptobyte.String)(&e.Data)) {
+			return
+		}
+		parsed.Extensions = append(parsed.Extensions, e)
+	}
+	ec.ConfigID = parsed.ConfigID
+	ec.KemID = parsed.KemID
+	ec.PublicKey = parsed.PublicKey
+	ec.SymmetricCipherSuite = parsed.SymmetricCipherSuite
+	// The field grew to 16 bits in these drafts; clamp it for display.
+	ec.MaxNameLength = uint8(min(maxNameLength, 255))
+	ec.PublicName = publicName
+	ec.Extensions = parsed.Extensions
+	ec.trailing = len(contents)
+}
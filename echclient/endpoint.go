@@ -0,0 +1,196 @@
+package echclient
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
+)
+
+// SortEndpoints returns the ServiceMode records of an HTTPS RRset ordered by
+// SvcPriority, as clients must try them (RFC 9460, section 2.4.1). Records
+// of equal priority keep their order in the answer so that probes are
+// reproducible.
+func SortEndpoints(records []*HttpsRecord) []*HttpsRecord {
+	var endpoints []*HttpsRecord
+	for _, r := range records {
+		if r.Priority != 0 {
+			endpoints = append(endpoints, r)
+		}
+	}
+	slices.SortStableFunc(endpoints, func(a, b *HttpsRecord) int {
+		return int(a.Priority) - int(b.Priority)
+	})
+	return endpoints
+}
+
+// Endpoint returns the name of the service endpoint r points to, owned by
+// owner: its TargetName or, when that is ".", owner itself for a
+// ServiceMode record (RFC 9460, section 2.5.2). An AliasMode record with
+// the "." TargetName has no endpoint and yields an empty string, as does a
+// "." TargetName with an unknown owner.
+func (r *HttpsRecord) Endpoint(owner string) string {
+	if r.TargetName != "." {
+		return canonicalName(r.TargetName)
+	}
+	if r.Priority == 0 || owner == "" {
+		return ""
+	}
+	return canonicalName(owner)
+}
+
+// SupportedSvcParamKeys are the SvcParamKeys the probe understands. HTTPS
+// records listing any other key in their mandatory SvcParam are skipped, as
+// RFC 9460, section 8 requires.
+var SupportedSvcParamKeys = []uint16{
+	SvcParamALPN,
+	SvcParamNoDefaultALPN,
+	SvcParamPort,
+	SvcParamIPv4Hint,
+	SvcParamECH,
+	SvcParamIPv6Hint,
+}
+
+// SkippedRecord is an HTTPS record left out of endpoint selection.
+type SkippedRecord struct {
+	Record *HttpsRecord `json:"record"`
+	Reason string       `json:"reason"`
+}
+
+// Policy tunes SelectEndpoint. The zero Policy keeps every record the
+// probe can use, in SvcPriority order.
+type Policy struct {
+	// ALPN lists the protocols the client speaks. Records whose effective
+	// ALPN set shares none of them are skipped. If empty, the ALPN of the
+	// records is not checked.
+	ALPN []string
+
+	// SupportedKeys lists the SvcParamKeys the client understands, which
+	// records may make mandatory. If nil, SupportedSvcParamKeys is used.
+	SupportedKeys []uint16
+
+	// Shuffle randomizes the order of records of equal SvcPriority, as
+	// RFC 9460, section 2.4.1 suggests, using Rand or, if nil, the
+	// global source of math/rand/v2.
+	Shuffle bool
+	Rand    *rand.Rand
+}
+
+// SelectEndpoint returns the ServiceMode records of an HTTPS RRset that a
+// client following policy may use, in the order it should try them, and
+// those it must ignore with the reason: records making unsupported keys
+// mandatory (RFC 9460, section 8) and records without a protocol in common
+// with policy.ALPN. When the RRset holds an AliasMode record every
+// ServiceMode record is ignored (section 2.4.2) and the alias is to be
+// followed instead.
+func SelectEndpoint(records []*HttpsRecord, policy Policy) ([]*HttpsRecord, []SkippedRecord) {
+	var (
+		usable  []*HttpsRecord
+		skipped []SkippedRecord
+	)
+	alias := slices.ContainsFunc(records, func(r *HttpsRecord) bool {
+		return r.Priority == 0
+	})
+	for _, r := range SortEndpoints(records) {
+		if reason := policy.check(r, alias); reason != "" {
+			skipped = append(skipped, SkippedRecord{Record: r, Reason: reason})
+			continue
+		}
+		usable = append(usable, r)
+	}
+	if policy.Shuffle {
+		for i := 0; i < len(usable); {
+			j := i + 1
+			for j < len(usable) && usable[j].Priority == usable[i].Priority {
+				j++
+			}
+			group := usable[i:j]
+			shuffle := rand.Shuffle
+			if policy.Rand != nil {
+				shuffle = policy.Rand.Shuffle
+			}
+			shuffle(len(group), func(a, b int) {
+				group[a], group[b] = group[b], group[a]
+			})
+			i = j
+		}
+	}
+	return usable, skipped
+}
+
+// check returns why a client following p must ignore the ServiceMode
+// record r, or an empty string if it may use it.
+func (p Policy) check(r *HttpsRecord, alias bool) string {
+	if alias {
+		return "ignored in favor of the AliasMode record"
+	}
+	supported := p.SupportedKeys
+	if supported == nil {
+		supported = SupportedSvcParamKeys
+	}
+	if reason := checkMandatory(r, supported); reason != "" {
+		return reason
+	}
+	if len(p.ALPN) > 0 && !slices.ContainsFunc(r.EffectiveALPN(), func(proto string) bool {
+		return slices.Contains(p.ALPN, proto)
+	}) {
+		return fmt.Sprintf("no supported protocol in ALPN %s", strings.Join(r.EffectiveALPN(), ","))
+	}
+	return ""
+}
+
+// checkMandatory returns why r must be ignored because of its mandatory
+// SvcParam, given the keys the client supports, or an empty string if it
+// may be used.
+func checkMandatory(r *HttpsRecord, supported []uint16) string {
+	v, ok := r.Param(SvcParamMandatory)
+	if !ok {
+		return ""
+	}
+	keys := decodeKeyList(v)
+	if len(keys) == 0 {
+		return "malformed mandatory SvcParam"
+	}
+	for _, key := range keys {
+		if key == SvcParamMandatory {
+			return "mandatory SvcParam lists itself"
+		}
+		if _, ok := r.Param(key); !ok {
+			return fmt.Sprintf("mandatory key %s is missing", SvcParamKeyName(key))
+		}
+		if !slices.Contains(supported, key) {
+			return fmt.Sprintf("mandatory key %s is not supported", SvcParamKeyName(key))
+		}
+	}
+	return ""
+}
+
+// distinctECHConfigLists returns the distinct ECHConfigLists published by
+// records, in order.
+func distinctECHConfigLists(records []*HttpsRecord) [][]byte {
+	var lists [][]byte
+	for _, r := range records {
+		for _, list := range r.ECHConfigLists() {
+			if len(list) > 0 && !slices.ContainsFunc(lists, func(l []byte) bool { return bytes.Equal(l, list) }) {
+				lists = append(lists, list)
+			}
+		}
+	}
+	return lists
+}
+
+// selectEndpoint returns the endpoint forced by EndpointIndex, or the one
+// with the lowest SvcPriority.
+func (c *ProbeConfig) selectEndpoint(hostname string, endpoints []*HttpsRecord) (*HttpsRecord, error) {
+	if n := c.endpointIndex(); n > 0 {
+		if n > len(endpoints) {
+			return nil, fmt.Errorf("endpoint index %d out of range: %s has %d endpoints", n, hostname, len(endpoints))
+		}
+		return endpoints[n-1], nil
+	}
+	if len(endpoints) > 0 {
+		return endpoints[0], nil
+	}
+	return nil, fmt.Errorf("%w for %s", ErrNoHTTPSRecord, hostname)
+}
@@ -0,0 +1,85 @@
+package echclient
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+)
+
+// TLSEngine performs the TLS handshakes of probes in place of crypto/tls,
+// for instance to present the ClientHello fingerprint of a browser, so that
+// blocking keyed on ECH can be told apart from blocking keyed on the Go
+// fingerprint.
+type TLSEngine interface {
+	// Handshake performs a TLS handshake over conn as configured by
+	// config, offering config.EncryptedClientHelloConfigList, and returns
+	// the established connection and its state. An ECH rejection must be
+	// returned as a *tls.ECHRejectionError, and config.VerifyConnection,
+	// if set, called once the server certificate of an accepted or
+	// non-ECH handshake is verified.
+	Handshake(ctx context.Context, conn net.Conn, config *tls.Config) (net.Conn, tls.ConnectionState, error)
+
+	// String names the engine in results, such as "utls-chrome".
+	String() string
+}
+
+// handshake performs a TLS handshake over conn with the configured
+// TLSEngine, or crypto/tls if none.
+func (c *ProbeConfig) handshake(ctx context.Context, conn net.Conn, config *tls.Config) (net.Conn, tls.ConnectionState, error) {
+	if engine := c.tlsEngine(); engine != nil {
+		return engine.Handshake(ctx, conn, config)
+	}
+	tlsConn := tls.Client(conn, config)
+	err := tlsConn.HandshakeContext(ctx)
+	return tlsConn, tlsConn.ConnectionState(), err
+}
+
+// dialTLS returns a DialTLSContext for transport performing the handshakes
+// for serverName itself, with the configured TLSEngine or crypto/tls, and
+// recording them in r as the trace of doRequest does. Unlike net/http, it
+// offers exactly the NextProtos of transport, without adding h2 or
+// http/1.1. Only HTTP/1.1 can be spoken over the connections of an engine.
+func (c *ProbeConfig) dialTLS(r *ProbeResult, transport *http.Transport, serverName string) dialFunc {
+	dial := transport.DialContext
+	if dial == nil {
+		var dialer net.Dialer
+		dial = dialer.DialContext
+	}
+	// net/http edits the NextProtos of transport when first used.
+	tlsConfig := transport.TLSClientConfig.Clone()
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		r.RemoteAddr = conn.RemoteAddr().String()
+		config := tlsConfig.Clone()
+		if config.ServerName == "" {
+			config.ServerName = serverName
+		}
+		start := time.Now()
+		tlsConn, cs, err := c.handshake(ctx, conn, config)
+		r.Timings.TLSHandshake = time.Since(start)
+		c.hooks().tlsHandshakeDone(cs, err)
+		if err != nil {
+			c.checkUnverifiedCertificates(r, err, config.ServerName)
+			conn.Close()
+			return nil, err
+		}
+		c.setConnectionState(r, cs, config.ServerName)
+		return tlsConn, nil
+	}
+}
+
+// setConnectionState records in r the outcome of a successful handshake
+// with the server of host.
+func (c *ProbeConfig) setConnectionState(r *ProbeResult, cs tls.ConnectionState, host string) {
+	r.ECHAccepted = cs.ECHAccepted
+	r.TLSVersion = tls.VersionName(cs.Version)
+	r.CipherSuite = tls.CipherSuiteName(cs.CipherSuite)
+	r.ALPN = cs.NegotiatedProtocol
+	r.DidResume = cs.DidResume
+	c.checkCertificates(r, cs.PeerCertificates, host)
+}
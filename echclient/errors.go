@@ -0,0 +1,162 @@
+package echclient
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNoHTTPSRecord is returned when the DNS response carries no HTTPS RR
+	// for the queried name.
+	ErrNoHTTPSRecord = errors.New("echclient: no HTTPS record")
+
+	// ErrNoECHConfig is returned when the HTTPS RR has no ech SvcParam.
+	ErrNoECHConfig = errors.New("echclient: HTTPS record has no ech parameter")
+
+	// ErrDNSStatus is matched by every DNSStatusError.
+	ErrDNSStatus = errors.New("echclient: DNS query failed")
+
+	// ErrNXDomain, ErrServFail and ErrRefused are matched by the
+	// DNSStatusErrors with the corresponding RCODE.
+	ErrNXDomain = errors.New("echclient: DNS name does not exist")
+	ErrServFail = errors.New("echclient: DNS server failure")
+	ErrRefused  = errors.New("echclient: DNS query refused")
+
+	// ErrCNAMEChain is returned when the CNAME chain leading to the HTTPS
+	// RR loops or is longer than the hop limit.
+	ErrCNAMEChain = errors.New("echclient: CNAME chain loops or is too long")
+
+	// ErrAliasChain is returned when a chain of AliasMode records loops
+	// or is deeper than ProbeConfig.MaxAliasDepth.
+	ErrAliasChain = errors.New("echclient: AliasMode chain loops or is too deep")
+
+	// ErrNoAddress is returned when a host has no usable A or AAAA
+	// record.
+	ErrNoAddress = errors.New("echclient: no address")
+
+	// ErrMalformedRR is returned when the RDATA of an HTTPS or SVCB RR
+	// cannot be decoded.
+	ErrMalformedRR = errors.New("echclient: malformed HTTPS or SVCB RR")
+
+	// ErrMalformedECHConfig is returned when an ECHConfigList cannot be
+	// decoded.
+	ErrMalformedECHConfig = errors.New("tls: malformed ECHConfigList")
+
+	// ErrNoUsableECHConfig is returned when none of the published
+	// ECHConfigs has a version and algorithms crypto/tls supports.
+	ErrNoUsableECHConfig = errors.New("echclient: no usable ECHConfig")
+
+	// ErrNoWellKnown is returned when the origin-svcb well-known document
+	// is missing or cannot be decoded.
+	ErrNoWellKnown = errors.New("echclient: no usable origin-svcb document")
+
+	// ErrDoHResponse is returned when the DoH server answers with something
+	// other than a DNS response.
+	ErrDoHResponse = errors.New("echclient: invalid DoH response")
+
+	// ErrDNSResponse is returned when a wire-format answer cannot be
+	// decoded or does not match the query.
+	ErrDNSResponse = errors.New("echclient: invalid DNS response")
+
+	// ErrDNSInjection is returned when every UDP answer to a query failed
+	// the ID or 0x20 case check, suggesting they were injected.
+	ErrDNSInjection = errors.New("echclient: possibly injected DNS response")
+
+	// ErrNoODoHConfig is returned when an ODoH target publishes no usable
+	// ObliviousDoHConfig.
+	ErrNoODoHConfig = errors.New("echclient: no usable ODoH config")
+
+	// ErrNoNameservers is returned when the operating system has no
+	// resolver configured.
+	ErrNoNameservers = errors.New("echclient: no system nameservers configured")
+
+	// ErrNoAuthoritative is returned when no authoritative nameserver of
+	// a zone can be found.
+	ErrNoAuthoritative = errors.New("echclient: no authoritative nameserver")
+
+	// ErrNoDesignatedResolver is returned when DDR finds no usable
+	// encrypted resolver.
+	ErrNoDesignatedResolver = errors.New("echclient: no designated resolver")
+
+	// ErrDDRVerification is returned when a designated resolver fails the
+	// DDR verification.
+	ErrDDRVerification = errors.New("echclient: designated resolver verification failed")
+
+	// ErrWebSocketUpgrade is returned when the server does not complete
+	// the WebSocket opening handshake of a ws or wss probe.
+	ErrWebSocketUpgrade = errors.New("echclient: WebSocket upgrade failed")
+
+	// ErrECHConfigPinMismatch is returned when the fetched ECHConfigList
+	// differs from the one pinned in ProbeConfig.ECHConfigPins.
+	ErrECHConfigPinMismatch = errors.New("echclient: ECHConfigList differs from the pinned one")
+
+	// ErrSPKIPinMismatch is matched by every SPKIPinError.
+	ErrSPKIPinMismatch = errors.New("echclient: no certificate matches the pinned public keys")
+
+	// ErrDNSSECBogus is wrapped by the errors explaining a bogus DNSSEC
+	// validation.
+	ErrDNSSECBogus = errors.New("echclient: DNSSEC validation failed")
+)
+
+// DNSStatusError is returned when the resolver answers with a non-zero RCODE.
+type DNSStatusError struct {
+	Name   string
+	Status int
+}
+
+func (e *DNSStatusError) Error() string {
+	return fmt.Sprintf("echclient: DNS query for %s failed with status %s (%d)", e.Name, DNSStatusName(e.Status), e.Status)
+}
+
+func (e *DNSStatusError) Is(target error) bool {
+	switch target {
+	case ErrDNSStatus:
+		return true
+	case ErrNXDomain:
+		return e.Status == 3
+	case ErrServFail:
+		return e.Status == 2
+	case ErrRefused:
+		return e.Status == 5
+	}
+	return false
+}
+
+// SPKIPinError is returned when no certificate of the chain presented by a
+// server has one of the pinned SubjectPublicKeyInfo digests.
+type SPKIPinError struct {
+	// Certificates is the chain presented, leaf first.
+	Certificates []*x509.Certificate
+}
+
+func (e *SPKIPinError) Error() string {
+	return ErrSPKIPinMismatch.Error()
+}
+
+func (e *SPKIPinError) Is(target error) bool {
+	return target == ErrSPKIPinMismatch
+}
+
+var dnsStatusNames = map[int]string{
+	0:  "NOERROR",
+	1:  "FORMERR",
+	2:  "SERVFAIL",
+	3:  "NXDOMAIN",
+	4:  "NOTIMP",
+	5:  "REFUSED",
+	6:  "YXDOMAIN",
+	7:  "YXRRSET",
+	8:  "NXRRSET",
+	9:  "NOTAUTH",
+	10: "NOTZONE",
+}
+
+// DNSStatusName returns the mnemonic of an RCODE, or RCODEn for the ones
+// without one.
+func DNSStatusName(status int) string {
+	if name, ok := dnsStatusNames[status]; ok {
+		return name
+	}
+	return fmt.Sprintf("RCODE%d", status)
+}
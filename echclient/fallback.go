@@ -0,0 +1,105 @@
+package echclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// FallbackResolver is a Resolver trying each of Resolvers in order until one
+// answers. Any error, including a non-zero RCODE, moves on to the next
+// resolver, so that a single blocked or tampering resolver does not fail the
+// lookup.
+type FallbackResolver struct {
+	Resolvers []Resolver
+
+	// Timeout bounds the query to each resolver. Zero means no timeout
+	// beyond that of ctx.
+	Timeout time.Duration
+
+	// Logger receives the failures of individual resolvers. If nil,
+	// nothing is logged.
+	Logger *slog.Logger
+}
+
+// NewFallbackResolver returns a FallbackResolver over the resolvers built
+// by NewResolver for each of resolverURLs, each bounded by the DNS timeout
+// of c.
+func (c *ProbeConfig) NewFallbackResolver(resolverURLs ...string) (*FallbackResolver, error) {
+	resolvers, err := c.newResolvers(resolverURLs)
+	if err != nil {
+		return nil, err
+	}
+	return &FallbackResolver{
+		Resolvers: resolvers,
+		Timeout:   c.dnsTimeout(),
+		Logger:    c.logger(),
+	}, nil
+}
+
+func (c *ProbeConfig) newResolvers(resolverURLs []string) ([]Resolver, error) {
+	var resolvers []Resolver
+	for _, u := range resolverURLs {
+		r, err := c.NewResolver(u)
+		if err != nil {
+			return nil, err
+		}
+		resolvers = append(resolvers, r)
+	}
+	return resolvers, nil
+}
+
+func (fr *FallbackResolver) String() string {
+	return "fallback(" + joinResolvers(fr.Resolvers) + ")"
+}
+
+func joinResolvers(resolvers []Resolver) string {
+	names := make([]string, len(resolvers))
+	for i, r := range resolvers {
+		names[i] = r.String()
+	}
+	return strings.Join(names, ", ")
+}
+
+// Query implements Resolver. The Resolver field of the response names the
+// resolver that answered. If every resolver fails, the joined errors are
+// returned.
+func (fr *FallbackResolver) Query(ctx context.Context, name string, qtype RRType) (*DNSResponse, error) {
+	if len(fr.Resolvers) == 0 {
+		return nil, errors.New("no resolvers configured")
+	}
+	var errs []error
+	for _, r := range fr.Resolvers {
+		resp, err := fr.query(ctx, r, name, qtype)
+		if err == nil {
+			if resp.Resolver == "" {
+				resp.Resolver = r.String()
+			}
+			return resp, nil
+		}
+		if fr.Logger != nil {
+			fr.Logger.Warn("resolver failed", "resolver", r.String(), "name", name, "type", qtype, "error", err)
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", r, err))
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+func (fr *FallbackResolver) query(ctx context.Context, r Resolver, name string, qtype RRType) (*DNSResponse, error) {
+	return queryWithTimeout(ctx, r, fr.Timeout, name, qtype)
+}
+
+func queryWithTimeout(ctx context.Context, r Resolver, timeout time.Duration, name string, qtype RRType) (*DNSResponse, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return r.Query(ctx, name, qtype)
+}
@@ -0,0 +1,97 @@
+package echclient
+
+import (
+	"bytes"
+	"net/netip"
+	"testing"
+)
+
+// httpsSeeds returns HTTPS RDATA shaped like those of DNS answers, with
+// ECHConfigLists of freshly generated keys, and the RFC 9460 appendix D
+// vectors.
+func httpsSeeds(f *testing.F) [][]byte {
+	var seeds [][]byte
+	for _, kem := range []uint16{X25519, P256} {
+		key, err := GenerateECHKey(1, kem, "cloudflare-ech.com")
+		if err != nil {
+			f.Fatal(err)
+		}
+		list, err := ECHConfigList{key.Config}.Marshal()
+		if err != nil {
+			f.Fatal(err)
+		}
+		// The layout of the records Cloudflare publishes.
+		record := &HttpsRecord{Priority: 1, TargetName: ".", Params: []SvcParam{
+			ALPNParam("http/1.1", "h2"),
+			IPv4HintParam(netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("192.0.2.2")),
+			ECHParam(list),
+			IPv6HintParam(netip.MustParseAddr("2001:db8::1"), netip.MustParseAddr("2001:db8::2")),
+		}}
+		rdata, err := record.Marshal()
+		if err != nil {
+			f.Fatal(err)
+		}
+		seeds = append(seeds, rdata)
+	}
+	for _, vector := range []string{
+		"0000 03 666f6f 07 6578616d706c65 03 636f6d 00",
+		"0001 00",
+		"0010 03 666f6f 07 6578616d706c65 03 636f6d 00 0003 0002 0035",
+		"0001 03 666f6f 07 6578616d706c65 03 636f6d 00 029b 0009 68656c6c6fd2716f6f",
+		"0010 03 666f6f 07 6578616d706c65 03 6f7267 00" +
+			"0000 0004 0001 0004 0001 0009 02 6832 05 68332d3139 0004 0004 c0000201",
+		"0010 03 666f6f 07 6578616d706c65 03 6f7267 00 0001 000c 08 665c6f6f2c626172 02 6832",
+	} {
+		seeds = append(seeds, mustHex(f, vector))
+	}
+	return seeds
+}
+
+func FuzzParseHttpsRecord(f *testing.F) {
+	for _, seed := range httpsSeeds(f) {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		record, err := ParseHttpsRecord(data)
+		if err != nil {
+			return
+		}
+		rdata, err := record.Marshal()
+		if err != nil {
+			t.Fatalf("Marshal(%+v): %v", record, err)
+		}
+		if want := data[:len(data)-record.trailing]; !bytes.Equal(rdata, want) {
+			t.Fatalf("Marshal = %x, want %x", rdata, want)
+		}
+		if record.Violations() != nil {
+			return
+		}
+		// Valid records must read back from their presentation format.
+		parsed, err := ParseHttpsPresentation(record.String())
+		if err != nil {
+			t.Fatalf("ParseHttpsPresentation(%q): %v", record.String(), err)
+		}
+		if again, _ := parsed.Marshal(); !bytes.Equal(again, rdata) {
+			t.Fatalf("presentation %q reads back as %x, want %x", record.String(), again, rdata)
+		}
+	})
+}
+
+func FuzzParseECHConfigList(f *testing.F) {
+	for _, seed := range httpsSeeds(f) {
+		if record, err := ParseHttpsRecord(seed); err == nil && len(record.ECHConfigList()) > 0 {
+			f.Add(record.ECHConfigList())
+		}
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		configs, err := ParseECHConfigList(data)
+		if err != nil {
+			return
+		}
+		configs.Violations()
+		for i := range configs {
+			NewECHConfigInfo(&configs[i])
+		}
+		pickECHConfig(configs)
+	})
+}
@@ -0,0 +1,68 @@
+package echclient
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+)
+
+// ECHMode selects where the ECHConfigList offered by a probe comes from.
+type ECHMode string
+
+const (
+	// ECHModeDNS uses the ECHConfigList published in the HTTPS RR.
+	ECHModeDNS ECHMode = "dns"
+
+	// ECHModeGREASE offers a randomly generated ECHConfigList, to test
+	// whether the path to the server tolerates the ECH extension.
+	ECHModeGREASE ECHMode = "grease"
+
+	// ECHModeStatic offers ProbeConfig.ECHConfigList without looking it
+	// up, to test deployments not yet published in DNS or to replay past
+	// measurements.
+	ECHModeStatic ECHMode = "static"
+)
+
+// ECHFallback selects what a probe in ECHModeDNS offers when no
+// ECHConfigList can be found for the host.
+type ECHFallback string
+
+const (
+	// ECHFallbackNone fails the probe.
+	ECHFallbackNone ECHFallback = "none"
+
+	// ECHFallbackGREASE offers a GREASE ECHConfigList, as in
+	// ECHModeGREASE.
+	ECHFallbackGREASE ECHFallback = "grease"
+
+	// ECHFallbackPlain connects with plain TLS, without ECH.
+	ECHFallbackPlain ECHFallback = "plain"
+)
+
+// GenerateGREASEECHConfigList returns a syntactically valid ECHConfigList
+// with a random config_id and a fresh X25519 public key whose private key is
+// discarded. A server can never decrypt a ClientHello built from it, so
+// offering it results in a handshake with publicName and an
+// ECHRejectionError, unless something on path drops ECH connections.
+//
+// See draft-ietf-tls-esni-18, section 6.2.
+func GenerateGREASEECHConfigList(publicName string) ([]byte, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	var id [1]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+	config := ECHConfig{
+		Version:   extensionEncryptedClientHello,
+		ConfigID:  id[0],
+		KemID:     X25519,
+		PublicKey: key.PublicKey().Bytes(),
+		SymmetricCipherSuite: []ECHCipher{
+			{KDFID: HKDFSHA256, AEADID: AES128GCM},
+		},
+		PublicName: []byte(publicName),
+	}
+	return ECHConfigList{config}.Marshal()
+}
@@ -0,0 +1,282 @@
+package echclient
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// CertificateInfo describes a certificate presented by the server.
+type CertificateInfo struct {
+	Subject   string    `json:"subject"`
+	Issuer    string    `json:"issuer"`
+	DNSNames  []string  `json:"dns_names,omitempty"`
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+
+	// KeyType is the algorithm and size of the public key, such as
+	// "ECDSA P-256" or "RSA 2048".
+	KeyType string `json:"key_type"`
+
+	// SHA256 is the hex fingerprint of the DER encoding.
+	SHA256 string `json:"sha256"`
+
+	// SPKISHA256 is the base64 SHA-256 digest of the
+	// SubjectPublicKeyInfo, as pinned by ProbeConfig.PinnedSPKI.
+	SPKISHA256 string `json:"spki_sha256"`
+}
+
+// CertificateCheck describes how the chain presented by the server
+// verifies, to diagnose split-mode deployments answering with the
+// certificate of the wrong server.
+type CertificateCheck struct {
+	// Verified reports whether the chain verifies for the host against
+	// the RootCAs of ProbeConfig.TLSConfig or the system roots, checked
+	// even with InsecureSkipVerify, and VerifyError why not.
+	Verified    bool   `json:"verified"`
+	VerifyError string `json:"verify_error,omitempty"`
+
+	// CoversHost reports whether the leaf is valid for the host, the name
+	// of the ClientHelloInner with ECH, and CoversPublicName whether it is
+	// valid for PublicName, that of the ClientHelloOuter. A leaf only
+	// covering the public_name comes from the client-facing server.
+	CoversHost       bool   `json:"covers_host"`
+	PublicName       string `json:"public_name,omitempty"`
+	CoversPublicName bool   `json:"covers_public_name,omitempty"`
+}
+
+// NewCertificateInfo returns the JSON friendly view of cert.
+func NewCertificateInfo(cert *x509.Certificate) CertificateInfo {
+	sum := sha256.Sum256(cert.Raw)
+	spki := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return CertificateInfo{
+		Subject:    cert.Subject.String(),
+		Issuer:     cert.Issuer.String(),
+		DNSNames:   cert.DNSNames,
+		NotBefore:  cert.NotBefore,
+		NotAfter:   cert.NotAfter,
+		KeyType:    keyType(cert.PublicKey),
+		SHA256:     hex.EncodeToString(sum[:]),
+		SPKISHA256: base64.StdEncoding.EncodeToString(spki[:]),
+	}
+}
+
+// keyType names the algorithm and size of a certificate public key.
+func keyType(pub any) string {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA %d", k.N.BitLen())
+	case *ecdsa.PublicKey:
+		return "ECDSA " + k.Curve.Params().Name
+	case ed25519.PublicKey:
+		return "Ed25519"
+	}
+	return fmt.Sprintf("%T", pub)
+}
+
+// certificateHost returns the name the certificate presented for u must
+// cover.
+func (c *ProbeConfig) certificateHost(u *url.URL) string {
+	if c != nil && c.TLSConfig != nil && c.TLSConfig.ServerName != "" {
+		return c.TLSConfig.ServerName
+	}
+	return u.Hostname()
+}
+
+// checkCertificates records in r the chain presented for host, leaf first,
+// and how it verifies.
+func (c *ProbeConfig) checkCertificates(r *ProbeResult, certs []*x509.Certificate, host string) {
+	if len(certs) == 0 {
+		return
+	}
+	r.PeerCertificates = nil
+	for _, cert := range certs {
+		r.PeerCertificates = append(r.PeerCertificates, NewCertificateInfo(cert))
+	}
+	leaf := certs[0]
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	check := &CertificateCheck{CoversHost: leaf.VerifyHostname(host) == nil}
+	_, err := leaf.Verify(x509.VerifyOptions{
+		DNSName:       host,
+		Roots:         c.tlsConfig(nil).RootCAs,
+		Intermediates: intermediates,
+	})
+	check.Verified = err == nil
+	if err != nil {
+		check.VerifyError = err.Error()
+	}
+	if ec := r.SelectedECHConfig; ec != nil && ec.PublicName != "" && r.ECHMode != ECHModeGREASE && r.Fallback == "" {
+		check.PublicName = ec.PublicName
+		check.CoversPublicName = leaf.VerifyHostname(ec.PublicName) == nil
+	}
+	r.CertificateCheck = check
+}
+
+// checkUnverifiedCertificates records in r the chain presented for host
+// when err is the failure to verify it or to match PinnedSPKI.
+func (c *ProbeConfig) checkUnverifiedCertificates(r *ProbeResult, err error, host string) {
+	var certErr *tls.CertificateVerificationError
+	var pinErr *SPKIPinError
+	switch {
+	case errors.As(err, &certErr):
+		c.checkCertificates(r, certErr.UnverifiedCertificates, host)
+	case errors.As(err, &pinErr):
+		c.checkCertificates(r, pinErr.Certificates, host)
+	}
+}
+
+// pinSPKI makes the handshakes using config fail unless the chain matches
+// PinnedSPKI, once any VerifyConnection of config succeeded. The pins are
+// matched against the verified chains, which end at a trusted root, rather
+// than the certificates the server sent, which may include unrelated ones;
+// only with InsecureSkipVerify, when there is no verified chain, are the
+// certificates sent used.
+func (c *ProbeConfig) pinSPKI(config *tls.Config) {
+	pins := c.pinnedSPKI()
+	if len(pins) == 0 {
+		return
+	}
+	verify := config.VerifyConnection
+	insecure := config.InsecureSkipVerify
+	config.VerifyConnection = func(cs tls.ConnectionState) error {
+		if verify != nil {
+			if err := verify(cs); err != nil {
+				return err
+			}
+		}
+		certs := cs.PeerCertificates
+		if !insecure {
+			certs = nil
+			for _, chain := range cs.VerifiedChains {
+				certs = append(certs, chain...)
+			}
+		}
+		if verifyPins(certs, pins) != nil {
+			return &SPKIPinError{Certificates: cs.PeerCertificates}
+		}
+		return nil
+	}
+}
+
+// doHandshake connects to the endpoint of targetURL with dial and performs
+// the TLS handshake offering echConfigList, recording its outcome and the
+// certificate chain of the server in r. No HTTP request is sent.
+func (c *ProbeConfig) doHandshake(ctx context.Context, r *ProbeResult, targetURL string, echConfigList []byte, dial dialFunc) error {
+	r.HandshakeOnly = true
+	if r.EarlyData {
+		c.logger().Warn("early data is only sent over HTTP/3, not by handshake-only probes")
+		r.EarlyData = false
+	}
+	u, err := url.Parse(targetURL)
+	if err != nil {
+		return err
+	}
+	addr := c.connectAddr(u, r.Target, r.Port)
+	if addr == "" {
+		if urlPort(u) == "" {
+			return fmt.Errorf("no port in %s and no default one for scheme %s", targetURL, u.Scheme)
+		}
+		addr = net.JoinHostPort(u.Hostname(), urlPort(u))
+	}
+	if dial == nil {
+		var dialer net.Dialer
+		dial = dialer.DialContext
+	}
+	if timeout := c.timeout(); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	conn, err := dial(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	r.RemoteAddr = conn.RemoteAddr().String()
+
+	config := c.tlsConfig(echConfigList)
+	c.pinSPKI(config)
+	if config.ServerName == "" {
+		config.ServerName = u.Hostname()
+	}
+	var protos []string
+	if alpn := c.alpn(); alpn != nil {
+		protos = alpn
+	} else if _, ok := defaultPorts[strings.ToLower(u.Scheme)]; ok {
+		protos = selectProtocols(r.AdvertisedALPN)
+		if slices.Equal(protos, []string{"h3"}) {
+			c.logger().Warn("endpoint only advertises h3, the handshake goes over TCP")
+			protos = tcpProtocols
+		}
+	} else if r.HTTPSRecord != nil {
+		// Other services only get the protocols the record names, without
+		// the default http/1.1 of HTTPS records.
+		protos = r.HTTPSRecord.ALPN()
+	}
+	if config.NextProtos == nil {
+		config.NextProtos = protos
+	}
+	r.OfferedALPN = config.NextProtos
+	r.recordCertificateRequest(config)
+
+	if engine := c.tlsEngine(); engine != nil {
+		r.TLSEngine = engine.String()
+	}
+	start := time.Now()
+	tlsConn, cs, err := c.handshake(ctx, conn, config)
+	r.Timings.TLSHandshake = time.Since(start)
+	c.hooks().tlsHandshakeDone(cs, err)
+	var echErr *tls.ECHRejectionError
+	if errors.As(err, &echErr) {
+		r.ECHRejected = true
+		r.RetryConfigList = echErr.RetryConfigList
+	}
+	if err != nil {
+		c.checkUnverifiedCertificates(r, err, config.ServerName)
+		return err
+	}
+	c.setConnectionState(r, cs, config.ServerName)
+	r.ALPNMismatch = alpnMismatch(r.AdvertisedALPN, r.ALPN)
+	if cs.Version == tls.VersionTLS13 && (config.ClientSessionCache != nil || r.ClientCertRequested) {
+		return readAfterHandshake(tlsConn)
+	}
+	return nil
+}
+
+// recordCertificateRequest makes handshakes using config record in r
+// whether the server requested a client certificate and whether one was
+// sent, choosing among config.Certificates as crypto/tls does.
+func (r *ProbeResult) recordCertificateRequest(config *tls.Config) {
+	certs, get := config.Certificates, config.GetClientCertificate
+	config.GetClientCertificate = func(cri *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		r.ClientCertRequested = true
+		if get != nil {
+			cert, err := get(cri)
+			r.ClientCertSent = err == nil && cert != nil && len(cert.Certificate) > 0
+			return cert, err
+		}
+		for i := range certs {
+			if cri.SupportsCertificate(&certs[i]) == nil {
+				r.ClientCertSent = true
+				return &certs[i], nil
+			}
+		}
+		return &tls.Certificate{}, nil
+	}
+}
@@ -0,0 +1,67 @@
+package echclient
+
+import (
+	"crypto/tls"
+	"errors"
+	"time"
+)
+
+// Hooks are callbacks invoked as a lookup or probe progresses. Any of them
+// may be nil.
+type Hooks struct {
+	// OnDNSLookup is called after every DNS query with the response or the
+	// error and the time the query took.
+	OnDNSLookup func(name string, qtype RRType, resp *DNSResponse, err error, rtt time.Duration)
+
+	// OnHTTPSRecordParsed is called when the HTTPS RR for name has been
+	// decoded.
+	OnHTTPSRecordParsed func(name string, record *HttpsRecord)
+
+	// OnECHConfigSelected is called with the config crypto/tls is expected
+	// to use out of the published list.
+	OnECHConfigSelected func(name string, config *ECHConfig)
+
+	// OnTLSHandshakeDone is called when the TLS handshake completes or
+	// fails.
+	OnTLSHandshakeDone func(state tls.ConnectionState, err error)
+
+	// OnECHRejected is called when the server rejects ECH.
+	OnECHRejected func(err *tls.ECHRejectionError)
+}
+
+func (c *ProbeConfig) hooks() *Hooks {
+	if c == nil || c.Hooks == nil {
+		return &Hooks{}
+	}
+	return c.Hooks
+}
+
+func (h *Hooks) dnsLookup(name string, qtype RRType, resp *DNSResponse, err error, rtt time.Duration) {
+	if h.OnDNSLookup != nil {
+		h.OnDNSLookup(name, qtype, resp, err, rtt)
+	}
+}
+
+func (h *Hooks) httpsRecordParsed(name string, record *HttpsRecord) {
+	if h.OnHTTPSRecordParsed != nil {
+		h.OnHTTPSRecordParsed(name, record)
+	}
+}
+
+func (h *Hooks) echConfigSelected(name string, config *ECHConfig) {
+	if h.OnECHConfigSelected != nil {
+		h.OnECHConfigSelected(name, config)
+	}
+}
+
+// tlsHandshakeDone calls OnTLSHandshakeDone and, if err is an
+// ECHRejectionError, OnECHRejected.
+func (h *Hooks) tlsHandshakeDone(state tls.ConnectionState, err error) {
+	if h.OnTLSHandshakeDone != nil {
+		h.OnTLSHandshakeDone(state, err)
+	}
+	var echErr *tls.ECHRejectionError
+	if h.OnECHRejected != nil && errors.As(err, &echErr) {
+		h.OnECHRejected(echErr)
+	}
+}
@@ -0,0 +1,149 @@
+package echclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// HPKE algorithm identifiers from the IANA HPKE registry (RFC 9180).
+const (
+	// KEMs
+	P256   uint16 = 0x0010 // DHKEM(P-256, HKDF-SHA256)
+	P384   uint16 = 0x0011 // DHKEM(P-384, HKDF-SHA384)
+	P521   uint16 = 0x0012 // DHKEM(P-521, HKDF-SHA512)
+	X25519 uint16 = 0x0020 // DHKEM(X25519, HKDF-SHA256)
+	X448   uint16 = 0x0021 // DHKEM(X448, HKDF-SHA512)
+
+	// Post-quantum and hybrid KEMs. None is supported by crypto/tls; they
+	// are named when seen in published configs.
+	X25519Kyber768Draft00 uint16 = 0x0030 // X25519Kyber768Draft00
+	MLKEM512              uint16 = 0x0040 // ML-KEM-512
+	MLKEM768              uint16 = 0x0041 // ML-KEM-768
+	MLKEM1024             uint16 = 0x0042 // ML-KEM-1024
+	MLKEM768P256          uint16 = 0x0050 // MLKEM768-P256
+	MLKEM1024P384         uint16 = 0x0051 // MLKEM1024-P384
+	XWing                 uint16 = 0x647a // X-Wing, MLKEM768-X25519
+
+	// KDFs
+	HKDFSHA256 uint16 = 0x0001
+	HKDFSHA384 uint16 = 0x0002
+	HKDFSHA512 uint16 = 0x0003
+
+	// AEADs
+	AES128GCM        uint16 = 0x0001
+	AES256GCM        uint16 = 0x0002
+	ChaCha20Poly1305 uint16 = 0x0003
+)
+
+var kemNames = map[uint16]string{
+	P256:   "p256",
+	P384:   "p384",
+	P521:   "p521",
+	X25519: "x25519",
+	X448:   "x448",
+
+	X25519Kyber768Draft00: "x25519kyber768draft00",
+	MLKEM512:              "mlkem512",
+	MLKEM768:              "mlkem768",
+	MLKEM1024:             "mlkem1024",
+	MLKEM768P256:          "mlkem768-p256",
+	MLKEM1024P384:         "mlkem1024-p384",
+	XWing:                 "xwing",
+}
+
+// postQuantumKEMs are the KEMs resisting a quantum computer, on their own
+// or combined with a classical one.
+var postQuantumKEMs = map[uint16]bool{
+	X25519Kyber768Draft00: true,
+	MLKEM512:              true,
+	MLKEM768:              true,
+	MLKEM1024:             true,
+	MLKEM768P256:          true,
+	MLKEM1024P384:         true,
+	XWing:                 true,
+}
+
+var kdfNames = map[uint16]string{
+	HKDFSHA256: "hkdf-sha256",
+	HKDFSHA384: "hkdf-sha384",
+	HKDFSHA512: "hkdf-sha512",
+}
+
+var aeadNames = map[uint16]string{
+	AES128GCM:        "aes128gcm",
+	AES256GCM:        "aes256gcm",
+	ChaCha20Poly1305: "chacha20poly1305",
+}
+
+// KEMName returns the short name of an HPKE KEM, or its hex identifier if
+// it is not registered.
+func KEMName(id uint16) string {
+	return hpkeName(kemNames, id)
+}
+
+// IsPostQuantumKEM reports whether the KEM id is a post-quantum or hybrid
+// KEM, such as ML-KEM or X-Wing.
+func IsPostQuantumKEM(id uint16) bool {
+	return postQuantumKEMs[id]
+}
+
+// KDFName returns the short name of an HPKE KDF, or its hex identifier if
+// it is not registered.
+func KDFName(id uint16) string {
+	return hpkeName(kdfNames, id)
+}
+
+// AEADName returns the short name of an HPKE AEAD, or its hex identifier if
+// it is not registered.
+func AEADName(id uint16) string {
+	return hpkeName(aeadNames, id)
+}
+
+// ParseKEM returns the identifier of the KEM with the given short name.
+func ParseKEM(name string) (uint16, error) {
+	return parseHPKEName(kemNames, "KEM", name)
+}
+
+// ParseKDF returns the identifier of the KDF with the given short name.
+func ParseKDF(name string) (uint16, error) {
+	return parseHPKEName(kdfNames, "KDF", name)
+}
+
+// ParseAEAD returns the identifier of the AEAD with the given short name.
+func ParseAEAD(name string) (uint16, error) {
+	return parseHPKEName(aeadNames, "AEAD", name)
+}
+
+// String returns the names of the KDF and AEAD of c, as in
+// "hkdf-sha256/aes128gcm".
+func (c ECHCipher) String() string {
+	return KDFName(c.KDFID) + "/" + AEADName(c.AEADID)
+}
+
+// MarshalJSON adds the names of the KDF and AEAD to the JSON encoding of c.
+func (c ECHCipher) MarshalJSON() ([]byte, error) {
+	type echCipher ECHCipher
+	return json.Marshal(struct {
+		echCipher
+		KDF  string `json:"kdf"`
+		AEAD string `json:"aead"`
+	}{echCipher(c), KDFName(c.KDFID), AEADName(c.AEADID)})
+}
+
+func hpkeName(names map[uint16]string, id uint16) string {
+	if name, ok := names[id]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%04x", id)
+}
+
+func parseHPKEName(names map[uint16]string, kind, name string) (uint16, error) {
+	name = strings.ToLower(name)
+	for id, n := range names {
+		if n == name {
+			return id, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown HPKE %s %q", kind, name)
+}
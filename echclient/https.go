@@ -0,0 +1,208 @@
+package echclient
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// HttpsRecord is an HTTPS RR or, as the two share their RDATA format
+// (RFC 9460), an SVCB RR.
+type HttpsRecord struct {
+	Priority   uint16     `json:"priority"`
+	TargetName string     `json:"target_name"`
+	Params     []SvcParam `json:"params"`
+
+	// trailing counts the bytes after the last SvcParam, too few to
+	// hold another one.
+	trailing int
+}
+
+type SvcParam struct {
+	Key   uint16 `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// SVCBRecord is the name of HttpsRecord used for SVCB RRs.
+type SVCBRecord = HttpsRecord
+
+// ParseSVCBRecord parses the RDATA of an SVCB RR.
+func ParseSVCBRecord(data []byte) (*SVCBRecord, error) {
+	return ParseHttpsRecord(data)
+}
+
+// ParseHttpsRecord parses the RDATA of an HTTPS RR
+func ParseHttpsRecord(data []byte) (*HttpsRecord, error) {
+	if len(data) < 3 {
+		return nil, fmt.Errorf("%w: invalid data length", ErrMalformedRR)
+	}
+
+	record := &HttpsRecord{}
+
+	// Read Priority (2 bytes)
+	record.Priority = uint16(data[0])<<8 | uint16(data[1])
+
+	// Target Name: sequence of length prefixed labels ending with the
+	// root label
+	target, n, err := readTargetName(data[2:])
+	if err != nil {
+		return nil, err
+	}
+	record.TargetName = target
+	idx := 2 + n
+
+	// Parse SvcParams
+	for idx+4 <= len(data) {
+		key := uint16(data[idx])<<8 | uint16(data[idx+1])
+		length := int(data[idx+2])<<8 | int(data[idx+3])
+		idx += 4
+
+		if idx+length > len(data) {
+			return nil, fmt.Errorf("%w: invalid parameter length", ErrMalformedRR)
+		}
+
+		value := data[idx : idx+length]
+		record.Params = append(record.Params, SvcParam{Key: key, Value: value})
+		idx += length
+	}
+	record.trailing = len(data) - idx
+
+	return record, nil
+}
+
+// Marshal encodes r into the RDATA of an HTTPS or SVCB RR (RFC 9460, section
+// 2.2), the inverse of ParseHttpsRecord. The SvcParams are written in the
+// order of r.Params; the TargetName may use the escapes of the presentation
+// format.
+func (r *HttpsRecord) Marshal() ([]byte, error) {
+	target, err := packTargetName(r.TargetName)
+	if err != nil {
+		return nil, err
+	}
+	var b cryptobyte.Builder
+	b.AddUint16(r.Priority)
+	b.AddBytes(target)
+	for _, p := range r.Params {
+		if len(p.Value) > 0xffff {
+			return nil, fmt.Errorf("%w: value of %s longer than 65535 octets", ErrMalformedRR, SvcParamKeyName(p.Key))
+		}
+		b.AddUint16(p.Key)
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddBytes(p.Value)
+		})
+	}
+	return b.Bytes()
+}
+
+// packTargetName encodes a TargetName in presentation format as
+// uncompressed wire-format labels, keeping its case.
+func packTargetName(name string) ([]byte, error) {
+	var (
+		wire  []byte
+		label []byte
+	)
+	endLabel := func() error {
+		if len(label) == 0 {
+			return fmt.Errorf("%w: empty label in target name %q", ErrMalformedRR, name)
+		}
+		if len(label) > 63 {
+			return fmt.Errorf("%w: label longer than 63 octets in target name %q", ErrMalformedRR, name)
+		}
+		wire = append(wire, byte(len(label)))
+		wire = append(wire, label...)
+		label = label[:0]
+		return nil
+	}
+	if name != "." && name != "" {
+		for i := 0; i < len(name); i++ {
+			switch {
+			case name[i] == '\\' && i+3 < len(name) && isDigits(name[i+1:i+4]):
+				n, _ := strconv.Atoi(name[i+1 : i+4])
+				if n > 255 {
+					return nil, fmt.Errorf("%w: invalid escape in target name %q", ErrMalformedRR, name)
+				}
+				label = append(label, byte(n))
+				i += 3
+			case name[i] == '\\' && i+1 < len(name):
+				label = append(label, name[i+1])
+				i++
+			case name[i] == '.':
+				if err := endLabel(); err != nil {
+					return nil, err
+				}
+			default:
+				label = append(label, name[i])
+			}
+		}
+		if len(label) > 0 {
+			if err := endLabel(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	wire = append(wire, 0)
+	if len(wire) > maxNameLength {
+		return nil, fmt.Errorf("%w: target name longer than %d octets", ErrMalformedRR, maxNameLength)
+	}
+	return wire, nil
+}
+
+// maxNameLength is the maximum length of a wire-format domain name (RFC 1035,
+// section 2.3.4).
+const maxNameLength = 255
+
+// readTargetName decodes the wire-format TargetName at the start of data
+// and returns it in presentation format, with its length in data. RFC 9460,
+// section 2.2 forbids name compression in the RDATA, so compression pointers
+// are rejected.
+func readTargetName(data []byte) (string, int, error) {
+	var labels []string
+	idx := 0
+	for {
+		if idx >= len(data) {
+			return "", 0, fmt.Errorf("%w: truncated target name", ErrMalformedRR)
+		}
+		length := int(data[idx])
+		if length&0xc0 != 0 {
+			// 0xc0 marks a compression pointer; 0x40 and 0x80 are
+			// obsolete extended label types.
+			return "", 0, fmt.Errorf("%w: compressed target name", ErrMalformedRR)
+		}
+		idx++
+		if length == 0 {
+			break
+		}
+		if idx+length > len(data) {
+			return "", 0, fmt.Errorf("%w: truncated target name", ErrMalformedRR)
+		}
+		labels = append(labels, escapeLabel(data[idx:idx+length]))
+		idx += length
+	}
+	if idx > maxNameLength {
+		return "", 0, fmt.Errorf("%w: target name longer than %d octets", ErrMalformedRR, maxNameLength)
+	}
+	if len(labels) == 0 {
+		return ".", idx, nil
+	}
+	return strings.Join(labels, ".") + ".", idx, nil
+}
+
+// escapeLabel returns label in presentation format, escaping the characters
+// special in zone files and the non-printable ones (RFC 1035, section 5.1).
+func escapeLabel(label []byte) string {
+	var sb strings.Builder
+	for _, c := range label {
+		switch {
+		case strings.IndexByte(`."\();@$`, c) >= 0:
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c <= ' ' || c > '~':
+			fmt.Fprintf(&sb, "\\%03d", c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
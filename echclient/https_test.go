@@ -0,0 +1,591 @@
+package echclient
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+func mustHex(t testing.TB, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// The vectors are those of RFC 9460, appendix D, and escaping corner cases.
+func TestParseHttpsRecord(t *testing.T) {
+	tests := []struct {
+		name     string
+		rdata    string
+		priority uint16
+		target   string
+		params   []string
+	}{
+		{
+			name:     "AliasMode",
+			rdata:    "0000 03 666f6f 07 6578616d706c65 03 636f6d 00",
+			priority: 0,
+			target:   "foo.example.com.",
+		},
+		{
+			name:     "root target",
+			rdata:    "0001 00",
+			priority: 1,
+			target:   ".",
+		},
+		{
+			name:     "port",
+			rdata:    "0010 03 666f6f 07 6578616d706c65 03 636f6d 00 0003 0002 0035",
+			priority: 16,
+			target:   "foo.example.com.",
+			params:   []string{"port=53"},
+		},
+		{
+			name:     "generic key",
+			rdata:    "0001 03 666f6f 07 6578616d706c65 03 636f6d 00 029b 0005 68656c6c6f",
+			priority: 1,
+			target:   "foo.example.com.",
+			params:   []string{`key667="hello"`},
+		},
+		{
+			name:     "generic key with escapes",
+			rdata:    "0001 03 666f6f 07 6578616d706c65 03 636f6d 00 029b 0009 68656c6c6fd2716f6f",
+			priority: 1,
+			target:   "foo.example.com.",
+			params:   []string{`key667="hello\210qoo"`},
+		},
+		{
+			name:     "empty generic key",
+			rdata:    "0001 00 029b 0000",
+			priority: 1,
+			target:   ".",
+			params:   []string{"key667"},
+		},
+		{
+			name:     "generic key with quote and backslash",
+			rdata:    "0001 00 fde8 0004 61225c62",
+			priority: 1,
+			target:   ".",
+			params:   []string{`key65000="a\"\\b"`},
+		},
+		{
+			name:     "alpn with escaped comma",
+			rdata:    "0010 03 666f6f 07 6578616d706c65 03 6f7267 00 0001 000c 08 665c6f6f2c626172 02 6832",
+			priority: 16,
+			target:   "foo.example.org.",
+			params:   []string{`alpn="f\\\\oo\\,bar,h2"`},
+		},
+		{
+			name: "ipv6hint",
+			rdata: "0001 03 666f6f 07 6578616d706c65 03 636f6d 00 0006 0020" +
+				"20010db8000000000000000000000001 20010db8000000000000000000530001",
+			priority: 1,
+			target:   "foo.example.com.",
+			params:   []string{"ipv6hint=2001:db8::1,2001:db8::53:1"},
+		},
+		{
+			name: "mandatory alpn ipv4hint",
+			rdata: "0010 03 666f6f 07 6578616d706c65 03 6f7267 00" +
+				"0000 0004 0001 0004 0001 0009 02 6832 05 68332d3139 0004 0004 c0000201",
+			priority: 16,
+			target:   "foo.example.org.",
+			params:   []string{"mandatory=alpn,ipv4hint", `alpn="h2,h3-19"`, "ipv4hint=192.0.2.1"},
+		},
+		{
+			name:     "escaped dot",
+			rdata:    "0001 03 612e62 00",
+			priority: 1,
+			target:   `a\.b.`,
+		},
+		{
+			name:     "non-printable",
+			rdata:    "0001 02 0041 03 636f6d 00",
+			priority: 1,
+			target:   `\000A.com.`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record, err := ParseHttpsRecord(mustHex(t, tt.rdata))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if record.Priority != tt.priority {
+				t.Errorf("priority = %d, want %d", record.Priority, tt.priority)
+			}
+			if record.TargetName != tt.target {
+				t.Errorf("target = %q, want %q", record.TargetName, tt.target)
+			}
+			var params []string
+			for _, p := range record.Params {
+				params = append(params, p.String())
+			}
+			if strings.Join(params, " ") != strings.Join(tt.params, " ") {
+				t.Errorf("params = %q, want %q", params, tt.params)
+			}
+			rdata, err := record.Marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(rdata, mustHex(t, tt.rdata)) {
+				t.Errorf("Marshal = %x, want %s", rdata, tt.rdata)
+			}
+			// The presentation format must read back to the same record.
+			presentation := strings.Join(append([]string{strconv.Itoa(int(tt.priority)), tt.target}, params...), " ")
+			parsed, err := ParseHttpsPresentation(presentation)
+			if err != nil {
+				t.Fatalf("ParseHttpsPresentation(%q): %v", presentation, err)
+			}
+			if len(parsed.Params) != len(record.Params) {
+				t.Fatalf("ParseHttpsPresentation(%q) = %+v, want %+v", presentation, parsed.Params, record.Params)
+			}
+			for i, p := range parsed.Params {
+				if p.Key != record.Params[i].Key || !bytes.Equal(p.Value, record.Params[i].Value) {
+					t.Errorf("ParseHttpsPresentation(%q) = %+v, want %+v", presentation, parsed.Params, record.Params)
+				}
+			}
+		})
+	}
+}
+
+func TestParseHttpsRecordMalformed(t *testing.T) {
+	long := []byte{0, 1}
+	for range 4 {
+		long = append(long, 63)
+		long = append(long, bytes.Repeat([]byte{'a'}, 63)...)
+	}
+	long = append(long, 0)
+	tests := []struct {
+		name  string
+		rdata []byte
+	}{
+		{"empty", nil},
+		{"compression pointer", mustHex(t, "0001 c00c")},
+		{"pointer after label", mustHex(t, "0001 03 666f6f c00c")},
+		{"extended label", mustHex(t, "0001 40 00")},
+		{"truncated label", mustHex(t, "0001 03 666f")},
+		{"missing root label", mustHex(t, "0001 03 666f6f")},
+		{"name too long", long},
+		{"truncated param", mustHex(t, "0001 00 0003 0004 0035")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record, err := ParseHttpsRecord(tt.rdata)
+			if !errors.Is(err, ErrMalformedRR) {
+				t.Fatalf("ParseHttpsRecord = %+v, %v; want ErrMalformedRR", record, err)
+			}
+		})
+	}
+}
+
+func TestHttpsRecordViolations(t *testing.T) {
+	tests := []struct {
+		name       string
+		rdata      string
+		violations []string
+	}{
+		{"valid", "0001 00 0001 0003 02 6832 0003 0002 01bb", nil},
+		{
+			name:       "out of order",
+			rdata:      "0001 00 0003 0002 01bb 0001 0003 02 6832",
+			violations: []string{"SvcParamKey 1 (alpn) out of order after 3 (port)"},
+		},
+		{
+			name:       "duplicate",
+			rdata:      "0001 00 0005 0001 00 0005 0001 00",
+			violations: []string{"duplicate SvcParamKey 5 (ech)"},
+		},
+		{
+			name:       "unknown keys out of order",
+			rdata:      "0001 00 fde9 0000 fde8 0000",
+			violations: []string{"SvcParamKey 65000 out of order after 65001"},
+		},
+		{
+			name:       "mandatory out of order",
+			rdata:      "0001 00 0000 0004 0004 0001 0001 0003 02 6832 0004 0004 c0000201",
+			violations: []string{"mandatory: key 1 (alpn) out of order after 4 (ipv4hint)"},
+		},
+		{
+			name:       "mandatory lists itself",
+			rdata:      "0001 00 0000 0002 0000",
+			violations: []string{"mandatory: lists key 0 (mandatory)"},
+		},
+		{
+			name:       "wrong lengths and trailing bytes",
+			rdata:      "0001 00 0003 0001 01 0006 0004 20010db8 ffff",
+			violations: []string{"port: length 1, want 2", "ipv6hint: length 4 is not a non-zero multiple of 16", "2 trailing bytes after the SvcParams"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record, err := ParseHttpsRecord(mustHex(t, tt.rdata))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := record.Violations(); strings.Join(got, "; ") != strings.Join(tt.violations, "; ") {
+				t.Errorf("Violations() = %q, want %q", got, tt.violations)
+			}
+		})
+	}
+}
+
+func TestHttpsRecordEndpoint(t *testing.T) {
+	tests := []struct {
+		priority uint16
+		target   string
+		owner    string
+		want     string
+	}{
+		{1, ".", "Example.com", "example.com."},
+		{1, "svc.example.net.", "example.com.", "svc.example.net."},
+		{0, "svc.example.net.", "example.com.", "svc.example.net."},
+		{0, ".", "example.com.", ""},
+		{1, ".", "", ""},
+	}
+	for _, tt := range tests {
+		r := &HttpsRecord{Priority: tt.priority, TargetName: tt.target}
+		if got := r.Endpoint(tt.owner); got != tt.want {
+			t.Errorf("%d %s owned by %q: Endpoint = %q, want %q", tt.priority, tt.target, tt.owner, got, tt.want)
+		}
+	}
+}
+
+func TestSelectEndpoint(t *testing.T) {
+	h3 := &HttpsRecord{Priority: 1, TargetName: "h3.example.", Params: []SvcParam{ALPNParam("h3"), NoDefaultALPNParam()}}
+	h2 := &HttpsRecord{Priority: 2, TargetName: "h2.example.", Params: []SvcParam{ALPNParam("h2")}}
+	unknown := &HttpsRecord{Priority: 1, TargetName: "new.example.", Params: []SvcParam{MandatoryParam(SvcParamOHTTP), {Key: SvcParamOHTTP}}}
+	plain := &HttpsRecord{Priority: 3, TargetName: "."}
+	records := []*HttpsRecord{plain, h2, unknown, h3}
+
+	endpoints, skipped := SelectEndpoint(records, Policy{})
+	if want := []*HttpsRecord{h3, h2, plain}; !slices.Equal(endpoints, want) {
+		t.Errorf("endpoints = %v, want %v", endpoints, want)
+	}
+	if len(skipped) != 1 || skipped[0].Record != unknown {
+		t.Errorf("skipped = %v, want %v", skipped, unknown)
+	}
+
+	endpoints, skipped = SelectEndpoint(records, Policy{ALPN: []string{"http/1.1"}, SupportedKeys: []uint16{SvcParamOHTTP}})
+	if want := []*HttpsRecord{unknown, h2, plain}; !slices.Equal(endpoints, want) {
+		t.Errorf("endpoints = %v, want %v", endpoints, want)
+	}
+	if len(skipped) != 1 || skipped[0].Record != h3 {
+		t.Errorf("skipped = %v, want %v", skipped, h3)
+	}
+
+	endpoints, _ = SelectEndpoint(append(records, &HttpsRecord{TargetName: "alias.example."}), Policy{})
+	if len(endpoints) != 0 {
+		t.Errorf("endpoints next to an AliasMode record = %v, want none", endpoints)
+	}
+
+	a := &HttpsRecord{Priority: 1, TargetName: "a.example."}
+	b := &HttpsRecord{Priority: 1, TargetName: "b.example."}
+	seen := map[*HttpsRecord]bool{}
+	rnd := rand.New(rand.NewPCG(1, 2))
+	for range 32 {
+		endpoints, _ := SelectEndpoint([]*HttpsRecord{h2, a, b}, Policy{Shuffle: true, Rand: rnd})
+		if len(endpoints) != 3 || endpoints[2] != h2 {
+			t.Fatalf("shuffled endpoints = %v, want h2 last", endpoints)
+		}
+		seen[endpoints[0]] = true
+	}
+	if !seen[a] || !seen[b] {
+		t.Errorf("shuffling never put both records of priority 1 first")
+	}
+}
+
+func TestLintECHConfigList(t *testing.T) {
+	good := ECHConfig{
+		Version:              extensionEncryptedClientHello,
+		ConfigID:             1,
+		KemID:                X25519,
+		PublicKey:            make([]byte, 32),
+		SymmetricCipherSuite: []ECHCipher{{HKDFSHA256, AES128GCM}},
+		PublicName:           []byte("ech.example.com"),
+	}
+	bad := ECHConfig{
+		Version:              extensionEncryptedClientHello,
+		ConfigID:             1,
+		KemID:                0x0099,
+		SymmetricCipherSuite: []ECHCipher{{HKDFSHA256, 0x0042}},
+		MaxNameLength:        8,
+		PublicName:           []byte("192.0.2.1"),
+		Extensions:           []ECHExtension{{Type: 0x8001}},
+	}
+	future := good
+	future.Version = 0xfe0e
+	list, err := ECHConfigList{good, future, bad}.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	findings, err := LintECHConfigList(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, f := range findings {
+		got = append(got, f.String())
+	}
+	want := []string{
+		"warning: config 1 (config_id 0): version 0xfe0e is not supported, clients skip this config",
+		"error: config 2 (config_id 1): KEM 0x0099 is not in the HPKE registry",
+		"error: config 2 (config_id 1): AEAD 0x0042 is not in the HPKE registry",
+		"warning: config 2 (config_id 1): no cipher suite is supported by crypto/tls, add hkdf-sha256/aes128gcm",
+		`error: config 2 (config_id 1): public_name "192.0.2.1" is an IP literal, clients must reject it`,
+		"warning: config 2 (config_id 1): maximum_name_length 8 is shorter than typical backend names, whose length the ClientHello then reveals",
+		"error: config 2 (config_id 1): mandatory extension 0x8001 cannot be satisfied, clients skip this config",
+		"warning: config 2 (config_id 1): config_id also used by config 0, servers cannot tell them apart",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("findings:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestPublicNameProblem(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		valid bool
+	}{
+		{"ech.example.com", true},
+		{"xn--bcher-kva.example", true},
+		{"cover-1.example.net", true},
+		{"", false},
+		{"[2001:db8::1]", false},
+		{"127.1", false},
+		{"cover.0x7f", false},
+		{"cover.0x", false},
+		{"example.123", false},
+		{".example.com", false},
+		{"example.com.", false},
+		{"bücher.example", false},
+		{"xn--a.example", false},
+		{"a_b.example", false},
+		{"-a.example", false},
+		{strings.Repeat("a", 64) + ".example", false},
+		{"localhost", false},
+	} {
+		if problem := publicNameProblem(tc.name); (problem == "") != tc.valid {
+			t.Errorf("publicNameProblem(%q) = %q, want valid %v", tc.name, problem, tc.valid)
+		}
+	}
+}
+
+func TestFilterECHConfigList(t *testing.T) {
+	usable := ECHConfig{
+		Version:              extensionEncryptedClientHello,
+		ConfigID:             1,
+		KemID:                X25519,
+		PublicKey:            make([]byte, 32),
+		SymmetricCipherSuite: []ECHCipher{{HKDFSHA256, AES128GCM}},
+		PublicName:           []byte("ech.example.com"),
+	}
+	p256 := usable
+	p256.ConfigID, p256.KemID, p256.PublicKey = 2, P256, make([]byte, 65)
+	sha512 := usable
+	sha512.ConfigID, sha512.SymmetricCipherSuite = 3, []ECHCipher{{HKDFSHA512, AES256GCM}}
+	future := usable
+	future.Version = 0xfe0e
+	mandatory := usable
+	mandatory.ConfigID, mandatory.Extensions = 4, []ECHExtension{{Type: 0x0001}, {Type: 0xfe01, Data: []byte{1}}}
+
+	list, err := ECHConfigList{future, p256, usable, sha512, mandatory}.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	filtered, skipped, err := FilterECHConfigList(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, _ := (ECHConfigList{usable}).Marshal(); !bytes.Equal(filtered, want) {
+		t.Errorf("filtered = %x, want %x", filtered, want)
+	}
+	want := []string{
+		"version 0xfe0e is not supported",
+		"config_id 2: KEM p256 is not supported",
+		"config_id 3: none of the cipher suites hkdf-sha512/aes256gcm is supported",
+		"config_id 4: mandatory extension 0xfe01 is not supported",
+	}
+	if strings.Join(skipped, "\n") != strings.Join(want, "\n") {
+		t.Errorf("skipped = %q, want %q", skipped, want)
+	}
+
+	list, _ = ECHConfigList{p256}.Marshal()
+	if _, _, err := FilterECHConfigList(list); !errors.Is(err, ErrNoUsableECHConfig) ||
+		!strings.Contains(err.Error(), "published config_id 2 with KEM p256 and cipher suites hkdf-sha256/aes128gcm") {
+		t.Errorf("FilterECHConfigList = %v, want ErrNoUsableECHConfig describing config_id 2", err)
+	}
+}
+
+func TestConfigIDChanges(t *testing.T) {
+	old := ECHConfig{Version: extensionEncryptedClientHello, ConfigID: 1, PublicKey: []byte{1}}
+	rekeyed := old
+	rekeyed.PublicKey = []byte{2}
+	next := rekeyed
+	next.ConfigID = 2
+
+	if got := (ECHConfigList{old, rekeyed, next}).ConfigIDCollisions(); len(got) != 1 ||
+		got[0] != "config_id 1 is shared by 2 configs, servers cannot tell their keys apart" {
+		t.Errorf("ConfigIDCollisions = %q", got)
+	}
+	for _, tt := range []struct {
+		previous, current ECHConfigList
+		want              string
+	}{
+		{ECHConfigList{old}, ECHConfigList{old}, ""},
+		{ECHConfigList{old}, ECHConfigList{next, old}, ""},
+		{ECHConfigList{old}, ECHConfigList{rekeyed}, "config_id 1 was reused for a new public key"},
+		{ECHConfigList{old}, ECHConfigList{next}, "config_id changed from 1 to 2 without overlap"},
+		{nil, ECHConfigList{next}, ""},
+	} {
+		if got := strings.Join(ConfigIDChanges(tt.previous, tt.current), "; "); got != tt.want {
+			t.Errorf("ConfigIDChanges(%d configs, %d configs) = %q, want %q", len(tt.previous), len(tt.current), got, tt.want)
+		}
+	}
+}
+
+func TestParseECHConfigListLegacy(t *testing.T) {
+	// A draft-10 config, with a 16-bit maximum_name_length and public_name
+	// length, followed by one of an unknown version.
+	data := mustHex(t, "003f"+
+		"fe0a 0034 07 0020 0020 "+strings.Repeat("00", 32)+" 0004 00010001 0040 0003 612e62 0000"+
+		"fe77 0003 010203")
+	configs, err := ParseECHConfigList(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(configs) != 2 {
+		t.Fatalf("got %d configs, want 2", len(configs))
+	}
+	if ec := configs[0]; ec.ConfigID != 7 || ec.KemID != X25519 || ec.MaxNameLength != 64 || string(ec.PublicName) != "a.b" {
+		t.Errorf("draft-10 config = %+v", ec)
+	}
+	if info := NewECHConfigInfo(&configs[1]); info.Draft != "0xfe77" || !bytes.Equal(info.Raw, mustHex(t, "fe77 0003 010203")) {
+		t.Errorf("unknown version = %+v", info)
+	}
+	if pickECHConfig(configs) != nil {
+		t.Errorf("picked a config crypto/tls cannot use")
+	}
+	if marshaled, err := configs.Marshal(); err != nil || !bytes.Equal(marshaled, data) {
+		t.Errorf("Marshal = %x, %v; want %x", marshaled, err, data)
+	}
+}
+
+func TestDiffECHConfigs(t *testing.T) {
+	a := ECHConfig{Version: extensionEncryptedClientHello, ConfigID: 1, PublicKey: []byte{1}, PublicName: []byte("a.example")}
+	rotated := a
+	rotated.PublicKey, rotated.PublicName = []byte{2}, []byte("b.example")
+	b := a
+	b.ConfigID = 2
+
+	want := []string{"config_id 1: public key changed", "config_id 1: public_name changed from a.example to b.example"}
+	if got := DiffECHConfigs(ECHConfigList{a}, ECHConfigList{rotated}); !slices.Equal(got, want) {
+		t.Errorf("DiffECHConfigs(rotated) = %q, want %q", got, want)
+	}
+	want = []string{"config_id 1 removed", "config_id 2 added with public_name a.example"}
+	if got := DiffECHConfigs(ECHConfigList{a}, ECHConfigList{b}); !slices.Equal(got, want) {
+		t.Errorf("DiffECHConfigs(replaced) = %q, want %q", got, want)
+	}
+	if got := DiffECHConfigs(ECHConfigList{a, b}, ECHConfigList{b, a}); got != nil {
+		t.Errorf("DiffECHConfigs(reordered) = %q, want none", got)
+	}
+}
+
+func TestECHConfigPins(t *testing.T) {
+	a := ECHConfig{Version: extensionEncryptedClientHello, ConfigID: 1, PublicKey: []byte{1}, PublicName: []byte("a.example")}
+	swapped := a
+	swapped.PublicKey = []byte{2}
+	first, _ := ECHConfigList{a}.Marshal()
+	second, _ := ECHConfigList{swapped}.Marshal()
+
+	pins := NewECHConfigPins()
+	if err := pins.Check("Example.com", first); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	if err := pins.Check("example.com.", first); err != nil {
+		t.Fatalf("same list: %v", err)
+	}
+	err := pins.Check("example.com", second)
+	if !errors.Is(err, ErrECHConfigPinMismatch) || !strings.HasSuffix(err.Error(), "config_id 1: public key changed") {
+		t.Fatalf("swapped key: %v, want ErrECHConfigPinMismatch naming the change", err)
+	}
+	// The pin is kept until removed.
+	if err := pins.Check("example.com", second); err == nil {
+		t.Error("swapped list was pinned")
+	}
+}
+
+func TestECHConfigFormats(t *testing.T) {
+	a := ECHConfig{Version: extensionEncryptedClientHello, ConfigID: 1, KemID: X25519, PublicKey: make([]byte, 32), PublicName: []byte("a.example")}
+	b := a
+	b.ConfigID = 2
+	list, _ := ECHConfigList{a, b}.Marshal()
+	single, _ := ECHConfigList{a}.Marshal()
+
+	for _, format := range ECHConfigFormats {
+		in := list
+		if format == ECHConfigFormatBoringSSL {
+			in = single
+		}
+		encoded, err := EncodeECHConfigList(in, format)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		for _, from := range []ECHConfigFormat{format, ECHConfigFormatAuto} {
+			decoded, err := DecodeECHConfigList(encoded, from)
+			if err != nil || !bytes.Equal(decoded, in) {
+				t.Errorf("%s from %s = %x, %v, want %x", format, from, decoded, err, in)
+			}
+		}
+	}
+	if _, err := EncodeECHConfigList(list, ECHConfigFormatBoringSSL); err == nil {
+		t.Error("encoded two configs in the boringssl format")
+	}
+	if got, err := SelectECHConfig(list, 1); err != nil || !bytes.Equal(got, single) {
+		t.Errorf("SelectECHConfig(1) = %x, %v, want %x", got, err, single)
+	}
+}
+
+func TestParseClientHello(t *testing.T) {
+	var b cryptobyte.Builder
+	b.AddUint8(0) // outer
+	b.AddUint16(HKDFSHA256)
+	b.AddUint16(AES128GCM)
+	b.AddUint8(5)
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(make([]byte, 32)) })
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(make([]byte, 100)) })
+	msg := handshakeMessage(1, clientHelloBody(make([]byte, 32), nil, "public.example", make([]byte, 32), b.BytesOrPanic()))
+
+	// Split the message over two records, as large ClientHellos are.
+	var records []byte
+	for _, fragment := range [][]byte{msg[:50], msg[50:]} {
+		records = append(records, 22, 3, 1, byte(len(fragment)>>8), byte(len(fragment)))
+		records = append(records, fragment...)
+	}
+	if _, err := ParseClientHello(records[:len(records)-1]); err == nil {
+		t.Error("parsed a truncated ClientHello")
+	}
+	hello, err := ParseClientHello(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(hello.Raw, msg) || hello.ServerName != "public.example" || !slices.Equal(hello.ALPN, tcpProtocols) {
+		t.Errorf("ParseClientHello = %+v", hello)
+	}
+	want := ClientHelloECH{CipherSuite: ECHCipher{HKDFSHA256, AES128GCM}, ConfigID: 5, EncLength: 32, PayloadLength: 100}
+	if hello.ECH == nil || *hello.ECH != want {
+		t.Errorf("ECH = %+v, want %+v", hello.ECH, want)
+	}
+	if last := hello.Extensions[len(hello.Extensions)-1]; last.Name != "encrypted_client_hello" {
+		t.Errorf("last extension = %+v, want encrypted_client_hello", last)
+	}
+}
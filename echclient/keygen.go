@@ -0,0 +1,50 @@
+package echclient
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"fmt"
+)
+
+// ECHKey is an ECHConfig together with the HPKE private key needed to
+// decrypt ClientHellos encrypted to it.
+type ECHKey struct {
+	Config     ECHConfig
+	PrivateKey *ecdh.PrivateKey
+}
+
+// GenerateECHKey generates an HPKE key pair for kemID and wraps its public
+// key in an ECHConfig for publicName, offering HKDF-SHA256 with AES-128-GCM
+// and ChaCha20Poly1305. Only the DH based KEMs backed by crypto/ecdh are
+// supported.
+func GenerateECHKey(configID uint8, kemID uint16, publicName string) (*ECHKey, error) {
+	var curve ecdh.Curve
+	switch kemID {
+	case X25519:
+		curve = ecdh.X25519()
+	case P256:
+		curve = ecdh.P256()
+	case P384:
+		curve = ecdh.P384()
+	case P521:
+		curve = ecdh.P521()
+	default:
+		return nil, fmt.Errorf("unsupported KEM 0x%04x", kemID)
+	}
+	priv, err := curve.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	config, err := NewECHConfig().
+		ConfigID(configID).
+		KEM(kemID).
+		PublicKey(priv.PublicKey().Bytes()).
+		AddCipher(HKDFSHA256, AES128GCM).
+		AddCipher(HKDFSHA256, ChaCha20Poly1305).
+		PublicName(publicName).
+		Build()
+	if err != nil {
+		return nil, err
+	}
+	return &ECHKey{Config: config, PrivateKey: priv}, nil
+}
@@ -0,0 +1,296 @@
+package echclient
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/cryptobyte"
+	"golang.org/x/net/idna"
+)
+
+// Severities of a LintFinding. Errors make the config unusable or break the
+// specification; warnings point at configs that work but leak or limit
+// their users.
+const (
+	LintError   = "error"
+	LintWarning = "warning"
+)
+
+// typicalNameLength is the length under which a nonzero
+// maximum_name_length is reported: clients pad the server name to it, so
+// a shorter value leaves the length of most backend names visible.
+const typicalNameLength = 32
+
+// LintFinding is a problem LintECHConfigList found in an ECHConfig. Index is
+// the position of the config in the list, counting those of other
+// versions, or -1 for the list as a whole.
+type LintFinding struct {
+	Severity string `json:"severity"`
+	Index    int    `json:"index"`
+	ConfigID uint8  `json:"config_id"`
+	Message  string `json:"message"`
+}
+
+func (f LintFinding) String() string {
+	if f.Index < 0 {
+		return fmt.Sprintf("%s: %s", f.Severity, f.Message)
+	}
+	return fmt.Sprintf("%s: config %d (config_id %d): %s", f.Severity, f.Index, f.ConfigID, f.Message)
+}
+
+// LintECHConfigList checks the ECHConfigList data against the ECH
+// specification and the HPKE registry (RFC 9180) and returns what a
+// publisher should fix, in list order. Configs of versions crypto/tls
+// cannot use are reported without further checks. An error is only returned
+// when the list cannot be decoded at all.
+func LintECHConfigList(data []byte) ([]LintFinding, error) {
+	s := cryptobyte.String(data)
+	var list cryptobyte.String
+	if !s.ReadUint16LengthPrefixed(&list) || !s.Empty() {
+		return nil, fmt.Errorf("%w: list length does not match the data", ErrMalformedECHConfig)
+	}
+	var (
+		findings []LintFinding
+		usable   []ECHConfig
+		ids      = map[uint8]int{}
+	)
+	for i := 0; !list.Empty(); i++ {
+		var (
+			version  uint16
+			contents cryptobyte.String
+		)
+		if !list.ReadUint16(&version) || !list.ReadUint16LengthPrefixed(&contents) {
+			return nil, fmt.Errorf("%w: truncated config %d", ErrMalformedECHConfig, i)
+		}
+		if version != extensionEncryptedClientHello {
+			findings = append(findings, LintFinding{LintWarning, i, 0,
+				fmt.Sprintf("version %s is not supported, clients skip this config", versionLabel(version))})
+			continue
+		}
+		// Reparse the config alone to get at its fields.
+		var b cryptobyte.Builder
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddUint16(version)
+			b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(contents) })
+		})
+		configs, err := ParseECHConfigList(b.BytesOrPanic())
+		if err != nil {
+			return nil, fmt.Errorf("config %d: %w", i, err)
+		}
+		ec := configs[0]
+		for _, msg := range lintConfig(&ec) {
+			findings = append(findings, LintFinding{msg.severity, i, ec.ConfigID, msg.text})
+		}
+		if j, ok := ids[ec.ConfigID]; ok {
+			findings = append(findings, LintFinding{LintWarning, i, ec.ConfigID,
+				fmt.Sprintf("config_id also used by config %d, servers cannot tell them apart", j)})
+		}
+		ids[ec.ConfigID] = i
+		usable = append(usable, ec)
+	}
+	if pickECHConfig(usable) == nil {
+		findings = append(findings, LintFinding{LintError, -1, 0, "no config is usable by crypto/tls"})
+	}
+	return findings, nil
+}
+
+// LintPublicNames checks the client-facing servers of the configs of data:
+// that their public_name resolves with the configured resolver and that the
+// server listening on port 443 presents a certificate valid for it. Clients
+// authenticate ECH rejections and their retry configs with that
+// certificate, so without it they cannot recover from stale keys. Configs
+// whose public_name fails the checks of LintECHConfigList are skipped.
+func (c *ProbeConfig) LintPublicNames(ctx context.Context, data []byte) ([]LintFinding, error) {
+	configs, err := ParseECHConfigList(data)
+	if err != nil {
+		return nil, err
+	}
+	var findings []LintFinding
+	checked := map[string]string{}
+	for i := range configs {
+		ec := &configs[i]
+		name := string(ec.PublicName)
+		if ec.Version != extensionEncryptedClientHello || publicNameProblem(name) != "" {
+			continue
+		}
+		problem, ok := checked[name]
+		if !ok {
+			problem = c.checkPublicName(ctx, name)
+			checked[name] = problem
+		}
+		if problem != "" {
+			findings = append(findings, LintFinding{LintError, i, ec.ConfigID, problem})
+		}
+	}
+	return findings, nil
+}
+
+// checkPublicName returns what prevents clients from authenticating the
+// client-facing server of name, or "" if nothing does.
+func (c *ProbeConfig) checkPublicName(ctx context.Context, name string) string {
+	addrs, err := c.LookupAddrs(ctx, name)
+	if err != nil {
+		return fmt.Sprintf("public_name %s does not resolve: %v", name, err)
+	}
+	if timeout := c.timeout(); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	conn, err := c.dialAddrs(addrs)(ctx, "tcp", net.JoinHostPort(name, "443"))
+	if err != nil {
+		return fmt.Sprintf("cannot connect to public_name %s: %v", name, err)
+	}
+	defer conn.Close()
+	config := c.tlsConfig(nil)
+	config.ServerName = name
+	err = tls.Client(conn, config).HandshakeContext(ctx)
+	var certErr *tls.CertificateVerificationError
+	switch {
+	case errors.As(err, &certErr):
+		return fmt.Sprintf("the client-facing server %s has no valid certificate for public_name %s: %v", conn.RemoteAddr(), name, certErr.Err)
+	case err != nil:
+		return fmt.Sprintf("TLS handshake with the client-facing server %s of public_name %s failed: %v", conn.RemoteAddr(), name, err)
+	}
+	return ""
+}
+
+type lintMessage struct {
+	severity, text string
+}
+
+// lintConfig returns the problems of a single ECHConfig.
+func lintConfig(ec *ECHConfig) []lintMessage {
+	var msgs []lintMessage
+	add := func(severity, format string, args ...any) {
+		msgs = append(msgs, lintMessage{severity, fmt.Sprintf(format, args...)})
+	}
+	if _, ok := kemNames[ec.KemID]; !ok {
+		add(LintError, "KEM 0x%04x is not in the HPKE registry", ec.KemID)
+	} else {
+		if !supportedKEMs[ec.KemID] {
+			add(LintWarning, "KEM %s is not supported by crypto/tls and most browsers, which only implement x25519", KEMName(ec.KemID))
+		}
+		if n := kemPublicKeySize[ec.KemID]; len(ec.PublicKey) != n {
+			add(LintError, "public key is %d bytes, %s keys are %d", len(ec.PublicKey), KEMName(ec.KemID), n)
+		}
+	}
+	if len(ec.SymmetricCipherSuite) == 0 {
+		add(LintError, "empty cipher suite list")
+	}
+	supported := false
+	for _, cs := range ec.SymmetricCipherSuite {
+		if _, ok := kdfNames[cs.KDFID]; !ok {
+			add(LintError, "KDF 0x%04x is not in the HPKE registry", cs.KDFID)
+		}
+		if _, ok := aeadNames[cs.AEADID]; !ok {
+			add(LintError, "AEAD 0x%04x is not in the HPKE registry", cs.AEADID)
+		}
+		supported = supported || supportedKDFs[cs.KDFID] && supportedAEADs[cs.AEADID]
+	}
+	if len(ec.SymmetricCipherSuite) > 0 && !supported {
+		add(LintWarning, "no cipher suite is supported by crypto/tls, add hkdf-sha256/aes128gcm")
+	}
+	if problem := publicNameProblem(string(ec.PublicName)); problem != "" {
+		add(LintError, "%s", problem)
+	}
+	if ec.MaxNameLength != 0 && ec.MaxNameLength < typicalNameLength {
+		add(LintWarning, "maximum_name_length %d is shorter than typical backend names, whose length the ClientHello then reveals", ec.MaxNameLength)
+	}
+	for _, ext := range ec.Extensions {
+		// The high bit marks extensions clients must understand to use
+		// the config.
+		if ext.Type&0x8000 != 0 && !supportedECHExtensions[ext.Type] {
+			add(LintError, "mandatory extension 0x%04x cannot be satisfied, clients skip this config", ext.Type)
+		}
+	}
+	if ec.trailing > 0 {
+		add(LintError, "%d trailing bytes after the extensions", ec.trailing)
+	}
+	return msgs
+}
+
+// publicNameProblem returns why name cannot be a public_name, or "" if it
+// can. Clients ignore configs whose public_name is not a sequence of LDH
+// labels, in A-label form for internationalized names, or looks like an
+// IPv4 address, shorthand forms such as 127.1 or 0x7f.1 included (RFC
+// 9849, section 6.1).
+func publicNameProblem(name string) string {
+	if name == "" {
+		return "public_name is empty"
+	}
+	if _, err := netip.ParseAddr(strings.Trim(name, "[]")); err == nil {
+		return fmt.Sprintf("public_name %q is an IP literal, clients must reject it", name)
+	}
+	if strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".") {
+		return fmt.Sprintf("public_name %q begins or ends with a dot, clients must reject it", name)
+	}
+	if strings.ContainsFunc(name, func(r rune) bool { return r >= utf8.RuneSelf }) {
+		if ascii, err := ToASCIIHost(name); err == nil {
+			return fmt.Sprintf("public_name %q uses U-labels, publish its A-label form %q", name, ascii)
+		}
+		return fmt.Sprintf("public_name %q is not a valid internationalized name", name)
+	}
+	if len(name) > 253 {
+		return fmt.Sprintf("public_name is %d bytes long, DNS names are at most 253", len(name))
+	}
+	labels := strings.Split(name, ".")
+	for _, label := range labels {
+		if len(label) > 63 {
+			return fmt.Sprintf("public_name %q has a label of %d bytes, DNS labels are at most 63", name, len(label))
+		}
+		if !isLDHLabel(label) {
+			return fmt.Sprintf("public_name %q has the label %q, which is not made of letters, digits and inner hyphens", name, label)
+		}
+		if strings.HasPrefix(strings.ToLower(label), "xn--") {
+			if _, err := idna.Lookup.ToUnicode(label); err != nil {
+				return fmt.Sprintf("public_name %q has the label %q, which is not a valid A-label: %v", name, label, err)
+			}
+		}
+	}
+	if len(labels) == 1 {
+		return fmt.Sprintf("public_name %q is a single label, which crypto/tls rejects", name)
+	}
+	if last := strings.ToLower(labels[len(labels)-1]); isNumericLabel(last) {
+		return fmt.Sprintf("public_name %q ends with the numeric label %q, clients take it for an IPv4 address", name, last)
+	}
+	if !validDNSName(name) {
+		return fmt.Sprintf("public_name %q is not a valid DNS name", name)
+	}
+	return ""
+}
+
+// isLDHLabel reports whether label is made of ASCII letters, digits and
+// hyphens, neither first nor last.
+func isLDHLabel(label string) bool {
+	if label == "" || label[0] == '-' || label[len(label)-1] == '-' {
+		return false
+	}
+	for i := 0; i < len(label); i++ {
+		c := label[i]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// isNumericLabel reports whether the lowercase label is all digits or 0x
+// followed by hexadecimal digits, the final labels of IPv4 addresses in the
+// forms inet_aton accepts.
+func isNumericLabel(label string) bool {
+	digits := "0123456789"
+	if strings.HasPrefix(label, "0x") {
+		label, digits = label[2:], "0123456789abcdef"
+		if label == "" {
+			return true
+		}
+	}
+	return strings.Trim(label, digits) == ""
+}
@@ -0,0 +1,59 @@
+package echclient
+
+import "time"
+
+// Metrics receives counters and latency observations from lookups and
+// probes. Implementations must be safe for concurrent use.
+type Metrics interface {
+	// DNSLookup records a DNS query attempt, the resolver and transport
+	// (doh, dot, doq, do53 or odoh) it was sent with, whether it failed,
+	// and its latency.
+	DNSLookup(resolver, transport, qtype string, err error, rtt time.Duration)
+
+	// TLSHandshake records the latency of a completed TLS handshake.
+	TLSHandshake(d time.Duration)
+
+	// HTTPRequest records the latency of a probe request.
+	HTTPRequest(d time.Duration)
+
+	// ECHResult records whether the server accepted ECH.
+	ECHResult(accepted bool)
+
+	// Error records a probe failure by its ErrorClass.
+	Error(class string)
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) DNSLookup(string, string, string, error, time.Duration) {}
+func (nopMetrics) TLSHandshake(time.Duration)                             {}
+func (nopMetrics) HTTPRequest(time.Duration)                              {}
+func (nopMetrics) ECHResult(bool)                                         {}
+func (nopMetrics) Error(string)                                           {}
+
+func (c *ProbeConfig) metrics() Metrics {
+	if c == nil || c.Metrics == nil {
+		return nopMetrics{}
+	}
+	return c.Metrics
+}
+
+// recordProbe reports the outcome of a probe to the configured Metrics.
+func (c *ProbeConfig) recordProbe(r *ProbeResult) {
+	m := c.metrics()
+	if r.ErrorClass != "" {
+		m.Error(r.ErrorClass)
+	}
+	if r.TLSVersion != "" {
+		m.TLSHandshake(r.Timings.TLSHandshake)
+		m.ECHResult(r.ECHAccepted)
+	} else if r.ErrorClass == ErrorClassECHRejected {
+		m.ECHResult(false)
+	}
+	if r.Timings.HTTP != 0 {
+		m.HTTPRequest(r.Timings.HTTP)
+	}
+	if r.Retry != nil {
+		c.recordProbe(r.Retry)
+	}
+}
@@ -0,0 +1,159 @@
+package echclient
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+)
+
+// MonitorEventKind tells what a MonitorEvent reports.
+type MonitorEventKind string
+
+const (
+	// MonitorStarted reports the state found by the first probe.
+	MonitorStarted MonitorEventKind = "started"
+	// MonitorChanged reports a change of the published ECHConfigList.
+	MonitorChanged MonitorEventKind = "changed"
+	// MonitorDisappeared reports that no ECHConfigList is published any
+	// more, and MonitorAppeared that one is again.
+	MonitorDisappeared MonitorEventKind = "disappeared"
+	MonitorAppeared    MonitorEventKind = "appeared"
+	// MonitorRejected reports that the server stopped accepting ECH, and
+	// MonitorAccepted that it accepts it again.
+	MonitorRejected MonitorEventKind = "rejected"
+	MonitorAccepted MonitorEventKind = "accepted"
+)
+
+// MonitorEvent is a change Monitor observed between two probes.
+type MonitorEvent struct {
+	Time time.Time        `json:"time"`
+	URL  string           `json:"url"`
+	Kind MonitorEventKind `json:"kind"`
+
+	// Changes describes what changed in the ECHConfigList, as returned by
+	// DiffECHConfigs.
+	Changes []string `json:"changes,omitempty"`
+
+	// ConfigIDs lists the config_ids of the published ECHConfigList and
+	// ECHAccepted whether the server accepted ECH.
+	ConfigIDs   []int `json:"config_ids"`
+	ECHAccepted bool  `json:"ech_accepted"`
+
+	// Error is the error of the probe, if any.
+	Error string `json:"error,omitempty"`
+
+	// Result is the probe that triggered the event. It is not encoded, to
+	// keep event streams short.
+	Result *ProbeResult `json:"-"`
+}
+
+// DiffECHConfigs returns the differences between the configs of previous
+// and current, matched by config_id: configs added or removed and changes of
+// public key or public_name.
+func DiffECHConfigs(previous, current ECHConfigList) []string {
+	var changes []string
+	find := func(l ECHConfigList, ec *ECHConfig) *ECHConfig {
+		for i := range l {
+			if l[i].Version == ec.Version && l[i].ConfigID == ec.ConfigID {
+				return &l[i]
+			}
+		}
+		return nil
+	}
+	for i := range previous {
+		if find(current, &previous[i]) == nil {
+			changes = append(changes, fmt.Sprintf("config_id %d removed", previous[i].ConfigID))
+		}
+	}
+	for i := range current {
+		ec := &current[i]
+		old := find(previous, ec)
+		switch {
+		case old == nil:
+			changes = append(changes, fmt.Sprintf("config_id %d added with public_name %s", ec.ConfigID, ec.PublicName))
+		case !bytes.Equal(old.PublicKey, ec.PublicKey):
+			changes = append(changes, fmt.Sprintf("config_id %d: public key changed", ec.ConfigID))
+		}
+		if old != nil && !bytes.Equal(old.PublicName, ec.PublicName) {
+			changes = append(changes, fmt.Sprintf("config_id %d: public_name changed from %s to %s", ec.ConfigID, old.PublicName, ec.PublicName))
+		}
+	}
+	return changes
+}
+
+// monitorState is what Monitor compares between probes.
+type monitorState struct {
+	raw      []byte
+	configs  ECHConfigList
+	accepted bool
+}
+
+// Monitor probes targetURL every interval until ctx is done and calls
+// onEvent with the state found by the first probe and then with every
+// change: the ECHConfigList changing, disappearing or reappearing, and the
+// server starting or stopping to accept ECH. HTTPS answers come from the
+// configured cache while they are fresh, as they would for a browser. It
+// returns the error of ctx.
+func (c *ProbeConfig) Monitor(ctx context.Context, targetURL string, interval time.Duration, onEvent func(MonitorEvent)) error {
+	var previous *monitorState
+	for {
+		r, err := c.ProbeURL(ctx, targetURL)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		current := &monitorState{raw: r.ECHConfigList, accepted: r.Final().ECHAccepted}
+		current.configs, _ = ParseECHConfigList(r.ECHConfigList)
+		event := MonitorEvent{Time: time.Now(), URL: targetURL, ConfigIDs: []int{}, ECHAccepted: current.accepted, Result: r}
+		for _, ec := range current.configs {
+			event.ConfigIDs = append(event.ConfigIDs, int(ec.ConfigID))
+		}
+		if err != nil {
+			event.Error = err.Error()
+		} else if !current.accepted && len(current.raw) > 0 {
+			event.Error = "the server did not accept ECH"
+		}
+		for _, kind := range monitorEvents(previous, current, &event) {
+			event.Kind = kind
+			onEvent(event)
+		}
+		previous = current
+		if err := sleep(ctx, interval); err != nil {
+			return err
+		}
+	}
+}
+
+// monitorEvents returns the kinds of the events the change from previous to
+// current raises, setting the changes of the ECHConfigList in event.
+func monitorEvents(previous, current *monitorState, event *MonitorEvent) []MonitorEventKind {
+	if previous == nil {
+		return []MonitorEventKind{MonitorStarted}
+	}
+	var kinds []MonitorEventKind
+	switch {
+	case len(previous.raw) > 0 && len(current.raw) == 0:
+		kinds = append(kinds, MonitorDisappeared)
+	case len(previous.raw) == 0 && len(current.raw) > 0:
+		kinds = append(kinds, MonitorAppeared)
+	case !bytes.Equal(previous.raw, current.raw):
+		event.Changes = DiffECHConfigs(previous.configs, current.configs)
+		if len(event.Changes) == 0 {
+			// Changes of cipher suites, extensions or order.
+			event.Changes = []string{"ECHConfigList changed"}
+		}
+		kinds = append(kinds, MonitorChanged)
+	}
+	// Acceptance only matters while a config is published both times;
+	// the other cases are covered by MonitorDisappeared and
+	// MonitorAppeared.
+	if len(previous.raw) > 0 && len(current.raw) > 0 {
+		switch {
+		case previous.accepted && !current.accepted:
+			kinds = append(kinds, MonitorRejected)
+		case !previous.accepted && current.accepted:
+			kinds = append(kinds, MonitorAccepted)
+		}
+	}
+	return kinds
+}
@@ -0,0 +1,298 @@
+package echclient
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base32"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// nsec3HashSHA1 is the only NSEC3 hash algorithm (RFC 5155 section 11).
+const nsec3HashSHA1 = 1
+
+// nsec3FlagOptOut marks an NSEC3 record whose span may cover unsigned
+// delegations.
+const nsec3FlagOptOut = 0x01
+
+// maxNSEC3Iterations bounds the hashing work an NSEC3 record can demand,
+// the limit of RFC 5155 section 10.3 for the largest keys.
+const maxNSEC3Iterations = 2500
+
+// nsec3Base32 is the base32hex encoding of the hashed owner names.
+var nsec3Base32 = base32.HexEncoding.WithPadding(base32.NoPadding)
+
+type nsec3Record struct {
+	// zone is the zone the hashed owner name belongs to.
+	zone string
+
+	hash       []byte
+	algorithm  uint8
+	flags      uint8
+	iterations uint16
+	salt       []byte
+	next       []byte
+	types      []byte
+}
+
+// denyDS returns DNSSECInsecure if the authority section of resp, an
+// answer without DS records for zone, authenticates their absence with an
+// NSEC or NSEC3 record showing an unsigned delegation (RFC 4035 section
+// 5.2, RFC 5155 section 8.6), or if the parent zone is itself unsigned.
+// Otherwise the missing DS records may have been stripped, and the zone is
+// bogus.
+func (v *dnssecValidator) denyDS(zone string, resp *DNSResponse) (DNSSECStatus, error) {
+	zone = canonicalName(zone)
+	err := fmt.Errorf("%w: no authenticated denial of the DS records of %s", ErrDNSSECBogus, zone)
+	var nsec3 []nsec3Record
+	found := false
+	for _, typ := range []RRType{TypeNSEC, TypeNSEC3} {
+		for _, owner := range rrOwners(resp.Authority, typ) {
+			rdatas, sigs, e := rrset(resp.Authority, owner, typ)
+			if e != nil {
+				return DNSSECBogus, e
+			}
+			if len(sigs) == 0 {
+				continue
+			}
+			// Only the parent zone can deny the DS records of zone.
+			for _, sig := range sigs {
+				if canonicalName(sig.SignerName) == zone || !isSubdomain(zone, sig.SignerName) {
+					return DNSSECBogus, fmt.Errorf("%w: %s of %s is signed by %s, not by a parent of %s",
+						ErrDNSSECBogus, typ, owner, sig.SignerName, zone)
+				}
+			}
+			found = true
+			status, e := v.verifyRRset(owner, typ, rdatas, sigs)
+			if status != DNSSECSecure {
+				return status, e
+			}
+			for _, rdata := range rdatas {
+				if typ == TypeNSEC {
+					if canonicalName(owner) != zone {
+						continue
+					}
+					types, ok := nsecTypes(rdata)
+					if !ok {
+						return DNSSECBogus, fmt.Errorf("%w: malformed NSEC of %s", ErrDNSSECBogus, owner)
+					}
+					if unsignedDelegation(types) {
+						return DNSSECInsecure, nil
+					}
+					err = fmt.Errorf("%w: the NSEC of %s does not show an unsigned delegation", ErrDNSSECBogus, zone)
+					continue
+				}
+				r, ok := parseNSEC3(owner, rdata)
+				if !ok {
+					return DNSSECBogus, fmt.Errorf("%w: malformed NSEC3 of %s", ErrDNSSECBogus, owner)
+				}
+				nsec3 = append(nsec3, r)
+			}
+		}
+	}
+	if len(nsec3) > 0 {
+		if nsec3DeniesDS(zone, nsec3) {
+			return DNSSECInsecure, nil
+		}
+		err = fmt.Errorf("%w: the NSEC3 records do not show an unsigned delegation of %s", ErrDNSSECBogus, zone)
+	}
+	if found {
+		return DNSSECBogus, err
+	}
+	parent, e := v.c.enclosingZone(v.ctx, parentName(zone))
+	if e != nil {
+		return DNSSECBogus, e
+	}
+	switch keys := v.zoneKeys(parent); keys.status {
+	case DNSSECInsecure:
+		return DNSSECInsecure, nil
+	case DNSSECSecure:
+		return DNSSECBogus, err
+	default:
+		return DNSSECBogus, keys.err
+	}
+}
+
+// nsec3DeniesDS reports whether records prove that zone is an unsigned
+// delegation: with a record matching it whose bitmap has NS but neither DS
+// nor SOA, or with the closest encloser proof of RFC 5155 section 7.2.1
+// whose record covering the next closer name has the opt-out flag.
+func nsec3DeniesDS(zone string, records []nsec3Record) bool {
+	if r, ok := matchingNSEC3(zone, records); ok {
+		return unsignedDelegation(r.types)
+	}
+	for next, ce := zone, parentName(zone); ; next, ce = ce, parentName(ce) {
+		if _, ok := matchingNSEC3(ce, records); ok {
+			r, ok := coveringNSEC3(next, records)
+			return ok && r.flags&nsec3FlagOptOut != 0
+		}
+		if ce == "." {
+			return false
+		}
+	}
+}
+
+// matchingNSEC3 returns the record of records whose owner is the hash of
+// name.
+func matchingNSEC3(name string, records []nsec3Record) (nsec3Record, bool) {
+	for _, r := range records {
+		if !isSubdomain(name, r.zone) {
+			continue
+		}
+		if h, ok := r.hashName(name); ok && bytes.Equal(h, r.hash) {
+			return r, true
+		}
+	}
+	return nsec3Record{}, false
+}
+
+// coveringNSEC3 returns the record of records whose span covers the hash of
+// name.
+func coveringNSEC3(name string, records []nsec3Record) (nsec3Record, bool) {
+	for _, r := range records {
+		if !isSubdomain(name, r.zone) {
+			continue
+		}
+		h, ok := r.hashName(name)
+		if !ok {
+			continue
+		}
+		afterOwner, beforeNext := bytes.Compare(h, r.hash) > 0, bytes.Compare(h, r.next) < 0
+		// The last record of the zone wraps around to the first.
+		if bytes.Compare(r.hash, r.next) < 0 && afterOwner && beforeNext ||
+			bytes.Compare(r.hash, r.next) >= 0 && (afterOwner || beforeNext) {
+			return r, true
+		}
+	}
+	return nsec3Record{}, false
+}
+
+// hashName returns the NSEC3 hash of name with the parameters of r.
+func (r *nsec3Record) hashName(name string) ([]byte, bool) {
+	if r.algorithm != nsec3HashSHA1 || r.iterations > maxNSEC3Iterations {
+		return nil, false
+	}
+	var b cryptobyte.Builder
+	addWireName(&b, name)
+	data, err := b.Bytes()
+	if err != nil {
+		return nil, false
+	}
+	h := sha1.Sum(append(data, r.salt...))
+	for range r.iterations {
+		h = sha1.Sum(append(h[:], r.salt...))
+	}
+	return h[:], true
+}
+
+// parseNSEC3 decodes the NSEC3 RDATA of the record owned by owner.
+func parseNSEC3(owner string, rdata []byte) (nsec3Record, bool) {
+	label, zone, _ := strings.Cut(canonicalName(owner), ".")
+	hash, err := nsec3Base32.DecodeString(strings.ToUpper(label))
+	if err != nil || zone == "" {
+		return nsec3Record{}, false
+	}
+	r := nsec3Record{zone: canonicalName(zone), hash: hash}
+	s := cryptobyte.String(rdata)
+	var salt, next cryptobyte.String
+	if !s.ReadUint8(&r.algorithm) || !s.ReadUint8(&r.flags) || !s.ReadUint16(&r.iterations) ||
+		!s.ReadUint8LengthPrefixed(&salt) || !s.ReadUint8LengthPrefixed(&next) || len(next) != len(hash) {
+		return nsec3Record{}, false
+	}
+	r.salt, r.next, r.types = salt, next, s
+	return r, validTypeBitmap(r.types)
+}
+
+// nsecTypes returns the type bitmap of an NSEC RDATA.
+func nsecTypes(rdata []byte) ([]byte, bool) {
+	s := cryptobyte.String(rdata)
+	var next string
+	if !readWireName(&s, &next) || !validTypeBitmap(s) {
+		return nil, false
+	}
+	return s, true
+}
+
+// unsignedDelegation reports whether an NSEC or NSEC3 type bitmap shows a
+// delegation without DS records: NS present, and neither DS nor the SOA of
+// a zone apex.
+func unsignedDelegation(types []byte) bool {
+	return hasType(types, TypeNS) && !hasType(types, TypeDS) && !hasType(types, TypeSOA)
+}
+
+// validTypeBitmap reports whether b is a well-formed type bitmap (RFC 4034
+// section 4.1.2).
+func validTypeBitmap(b []byte) bool {
+	for len(b) > 0 {
+		if len(b) < 2 || b[1] == 0 || b[1] > 32 || len(b) < 2+int(b[1]) {
+			return false
+		}
+		b = b[2+int(b[1]):]
+	}
+	return true
+}
+
+// hasType reports whether the type bitmap b lists t.
+func hasType(b []byte, t RRType) bool {
+	window, bit := byte(t>>8), int(t&0xff)
+	for len(b) >= 2 && len(b) >= 2+int(b[1]) {
+		n := int(b[1])
+		if b[0] == window {
+			return bit/8 < n && b[2+bit/8]&(0x80>>(bit%8)) != 0
+		}
+		b = b[2+n:]
+	}
+	return false
+}
+
+// addTypeBitmap appends the type bitmap listing the types named in the
+// presentation format fields.
+func addTypeBitmap(b *cryptobyte.Builder, fields []string) error {
+	var windows [256][32]byte
+	for _, f := range fields {
+		t, err := ParseRRType(f)
+		if err != nil {
+			return err
+		}
+		windows[t>>8][(t&0xff)/8] |= 0x80 >> (t % 8)
+	}
+	for i, w := range windows {
+		n := len(w)
+		for n > 0 && w[n-1] == 0 {
+			n--
+		}
+		if n == 0 {
+			continue
+		}
+		b.AddUint8(uint8(i))
+		b.AddUint8(uint8(n))
+		b.AddBytes(w[:n])
+	}
+	return nil
+}
+
+// rrOwners returns the distinct owner names of the qtype records of
+// section.
+func rrOwners(section []DNSAnswer, qtype RRType) []string {
+	var owners []string
+	seen := map[string]bool{}
+	for _, rr := range section {
+		if name := canonicalName(rr.Name); RRType(rr.Type) == qtype && !seen[name] {
+			seen[name] = true
+			owners = append(owners, name)
+		}
+	}
+	return owners
+}
+
+// parentName returns name without its first label, "." for a top-level
+// name and the root.
+func parentName(name string) string {
+	_, parent, _ := strings.Cut(canonicalName(name), ".")
+	if parent == "" {
+		return "."
+	}
+	return parent
+}
@@ -0,0 +1,393 @@
+package echclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/cloudflare/circl/hpke"
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// ODoHConfigPath is the well-known path serving the ObliviousDoHConfigs of
+// an ODoH target.
+const ODoHConfigPath = "/.well-known/odohconfigs"
+
+const (
+	odohVersion         = 0x0001
+	odohMessageQuery    = 0x01
+	odohMessageResponse = 0x02
+
+	// odohPaddingBlock is the block size queries are padded to, as
+	// recommended by RFC 8467.
+	odohPaddingBlock = 128
+)
+
+// ODoHConfig is an ObliviousDoHConfigContents of RFC 9230 section 6.
+type ODoHConfig struct {
+	KemID     uint16
+	KDFID     uint16
+	AEADID    uint16
+	PublicKey []byte
+
+	raw []byte
+}
+
+// ParseODoHConfigs decodes an ObliviousDoHConfigs structure, skipping the
+// configs of unknown versions or with unsupported algorithms.
+func ParseODoHConfigs(data []byte) ([]ODoHConfig, error) {
+	s := cryptobyte.String(data)
+	var list cryptobyte.String
+	if !s.ReadUint16LengthPrefixed(&list) || !s.Empty() {
+		return nil, fmt.Errorf("%w: malformed ObliviousDoHConfigs", ErrNoODoHConfig)
+	}
+	var configs []ODoHConfig
+	for !list.Empty() {
+		var version uint16
+		var contents cryptobyte.String
+		if !list.ReadUint16(&version) || !list.ReadUint16LengthPrefixed(&contents) {
+			return nil, fmt.Errorf("%w: malformed ObliviousDoHConfig", ErrNoODoHConfig)
+		}
+		if version != odohVersion {
+			continue
+		}
+		c := ODoHConfig{raw: contents}
+		var pk cryptobyte.String
+		if !contents.ReadUint16(&c.KemID) || !contents.ReadUint16(&c.KDFID) ||
+			!contents.ReadUint16(&c.AEADID) || !contents.ReadUint16LengthPrefixed(&pk) || !contents.Empty() {
+			return nil, fmt.Errorf("%w: malformed ObliviousDoHConfigContents", ErrNoODoHConfig)
+		}
+		c.PublicKey = pk
+		if !hpke.KEM(c.KemID).IsValid() || !hpke.KDF(c.KDFID).IsValid() || !hpke.AEAD(c.AEADID).IsValid() {
+			continue
+		}
+		configs = append(configs, c)
+	}
+	if len(configs) == 0 {
+		return nil, ErrNoODoHConfig
+	}
+	return configs, nil
+}
+
+// KeyID returns the key identifier of c, derived from its encoding.
+func (c *ODoHConfig) KeyID() []byte {
+	kdf := hpke.KDF(c.KDFID)
+	return kdf.Expand(kdf.Extract(c.raw, nil), []byte("odoh key id"), uint(kdf.ExtractSize()))
+}
+
+// ODoHResolver is an Oblivious DNS-over-HTTPS (RFC 9230) Resolver: queries
+// are encrypted to the target and relayed by the proxy, so that neither
+// learns both the client address and the query.
+type ODoHResolver struct {
+	// TargetURL is the DoH endpoint of the target, e.g.
+	// https://odoh.cloudflare-dns.com/dns-query.
+	TargetURL string
+
+	// ProxyURL is the oblivious proxy endpoint. If empty, the encrypted
+	// queries are sent to the target directly.
+	ProxyURL string
+
+	// Configs is the ObliviousDoHConfigs of the target, as served at
+	// ODoHConfigPath. If nil, Config fetches them from the target
+	// directly: oblivious proxies only relay ODoH messages, so that one
+	// request reveals the client address to the target, though not the
+	// queries. Setting Configs, fetched beforehand over another path,
+	// avoids it.
+	Configs []byte
+
+	// Client sends the requests. If nil, http.DefaultClient is used.
+	Client *http.Client
+
+	// Logger receives the raw responses at debug level. If nil, nothing
+	// is logged.
+	Logger *slog.Logger
+
+	// DNSSEC requests the RRSIGs needed for local validation.
+	DNSSEC bool
+
+	mu     sync.Mutex
+	config *ODoHConfig
+}
+
+// NewODoHResolver returns an ODoHResolver for the given target and proxy,
+// using the timeout and proxy of c. A target without a scheme is taken as
+// a host serving /dns-query.
+func (c *ProbeConfig) NewODoHResolver(targetURL, proxyURL string) (*ODoHResolver, error) {
+	if !strings.Contains(targetURL, "://") {
+		targetURL = "https://" + targetURL + "/dns-query"
+	}
+	if _, err := url.Parse(targetURL); err != nil {
+		return nil, err
+	}
+	if _, err := url.Parse(proxyURL); err != nil {
+		return nil, err
+	}
+	return &ODoHResolver{
+		TargetURL: targetURL,
+		ProxyURL:  proxyURL,
+		Client: &http.Client{
+			Timeout: c.dnsTimeout(),
+			Transport: &http.Transport{
+				Proxy: c.proxy(),
+			},
+		},
+		Logger: c.logger(),
+		DNSSEC: c.validateDNSSEC(),
+	}, nil
+}
+
+func (r *ODoHResolver) String() string {
+	if r.ProxyURL == "" {
+		return "odoh(" + r.TargetURL + ")"
+	}
+	return "odoh(" + r.TargetURL + " via " + r.ProxyURL + ")"
+}
+
+func (r *ODoHResolver) client() *http.Client {
+	if r.Client == nil {
+		return http.DefaultClient
+	}
+	return r.Client
+}
+
+// Config returns the ODoHConfig used to encrypt queries, decoded from
+// Configs or, if nil, fetched from the well-known URI of the target on first
+// use.
+func (r *ODoHResolver) Config(ctx context.Context) (*ODoHConfig, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.config != nil {
+		return r.config, nil
+	}
+	data := r.Configs
+	if data == nil {
+		var err error
+		if data, err = r.fetchConfigs(ctx); err != nil {
+			return nil, err
+		}
+	}
+	configs, err := ParseODoHConfigs(data)
+	if err != nil {
+		return nil, err
+	}
+	r.config = &configs[0]
+	return r.config, nil
+}
+
+// fetchConfigs fetches the ObliviousDoHConfigs from the well-known URI of
+// the target, bypassing the oblivious proxy.
+func (r *ODoHResolver) fetchConfigs(ctx context.Context) ([]byte, error) {
+	target, err := url.Parse(r.TargetURL)
+	if err != nil {
+		return nil, err
+	}
+	if r.ProxyURL != "" && r.Logger != nil {
+		r.Logger.Warn("fetching the ODoH config from the target directly, which reveals the client address to it",
+			"target", target.Host)
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://"+target.Host+ODoHConfigPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.client().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch ODoH config: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: HTTP status %d", ErrNoODoHConfig, resp.StatusCode)
+	}
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(resp.Body); err != nil {
+		return nil, fmt.Errorf("failed to fetch ODoH config: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Query implements Resolver.
+func (r *ODoHResolver) Query(ctx context.Context, name string, qtype RRType) (*DNSResponse, error) {
+	config, err := r.Config(ctx)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := buildQuery(0, name, qtype, r.DNSSEC)
+	if err != nil {
+		return nil, err
+	}
+	plain := odohPlaintext(msg)
+	body, sealer, err := odohSealQuery(config, plain)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := r.newRequest(ctx, body)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.client().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("ODoH query for %s failed: %w", name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: HTTP status %d", ErrDoHResponse, resp.StatusCode)
+	}
+	var data bytes.Buffer
+	if _, err := data.ReadFrom(resp.Body); err != nil {
+		return nil, fmt.Errorf("failed to read ODoH response: %w", err)
+	}
+	if r.Logger != nil {
+		r.Logger.Debug("ODoH response", "name", name, "target", r.TargetURL, "proxy", r.ProxyURL, "length", data.Len())
+	}
+
+	answer, err := odohOpenResponse(config, sealer, plain, data.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return parseReply(answer, 0, name)
+}
+
+// newRequest returns the POST carrying body to the proxy, or to the target
+// if no proxy is configured.
+func (r *ODoHResolver) newRequest(ctx context.Context, body []byte) (*http.Request, error) {
+	endpoint := r.TargetURL
+	if r.ProxyURL != "" {
+		target, err := url.Parse(r.TargetURL)
+		if err != nil {
+			return nil, err
+		}
+		proxy, err := url.Parse(r.ProxyURL)
+		if err != nil {
+			return nil, err
+		}
+		query := proxy.Query()
+		query.Set("targethost", target.Host)
+		query.Set("targetpath", target.Path)
+		proxy.RawQuery = query.Encode()
+		endpoint = proxy.String()
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/oblivious-dns-message")
+	req.Header.Set("Accept", "application/oblivious-dns-message")
+	return req, nil
+}
+
+// odohSealQuery encrypts the ObliviousDoHMessagePlaintext plain to config,
+// returning the ObliviousDoHMessage and the HPKE context the response is
+// decrypted with.
+func odohSealQuery(config *ODoHConfig, plain []byte) ([]byte, hpke.Sealer, error) {
+	suite := hpke.NewSuite(hpke.KEM(config.KemID), hpke.KDF(config.KDFID), hpke.AEAD(config.AEADID))
+	pk, err := hpke.KEM(config.KemID).Scheme().UnmarshalBinaryPublicKey(config.PublicKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrNoODoHConfig, err)
+	}
+	sender, err := suite.NewSender(pk, []byte("odoh query"))
+	if err != nil {
+		return nil, nil, err
+	}
+	enc, sealer, err := sender.Setup(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyID := config.KeyID()
+	ct, err := sealer.Seal(plain, odohAAD(odohMessageQuery, keyID))
+	if err != nil {
+		return nil, nil, err
+	}
+	body, err := odohMessage(odohMessageQuery, keyID, append(enc, ct...))
+	if err != nil {
+		return nil, nil, err
+	}
+	return body, sealer, nil
+}
+
+// odohOpenResponse decrypts an ObliviousDoHMessage answering the query
+// plain, returning the DNS message it carries.
+func odohOpenResponse(config *ODoHConfig, sealer hpke.Sealer, plain, data []byte) ([]byte, error) {
+	s := cryptobyte.String(data)
+	var msgType uint8
+	var nonce, ct cryptobyte.String
+	if !s.ReadUint8(&msgType) || !s.ReadUint16LengthPrefixed(&nonce) ||
+		!s.ReadUint16LengthPrefixed(&ct) || !s.Empty() || msgType != odohMessageResponse {
+		return nil, fmt.Errorf("%w: malformed ODoH response", ErrDNSResponse)
+	}
+
+	aead := hpke.AEAD(config.AEADID)
+	kdf := hpke.KDF(config.KDFID)
+	// RFC 9230 section 6.4: the nonce is as long as the longest of the
+	// AEAD key and nonce.
+	if uint(len(nonce)) != max(aead.KeySize(), aead.NonceSize()) {
+		return nil, fmt.Errorf("%w: ODoH response nonce of %d bytes", ErrDNSResponse, len(nonce))
+	}
+	secret := sealer.Export([]byte("odoh response"), aead.KeySize())
+	var salt cryptobyte.Builder
+	salt.AddBytes(plain)
+	salt.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(nonce)
+	})
+	prk := kdf.Extract(secret, salt.BytesOrPanic())
+	key := kdf.Expand(prk, []byte("odoh key"), aead.KeySize())
+	iv := kdf.Expand(prk, []byte("odoh nonce"), aead.NonceSize())
+	cipher, err := aead.New(key)
+	if err != nil {
+		return nil, err
+	}
+	respPlain, err := cipher.Open(nil, iv, ct, odohAAD(odohMessageResponse, nonce))
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to decrypt ODoH response: %v", ErrDNSResponse, err)
+	}
+	p := cryptobyte.String(respPlain)
+	var answer, padding cryptobyte.String
+	if !p.ReadUint16LengthPrefixed(&answer) || !p.ReadUint16LengthPrefixed(&padding) || !p.Empty() {
+		return nil, fmt.Errorf("%w: malformed ODoH plaintext", ErrDNSResponse)
+	}
+	if slices.ContainsFunc(padding, func(b byte) bool { return b != 0 }) {
+		return nil, fmt.Errorf("%w: nonzero ODoH padding", ErrDNSResponse)
+	}
+	return answer, nil
+}
+
+// odohPlaintext returns the ObliviousDoHMessagePlaintext carrying msg,
+// padded to a multiple of odohPaddingBlock.
+func odohPlaintext(msg []byte) []byte {
+	padding := (odohPaddingBlock - (len(msg)+4)%odohPaddingBlock) % odohPaddingBlock
+	var b cryptobyte.Builder
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(msg)
+	})
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(make([]byte, padding))
+	})
+	return b.BytesOrPanic()
+}
+
+func odohAAD(msgType uint8, keyID []byte) []byte {
+	var b cryptobyte.Builder
+	b.AddUint8(msgType)
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(keyID)
+	})
+	return b.BytesOrPanic()
+}
+
+func odohMessage(msgType uint8, keyID, encrypted []byte) ([]byte, error) {
+	var b cryptobyte.Builder
+	b.AddUint8(msgType)
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(keyID)
+	})
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(encrypted)
+	})
+	return b.Bytes()
+}
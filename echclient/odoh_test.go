@@ -0,0 +1,200 @@
+package echclient
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/cloudflare/circl/hpke"
+	"github.com/cloudflare/circl/kem"
+	"golang.org/x/crypto/cryptobyte"
+)
+
+const (
+	testODoHKEM  = hpke.KEM_X25519_HKDF_SHA256
+	testODoHKDF  = hpke.KDF_HKDF_SHA256
+	testODoHAEAD = hpke.AEAD_AES128GCM
+)
+
+// testODoHConfig returns an ODoHConfig for a fresh key pair, decoded from
+// the ObliviousDoHConfigs a target would publish, and its private key.
+func testODoHConfig(t *testing.T) (*ODoHConfig, kem.PrivateKey) {
+	t.Helper()
+	pk, sk, err := testODoHKEM.Scheme().GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkBytes, err := pk.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b cryptobyte.Builder
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddUint16(odohVersion)
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddUint16(uint16(testODoHKEM))
+			b.AddUint16(uint16(testODoHKDF))
+			b.AddUint16(uint16(testODoHAEAD))
+			b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+				b.AddBytes(pkBytes)
+			})
+		})
+	})
+	configs, err := ParseODoHConfigs(b.BytesOrPanic())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &configs[0], sk
+}
+
+// odohTarget decrypts the ObliviousDoHMessage query as a target would,
+// returning the plaintext and the HPKE context of the response.
+func odohTarget(t *testing.T, config *ODoHConfig, sk kem.PrivateKey, query []byte) ([]byte, hpke.Opener) {
+	t.Helper()
+	s := cryptobyte.String(query)
+	var msgType uint8
+	var keyID, encrypted cryptobyte.String
+	if !s.ReadUint8(&msgType) || !s.ReadUint16LengthPrefixed(&keyID) || !s.ReadUint16LengthPrefixed(&encrypted) ||
+		!s.Empty() || msgType != odohMessageQuery {
+		t.Fatal("malformed ODoH query")
+	}
+	if !bytes.Equal(keyID, config.KeyID()) {
+		t.Fatalf("query key ID = %x, want %x", []byte(keyID), config.KeyID())
+	}
+	encSize := testODoHKEM.Scheme().CiphertextSize()
+	receiver, err := hpke.NewSuite(testODoHKEM, testODoHKDF, testODoHAEAD).NewReceiver(sk, []byte("odoh query"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opener, err := receiver.Setup(encrypted[:encSize])
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := opener.Open(encrypted[encSize:], odohAAD(odohMessageQuery, keyID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return plain, opener
+}
+
+// odohTargetResponse encrypts the ObliviousDoHMessagePlaintext respPlain
+// answering plain with the given nonce, following RFC 9230 section 6.4.
+func odohTargetResponse(t *testing.T, opener hpke.Opener, plain, nonce, respPlain []byte) []byte {
+	t.Helper()
+	secret := opener.Export([]byte("odoh response"), 16)
+	salt := append(append(bytes.Clone(plain), byte(len(nonce)>>8), byte(len(nonce))), nonce...)
+	prk, err := hkdf.Extract(sha256.New, secret, salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := hkdf.Expand(sha256.New, prk, "odoh key", 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iv, err := hkdf.Expand(sha256.New, prk, "odoh nonce", 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := odohMessage(odohMessageResponse, nonce, gcm.Seal(nil, iv, respPlain, odohAAD(odohMessageResponse, nonce)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestODoHKeyID(t *testing.T) {
+	config, _ := testODoHConfig(t)
+	prk, err := hkdf.Extract(sha256.New, config.raw, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := hkdf.Expand(sha256.New, prk, "odoh key id", sha256.Size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := config.KeyID(); !bytes.Equal(got, want) {
+		t.Errorf("KeyID() = %x, want %x", got, want)
+	}
+}
+
+func TestODoHPlaintext(t *testing.T) {
+	for _, n := range []int{0, 1, 123, 124, 125, 300} {
+		msg := bytes.Repeat([]byte{0xaa}, n)
+		plain := odohPlaintext(msg)
+		if len(plain)%odohPaddingBlock != 0 {
+			t.Errorf("odohPlaintext(%d bytes) = %d bytes, want a multiple of %d", n, len(plain), odohPaddingBlock)
+		}
+		s := cryptobyte.String(plain)
+		var got, padding cryptobyte.String
+		if !s.ReadUint16LengthPrefixed(&got) || !s.ReadUint16LengthPrefixed(&padding) || !s.Empty() {
+			t.Errorf("odohPlaintext(%d bytes) is malformed", n)
+			continue
+		}
+		if !bytes.Equal(got, msg) || slices.ContainsFunc(padding, func(b byte) bool { return b != 0 }) {
+			t.Errorf("odohPlaintext(%d bytes) = %x, want the message and zero padding", n, plain)
+		}
+	}
+}
+
+func TestODoHRoundTrip(t *testing.T) {
+	config, sk := testODoHConfig(t)
+	msg, err := buildQuery(0, "example.com", TypeHTTPS, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := odohPlaintext(msg)
+	query, sealer, err := odohSealQuery(config, plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, opener := odohTarget(t, config, sk, query)
+	if !bytes.Equal(got, plain) {
+		t.Fatalf("target decrypted %x, want %x", got, plain)
+	}
+
+	answer := []byte("answer")
+	var padded cryptobyte.Builder
+	padded.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(answer)
+	})
+	padded.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes([]byte{0, 1, 0})
+	})
+	// The response nonce is max(Nn, Nk) = 16 bytes for AES-128-GCM.
+	nonce := bytes.Repeat([]byte{0x42}, 16)
+	tests := []struct {
+		name    string
+		resp    []byte
+		wantErr bool
+	}{
+		{"valid", odohTargetResponse(t, opener, plain, nonce, odohPlaintext(answer)), false},
+		{"short nonce", odohTargetResponse(t, opener, plain, nonce[:12], odohPlaintext(answer)), true},
+		{"nonzero padding", odohTargetResponse(t, opener, plain, nonce, padded.BytesOrPanic()), true},
+		{"other query", odohTargetResponse(t, opener, odohPlaintext(answer), nonce, odohPlaintext(answer)), true},
+	}
+	for _, tt := range tests {
+		got, err := odohOpenResponse(config, sealer, plain, tt.resp)
+		if tt.wantErr {
+			if !errors.Is(err, ErrDNSResponse) {
+				t.Errorf("%s: odohOpenResponse() = %x, %v, want ErrDNSResponse", tt.name, got, err)
+			}
+			continue
+		}
+		if err != nil || !bytes.Equal(got, answer) {
+			t.Errorf("%s: odohOpenResponse() = %q, %v, want %q", tt.name, got, err, answer)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package echclient
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// defaultPorts are the ports used by URLs of these schemes without one.
+var defaultPorts = map[string]string{
+	"https": "443",
+	"http":  "80",
+	"wss":   "443",
+	"ws":    "80",
+}
+
+// HTTPSQueryName returns the name at which the HTTPS RR describing the
+// origin of u is published. It is the host name for https and http URLs on
+// their default port, and uses Port Prefix Naming otherwise (RFC 9460,
+// sections 2.3 and 9.1): https://example.com:8443 is described at
+// _8443._https.example.com. WebSocket URLs use the HTTPS RR of the
+// matching http or https URL (RFC 9460, section 9.5), so wss://example.com
+// is described at example.com.
+func HTTPSQueryName(u *url.URL) string {
+	host := u.Hostname()
+	scheme := strings.ToLower(u.Scheme)
+	port := urlPort(u)
+	if s, ok := webSocketSchemes[scheme]; ok {
+		scheme = s
+	}
+	switch {
+	case scheme == "https" || scheme == "http":
+		if port == defaultPorts[scheme] {
+			return host
+		}
+		// An http origin is upgraded to https on the same port.
+		return "_" + port + "._https." + host
+	case port == "":
+		return host
+	}
+	return "_" + port + "._" + scheme + "." + host
+}
+
+// urlPort returns the port of u, or the default one of its scheme.
+func urlPort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return port
+	}
+	return defaultPorts[strings.ToLower(u.Scheme)]
+}
+
+// ToASCIIHost converts an internationalized host name to the A-labels
+// (punycode) sent in DNS queries and as the TLS server name. ASCII names
+// are returned unchanged, so that names such as _8443._https.example.com
+// are not rejected.
+func ToASCIIHost(host string) (string, error) {
+	if isASCII(host) {
+		return host, nil
+	}
+	ascii, err := idna.Lookup.ToASCII(host)
+	if err != nil {
+		return "", fmt.Errorf("invalid internationalized host name %q: %w", host, err)
+	}
+	return ascii, nil
+}
+
+// ToUnicodeHost returns host with its A-labels decoded to U-labels for
+// display, or host itself if it has none or cannot be decoded.
+func ToUnicodeHost(host string) string {
+	if !strings.Contains(strings.ToLower(host), "xn--") {
+		return host
+	}
+	unicode, err := idna.Display.ToUnicode(host)
+	if err != nil {
+		return host
+	}
+	return unicode
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,150 @@
+package echclient
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+)
+
+// PaddingSample is the length of the encrypted ClientHelloInner sent for a
+// server name of NameLength bytes.
+type PaddingSample struct {
+	NameLength    int `json:"name_length"`
+	PayloadLength int `json:"payload_length"`
+}
+
+// PaddingReport shows how the ClientHelloInner crypto/tls sends with a
+// config is padded, so that operators can check that maximum_name_length
+// hides the length of the names of their backends.
+type PaddingReport struct {
+	ConfigID      uint8 `json:"config_id"`
+	MaxNameLength uint8 `json:"max_name_length"`
+
+	// NameLength is the length of the probed server name and
+	// PayloadLength that of the encrypted ClientHelloInner sent for it,
+	// AEAD tag included.
+	NameLength    int `json:"name_length"`
+	PayloadLength int `json:"payload_length"`
+
+	// Samples holds the payload lengths of synthetic names of other
+	// lengths offered with the same config and protocols.
+	Samples []PaddingSample `json:"samples"`
+
+	// HidesNameLength records that every sampled name no longer than
+	// maximum_name_length gives PayloadLength. It is false when
+	// maximum_name_length is zero, as only rounding then evens out the
+	// lengths.
+	HidesNameLength bool `json:"hides_name_length"`
+}
+
+// paddingSampleLengths are the name lengths sampled by PaddingReport.
+var paddingSampleLengths = []int{8, 16, 32, 64, 128, 253}
+
+// NewPaddingReport returns the padding of the ClientHelloInner crypto/tls
+// builds for serverName offering echConfigList and the ALPN protocols
+// alpn. The ClientHellos are captured locally, without connecting.
+func NewPaddingReport(echConfigList []byte, serverName string, alpn []string) (*PaddingReport, error) {
+	configs, err := ParseECHConfigList(echConfigList)
+	if err != nil {
+		return nil, err
+	}
+	ec := pickECHConfig(configs)
+	if ec == nil {
+		return nil, fmt.Errorf("%w: no config crypto/tls can use", ErrNoUsableECHConfig)
+	}
+	report := &PaddingReport{ConfigID: ec.ConfigID, MaxNameLength: ec.MaxNameLength, NameLength: len(serverName)}
+	if report.PayloadLength, err = innerHelloLength(echConfigList, serverName, alpn); err != nil {
+		return nil, err
+	}
+	lengths := append(slices.Clone(paddingSampleLengths), len(serverName))
+	if ec.MaxNameLength > 0 {
+		lengths = append(lengths, int(ec.MaxNameLength))
+	}
+	slices.Sort(lengths)
+	report.HidesNameLength = ec.MaxNameLength > 0
+	for _, n := range slices.Compact(lengths) {
+		sample := PaddingSample{NameLength: n, PayloadLength: report.PayloadLength}
+		if n != len(serverName) {
+			if sample.PayloadLength, err = innerHelloLength(echConfigList, syntheticName(n), alpn); err != nil {
+				return nil, err
+			}
+		}
+		if n <= int(ec.MaxNameLength) && sample.PayloadLength != report.PayloadLength {
+			report.HidesNameLength = false
+		}
+		report.Samples = append(report.Samples, sample)
+	}
+	return report, nil
+}
+
+// reportInnerPadding sets r.Padding for the ClientHelloInner the probe of
+// host sends offering echConfigList.
+func (c *ProbeConfig) reportInnerPadding(r *ProbeResult, host string, echConfigList []byte) {
+	protos := c.offeredProtocols(r.AdvertisedALPN)
+	if r.WebSocket {
+		protos = []string{"http/1.1"}
+	}
+	report, err := NewPaddingReport(echConfigList, host, protos)
+	if err != nil {
+		c.logger().Warn("failed to measure the padding of the ClientHelloInner", "error", err)
+		return
+	}
+	r.Padding = report
+}
+
+// syntheticName returns a valid DNS name of n bytes.
+func syntheticName(n int) string {
+	b := []byte(strings.Repeat("a", n))
+	for i := 62; i < n-1; i += 63 {
+		b[i] = '.'
+	}
+	return string(b)
+}
+
+// errHelloCaptured stops the handshake once the ClientHello is written.
+var errHelloCaptured = errors.New("ClientHello captured")
+
+// helloCaptureConn is a net.Conn recording the first write of a TLS client.
+type helloCaptureConn struct {
+	hello []byte
+}
+
+func (c *helloCaptureConn) Write(b []byte) (int, error) {
+	c.hello = append([]byte(nil), b...)
+	return 0, errHelloCaptured
+}
+
+func (c *helloCaptureConn) Read([]byte) (int, error)         { return 0, errHelloCaptured }
+func (c *helloCaptureConn) Close() error                     { return nil }
+func (c *helloCaptureConn) LocalAddr() net.Addr              { return &net.TCPAddr{} }
+func (c *helloCaptureConn) RemoteAddr() net.Addr             { return &net.TCPAddr{} }
+func (c *helloCaptureConn) SetDeadline(time.Time) error      { return nil }
+func (c *helloCaptureConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *helloCaptureConn) SetWriteDeadline(time.Time) error { return nil }
+
+// innerHelloLength returns the length of the payload of the
+// encrypted_client_hello extension crypto/tls sends for serverName.
+func innerHelloLength(echConfigList []byte, serverName string, alpn []string) (int, error) {
+	conn := &helloCaptureConn{}
+	tls.Client(conn, &tls.Config{
+		ServerName:                     serverName,
+		NextProtos:                     alpn,
+		MinVersion:                     tls.VersionTLS13,
+		EncryptedClientHelloConfigList: echConfigList,
+	}).Handshake()
+	if conn.hello == nil {
+		return 0, errors.New("crypto/tls did not send a ClientHello")
+	}
+	hello, err := ParseClientHello(conn.hello)
+	if err != nil {
+		return 0, err
+	}
+	if hello.ECH == nil {
+		return 0, errors.New("crypto/tls did not offer ECH")
+	}
+	return hello.ECH.PayloadLength, nil
+}
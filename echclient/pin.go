@@ -0,0 +1,108 @@
+package echclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+)
+
+// ECHConfigPins remembers the ECHConfigList first fetched for each name
+// (trust on first use), so that a later list served by DNS can be told
+// apart from it: an on-path attacker or a misbehaving resolver swapping the
+// ECH key would otherwise go unnoticed. It is safe for concurrent use.
+type ECHConfigPins struct {
+	mu   sync.Mutex
+	pins map[string]ECHConfigPin
+}
+
+// ECHConfigPin is the ECHConfigList pinned for a name and when it was
+// first seen.
+type ECHConfigPin struct {
+	ECHConfigList []byte    `json:"ech_config_list"`
+	Pinned        time.Time `json:"pinned"`
+}
+
+// NewECHConfigPins returns an empty ECHConfigPins.
+func NewECHConfigPins() *ECHConfigPins {
+	return &ECHConfigPins{pins: map[string]ECHConfigPin{}}
+}
+
+// LoadECHConfigPins returns the pins of the JSON file at path, as written
+// by Save. A missing file yields no pins.
+func LoadECHConfigPins(path string) (*ECHConfigPins, error) {
+	p := NewECHConfigPins()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &p.pins); err != nil {
+		return nil, fmt.Errorf("invalid ECHConfig pin file %s: %w", path, err)
+	}
+	return p, nil
+}
+
+// Save writes the pins to the JSON file at path, replacing it atomically.
+func (p *ECHConfigPins) Save(path string) error {
+	p.mu.Lock()
+	data, err := json.MarshalIndent(p.pins, "", "  ")
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// Check pins echConfigList for name if nothing is pinned for it yet, and
+// otherwise returns an error wrapping ErrECHConfigPinMismatch when it
+// differs from the pinned list. A changed list is not pinned: delete the
+// pin to accept a legitimate key rotation.
+func (p *ECHConfigPins) Check(name string, echConfigList []byte) error {
+	name = canonicalName(name)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pin, ok := p.pins[name]
+	if !ok {
+		p.pins[name] = ECHConfigPin{ECHConfigList: echConfigList, Pinned: time.Now().UTC()}
+		return nil
+	}
+	if bytes.Equal(pin.ECHConfigList, echConfigList) {
+		return nil
+	}
+	msg := "the configs differ"
+	previous, err1 := ParseECHConfigList(pin.ECHConfigList)
+	current, err2 := ParseECHConfigList(echConfigList)
+	if changes := DiffECHConfigs(previous, current); err1 == nil && err2 == nil && len(changes) > 0 {
+		msg = changes[0]
+		if len(changes) > 1 {
+			msg = fmt.Sprintf("%s, and %d more", msg, len(changes)-1)
+		}
+	}
+	return fmt.Errorf("%w for %s, pinned on %s: %s", ErrECHConfigPinMismatch, name, pin.Pinned.Format(time.RFC3339), msg)
+}
+
+// checkPin checks echConfigList against the pin of name, recording a
+// mismatch in r, and returns the error that fails the probe unless
+// PinWarnOnly is set.
+func (c *ProbeConfig) checkPin(r *ProbeResult, name string, echConfigList []byte) error {
+	if c == nil || c.ECHConfigPins == nil || len(echConfigList) == 0 {
+		return nil
+	}
+	err := c.ECHConfigPins.Check(name, echConfigList)
+	if err == nil {
+		return nil
+	}
+	r.ECHConfigPinMismatch = true
+	if c.PinWarnOnly {
+		c.logger().Warn("ECHConfigList differs from the pinned one", "name", name, "error", err)
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,271 @@
+package echclient
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ParseHttpsPresentation parses the RDATA of an HTTPS or SVCB RR in the
+// presentation format of RFC 9460, section 2.1, as printed by dig and
+// returned by the Google JSON API:
+//
+//	1 . alpn="h2,h3" ech=AEX+DQBB...
+//
+// The SvcParams are returned in increasing key order.
+func ParseHttpsPresentation(s string) (*HttpsRecord, error) {
+	fields, err := splitPresentation(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("%w: expected priority and target name in %q", ErrMalformedRR, s)
+	}
+	priority, err := strconv.ParseUint(fields[0], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid priority %q", ErrMalformedRR, fields[0])
+	}
+	record := &HttpsRecord{Priority: uint16(priority), TargetName: fqdn(fields[1])}
+	for _, field := range fields[2:] {
+		name, value, hasValue := strings.Cut(field, "=")
+		key, ok := ParseSvcParamKey(name)
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown SvcParamKey %q", ErrMalformedRR, name)
+		}
+		if slices.ContainsFunc(record.Params, func(p SvcParam) bool { return p.Key == key }) {
+			return nil, fmt.Errorf("%w: duplicate SvcParamKey %s", ErrMalformedRR, keyLabel(key))
+		}
+		raw, err := unescapeCharString(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrMalformedRR, name, err)
+		}
+		v, err := encodeSvcParamValue(key, raw, hasValue)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrMalformedRR, name, err)
+		}
+		record.Params = append(record.Params, SvcParam{Key: key, Value: v})
+	}
+	slices.SortFunc(record.Params, func(a, b SvcParam) int {
+		return int(a.Key) - int(b.Key)
+	})
+	return record, nil
+}
+
+// String returns the RDATA of r in presentation format, as printed by dig:
+//
+//	1 . alpn="h2,h3" ech=AEX+DQBB...
+func (r *HttpsRecord) String() string {
+	fields := []string{strconv.Itoa(int(r.Priority)), r.TargetName}
+	for _, p := range r.Params {
+		fields = append(fields, p.String())
+	}
+	return strings.Join(fields, " ")
+}
+
+// FormatRR renders an HTTPS or SVCB record of type qtype owned by name the
+// way dig prints answers, so that it can be pasted into a zone file:
+//
+//	example.com.	300	IN	HTTPS	1 . alpn="h2,h3" ech=AEX+DQBB...
+func FormatRR(name string, ttl uint32, qtype RRType, r *HttpsRecord) string {
+	return fmt.Sprintf("%s\t%d\tIN\t%s\t%s", fqdn(name), ttl, qtype, r)
+}
+
+// FormatGenericRR renders an RR of type qtype owned by name with the given
+// RDATA in the RFC 3597 generic form, which DNS providers lacking native
+// support for the type accept:
+//
+//	example.com.	300	IN	TYPE65	\# 10 000100000100030268...
+func FormatGenericRR(name string, ttl uint32, qtype RRType, rdata []byte) string {
+	return fmt.Sprintf("%s\t%d\tIN\tTYPE%d\t%s", fqdn(name), ttl, uint16(qtype), genericData(rdata))
+}
+
+// FormatAnswer renders answer like FormatRR, decoding the data of HTTPS and
+// SVCB records into presentation format whichever form the resolver used.
+// The data of other types is printed as returned.
+func FormatAnswer(answer DNSAnswer) (string, error) {
+	qtype := RRType(answer.Type)
+	if qtype != TypeHTTPS && qtype != TypeSVCB {
+		return fmt.Sprintf("%s\t%d\tIN\t%s\t%s", fqdn(answer.Name), answer.TTL, qtype, answer.Data), nil
+	}
+	record, err := decodeSVCBData(answer.Data)
+	if err != nil {
+		return "", err
+	}
+	return FormatRR(answer.Name, uint32(answer.TTL), qtype, record), nil
+}
+
+// ParseSvcParamKey returns the key named name, either registered or in the
+// generic keyNNNNN form.
+func ParseSvcParamKey(name string) (uint16, bool) {
+	for key, n := range svcParamKeyNames {
+		if n == name {
+			return key, true
+		}
+	}
+	digits, ok := strings.CutPrefix(name, "key")
+	if !ok || digits == "" || len(digits) > 1 && digits[0] == '0' {
+		return 0, false
+	}
+	key, err := strconv.ParseUint(digits, 10, 16)
+	return uint16(key), err == nil
+}
+
+// encodeSvcParamValue returns the wire format of the unescaped
+// presentation value of key.
+func encodeSvcParamValue(key uint16, value string, hasValue bool) ([]byte, error) {
+	switch key {
+	case SvcParamMandatory:
+		var v []byte
+		for _, name := range strings.Split(value, ",") {
+			k, ok := ParseSvcParamKey(name)
+			if !ok {
+				return nil, fmt.Errorf("unknown key %q", name)
+			}
+			v = append(v, byte(k>>8), byte(k))
+		}
+		return v, nil
+	case SvcParamALPN:
+		var v []byte
+		for _, proto := range splitValueList(value) {
+			if proto == "" || len(proto) > 255 {
+				return nil, fmt.Errorf("invalid protocol %q", proto)
+			}
+			v = append(v, byte(len(proto)))
+			v = append(v, proto...)
+		}
+		return v, nil
+	case SvcParamNoDefaultALPN, SvcParamOHTTP:
+		if hasValue {
+			return nil, fmt.Errorf("unexpected value %q", value)
+		}
+		return []byte{}, nil
+	case SvcParamPort:
+		port, err := strconv.ParseUint(value, 10, 16)
+		if err != nil {
+			return nil, err
+		}
+		return []byte{byte(port >> 8), byte(port)}, nil
+	case SvcParamIPv4Hint, SvcParamIPv6Hint:
+		var v []byte
+		for _, s := range strings.Split(value, ",") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, err
+			}
+			if addr.Is4() != (key == SvcParamIPv4Hint) {
+				return nil, fmt.Errorf("wrong address family %s", addr)
+			}
+			v = append(v, addr.AsSlice()...)
+		}
+		return v, nil
+	case SvcParamECH:
+		return base64.StdEncoding.DecodeString(value)
+	}
+	return []byte(value), nil
+}
+
+// splitPresentation splits s on whitespace outside of quotes, removing the
+// quotes but keeping the escape sequences.
+func splitPresentation(s string) ([]string, error) {
+	var (
+		fields  []string
+		field   strings.Builder
+		inField bool
+		quoted  bool
+	)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\':
+			if i+1 >= len(s) {
+				return nil, fmt.Errorf("%w: trailing backslash in %q", ErrMalformedRR, s)
+			}
+			field.WriteByte(c)
+			field.WriteByte(s[i+1])
+			i++
+			inField = true
+		case c == '"':
+			quoted = !quoted
+			inField = true
+		case !quoted && (c == ' ' || c == '\t' || c == '\n' || c == '\r'):
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		default:
+			field.WriteByte(c)
+			inField = true
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("%w: unterminated quote in %q", ErrMalformedRR, s)
+	}
+	if inField {
+		fields = append(fields, field.String())
+	}
+	return fields, nil
+}
+
+// unescapeCharString resolves the \X and \DDD escapes of a character-string.
+func unescapeCharString(s string) (string, error) {
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+3 < len(s) && isDigits(s[i+1:i+4]) {
+			n, _ := strconv.Atoi(s[i+1 : i+4])
+			if n > 255 {
+				return "", fmt.Errorf("invalid escape \\%s", s[i+1:i+4])
+			}
+			b.WriteByte(byte(n))
+			i += 3
+			continue
+		}
+		if i+1 >= len(s) {
+			return "", fmt.Errorf("trailing backslash")
+		}
+		b.WriteByte(s[i+1])
+		i++
+	}
+	return b.String(), nil
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// splitValueList splits an unescaped value-list on the commas not escaped
+// with a backslash (RFC 9460, appendix A.1).
+func splitValueList(s string) []string {
+	var (
+		items []string
+		item  strings.Builder
+	)
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s):
+			item.WriteByte(s[i+1])
+			i++
+		case s[i] == ',':
+			items = append(items, item.String())
+			item.Reset()
+		default:
+			item.WriteByte(s[i])
+		}
+	}
+	return append(items, item.String())
+}
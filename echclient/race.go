@@ -0,0 +1,81 @@
+package echclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// RaceResolver is a Resolver sending each query to all of Resolvers at once
+// and returning the first successful answer, cancelling the other queries.
+type RaceResolver struct {
+	Resolvers []Resolver
+
+	// Timeout bounds the query to each resolver. Zero means no timeout
+	// beyond that of ctx.
+	Timeout time.Duration
+
+	// Logger receives the failures of individual resolvers. If nil,
+	// nothing is logged.
+	Logger *slog.Logger
+}
+
+// NewRaceResolver returns a RaceResolver over the resolvers built by
+// NewResolver for each of resolverURLs, each bounded by the DNS timeout of c.
+func (c *ProbeConfig) NewRaceResolver(resolverURLs ...string) (*RaceResolver, error) {
+	resolvers, err := c.newResolvers(resolverURLs)
+	if err != nil {
+		return nil, err
+	}
+	return &RaceResolver{
+		Resolvers: resolvers,
+		Timeout:   c.dnsTimeout(),
+		Logger:    c.logger(),
+	}, nil
+}
+
+func (rr *RaceResolver) String() string {
+	return "race(" + joinResolvers(rr.Resolvers) + ")"
+}
+
+// Query implements Resolver. The Resolver field of the response names the
+// resolver that answered first. If every resolver fails, the joined errors
+// are returned.
+func (rr *RaceResolver) Query(ctx context.Context, name string, qtype RRType) (*DNSResponse, error) {
+	if len(rr.Resolvers) == 0 {
+		return nil, errors.New("no resolvers configured")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		resolver Resolver
+		resp     *DNSResponse
+		err      error
+	}
+	// Buffered so that the losing queries do not block once we return.
+	results := make(chan result, len(rr.Resolvers))
+	for _, r := range rr.Resolvers {
+		go func() {
+			resp, err := queryWithTimeout(ctx, r, rr.Timeout, name, qtype)
+			results <- result{r, resp, err}
+		}()
+	}
+	var errs []error
+	for range rr.Resolvers {
+		res := <-results
+		if res.err == nil {
+			if res.resp.Resolver == "" {
+				res.resp.Resolver = res.resolver.String()
+			}
+			return res.resp, nil
+		}
+		if rr.Logger != nil {
+			rr.Logger.Warn("resolver failed", "resolver", res.resolver.String(), "name", name, "type", qtype, "error", res.err)
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", res.resolver, res.err))
+	}
+	return nil, errors.Join(errs...)
+}
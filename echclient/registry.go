@@ -0,0 +1,34 @@
+package echclient
+
+import (
+	"sort"
+)
+
+// KnownResolver is a public resolver addressable by a short name wherever a
+// resolver URL is accepted.
+type KnownResolver struct {
+	// URL is the DoH endpoint.
+	URL string
+
+	// Method is the DoH encoding the endpoint supports, used unless
+	// ProbeConfig.DoHMethod is set.
+	Method DoHMethod
+}
+
+// KnownResolvers maps short names to well known public resolvers.
+var KnownResolvers = map[string]KnownResolver{
+	"cloudflare": {URL: "https://cloudflare-dns.com/dns-query", Method: DoHJSON},
+	"google":     {URL: "https://dns.google/resolve", Method: DoHJSON},
+	"quad9":      {URL: "https://dns.quad9.net/dns-query", Method: DoHPost},
+	"adguard":    {URL: "https://dns.adguard-dns.com/dns-query", Method: DoHPost},
+}
+
+// KnownResolverNames returns the sorted short names of KnownResolvers.
+func KnownResolverNames() []string {
+	names := make([]string, 0, len(KnownResolvers))
+	for name := range KnownResolvers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
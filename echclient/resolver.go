@@ -0,0 +1,366 @@
+package echclient
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"weak"
+)
+
+// RRType is a DNS resource record type.
+type RRType uint16
+
+const (
+	TypeA      RRType = 1
+	TypeNS     RRType = 2
+	TypeCNAME  RRType = 5
+	TypeSOA    RRType = 6
+	TypeAAAA   RRType = 28
+	TypeDS     RRType = 43
+	TypeRRSIG  RRType = 46
+	TypeNSEC   RRType = 47
+	TypeDNSKEY RRType = 48
+	TypeNSEC3  RRType = 50
+	TypeSVCB   RRType = 64
+	TypeHTTPS  RRType = 65
+)
+
+var rrTypeNames = map[RRType]string{
+	TypeA:      "A",
+	TypeNS:     "NS",
+	TypeCNAME:  "CNAME",
+	TypeSOA:    "SOA",
+	TypeAAAA:   "AAAA",
+	TypeDS:     "DS",
+	TypeRRSIG:  "RRSIG",
+	TypeNSEC:   "NSEC",
+	TypeDNSKEY: "DNSKEY",
+	TypeNSEC3:  "NSEC3",
+	TypeSVCB:   "SVCB",
+	TypeHTTPS:  "HTTPS",
+
+	// The other types in use, which the type bitmaps of NSEC and NSEC3
+	// records list in presentation format.
+	10:    "NULL",
+	12:    "PTR",
+	13:    "HINFO",
+	15:    "MX",
+	16:    "TXT",
+	17:    "RP",
+	18:    "AFSDB",
+	24:    "SIG",
+	25:    "KEY",
+	29:    "LOC",
+	33:    "SRV",
+	35:    "NAPTR",
+	36:    "KX",
+	37:    "CERT",
+	39:    "DNAME",
+	42:    "APL",
+	44:    "SSHFP",
+	45:    "IPSECKEY",
+	49:    "DHCID",
+	51:    "NSEC3PARAM",
+	52:    "TLSA",
+	53:    "SMIMEA",
+	55:    "HIP",
+	59:    "CDS",
+	60:    "CDNSKEY",
+	61:    "OPENPGPKEY",
+	62:    "CSYNC",
+	63:    "ZONEMD",
+	99:    "SPF",
+	104:   "NID",
+	105:   "L32",
+	106:   "L64",
+	107:   "LP",
+	108:   "EUI48",
+	109:   "EUI64",
+	256:   "URI",
+	257:   "CAA",
+	32768: "TA",
+	32769: "DLV",
+}
+
+func (t RRType) String() string {
+	if name, ok := rrTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("TYPE%d", uint16(t))
+}
+
+// ParseRRType parses a type mnemonic, case insensitively, or its generic
+// TYPEnnn form.
+func ParseRRType(s string) (RRType, error) {
+	s = strings.ToUpper(s)
+	for t, name := range rrTypeNames {
+		if name == s {
+			return t, nil
+		}
+	}
+	if n, ok := strings.CutPrefix(s, "TYPE"); ok {
+		if v, err := strconv.ParseUint(n, 10, 16); err == nil {
+			return RRType(v), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown RR type %q", s)
+}
+
+// Resolver sends DNS queries. A non-zero RCODE is reported as a
+// DNSStatusError.
+type Resolver interface {
+	Query(ctx context.Context, name string, qtype RRType) (*DNSResponse, error)
+
+	// String describes the resolver in results and logs.
+	String() string
+}
+
+// Query sends a DNS query for name and qtype to the default resolver.
+func Query(ctx context.Context, name string, qtype RRType) (*DNSResponse, error) {
+	return (*ProbeConfig)(nil).Query(ctx, name, qtype)
+}
+
+// Query sends a DNS query for name and qtype to the configured resolver, or
+// answers it from the configured cache. Failed queries are retried up to
+// DNSRetries times with exponential backoff; if they still fail with a
+// retryable error, an expired answer retained by the cache is returned with
+// Stale set.
+func (c *ProbeConfig) Query(ctx context.Context, name string, qtype RRType) (*DNSResponse, error) {
+	cache := c.cache()
+	if cache != nil {
+		if resp, ok := cache.Get(name, qtype); ok {
+			c.logger().Debug("DNS cache hit", "name", name, "type", qtype)
+			return resp, nil
+		}
+	}
+	resolver, err := c.resolver()
+	if err != nil {
+		return nil, err
+	}
+	var resp *DNSResponse
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		resp, err = resolver.Query(ctx, name, qtype)
+		rtt := time.Since(start)
+		if resp != nil && resp.Resolver == "" {
+			resp.Resolver = resolver.String()
+		}
+		a := DNSAttempt{Name: name, Type: qtype.String(), Attempt: attempt, Resolver: resolver.String(), RTT: rtt}
+		if resp != nil {
+			a.Resolver = resp.Resolver
+		}
+		a.Transport = ResolverTransport(a.Resolver)
+		if err != nil {
+			a.Error = err.Error()
+		}
+		c.hooks().dnsLookup(name, qtype, resp, err, rtt)
+		c.metrics().DNSLookup(a.Resolver, a.Transport, a.Type, err, rtt)
+		traceDNSAttempt(ctx, a)
+		if err == nil || attempt > c.dnsRetries() || !retryable(err) {
+			break
+		}
+		delay := c.backoff(attempt)
+		c.logger().Debug("retrying DNS query", "name", name, "type", qtype, "attempt", attempt, "delay", delay, "error", err)
+		if sleep(ctx, delay) != nil {
+			break
+		}
+	}
+	if err == nil && cache != nil {
+		cache.Put(name, qtype, resp)
+	}
+	if err != nil && cache != nil && retryable(err) {
+		if stale, ok := cache.Stale(name, qtype); ok {
+			c.logger().Warn("DNS query failed, using an expired cached answer", "name", name, "type", qtype, "error", err)
+			return stale, nil
+		}
+	}
+	return resp, err
+}
+
+// defaultResolvers holds the resolver built from ResolverURL for each
+// ProbeConfig without a Resolver, so that its connections, TLS sessions and
+// DDR discovery outlive a single query. The ProbeConfigs are referenced
+// weakly and their entry is dropped when they are collected.
+var defaultResolvers struct {
+	mu sync.Mutex
+	m  map[weak.Pointer[ProbeConfig]]defaultResolver
+}
+
+type defaultResolver struct {
+	settings resolverSettings
+	resolver Resolver
+}
+
+// resolverSettings are the fields of ProbeConfig the default resolver is
+// built from, except Proxy, which cannot be compared.
+type resolverSettings struct {
+	url       string
+	method    DoHMethod
+	timeout   time.Duration
+	dnssec    bool
+	randomize bool
+	logger    *slog.Logger
+}
+
+func (c *ProbeConfig) resolverSettings() resolverSettings {
+	s := resolverSettings{
+		url:       c.resolverURL(),
+		timeout:   c.dnsTimeout(),
+		dnssec:    c.validateDNSSEC(),
+		randomize: c.randomizeDo53(),
+		logger:    c.logger(),
+	}
+	if c != nil {
+		s.method = c.DoHMethod
+	}
+	return s
+}
+
+// resolver returns the configured Resolver, or the one built from
+// ResolverURL on first use, which is rebuilt if the settings it depends on
+// change.
+func (c *ProbeConfig) resolver() (Resolver, error) {
+	if c != nil && c.Resolver != nil {
+		return c.Resolver, nil
+	}
+	key, settings := weak.Make(c), c.resolverSettings()
+	defaultResolvers.mu.Lock()
+	d, ok := defaultResolvers.m[key]
+	defaultResolvers.mu.Unlock()
+	if ok && d.settings == settings {
+		return d.resolver, nil
+	}
+
+	// The resolver is built from a copy so that it does not keep c alive.
+	var cfg *ProbeConfig
+	if c != nil {
+		copied := *c
+		cfg = &copied
+	}
+	r, err := cfg.NewResolver(settings.url)
+	if err != nil {
+		return nil, err
+	}
+
+	defaultResolvers.mu.Lock()
+	defer defaultResolvers.mu.Unlock()
+	d, ok = defaultResolvers.m[key]
+	if ok && d.settings == settings {
+		return d.resolver, nil
+	}
+	if defaultResolvers.m == nil {
+		defaultResolvers.m = make(map[weak.Pointer[ProbeConfig]]defaultResolver)
+	}
+	if !ok && c != nil {
+		runtime.AddCleanup(c, func(key weak.Pointer[ProbeConfig]) {
+			defaultResolvers.mu.Lock()
+			delete(defaultResolvers.m, key)
+			defaultResolvers.mu.Unlock()
+		}, key)
+	}
+	defaultResolvers.m[key] = defaultResolver{settings, r}
+	return r, nil
+}
+
+// NewResolver returns a Resolver for the given URL, KnownResolvers short
+// name, SystemResolverName or DDRResolverName, using the DoH method, timeout
+// and proxy of c. The scheme selects the transport: https for DoH, tls for
+// DoT, quic for DoQ, and udp or tcp for unencrypted DNS.
+func (c *ProbeConfig) NewResolver(resolverURL string) (Resolver, error) {
+	switch resolverURL {
+	case SystemResolverName:
+		return c.NewSystemResolver()
+	case DDRResolverName:
+		return c.NewDDRResolver()
+	}
+	method := c.dohMethod()
+	if known, ok := KnownResolvers[resolverURL]; ok {
+		resolverURL = known.URL
+		if c == nil || c.DoHMethod == "" {
+			method = known.Method
+		}
+	}
+	u, err := url.Parse(resolverURL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "https":
+		return &DoHResolver{
+			URL:    resolverURL,
+			Method: method,
+			Client: &http.Client{
+				Timeout: c.dnsTimeout(),
+				Transport: &http.Transport{
+					Proxy: c.proxy(),
+				},
+			},
+			Logger: c.logger(),
+			DNSSEC: c.validateDNSSEC(),
+		}, nil
+	case "tls":
+		r, err := newDoTResolver(u)
+		if err != nil {
+			return nil, err
+		}
+		r.Timeout = c.dnsTimeout()
+		r.Logger = c.logger()
+		r.DNSSEC = c.validateDNSSEC()
+		return r, nil
+	case "udp", "tcp":
+		r := newDo53Resolver(u)
+		r.Timeout = c.dnsTimeout()
+		r.Logger = c.logger()
+		r.DNSSEC = c.validateDNSSEC()
+		r.Randomize = c.randomizeDo53()
+		return r, nil
+	case "quic":
+		r, err := newDoQResolver(u)
+		if err != nil {
+			return nil, err
+		}
+		r.Timeout = c.dnsTimeout()
+		r.Logger = c.logger()
+		r.DNSSEC = c.validateDNSSEC()
+		return r, nil
+	}
+	return nil, fmt.Errorf("unsupported resolver scheme %q", u.Scheme)
+}
+
+// ResolverTransport returns the transport of the resolver described by name,
+// as returned by Resolver.String: doh, dot, doq, do53 or odoh. It returns
+// an empty string for resolvers combining several transports.
+func ResolverTransport(name string) string {
+	if inner, ok := strings.CutPrefix(name, DDRResolverName+"("); ok {
+		return ResolverTransport(strings.TrimSuffix(inner, ")"))
+	}
+	scheme, _, _ := strings.Cut(name, ":")
+	switch {
+	case scheme == "https":
+		return "doh"
+	case scheme == "tls":
+		return "dot"
+	case scheme == "quic":
+		return "doq"
+	case scheme == "udp", scheme == "tcp":
+		return "do53"
+	case strings.HasPrefix(name, "odoh("):
+		return "odoh"
+	}
+	return ""
+}
+
+func (c *ProbeConfig) resolverName() string {
+	resolver, err := c.resolver()
+	if err != nil {
+		return c.resolverURL()
+	}
+	return resolver.String()
+}
@@ -0,0 +1,35 @@
+package echclient
+
+import "testing"
+
+func TestDefaultResolver(t *testing.T) {
+	c := &ProbeConfig{ResolverURL: "https://dns.example/dns-query"}
+	first, err := c.resolver()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := c.resolver(); again != first {
+		t.Errorf("resolver() built a new resolver for the same settings")
+	}
+	if other, _ := (&ProbeConfig{ResolverURL: c.ResolverURL}).resolver(); other == first {
+		t.Errorf("resolver() shared the resolver of another ProbeConfig")
+	}
+
+	c.DoHMethod = DoHPost
+	post, err := c.resolver()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if post == first || post.(*DoHResolver).Method != DoHPost {
+		t.Errorf("resolver() = %v with method %s, want a new resolver using POST", post, post.(*DoHResolver).Method)
+	}
+
+	var nilConfig *ProbeConfig
+	r, err := nilConfig.resolver()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := nilConfig.resolver(); again != r || r.String() != DefaultResolverURL {
+		t.Errorf("resolver() of a nil ProbeConfig = %v, %v, want the same %s resolver", r, again, DefaultResolverURL)
+	}
+}
@@ -0,0 +1,851 @@
+package echclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// ProbeResult is the machine-readable outcome of ProbeURL.
+type ProbeResult struct {
+	URL      string  `json:"url"`
+	Resolver string  `json:"resolver"`
+	ECHMode  ECHMode `json:"ech_mode"`
+
+	// QueryName is the name the HTTPS RR was queried at, which differs
+	// from the host for non-default ports and other schemes. It is made
+	// of A-labels for internationalized hosts.
+	QueryName string `json:"query_name,omitempty"`
+
+	// UnicodeHost is the U-label form of an internationalized host,
+	// whether the URL spelt it with U-labels or A-labels.
+	UnicodeHost string `json:"unicode_host,omitempty"`
+
+	// AnsweredBy names the resolver that answered the HTTPS query, which
+	// differs from Resolver when a FallbackResolver is used.
+	AnsweredBy string `json:"answered_by,omitempty"`
+
+	// DNSSEC is the validation status of the HTTPS RRset when
+	// ProbeConfig.ValidateDNSSEC is set.
+	DNSSEC      DNSSECStatus `json:"dnssec,omitempty"`
+	DNSSECError string       `json:"dnssec_error,omitempty"`
+
+	// DNSTruncated records that the HTTPS answer was truncated and the
+	// query was repeated over TCP or with DoH POST.
+	DNSTruncated bool `json:"dns_truncated,omitempty"`
+
+	// DNSStale records that the HTTPS answer is an expired cache entry,
+	// used because the resolver failed.
+	DNSStale bool `json:"dns_stale,omitempty"`
+
+	// DNSAttempts lists every DNS query attempt made by the probe, with
+	// the resolver and transport used and its round-trip time, including
+	// the retries of failed queries.
+	DNSAttempts []DNSAttempt `json:"dns_attempts,omitempty"`
+
+	// DNSSuspiciousReplies counts the UDP answers discarded because their
+	// ID or 0x20 casing did not match the query.
+	DNSSuspiciousReplies int `json:"dns_suspicious_replies,omitempty"`
+
+	// CNAMEChain lists the aliases followed from the host to the owner of
+	// the HTTPS RR.
+	CNAMEChain []string `json:"cname_chain,omitempty"`
+
+	// AliasChain lists the TargetNames of the AliasMode records followed
+	// to the endpoints.
+	AliasChain []string `json:"alias_chain,omitempty"`
+
+	// DNSStatus is the mnemonic of the RCODE of a failed HTTPS query, or
+	// NODATA when the name has no HTTPS record.
+	DNSStatus string `json:"dns_status,omitempty"`
+
+	// Fallback is set when no ECHConfigList was found and the probe went
+	// on according to ProbeConfig.ECHFallback. FallbackReason is the
+	// lookup error that triggered it.
+	Fallback       ECHFallback `json:"fallback,omitempty"`
+	FallbackReason string      `json:"fallback_reason,omitempty"`
+
+	ConfigSource      ConfigSource    `json:"config_source,omitempty"`
+	HTTPSRecord       *HttpsRecord    `json:"https_record,omitempty"`
+	HTTPSRecords      []*HttpsRecord  `json:"https_records,omitempty"`
+	SkippedRecords    []SkippedRecord `json:"skipped_records,omitempty"`
+	ParseWarnings     []string        `json:"parse_warnings,omitempty"`
+	WellKnown         *WellKnownSVCB  `json:"well_known,omitempty"`
+	ECHConfigList     []byte          `json:"ech_config_list,omitempty"`
+	ECHConfigs        []ECHConfigInfo `json:"ech_configs,omitempty"`
+	SelectedECHConfig *ECHConfigInfo  `json:"selected_ech_config,omitempty"`
+
+	// NameLengthWarning reports a host longer than the
+	// maximum_name_length of SelectedECHConfig, whose length the padding
+	// of the ClientHelloInner then fails to hide.
+	NameLengthWarning string `json:"name_length_warning,omitempty"`
+
+	// Padding describes the padding of the ClientHelloInner when
+	// ProbeConfig.ReportPadding is set.
+	Padding *PaddingReport `json:"padding,omitempty"`
+
+	// SkippedECHConfigs explains why the published ECHConfigs that
+	// crypto/tls cannot use were left out of the offered list.
+	SkippedECHConfigs []string `json:"skipped_ech_configs,omitempty"`
+
+	// ECHConfigPinMismatch records that the ECHConfigList differs from
+	// the one pinned in ProbeConfig.ECHConfigPins.
+	ECHConfigPinMismatch bool `json:"ech_config_pin_mismatch,omitempty"`
+
+	// ConfigIDWarnings reports config_ids shared by several configs or
+	// changed since the previous answer in the DNS cache.
+	ConfigIDWarnings []string `json:"config_id_warnings,omitempty"`
+
+	// ECHConfigLists lists the distinct ECHConfigLists published by the
+	// usable HTTPS records when there are several, the one of HTTPSRecord
+	// first, and ECHConfigsMerged records that ECHConfigList merges them.
+	ECHConfigLists   [][]byte `json:"ech_config_lists,omitempty"`
+	ECHConfigsMerged bool     `json:"ech_configs_merged,omitempty"`
+
+	ECHAccepted     bool   `json:"ech_accepted"`
+	ECHRejected     bool   `json:"ech_rejected"`
+	RetryConfigList []byte `json:"retry_config_list,omitempty"`
+	TLSVersion      string `json:"tls_version,omitempty"`
+	CipherSuite     string `json:"cipher_suite,omitempty"`
+	ALPN            string `json:"alpn,omitempty"`
+
+	// DidResume records that the handshake resumed a TLS session.
+	DidResume bool `json:"did_resume,omitempty"`
+
+	// ClientCertRequested records that the server requested a client
+	// certificate, and ClientCertSent that one of TLSConfig.Certificates
+	// suited the request and was sent. A server rejecting it fails the
+	// probe with its alert.
+	ClientCertRequested bool `json:"client_cert_requested,omitempty"`
+	ClientCertSent      bool `json:"client_cert_sent,omitempty"`
+
+	// EarlyData records that the request was sent as 0-RTT early data,
+	// and EarlyDataAccepted that the server accepted it.
+	EarlyData         bool `json:"early_data,omitempty"`
+	EarlyDataAccepted bool `json:"early_data_accepted,omitempty"`
+
+	// AdvertisedALPN is the effective ALPN set of the HTTPS record,
+	// OfferedALPN the protocols offered in the ClientHello and
+	// ALPNMismatch describes a negotiated protocol the record does not
+	// advertise.
+	AdvertisedALPN []string `json:"advertised_alpn,omitempty"`
+	OfferedALPN    []string `json:"offered_alpn,omitempty"`
+	ALPNMismatch   string   `json:"alpn_mismatch,omitempty"`
+
+	// WebSocket is set for ws and wss URLs, probed with a WebSocket
+	// opening handshake over HTTP/1.1 instead of a plain GET. StatusCode
+	// is then 101 when the upgrade succeeded.
+	WebSocket bool `json:"websocket,omitempty"`
+
+	// RemoteAddr is the address the probe connected to.
+	RemoteAddr string `json:"remote_addr,omitempty"`
+
+	// Target is the name of the endpoint of HTTPSRecord, the owner of the
+	// record when its TargetName is ".", which the probe connects to
+	// unless it goes through the proxy.
+	Target string `json:"target,omitempty"`
+
+	// AdvertisedPort is the port SvcParam of the HTTPS record and Port
+	// the port the probe connected to.
+	AdvertisedPort uint16 `json:"advertised_port,omitempty"`
+	Port           uint16 `json:"port,omitempty"`
+
+	// HandshakeOnly records that the probe stopped after the TLS
+	// handshake.
+	HandshakeOnly bool `json:"handshake_only,omitempty"`
+
+	// PeerCertificates holds the chain the server presented, leaf first,
+	// also when verifying it failed, and CertificateCheck how it
+	// verifies.
+	PeerCertificates []CertificateInfo `json:"peer_certificates,omitempty"`
+	CertificateCheck *CertificateCheck `json:"certificate_check,omitempty"`
+
+	// TLSEngine names the ProbeConfig.TLSEngine that performed the
+	// handshake, empty for crypto/tls.
+	TLSEngine string `json:"tls_engine,omitempty"`
+
+	// ClientHello is the ClientHello sent on the wire when
+	// ProbeConfig.CaptureClientHello is set.
+	ClientHello *ClientHelloInfo `json:"client_hello,omitempty"`
+
+	StatusCode int    `json:"status_code,omitempty"`
+	BodyLength int    `json:"body_length"`
+	Body       []byte `json:"-"`
+
+	Timings Timings `json:"timings"`
+
+	// AuthoritativeRecords holds the HTTPS records returned by the
+	// authoritative nameservers of the zone when
+	// ProbeConfig.CompareAuthoritative is set, and AuthoritativeDiffers
+	// whether they differ from the recursive answer.
+	AuthoritativeRecords    []*HttpsRecord `json:"authoritative_https_records,omitempty"`
+	AuthoritativeAnsweredBy string         `json:"authoritative_answered_by,omitempty"`
+	AuthoritativeDiffers    bool           `json:"authoritative_differs,omitempty"`
+	AuthoritativeError      string         `json:"authoritative_error,omitempty"`
+
+	// UsedHints records that the probe connected to the ipv4hint and
+	// ipv6hint addresses of the HTTPS record. Resolved then holds the
+	// outcome of the same request over the resolved addresses, and
+	// HintsDiffer whether the two differ.
+	UsedHints   bool         `json:"used_hints,omitempty"`
+	Resolved    *ProbeResult `json:"resolved,omitempty"`
+	HintsDiffer bool         `json:"hints_differ,omitempty"`
+
+	// Retry holds the outcome of the second attempt made with
+	// RetryConfigList after the server rejected ECH.
+	Retry *ProbeResult `json:"retry,omitempty"`
+
+	// RetryConfigDiffers records that the RetryConfigList differs from
+	// the published ECHConfigList, and RetryConfigDrift how, as returned
+	// by DiffECHConfigs: the sign of DNS serving stale keys.
+	RetryConfigDiffers bool     `json:"retry_config_differs,omitempty"`
+	RetryConfigDrift   []string `json:"retry_config_drift,omitempty"`
+
+	// Resumption holds the outcome of the request repeated on a new
+	// connection when ProbeConfig.Resume is set, which should resume the
+	// TLS session of this one and still have ECH accepted.
+	Resumption *ProbeResult `json:"resumption,omitempty"`
+
+	// ConfigProbes holds the outcome of the handshakes offering each
+	// config on its own when ProbeConfig.ProbeEachConfig is set.
+	ConfigProbes []ConfigProbe `json:"config_probes,omitempty"`
+
+	Error      string `json:"error,omitempty"`
+	ErrorClass string `json:"error_class,omitempty"`
+}
+
+// Final returns the result of the last attempt: Retry if a retry was made,
+// r otherwise.
+func (r *ProbeResult) Final() *ProbeResult {
+	if r.Retry != nil {
+		return r.Retry
+	}
+	return r
+}
+
+// Timings records the duration of each step of a probe, in nanoseconds when
+// encoded as JSON.
+type Timings struct {
+	DNS          time.Duration `json:"dns"`
+	TLSHandshake time.Duration `json:"tls_handshake"`
+	HTTP         time.Duration `json:"http"`
+	Total        time.Duration `json:"total"`
+}
+
+// ECHConfigInfo is a JSON friendly view of an ECHConfig.
+type ECHConfigInfo struct {
+	Version       uint16         `json:"version"`
+	Draft         string         `json:"draft"`
+	ConfigID      uint8          `json:"config_id"`
+	KemID         uint16         `json:"kem_id"`
+	KEM           string         `json:"kem"`
+	PostQuantum   bool           `json:"post_quantum,omitempty"`
+	PublicKey     []byte         `json:"public_key"`
+	CipherSuites  []ECHCipher    `json:"cipher_suites"`
+	MaxNameLength uint8          `json:"max_name_length"`
+	PublicName    string         `json:"public_name"`
+	Extensions    []ECHExtension `json:"extensions,omitempty"`
+
+	// CipherSuite is the cipher suite crypto/tls uses with the config,
+	// unset if it supports none.
+	CipherSuite *ECHCipher `json:"cipher_suite,omitempty"`
+
+	// Raw is the encoded config, set for versions crypto/tls cannot use
+	// so that they can be examined further.
+	Raw []byte `json:"raw,omitempty"`
+}
+
+// NewECHConfigInfo returns the JSON friendly view of ec.
+func NewECHConfigInfo(ec *ECHConfig) ECHConfigInfo {
+	info := ECHConfigInfo{
+		Version:       ec.Version,
+		Draft:         ECHVersionName(ec.Version),
+		ConfigID:      ec.ConfigID,
+		KemID:         ec.KemID,
+		KEM:           KEMName(ec.KemID),
+		PostQuantum:   IsPostQuantumKEM(ec.KemID),
+		PublicKey:     ec.PublicKey,
+		CipherSuites:  ec.SymmetricCipherSuite,
+		MaxNameLength: ec.MaxNameLength,
+		PublicName:    string(ec.PublicName),
+		Extensions:    ec.Extensions,
+	}
+	if ec.Version != extensionEncryptedClientHello {
+		info.Raw = ec.raw
+	} else if cs, ok := echCipherSuite(ec); ok {
+		info.CipherSuite = &cs
+	}
+	return info
+}
+
+// Error classes reported in ProbeResult.ErrorClass.
+const (
+	ErrorClassDNSStatus          = "dns_status"
+	ErrorClassNoHTTPSRecord      = "no_https_record"
+	ErrorClassNoECHConfig        = "no_ech_config"
+	ErrorClassCNAMEChain         = "cname_chain"
+	ErrorClassAliasChain         = "alias_chain"
+	ErrorClassMalformedRR        = "malformed_rr"
+	ErrorClassMalformedECHConfig = "malformed_ech_config"
+	ErrorClassNoUsableECHConfig  = "no_usable_ech_config"
+	ErrorClassPinMismatch        = "ech_config_pin_mismatch"
+	ErrorClassSPKIPinMismatch    = "spki_pin_mismatch"
+	ErrorClassDoH                = "doh"
+	ErrorClassDNSResponse        = "dns_response"
+	ErrorClassDNSInjection       = "dns_injection"
+	ErrorClassECHRejected        = "ech_rejected"
+	ErrorClassWebSocket          = "websocket"
+	ErrorClassTimeout            = "timeout"
+	ErrorClassCanceled           = "canceled"
+	ErrorClassTLS                = "tls"
+	ErrorClassOther              = "other"
+)
+
+// ClassifyError maps err to one of the ErrorClass constants.
+func ClassifyError(err error) string {
+	var echErr *tls.ECHRejectionError
+	var netErr net.Error
+	var recordErr tls.RecordHeaderError
+	var certErr *tls.CertificateVerificationError
+	var opErr *net.OpError
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, ErrDNSStatus):
+		return ErrorClassDNSStatus
+	case errors.Is(err, ErrNoHTTPSRecord):
+		return ErrorClassNoHTTPSRecord
+	case errors.Is(err, ErrNoECHConfig):
+		return ErrorClassNoECHConfig
+	case errors.Is(err, ErrCNAMEChain):
+		return ErrorClassCNAMEChain
+	case errors.Is(err, ErrAliasChain):
+		return ErrorClassAliasChain
+	case errors.Is(err, ErrMalformedRR):
+		return ErrorClassMalformedRR
+	case errors.Is(err, ErrMalformedECHConfig):
+		return ErrorClassMalformedECHConfig
+	case errors.Is(err, ErrNoUsableECHConfig):
+		return ErrorClassNoUsableECHConfig
+	case errors.Is(err, ErrECHConfigPinMismatch):
+		return ErrorClassPinMismatch
+	case errors.Is(err, ErrSPKIPinMismatch):
+		return ErrorClassSPKIPinMismatch
+	case errors.Is(err, ErrDoHResponse):
+		return ErrorClassDoH
+	case errors.Is(err, ErrDNSResponse):
+		return ErrorClassDNSResponse
+	case errors.Is(err, ErrDNSInjection):
+		return ErrorClassDNSInjection
+	case errors.Is(err, ErrWebSocketUpgrade):
+		return ErrorClassWebSocket
+	case errors.As(err, &echErr):
+		return ErrorClassECHRejected
+	case errors.Is(err, context.Canceled):
+		return ErrorClassCanceled
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return ErrorClassTimeout
+	case errors.As(err, &recordErr), errors.As(err, &certErr),
+		errors.As(err, &opErr) && opErr.Op == "remote error":
+		// crypto/tls reports the alerts sent by the server, such as
+		// certificate_required, as remote errors.
+		return ErrorClassTLS
+	}
+	return ErrorClassOther
+}
+
+func (r *ProbeResult) setError(err error) {
+	r.Error = err.Error()
+	r.ErrorClass = ClassifyError(err)
+}
+
+// ProbeURL fetches the ECHConfigList for the host of targetURL using the
+// default configuration and performs a GET request offering it.
+func ProbeURL(ctx context.Context, targetURL string) (*ProbeResult, error) {
+	return (*ProbeConfig)(nil).ProbeURL(ctx, targetURL)
+}
+
+// ProbeURL fetches the ECHConfigList for the host of targetURL and performs a
+// GET request offering it. The returned result is never nil; when a step
+// fails it records how far the probe got and err is also stored in it.
+func (c *ProbeConfig) ProbeURL(ctx context.Context, targetURL string) (*ProbeResult, error) {
+	r, err := c.probeURL(ctx, targetURL)
+	c.recordProbe(r)
+	return r, err
+}
+
+func (c *ProbeConfig) probeURL(ctx context.Context, targetURL string) (*ProbeResult, error) {
+	if c.resume() {
+		c = c.withSessionCache()
+	}
+	r := &ProbeResult{
+		URL:      targetURL,
+		Resolver: c.resolverName(),
+		ECHMode:  c.echMode(),
+	}
+	ctx, trace := withDNSTrace(ctx)
+	start := time.Now()
+	defer func() {
+		r.Timings.Total = time.Since(start)
+		r.DNSAttempts = trace.snapshot()
+	}()
+
+	u, err := url.Parse(targetURL)
+	if err != nil {
+		r.setError(err)
+		return r, err
+	}
+	host, err := ToASCIIHost(u.Hostname())
+	if err != nil {
+		r.setError(err)
+		return r, err
+	}
+	if host != u.Hostname() {
+		if port := u.Port(); port != "" {
+			u.Host = net.JoinHostPort(host, port)
+		} else {
+			u.Host = host
+		}
+		targetURL = u.String()
+	}
+	if hu, ok := webSocketToHTTP(u); ok {
+		r.WebSocket = true
+		u, targetURL = hu, hu.String()
+	}
+	if unicode := ToUnicodeHost(host); unicode != host {
+		r.UnicodeHost = unicode
+	}
+	r.QueryName = HTTPSQueryName(u)
+	echConfigList, err := c.resolveECHConfigList(ctx, r, host)
+	r.Timings.DNS = time.Since(start)
+	if c.compareAuthoritativeNS() && r.ConfigSource == ConfigSourceDNS {
+		c.compareAuthoritative(ctx, r, err)
+	}
+	if err != nil {
+		echConfigList, err = c.fallback(r, host, err)
+	}
+	if err != nil {
+		r.setError(err)
+		return r, err
+	}
+
+	if c.reportPadding() && r.SelectedECHConfig != nil && r.ECHMode != ECHModeGREASE && r.Fallback == "" {
+		c.reportInnerPadding(r, host, echConfigList)
+	}
+
+	r.Port = c.connectPort(u, r.HTTPSRecord)
+	dial := c.probeDial(r)
+	err = c.doRequest(ctx, r, targetURL, echConfigList, dial)
+	if r.UsedHints {
+		c.compareResolved(ctx, r, targetURL, echConfigList, err)
+	}
+	if c.probeEachConfig() && r.ECHMode != ECHModeGREASE && r.Fallback == "" {
+		c.probeConfigs(ctx, r, targetURL, echConfigList, dial)
+	}
+	if r.ECHRejected {
+		if r.ECHMode == ECHModeGREASE || r.Fallback == ECHFallbackGREASE {
+			// Reaching the rejection is what a GREASE probe checks for.
+			return r, nil
+		}
+		c.compareRetryConfigs(r, echConfigList)
+		if len(r.RetryConfigList) > 0 && !c.disableECHRetry() {
+			r.setError(err)
+			return r, c.retry(ctx, r, targetURL, dial)
+		}
+	}
+	if err != nil {
+		r.setError(err)
+		return r, err
+	}
+	if c.resume() {
+		c.resumeSession(ctx, r, targetURL, echConfigList, dial)
+	}
+	return r, nil
+}
+
+// fallback records the lookup error err in r and returns the ECHConfigList
+// to offer instead according to the configured ECHFallback, or err if the
+// probe cannot go on.
+func (c *ProbeConfig) fallback(r *ProbeResult, host string, err error) ([]byte, error) {
+	var statusErr *DNSStatusError
+	switch {
+	case errors.As(err, &statusErr):
+		r.DNSStatus = DNSStatusName(statusErr.Status)
+	case errors.Is(err, ErrNoHTTPSRecord):
+		r.DNSStatus = "NODATA"
+	}
+	mode := c.echFallback()
+	if mode != ECHFallbackGREASE && mode != ECHFallbackPlain ||
+		!noECHInDNS(err) && !errors.Is(err, ErrNoWellKnown) && !errors.Is(err, ErrNoUsableECHConfig) {
+		return nil, err
+	}
+	c.logger().Info("no ECHConfigList found, falling back", "host", host, "fallback", mode, "error", err)
+	r.Fallback = mode
+	r.FallbackReason = err.Error()
+	if mode == ECHFallbackGREASE {
+		return GenerateGREASEECHConfigList(host)
+	}
+	return nil, nil
+}
+
+// probeDial returns how the probe connects: to the address hints of the
+// selected HTTPS record when UseHints is set without ConnectIP and it has
+// some, recording that in r, as configured otherwise.
+func (c *ProbeConfig) probeDial(r *ProbeResult) dialFunc {
+	if !c.useHints() || c.connectIP().IsValid() || r.HTTPSRecord == nil {
+		return c.dialContext()
+	}
+	hints := append(r.HTTPSRecord.IPv4Hints(), r.HTTPSRecord.IPv6Hints()...)
+	if len(hints) == 0 {
+		return c.dialContext()
+	}
+	if c.proxy() != nil {
+		c.logger().Info("ignoring address hints, connections go through the proxy")
+		return c.dialContext()
+	}
+	r.UsedHints = true
+	return c.dialAddrs(hints)
+}
+
+// compareResolved repeats the request of r, which connected to the address
+// hints and failed with err, over the resolved addresses and records in r
+// whether the outcome differs.
+func (c *ProbeConfig) compareResolved(ctx context.Context, r *ProbeResult, targetURL string, echConfigList []byte, err error) {
+	resolved := &ProbeResult{
+		URL:            targetURL,
+		Resolver:       r.Resolver,
+		ECHMode:        r.ECHMode,
+		ECHConfigList:  echConfigList,
+		AdvertisedALPN: r.AdvertisedALPN,
+		WebSocket:      r.WebSocket,
+		Target:         r.Target,
+		AdvertisedPort: r.AdvertisedPort,
+		Port:           r.Port,
+	}
+	r.Resolved = resolved
+	start := time.Now()
+	resolvedErr := c.doRequest(ctx, resolved, targetURL, echConfigList, c.dialContext())
+	resolved.Timings.Total = time.Since(start)
+	if resolvedErr != nil {
+		resolved.setError(resolvedErr)
+	}
+	r.HintsDiffer = resolved.ECHAccepted != r.ECHAccepted ||
+		resolved.ECHRejected != r.ECHRejected ||
+		resolved.StatusCode != r.StatusCode ||
+		resolved.ErrorClass != ClassifyError(err)
+	c.logger().Debug("compared address hints with resolved addresses",
+		"hints_addr", r.RemoteAddr, "resolved_addr", resolved.RemoteAddr, "differ", r.HintsDiffer)
+}
+
+// compareRetryConfigs records in r whether the retry configs supplied by
+// the server differ from the published ECHConfigList, or from the offered
+// one when none was published, and how. Drift usually means DNS still
+// serves the list of keys the server has rotated away from.
+func (c *ProbeConfig) compareRetryConfigs(r *ProbeResult, offered []byte) {
+	if len(r.RetryConfigList) == 0 {
+		return
+	}
+	published := offered
+	if r.ECHConfigList != nil {
+		// The offered list may have been filtered or reordered.
+		published = r.ECHConfigList
+	}
+	r.RetryConfigDiffers = !bytes.Equal(r.RetryConfigList, published)
+	if !r.RetryConfigDiffers {
+		c.logger().Info("server rejected ECH but supplied the published configs as retry configs")
+		return
+	}
+	previous, err1 := ParseECHConfigList(published)
+	current, err2 := ParseECHConfigList(r.RetryConfigList)
+	if err1 == nil && err2 == nil {
+		r.RetryConfigDrift = DiffECHConfigs(previous, current)
+	}
+	c.logger().Info("server retry configs differ from the published ones", "drift", r.RetryConfigDrift)
+}
+
+// retry repeats the request of r once using the RetryConfigList supplied by
+// the server, storing the outcome in r.Retry.
+func (c *ProbeConfig) retry(ctx context.Context, r *ProbeResult, targetURL string, dial dialFunc) error {
+	retry := &ProbeResult{
+		URL:            targetURL,
+		Resolver:       r.Resolver,
+		ECHMode:        r.ECHMode,
+		ECHConfigList:  r.RetryConfigList,
+		AdvertisedALPN: r.AdvertisedALPN,
+		WebSocket:      r.WebSocket,
+		Target:         r.Target,
+		AdvertisedPort: r.AdvertisedPort,
+		Port:           r.Port,
+	}
+	r.Retry = retry
+	c.logger().Info("retrying with server supplied ECH configs", "differs", r.RetryConfigDiffers)
+
+	start := time.Now()
+	defer func() {
+		retry.Timings.Total = time.Since(start)
+	}()
+	configs, err := ParseECHConfigList(r.RetryConfigList)
+	if err != nil {
+		err = fmt.Errorf("failed to parse retry configs: %w", err)
+		retry.setError(err)
+		return err
+	}
+	for i := range configs {
+		retry.ECHConfigs = append(retry.ECHConfigs, NewECHConfigInfo(&configs[i]))
+	}
+	if ec := pickECHConfig(configs); ec != nil {
+		info := NewECHConfigInfo(ec)
+		retry.SelectedECHConfig = &info
+	}
+	offered, skipped, err := FilterECHConfigList(r.RetryConfigList)
+	retry.SkippedECHConfigs = skipped
+	if err != nil {
+		retry.setError(err)
+		return err
+	}
+	if err := c.doRequest(ctx, retry, targetURL, offered, dial); err != nil {
+		retry.setError(err)
+		return err
+	}
+	if c.resume() {
+		c.resumeSession(ctx, retry, targetURL, offered, dial)
+	}
+	return nil
+}
+
+// resolveECHConfigList returns the ECHConfigList to offer to host according
+// to the configured ECHMode, recording what was found or given in r.
+func (c *ProbeConfig) resolveECHConfigList(ctx context.Context, r *ProbeResult, host string) ([]byte, error) {
+	var (
+		parsed *ParsedEchConfig
+		err    error
+	)
+	switch c.echMode() {
+	case ECHModeGREASE:
+		return GenerateGREASEECHConfigList(host)
+	case ECHModeStatic:
+		parsed, err = c.staticECHConfigList(host)
+	default:
+		parsed, err = c.fetchFromSource(ctx, r, host)
+	}
+	if parsed != nil {
+		if parsed.Record != nil {
+			r.HTTPSRecord = parsed.Record
+			r.AdvertisedALPN = parsed.Record.EffectiveALPN()
+			r.AdvertisedPort, _ = parsed.Record.Port()
+		}
+		r.Target = parsed.Target
+		r.HTTPSRecords = parsed.Endpoints
+		r.SkippedRecords = parsed.Skipped
+		r.ParseWarnings = parsed.Warnings
+		r.ConfigIDWarnings = parsed.ConfigIDWarnings
+		if len(parsed.ConfigLists) > 1 {
+			r.ECHConfigLists = parsed.ConfigLists
+		}
+		r.ECHConfigsMerged = parsed.Merged
+		r.AnsweredBy = parsed.Resolver
+		r.CNAMEChain = parsed.CNAMEChain
+		r.AliasChain = parsed.AliasChain
+		r.DNSTruncated = parsed.Truncated
+		r.DNSStale = parsed.Stale
+		r.DNSSuspiciousReplies = parsed.SuspiciousReplies
+		r.DNSSEC = parsed.DNSSEC
+		if parsed.DNSSECError != nil {
+			r.DNSSECError = parsed.DNSSECError.Error()
+		}
+		r.ECHConfigList = parsed.Raw
+		for i := range parsed.Configs {
+			r.ECHConfigs = append(r.ECHConfigs, NewECHConfigInfo(&parsed.Configs[i]))
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if c.echMode() != ECHModeStatic {
+		name := host
+		if r.QueryName != "" {
+			name = r.QueryName
+		}
+		if err := c.checkPin(r, name, parsed.Raw); err != nil {
+			return nil, err
+		}
+	}
+	offered, skipped, err := FilterECHConfigList(parsed.Raw)
+	r.SkippedECHConfigs = skipped
+	for _, reason := range skipped {
+		c.logger().Info("skipping unusable ECHConfig", "host", host, "reason", reason)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if offered, err = c.preferECHConfig(offered); err != nil {
+		return nil, err
+	}
+	configs := parsed.Configs
+	if !bytes.Equal(offered, parsed.Raw) {
+		if configs, err = ParseECHConfigList(offered); err != nil {
+			return nil, err
+		}
+	}
+	if ec := pickECHConfig(configs); ec != nil {
+		info := NewECHConfigInfo(ec)
+		r.SelectedECHConfig = &info
+		if ec.MaxNameLength != 0 && len(host) > int(ec.MaxNameLength) {
+			r.NameLengthWarning = fmt.Sprintf("host is %d bytes long, more than the maximum_name_length %d of config_id %d",
+				len(host), ec.MaxNameLength, ec.ConfigID)
+		}
+		c.hooks().echConfigSelected(host, ec)
+		c.logger().Debug("selected ECHConfig", "config_id", ec.ConfigID, "kem", KEMName(ec.KemID))
+	}
+	return offered, nil
+}
+
+// doRequest performs a GET request for targetURL offering echConfigList,
+// connecting with dial, and records the TLS and HTTP outcome in r. With
+// HandshakeOnly it only performs the TLS handshake.
+func (c *ProbeConfig) doRequest(ctx context.Context, r *ProbeResult, targetURL string, echConfigList []byte, dial dialFunc) error {
+	if c.handshakeOnly() {
+		if c.captureClientHello() {
+			dial = c.recordClientHello(r, dial)
+		}
+		return c.doHandshake(ctx, r, targetURL, echConfigList, dial)
+	}
+	var (
+		handshakeStart time.Time
+		host           string
+	)
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			r.RemoteAddr = info.Conn.RemoteAddr().String()
+		},
+		TLSHandshakeStart: func() {
+			handshakeStart = time.Now()
+		},
+		TLSHandshakeDone: func(cs tls.ConnectionState, err error) {
+			r.Timings.TLSHandshake = time.Since(handshakeStart)
+			c.hooks().tlsHandshakeDone(cs, err)
+			if err != nil {
+				c.logger().Debug("TLS handshake failed", "error", err)
+				return
+			}
+			c.setConnectionState(r, cs, host)
+		},
+	}
+	httpStart := time.Now()
+	defer func() {
+		r.Timings.HTTP = time.Since(httpStart)
+	}()
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace), "GET", targetURL, nil)
+	if err != nil {
+		return err
+	}
+	host = c.certificateHost(req.URL)
+	var key string
+	if r.WebSocket {
+		if key, err = setWebSocketHeaders(req); err != nil {
+			return err
+		}
+	}
+	protos := c.offeredProtocols(r.AdvertisedALPN)
+	if err := checkHTTPProtocols(protos); err != nil {
+		return err
+	}
+	if r.WebSocket {
+		// The opening handshake needs HTTP/1.1 (RFC 6455, section 4.1).
+		protos = []string{"http/1.1"}
+	}
+	if slices.Equal(protos, []string{"h3"}) && (dial != nil || c.proxy() != nil) {
+		// HTTP/3 cannot go through the dialer or the proxy.
+		c.logger().Warn("endpoint only advertises h3, which cannot be used with a proxy or custom addresses; trying TCP")
+		protos = tcpProtocols
+	}
+	engine := c.tlsEngine()
+	if engine != nil {
+		r.TLSEngine = engine.String()
+		if !slices.Equal(protos, []string{"http/1.1"}) {
+			c.logger().Info("offering only http/1.1, the only protocol spoken over the connections of a TLS engine", "engine", engine)
+			protos = []string{"http/1.1"}
+		}
+		if c.proxy() != nil {
+			c.logger().Warn("connections through the proxy use crypto/tls, not the TLS engine", "engine", engine)
+		}
+	}
+	r.OfferedALPN = protos
+	if c.captureClientHello() {
+		if slices.Equal(protos, []string{"h3"}) {
+			c.logger().Warn("the ClientHello of HTTP/3 connections is not captured")
+		} else {
+			dial = c.recordClientHello(r, dial)
+		}
+	}
+	addr := c.connectAddr(req.URL, r.Target, r.Port)
+	client := c.newHTTPClient(echConfigList, dial, protos, addr)
+	// The transport is used for this request only.
+	defer client.CloseIdleConnections()
+	switch transport := client.Transport.(type) {
+	case *http.Transport:
+		r.recordCertificateRequest(transport.TLSClientConfig)
+	case *http3.Transport:
+		r.recordCertificateRequest(transport.TLSClientConfig)
+	}
+	if transport, ok := client.Transport.(*http.Transport); ok && (engine != nil || c.alpn() != nil) {
+		if c.proxy() != nil && engine == nil {
+			c.logger().Warn("net/http adds h2 and http/1.1 to the ALPN offered through the proxy", "alpn", protos)
+		}
+		transport.DialTLSContext = c.dialTLS(r, transport, host)
+	}
+	var earlyConn quic.EarlyConnection
+	if r.EarlyData {
+		if transport, ok := client.Transport.(*http3.Transport); ok {
+			req.Method = http3.MethodGet0RTT
+			transport.Dial = recordQUICConn(transport.Dial, &earlyConn)
+		} else {
+			c.logger().Warn("early data is only sent over HTTP/3, crypto/tls does not send it over TCP")
+			r.EarlyData = false
+		}
+	}
+	resp, err := client.Do(req)
+	if earlyConn != nil {
+		select {
+		case <-earlyConn.HandshakeComplete():
+			r.EarlyDataAccepted = earlyConn.ConnectionState().Used0RTT
+		case <-ctx.Done():
+		}
+	}
+	var echErr *tls.ECHRejectionError
+	if errors.As(err, &echErr) {
+		r.ECHRejected = true
+		r.RetryConfigList = echErr.RetryConfigList
+	}
+	if err != nil {
+		c.checkUnverifiedCertificates(r, err, host)
+		return err
+	}
+	defer resp.Body.Close()
+	if r.TLSVersion == "" && resp.TLS != nil {
+		// HTTP/3 does not report the handshake through httptrace.
+		c.hooks().tlsHandshakeDone(*resp.TLS, nil)
+		c.setConnectionState(r, *resp.TLS, host)
+	}
+	r.ALPNMismatch = alpnMismatch(r.AdvertisedALPN, r.ALPN)
+	r.StatusCode = resp.StatusCode
+	if r.WebSocket {
+		// The body of a 101 response is the WebSocket connection.
+		return checkWebSocketUpgrade(resp, key)
+	}
+	r.Body, err = io.ReadAll(resp.Body)
+	r.BodyLength = len(r.Body)
+	return err
+}
@@ -0,0 +1,93 @@
+package echclient
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// DefaultDNSBackoff is the delay before the first retry of a failed DNS
+// query when ProbeConfig.DNSBackoff is zero. It doubles with every attempt.
+const DefaultDNSBackoff = 250 * time.Millisecond
+
+// DNSAttempt records one attempt at a DNS query.
+type DNSAttempt struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Attempt int    `json:"attempt"`
+
+	// Resolver names the resolver that answered, or the configured one
+	// if the attempt failed, and Transport is its ResolverTransport.
+	Resolver  string `json:"resolver"`
+	Transport string `json:"transport,omitempty"`
+
+	RTT   time.Duration `json:"rtt"`
+	Error string        `json:"error,omitempty"`
+}
+
+// dnsTrace collects the DNSAttempts of the queries made with a context.
+type dnsTrace struct {
+	mu       sync.Mutex
+	attempts []DNSAttempt
+}
+
+type dnsTraceKey struct{}
+
+// withDNSTrace returns a context recording the DNS attempts made with it
+// in the returned trace.
+func withDNSTrace(ctx context.Context) (context.Context, *dnsTrace) {
+	t := &dnsTrace{}
+	return context.WithValue(ctx, dnsTraceKey{}, t), t
+}
+
+func (t *dnsTrace) add(a DNSAttempt) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.attempts = append(t.attempts, a)
+}
+
+// snapshot returns the attempts recorded so far.
+func (t *dnsTrace) snapshot() []DNSAttempt {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]DNSAttempt(nil), t.attempts...)
+}
+
+func traceDNSAttempt(ctx context.Context, a DNSAttempt) {
+	if t, ok := ctx.Value(dnsTraceKey{}).(*dnsTrace); ok {
+		t.add(a)
+	}
+}
+
+// retryable reports whether a failed query may succeed when repeated.
+// Authoritative answers such as NXDOMAIN are final, SERVFAIL and transport
+// errors are not.
+func retryable(err error) bool {
+	var statusErr *DNSStatusError
+	if errors.As(err, &statusErr) {
+		return errors.Is(err, ErrServFail)
+	}
+	return !errors.Is(err, context.Canceled)
+}
+
+// backoff returns the delay before the given retry, starting at 1.
+func (c *ProbeConfig) backoff(retry int) time.Duration {
+	d := DefaultDNSBackoff
+	if c != nil && c.DNSBackoff > 0 {
+		d = c.DNSBackoff
+	}
+	return d << (retry - 1)
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
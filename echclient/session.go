@@ -0,0 +1,198 @@
+package echclient
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// SessionCache is a tls.ClientSessionCache that can be saved to a file, so
+// that sessions can be resumed across invocations. It is safe for
+// concurrent use. The file holds resumption secrets and is written readable
+// by its owner only.
+type SessionCache struct {
+	mu       sync.Mutex
+	sessions map[string]*tls.ClientSessionState
+}
+
+// NewSessionCache returns an empty SessionCache.
+func NewSessionCache() *SessionCache {
+	return &SessionCache{sessions: map[string]*tls.ClientSessionState{}}
+}
+
+// Get implements tls.ClientSessionCache.
+func (c *SessionCache) Get(sessionKey string) (*tls.ClientSessionState, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	session, ok := c.sessions[sessionKey]
+	return session, ok
+}
+
+// Put implements tls.ClientSessionCache. A nil session removes the entry.
+func (c *SessionCache) Put(sessionKey string, cs *tls.ClientSessionState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cs == nil {
+		delete(c.sessions, sessionKey)
+		return
+	}
+	c.sessions[sessionKey] = cs
+}
+
+// sessionFileEntry is the JSON encoding of a session in a session cache
+// file.
+type sessionFileEntry struct {
+	Key    string `json:"key"`
+	Ticket []byte `json:"ticket"`
+	State  []byte `json:"state"`
+}
+
+// LoadSessionCache returns a SessionCache holding the sessions of the JSON
+// file at path, as written by Save. A missing file yields an empty cache.
+// crypto/tls discards the expired sessions when offering them.
+func LoadSessionCache(path string) (*SessionCache, error) {
+	c := NewSessionCache()
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []sessionFileEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("invalid session cache file %s: %w", path, err)
+	}
+	for _, e := range entries {
+		state, err := tls.ParseSessionState(e.State)
+		if err != nil {
+			return nil, fmt.Errorf("invalid session for %s in %s: %w", e.Key, path, err)
+		}
+		session, err := tls.NewResumptionState(e.Ticket, state)
+		if err != nil {
+			return nil, fmt.Errorf("invalid session for %s in %s: %w", e.Key, path, err)
+		}
+		c.sessions[e.Key] = session
+	}
+	return c, nil
+}
+
+// Save writes the sessions of c to the JSON file at path, replacing it
+// atomically.
+func (c *SessionCache) Save(path string) error {
+	c.mu.Lock()
+	entries := make([]sessionFileEntry, 0, len(c.sessions))
+	for key, session := range c.sessions {
+		ticket, state, err := session.ResumptionState()
+		if err != nil || state == nil {
+			continue
+		}
+		data, err := state.Bytes()
+		if err != nil {
+			continue
+		}
+		entries = append(entries, sessionFileEntry{Key: key, Ticket: ticket, State: data})
+	}
+	c.mu.Unlock()
+	slices.SortFunc(entries, func(a, b sessionFileEntry) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// postHandshakeWait is how long handshake-only probes read from the
+// connection for the messages TLS 1.3 servers send after the handshake.
+const postHandshakeWait = time.Second
+
+// readAfterHandshake reads from conn for postHandshakeWait so that
+// crypto/tls processes the session tickets sent after the handshake, and
+// returns the alert of a server rejecting the client certificate, which
+// TLS 1.3 only sends then. Application data is discarded.
+func readAfterHandshake(conn net.Conn) error {
+	conn.SetReadDeadline(time.Now().Add(postHandshakeWait))
+	buf := make([]byte, 1024)
+	for {
+		_, err := conn.Read(buf)
+		var netErr net.Error
+		switch {
+		case err == nil:
+		case errors.Is(err, io.EOF), errors.As(err, &netErr) && netErr.Timeout():
+			return nil
+		default:
+			return err
+		}
+	}
+}
+
+// withSessionCache returns c, or a copy of it caching sessions in memory if
+// TLSConfig has no ClientSessionCache, so that the requests of Resume
+// share their sessions.
+func (c *ProbeConfig) withSessionCache() *ProbeConfig {
+	if c.TLSConfig != nil && c.TLSConfig.ClientSessionCache != nil {
+		return c
+	}
+	cfg := *c
+	cfg.TLSConfig = c.tlsConfig(nil)
+	cfg.TLSConfig.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	return &cfg
+}
+
+// resumeSession repeats the successful request of r on a new connection,
+// which should resume the TLS session of the first and still have ECH
+// accepted, storing the outcome in r.Resumption.
+func (c *ProbeConfig) resumeSession(ctx context.Context, r *ProbeResult, targetURL string, echConfigList []byte, dial dialFunc) {
+	resumed := &ProbeResult{
+		URL:            targetURL,
+		Resolver:       r.Resolver,
+		ECHMode:        r.ECHMode,
+		ECHConfigList:  r.ECHConfigList,
+		AdvertisedALPN: r.AdvertisedALPN,
+		WebSocket:      r.WebSocket,
+		Target:         r.Target,
+		AdvertisedPort: r.AdvertisedPort,
+		Port:           r.Port,
+		EarlyData:      c.earlyData(),
+	}
+	r.Resumption = resumed
+	start := time.Now()
+	err := c.doRequest(ctx, resumed, targetURL, echConfigList, dial)
+	resumed.Timings.Total = time.Since(start)
+	if err != nil {
+		resumed.setError(err)
+	}
+	c.logger().Debug("repeated the request to resume the session",
+		"did_resume", resumed.DidResume, "ech_accepted", resumed.ECHAccepted,
+		"early_data_accepted", resumed.EarlyDataAccepted, "error", err)
+}
+
+// recordQUICConn returns a dial function for http3.Transport connecting
+// with dial, or quic.DialAddrEarly if nil, and storing the connection in
+// conn.
+func recordQUICConn(dial func(context.Context, string, *tls.Config, *quic.Config) (quic.EarlyConnection, error), conn *quic.EarlyConnection) func(context.Context, string, *tls.Config, *quic.Config) (quic.EarlyConnection, error) {
+	if dial == nil {
+		dial = quic.DialAddrEarly
+	}
+	return func(ctx context.Context, addr string, tlsConf *tls.Config, conf *quic.Config) (quic.EarlyConnection, error) {
+		c, err := dial(ctx, addr, tlsConf, conf)
+		if err == nil {
+			*conn = c
+		}
+		return c, err
+	}
+}
@@ -0,0 +1,162 @@
+package echclient
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Violations returns the requirements of RFC 9460 that r breaks without
+// preventing its decoding: SvcParamKeys not in strictly increasing order,
+// within the RDATA or the mandatory list, values of registered keys with
+// the wrong length or format, and bytes after the last SvcParam. The
+// offending keys are given by number. A record without violations yields
+// nil.
+func (r *HttpsRecord) Violations() []string {
+	keys := make([]uint16, len(r.Params))
+	for i, p := range r.Params {
+		keys[i] = p.Key
+	}
+	violations := checkKeyOrder("SvcParamKey", keys)
+	for _, p := range r.Params {
+		if reason := checkValue(p); reason != "" {
+			violations = append(violations, fmt.Sprintf("%s: %s", SvcParamKeyName(p.Key), reason))
+		}
+	}
+	if r.trailing > 0 {
+		violations = append(violations, fmt.Sprintf("%d trailing bytes after the SvcParams", r.trailing))
+	}
+	return violations
+}
+
+// checkKeyOrder returns a violation for every key of keys that does not
+// follow the previous one in strictly increasing order (RFC 9460, sections
+// 2.2 and 8).
+func checkKeyOrder(what string, keys []uint16) []string {
+	var violations []string
+	for i := 1; i < len(keys); i++ {
+		switch {
+		case keys[i] == keys[i-1]:
+			violations = append(violations, fmt.Sprintf("duplicate %s %s", what, keyLabel(keys[i])))
+		case keys[i] < keys[i-1]:
+			violations = append(violations, fmt.Sprintf("%s %s out of order after %s", what, keyLabel(keys[i]), keyLabel(keys[i-1])))
+		}
+	}
+	return violations
+}
+
+// keyLabel returns the number of key followed by its name if registered,
+// as in "5 (ech)".
+func keyLabel(key uint16) string {
+	if name, ok := svcParamKeyNames[key]; ok {
+		return fmt.Sprintf("%d (%s)", key, name)
+	}
+	return strconv.Itoa(int(key))
+}
+
+// checkValue returns why the value of a registered SvcParam is malformed,
+// or an empty string if it is not.
+func checkValue(p SvcParam) string {
+	v := p.Value
+	switch p.Key {
+	case SvcParamMandatory:
+		if len(v) == 0 || len(v)%2 != 0 {
+			return fmt.Sprintf("length %d is not a non-zero multiple of 2", len(v))
+		}
+		keys := decodeKeyList(v)
+		if slices.Contains(keys, SvcParamMandatory) {
+			return "lists key 0 (mandatory)"
+		}
+		if order := checkKeyOrder("key", keys); order != nil {
+			return strings.Join(order, ", ")
+		}
+	case SvcParamALPN:
+		if decodeALPN(v) == nil {
+			return "malformed or empty protocol list"
+		}
+	case SvcParamNoDefaultALPN, SvcParamOHTTP:
+		if len(v) != 0 {
+			return fmt.Sprintf("unexpected %d byte value", len(v))
+		}
+	case SvcParamPort:
+		if len(v) != 2 {
+			return fmt.Sprintf("length %d, want 2", len(v))
+		}
+	case SvcParamIPv4Hint:
+		if len(v) == 0 || len(v)%4 != 0 {
+			return fmt.Sprintf("length %d is not a non-zero multiple of 4", len(v))
+		}
+	case SvcParamIPv6Hint:
+		if len(v) == 0 || len(v)%16 != 0 {
+			return fmt.Sprintf("length %d is not a non-zero multiple of 16", len(v))
+		}
+	case SvcParamECH:
+		if len(v) == 0 {
+			return "empty ECHConfigList"
+		}
+	}
+	return ""
+}
+
+// Violations returns the requirements of the ECH specification that the
+// configs of l break without preventing their decoding: bytes after the
+// extensions, a missing public key, cipher suite or public_name. A list
+// without violations yields nil.
+func (l ECHConfigList) Violations() []string {
+	var violations []string
+	for _, ec := range l {
+		if ec.Version != extensionEncryptedClientHello {
+			// Older drafts are not held to the current one.
+			continue
+		}
+		var reasons []string
+		if ec.trailing > 0 {
+			reasons = append(reasons, fmt.Sprintf("%d trailing bytes after the extensions", ec.trailing))
+		}
+		if len(ec.PublicKey) == 0 {
+			reasons = append(reasons, "empty public key")
+		}
+		if len(ec.SymmetricCipherSuite) == 0 {
+			reasons = append(reasons, "no cipher suite")
+		}
+		if len(ec.PublicName) == 0 {
+			reasons = append(reasons, "empty public_name")
+		}
+		for _, reason := range reasons {
+			violations = append(violations, fmt.Sprintf("ECHConfig %d: %s", ec.ConfigID, reason))
+		}
+	}
+	return violations
+}
+
+// checkRecords returns the violations of records as warnings, prefixed by
+// the record they were found in, or an ErrMalformedRR error listing them
+// when Strict is set.
+func (c *ProbeConfig) checkRecords(hostname string, records []*HttpsRecord) ([]string, error) {
+	var warnings []string
+	for _, r := range records {
+		for _, v := range r.Violations() {
+			warnings = append(warnings, fmt.Sprintf("HTTPS record %d %s: %s", r.Priority, r.TargetName, v))
+		}
+	}
+	if len(warnings) > 0 && c.strict() {
+		return nil, fmt.Errorf("%w for %s: %s", ErrMalformedRR, hostname, strings.Join(warnings, "; "))
+	}
+	for _, w := range warnings {
+		c.logger().Debug("HTTPS record violates RFC 9460", "name", hostname, "violation", w)
+	}
+	return warnings, nil
+}
+
+// checkConfigs is checkRecords for the configs of an ECHConfigList.
+func (c *ProbeConfig) checkConfigs(hostname string, configs ECHConfigList) ([]string, error) {
+	warnings := configs.Violations()
+	if len(warnings) > 0 && c.strict() {
+		return nil, fmt.Errorf("%w for %s: %s", ErrMalformedECHConfig, hostname, strings.Join(warnings, "; "))
+	}
+	for _, w := range warnings {
+		c.logger().Debug("ECHConfigList violates the ECH specification", "name", hostname, "violation", w)
+	}
+	return warnings, nil
+}
@@ -0,0 +1,372 @@
+package echclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"net/url"
+
+	"github.com/cloudflare/circl/hpke"
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// SuiteProbe is the outcome of a ClientHello encrypted to a published config
+// with a single HPKE cipher suite.
+type SuiteProbe struct {
+	ConfigID uint8     `json:"config_id"`
+	KEM      string    `json:"kem"`
+	Cipher   ECHCipher `json:"cipher_suite"`
+
+	// Advertised records that the config lists the cipher suite.
+	Advertised bool `json:"advertised"`
+
+	// Accepted records that the server decrypted the ClientHelloInner,
+	// Rejected that it went on with the ClientHelloOuter.
+	Accepted bool `json:"accepted"`
+	Rejected bool `json:"rejected"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// SuiteScan is the outcome of ScanECHSuites.
+type SuiteScan struct {
+	URL           string       `json:"url"`
+	RemoteAddr    string       `json:"remote_addr,omitempty"`
+	ECHConfigList []byte       `json:"ech_config_list"`
+	Probes        []SuiteProbe `json:"probes"`
+}
+
+// ScanECHSuites enumerates the HPKE cipher suites the ECH deployment of
+// targetURL accepts, as testssl.sh does for TLS cipher suites. For each
+// config of the ECHConfigList, whatever its KEM, it sends a ClientHello
+// encrypted with every registered (KDF, AEAD) pair, advertised or not, and
+// reads from the ServerHello whether the server accepted it (RFC 9849,
+// section 7.2). The configs are offered unmodified, as they are bound to the
+// HPKE context byte for byte; the handshakes are not completed. Connections
+// go to the endpoint a probe would use, without the proxy.
+func (c *ProbeConfig) ScanECHSuites(ctx context.Context, targetURL string) (*SuiteScan, error) {
+	u, err := url.Parse(targetURL)
+	if err != nil {
+		return nil, err
+	}
+	host, err := ToASCIIHost(u.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	r := &ProbeResult{URL: targetURL, ECHMode: c.echMode(), QueryName: HTTPSQueryName(u)}
+	if r.ECHMode == ECHModeGREASE {
+		return nil, errors.New("cannot scan the cipher suites of GREASE ECH")
+	}
+	echConfigList, err := c.resolveECHConfigList(ctx, r, host)
+	if err != nil {
+		return nil, err
+	}
+	scan := &SuiteScan{URL: targetURL, ECHConfigList: echConfigList}
+	if r.ECHConfigList != nil {
+		// Scan the published configs, not only those crypto/tls can use.
+		scan.ECHConfigList = r.ECHConfigList
+	}
+	configs, err := ParseECHConfigList(scan.ECHConfigList)
+	if err != nil {
+		return nil, err
+	}
+	r.Port = c.connectPort(u, r.HTTPSRecord)
+	addr := c.connectAddr(u, r.Target, r.Port)
+	if addr == "" {
+		addr = net.JoinHostPort(host, urlPort(u))
+	}
+	dial := c.probeDial(r)
+	if dial == nil {
+		var dialer net.Dialer
+		dial = dialer.DialContext
+	}
+	for i := range configs {
+		ec := &configs[i]
+		if ec.Version != extensionEncryptedClientHello {
+			continue
+		}
+		for _, kdf := range []uint16{HKDFSHA256, HKDFSHA384, HKDFSHA512} {
+			for _, aead := range []uint16{AES128GCM, AES256GCM, ChaCha20Poly1305} {
+				p := SuiteProbe{ConfigID: ec.ConfigID, KEM: KEMName(ec.KemID), Cipher: ECHCipher{KDFID: kdf, AEADID: aead}}
+				for _, cs := range ec.SymmetricCipherSuite {
+					p.Advertised = p.Advertised || cs == p.Cipher
+				}
+				accepted, remoteAddr, err := c.probeSuite(ctx, dial, addr, host, ec, p.Cipher)
+				if remoteAddr != "" {
+					scan.RemoteAddr = remoteAddr
+				}
+				if err != nil {
+					p.Error = err.Error()
+				} else {
+					p.Accepted, p.Rejected = accepted, !accepted
+				}
+				c.logger().Debug("probed ECH cipher suite", "config_id", p.ConfigID, "kem", p.KEM, "cipher_suite", p.Cipher.String(),
+					"advertised", p.Advertised, "accepted", p.Accepted, "error", p.Error)
+				scan.Probes = append(scan.Probes, p)
+			}
+		}
+	}
+	return scan, nil
+}
+
+// probeSuite sends to addr a ClientHello for host encrypted to ec with cs and
+// reports whether the ServerHello confirms that ECH was accepted.
+func (c *ProbeConfig) probeSuite(ctx context.Context, dial dialFunc, addr, host string, ec *ECHConfig, cs ECHCipher) (bool, string, error) {
+	hello, inner, err := sealClientHello(host, ec, cs)
+	if err != nil {
+		return false, "", err
+	}
+	if timeout := c.timeout(); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	conn, err := dial(ctx, "tcp", addr)
+	if err != nil {
+		return false, "", err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	remoteAddr := conn.RemoteAddr().String()
+	record := []byte{22, 3, 1, byte(len(hello) >> 8), byte(len(hello))}
+	if _, err := conn.Write(append(record, hello...)); err != nil {
+		return false, remoteAddr, err
+	}
+	serverHello, err := readServerHello(conn)
+	if err != nil {
+		return false, remoteAddr, err
+	}
+	accepted, err := echAccepted(inner, serverHello)
+	return accepted, remoteAddr, err
+}
+
+// helloRetryRequestRandom is the random of a HelloRetryRequest (RFC 8446,
+// section 4.1.3).
+var helloRetryRequestRandom = []byte{
+	0xcf, 0x21, 0xad, 0x74, 0xe5, 0x9a, 0x61, 0x11, 0xbe, 0x1d, 0x8c, 0x02, 0x1e, 0x65, 0xb8, 0x91,
+	0xc2, 0xa2, 0x11, 0x16, 0x7a, 0xbb, 0x8c, 0x5e, 0x07, 0x9e, 0x09, 0xe2, 0xc8, 0xa8, 0x33, 0x9c,
+}
+
+// sealClientHello returns a ClientHelloOuter handshake message for the
+// public_name of ec carrying a ClientHelloInner for host encrypted with cs,
+// and the ClientHelloInner message the server would decrypt.
+func sealClientHello(host string, ec *ECHConfig, cs ECHCipher) ([]byte, []byte, error) {
+	kem, kdf, aead := hpke.KEM(ec.KemID), hpke.KDF(cs.KDFID), hpke.AEAD(cs.AEADID)
+	if !kem.IsValid() || !kdf.IsValid() || !aead.IsValid() {
+		return nil, nil, fmt.Errorf("HPKE suite %s/%s is not implemented", KEMName(ec.KemID), cs)
+	}
+	pk, err := kem.Scheme().UnmarshalBinaryPublicKey(ec.PublicKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: public key: %v", ErrMalformedECHConfig, err)
+	}
+	sender, err := hpke.NewSuite(kem, kdf, aead).NewSender(pk, append([]byte("tls ech\x00"), ec.Raw()...))
+	if err != nil {
+		return nil, nil, err
+	}
+	enc, sealer, err := sender.Setup(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	share, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	var innerRandom, outerRandom, sessionID [32]byte
+	rand.Read(innerRandom[:])
+	rand.Read(outerRandom[:])
+	rand.Read(sessionID[:])
+	innerExt := []byte{1}
+	// The server decrypts the EncodedClientHelloInner, without the
+	// session ID, and restores the one of the ClientHelloOuter.
+	encoded := clientHelloBody(innerRandom[:], nil, host, share.PublicKey().Bytes(), innerExt)
+	inner := handshakeMessage(1, clientHelloBody(innerRandom[:], sessionID[:], host, share.PublicKey().Bytes(), innerExt))
+
+	payload := make([]byte, len(encoded)+int(aead.CipherLen(0)))
+	outerExt, err := generateOuterECHExt(ec.ConfigID, cs.KDFID, cs.AEADID, enc, payload)
+	if err != nil {
+		return nil, nil, err
+	}
+	aad := clientHelloBody(outerRandom[:], sessionID[:], string(ec.PublicName), share.PublicKey().Bytes(), outerExt)
+	if payload, err = sealer.Seal(encoded, aad); err != nil {
+		return nil, nil, err
+	}
+	if outerExt, err = generateOuterECHExt(ec.ConfigID, cs.KDFID, cs.AEADID, enc, payload); err != nil {
+		return nil, nil, err
+	}
+	outer := handshakeMessage(1, clientHelloBody(outerRandom[:], sessionID[:], string(ec.PublicName), share.PublicKey().Bytes(), outerExt))
+	return outer, inner, nil
+}
+
+// clientHelloBody encodes a TLS 1.3 ClientHello for serverName with an
+// X25519 key share and the encrypted_client_hello extension ech.
+func clientHelloBody(random, sessionID []byte, serverName string, keyShare, ech []byte) []byte {
+	var b cryptobyte.Builder
+	b.AddUint16(0x0303)
+	b.AddBytes(random)
+	b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(sessionID) })
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddUint16(0x1301) // TLS_AES_128_GCM_SHA256
+		b.AddUint16(0x1302) // TLS_AES_256_GCM_SHA384
+		b.AddUint16(0x1303) // TLS_CHACHA20_POLY1305_SHA256
+	})
+	b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) { b.AddUint8(0) })
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		extension := func(typ uint16, body func(b *cryptobyte.Builder)) {
+			b.AddUint16(typ)
+			b.AddUint16LengthPrefixed(body)
+		}
+		extension(0, func(b *cryptobyte.Builder) { // server_name
+			b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+				b.AddUint8(0)
+				b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes([]byte(serverName)) })
+			})
+		})
+		extension(10, func(b *cryptobyte.Builder) { // supported_groups
+			b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddUint16(0x001d) })
+		})
+		extension(13, func(b *cryptobyte.Builder) { // signature_algorithms
+			b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+				for _, alg := range []uint16{0x0403, 0x0503, 0x0804, 0x0805, 0x0806, 0x0807, 0x0401, 0x0501, 0x0601} {
+					b.AddUint16(alg)
+				}
+			})
+		})
+		extension(16, func(b *cryptobyte.Builder) { // application_layer_protocol_negotiation
+			b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+				for _, proto := range tcpProtocols {
+					b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes([]byte(proto)) })
+				}
+			})
+		})
+		extension(43, func(b *cryptobyte.Builder) { // supported_versions
+			b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) { b.AddUint16(0x0304) })
+		})
+		extension(51, func(b *cryptobyte.Builder) { // key_share
+			b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+				b.AddUint16(0x001d)
+				b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(keyShare) })
+			})
+		})
+		extension(extensionEncryptedClientHello, func(b *cryptobyte.Builder) { b.AddBytes(ech) })
+	})
+	return b.BytesOrPanic()
+}
+
+func handshakeMessage(typ uint8, body []byte) []byte {
+	return append([]byte{typ, byte(len(body) >> 16), byte(len(body) >> 8), byte(len(body))}, body...)
+}
+
+// readServerHello reads the first handshake message of the server, which
+// must be a ServerHello or a HelloRetryRequest.
+func readServerHello(r io.Reader) ([]byte, error) {
+	var msg []byte
+	length := func() int { return 4 + (int(msg[1])<<16 | int(msg[2])<<8 | int(msg[3])) }
+	for len(msg) < 4 || len(msg) < length() {
+		var header [5]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return nil, err
+		}
+		fragment := make([]byte, int(header[3])<<8|int(header[4]))
+		if _, err := io.ReadFull(r, fragment); err != nil {
+			return nil, err
+		}
+		switch header[0] {
+		case 21:
+			if len(fragment) == 2 {
+				return nil, fmt.Errorf("server sent TLS alert %d", fragment[1])
+			}
+			return nil, errors.New("server sent a malformed TLS alert")
+		case 22:
+			msg = append(msg, fragment...)
+		default:
+			return nil, fmt.Errorf("unexpected TLS record type %d", header[0])
+		}
+	}
+	if msg[0] != 2 {
+		return nil, fmt.Errorf("unexpected TLS handshake message type %d", msg[0])
+	}
+	return msg[:length()], nil
+}
+
+// echAccepted checks the ECH acceptance confirmation of serverHello, a
+// ServerHello or HelloRetryRequest answering the ClientHelloInner inner
+// (RFC 9849, section 7.2).
+func echAccepted(inner, serverHello []byte) (bool, error) {
+	s := cryptobyte.String(serverHello[4:])
+	var (
+		version, suite uint16
+		random         []byte
+		sessionID      cryptobyte.String
+		compression    uint8
+		extensions     cryptobyte.String
+	)
+	if !s.ReadUint16(&version) || !s.ReadBytes(&random, 32) || !s.ReadUint8LengthPrefixed(&sessionID) ||
+		!s.ReadUint16(&suite) || !s.ReadUint8(&compression) || !s.ReadUint16LengthPrefixed(&extensions) {
+		return false, errors.New("malformed ServerHello")
+	}
+	newHash := sha256.New
+	if suite == 0x1302 {
+		newHash = sha512.New384
+	}
+	// The confirmation is the last 8 bytes of the random of a
+	// ServerHello, or the encrypted_client_hello extension of a
+	// HelloRetryRequest, computed over the message with them zeroed.
+	zeroed := bytes.Clone(serverHello)
+	label := "ech accept confirmation"
+	transcript := newHash()
+	transcript.Write(inner)
+	var confirmation []byte
+	if !bytes.Equal(random, helloRetryRequestRandom) {
+		confirmation = random[24:]
+		clear(zeroed[4+2+24 : 4+2+32])
+	} else {
+		label = "hrr ech accept confirmation"
+		offset := len(serverHello) - len(extensions)
+		for !extensions.Empty() {
+			var typ uint16
+			var body cryptobyte.String
+			if !extensions.ReadUint16(&typ) || !extensions.ReadUint16LengthPrefixed(&body) {
+				return false, errors.New("malformed HelloRetryRequest")
+			}
+			offset += 4
+			if typ == extensionEncryptedClientHello && len(body) == 8 {
+				confirmation = body
+				clear(zeroed[offset : offset+8])
+			}
+			offset += len(body)
+		}
+		if confirmation == nil {
+			return false, nil
+		}
+		// The ClientHello is replaced by its hash (RFC 8446, section
+		// 4.4.1).
+		sum := transcript.Sum(nil)
+		transcript.Reset()
+		transcript.Write(handshakeMessage(254, sum))
+	}
+	transcript.Write(zeroed)
+	secret := hmacSum(newHash, make([]byte, newHash().Size()), inner[4+2:4+2+32])
+	var info cryptobyte.Builder
+	info.AddUint16(8)
+	info.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes([]byte("tls13 " + label)) })
+	info.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(transcript.Sum(nil)) })
+	expected := hmacSum(newHash, secret, append(info.BytesOrPanic(), 1))[:8]
+	return hmac.Equal(expected, confirmation), nil
+}
+
+func hmacSum(newHash func() hash.Hash, key, data []byte) []byte {
+	mac := hmac.New(newHash, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,308 @@
+package echclient
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// SvcParamKeys registered by RFC 9460, RFC 9461 and RFC 9540.
+const (
+	SvcParamMandatory     uint16 = 0
+	SvcParamALPN          uint16 = 1
+	SvcParamNoDefaultALPN uint16 = 2
+	SvcParamPort          uint16 = 3
+	SvcParamIPv4Hint      uint16 = 4
+	SvcParamECH           uint16 = 5
+	SvcParamIPv6Hint      uint16 = 6
+	SvcParamDoHPath       uint16 = 7
+	SvcParamOHTTP         uint16 = 8
+)
+
+var svcParamKeyNames = map[uint16]string{
+	SvcParamMandatory:     "mandatory",
+	SvcParamALPN:          "alpn",
+	SvcParamNoDefaultALPN: "no-default-alpn",
+	SvcParamPort:          "port",
+	SvcParamIPv4Hint:      "ipv4hint",
+	SvcParamECH:           "ech",
+	SvcParamIPv6Hint:      "ipv6hint",
+	SvcParamDoHPath:       "dohpath",
+	SvcParamOHTTP:         "ohttp",
+}
+
+// MandatoryParam returns a mandatory SvcParam listing keys.
+func MandatoryParam(keys ...uint16) SvcParam {
+	v := make([]byte, 0, 2*len(keys))
+	for _, k := range keys {
+		v = append(v, byte(k>>8), byte(k))
+	}
+	return SvcParam{Key: SvcParamMandatory, Value: v}
+}
+
+// ALPNParam returns an alpn SvcParam listing protos, which must be 1 to 255
+// octets long.
+func ALPNParam(protos ...string) SvcParam {
+	var v []byte
+	for _, p := range protos {
+		v = append(v, byte(len(p)))
+		v = append(v, p...)
+	}
+	return SvcParam{Key: SvcParamALPN, Value: v}
+}
+
+// NoDefaultALPNParam returns a no-default-alpn SvcParam.
+func NoDefaultALPNParam() SvcParam {
+	return SvcParam{Key: SvcParamNoDefaultALPN, Value: []byte{}}
+}
+
+// PortParam returns a port SvcParam.
+func PortParam(port uint16) SvcParam {
+	return SvcParam{Key: SvcParamPort, Value: []byte{byte(port >> 8), byte(port)}}
+}
+
+// IPv4HintParam returns an ipv4hint SvcParam listing addrs, which must be
+// IPv4 addresses.
+func IPv4HintParam(addrs ...netip.Addr) SvcParam {
+	var v []byte
+	for _, a := range addrs {
+		v = append(v, a.Unmap().AsSlice()...)
+	}
+	return SvcParam{Key: SvcParamIPv4Hint, Value: v}
+}
+
+// ECHParam returns an ech SvcParam carrying echConfigList.
+func ECHParam(echConfigList []byte) SvcParam {
+	return SvcParam{Key: SvcParamECH, Value: echConfigList}
+}
+
+// IPv6HintParam returns an ipv6hint SvcParam listing addrs, which must be
+// IPv6 addresses.
+func IPv6HintParam(addrs ...netip.Addr) SvcParam {
+	var v []byte
+	for _, a := range addrs {
+		v = append(v, a.AsSlice()...)
+	}
+	return SvcParam{Key: SvcParamIPv6Hint, Value: v}
+}
+
+// MarshalJSON adds the presentation name of the key to the JSON encoding
+// of p, so that outputs need not be read with the registry at hand.
+func (p SvcParam) MarshalJSON() ([]byte, error) {
+	type svcParam SvcParam
+	return json.Marshal(struct {
+		Name string `json:"name"`
+		svcParam
+	}{SvcParamKeyName(p.Key), svcParam(p)})
+}
+
+// Param returns the value of the SvcParam with the given key.
+func (r *HttpsRecord) Param(key uint16) ([]byte, bool) {
+	for _, p := range r.Params {
+		if p.Key == key {
+			return p.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Mandatory returns the keys listed in the mandatory SvcParam.
+func (r *HttpsRecord) Mandatory() []uint16 {
+	v, _ := r.Param(SvcParamMandatory)
+	return decodeKeyList(v)
+}
+
+// ALPN returns the protocol identifiers listed in the alpn SvcParam.
+func (r *HttpsRecord) ALPN() []string {
+	v, _ := r.Param(SvcParamALPN)
+	return decodeALPN(v)
+}
+
+// NoDefaultALPN reports whether the no-default-alpn SvcParam is present.
+func (r *HttpsRecord) NoDefaultALPN() bool {
+	_, ok := r.Param(SvcParamNoDefaultALPN)
+	return ok
+}
+
+// OHTTP reports whether the ohttp SvcParam is present, which advertises an
+// Oblivious HTTP gateway (RFC 9540).
+func (r *HttpsRecord) OHTTP() bool {
+	_, ok := r.Param(SvcParamOHTTP)
+	return ok
+}
+
+// Port returns the value of the port SvcParam.
+func (r *HttpsRecord) Port() (uint16, bool) {
+	v, ok := r.Param(SvcParamPort)
+	if !ok || len(v) != 2 {
+		return 0, false
+	}
+	return uint16(v[0])<<8 | uint16(v[1]), true
+}
+
+// IPv4Hints returns the addresses listed in the ipv4hint SvcParam.
+func (r *HttpsRecord) IPv4Hints() []netip.Addr {
+	v, _ := r.Param(SvcParamIPv4Hint)
+	return decodeAddrs(v, 4)
+}
+
+// IPv6Hints returns the addresses listed in the ipv6hint SvcParam.
+func (r *HttpsRecord) IPv6Hints() []netip.Addr {
+	v, _ := r.Param(SvcParamIPv6Hint)
+	return decodeAddrs(v, 16)
+}
+
+// ECHConfigList returns the raw ECHConfigList carried in the ech SvcParam.
+// A record repeating the key, against RFC 9460, yields the first one.
+func (r *HttpsRecord) ECHConfigList() []byte {
+	v, _ := r.Param(SvcParamECH)
+	return v
+}
+
+// ECHConfigLists returns the raw ECHConfigLists of every ech SvcParam of
+// r, which only a malformed record has more than one of.
+func (r *HttpsRecord) ECHConfigLists() [][]byte {
+	var lists [][]byte
+	for _, p := range r.Params {
+		if p.Key == SvcParamECH {
+			lists = append(lists, p.Value)
+		}
+	}
+	return lists
+}
+
+// DoHPath returns the URI template of the dohpath SvcParam of a DNS server
+// SVCB record (RFC 9461).
+func (r *HttpsRecord) DoHPath() (string, bool) {
+	v, ok := r.Param(SvcParamDoHPath)
+	return string(v), ok
+}
+
+func decodeKeyList(v []byte) []uint16 {
+	if len(v)%2 != 0 {
+		return nil
+	}
+	var keys []uint16
+	for i := 0; i < len(v); i += 2 {
+		keys = append(keys, uint16(v[i])<<8|uint16(v[i+1]))
+	}
+	return keys
+}
+
+func decodeALPN(v []byte) []string {
+	s := cryptobyte.String(v)
+	var protos []string
+	for !s.Empty() {
+		var proto cryptobyte.String
+		if !s.ReadUint8LengthPrefixed(&proto) || proto.Empty() {
+			return nil
+		}
+		protos = append(protos, string(proto))
+	}
+	return protos
+}
+
+func decodeAddrs(v []byte, size int) []netip.Addr {
+	if len(v)%size != 0 {
+		return nil
+	}
+	var addrs []netip.Addr
+	for i := 0; i < len(v); i += size {
+		addr, _ := netip.AddrFromSlice(v[i : i+size])
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
+// SvcParamKeyName returns the presentation name of key, using the generic
+// keyNNNNN form for unregistered keys.
+func SvcParamKeyName(key uint16) string {
+	if name, ok := svcParamKeyNames[key]; ok {
+		return name
+	}
+	return "key" + strconv.Itoa(int(key))
+}
+
+// String returns the RFC 9460 presentation format of p. Values of
+// unregistered keys, and values that cannot be decoded, are rendered as
+// escaped character-strings in the generic form of section 2.1, e.g.
+// key667="hello\210qoo", which ParseHttpsPresentation reads back; empty
+// ones are left out.
+func (p SvcParam) String() string {
+	name := SvcParamKeyName(p.Key)
+	switch p.Key {
+	case SvcParamMandatory:
+		if keys := decodeKeyList(p.Value); keys != nil {
+			names := make([]string, len(keys))
+			for i, k := range keys {
+				names[i] = SvcParamKeyName(k)
+			}
+			return name + "=" + strings.Join(names, ",")
+		}
+	case SvcParamALPN:
+		if protos := decodeALPN(p.Value); protos != nil {
+			return name + `="` + escapeValueList(protos) + `"`
+		}
+	case SvcParamNoDefaultALPN, SvcParamOHTTP:
+		if len(p.Value) == 0 {
+			return name
+		}
+	case SvcParamPort:
+		if len(p.Value) == 2 {
+			return fmt.Sprintf("%s=%d", name, uint16(p.Value[0])<<8|uint16(p.Value[1]))
+		}
+	case SvcParamIPv4Hint, SvcParamIPv6Hint:
+		size := 4
+		if p.Key == SvcParamIPv6Hint {
+			size = 16
+		}
+		if addrs := decodeAddrs(p.Value, size); addrs != nil {
+			hints := make([]string, len(addrs))
+			for i, a := range addrs {
+				hints[i] = a.String()
+			}
+			return name + "=" + strings.Join(hints, ",")
+		}
+	case SvcParamECH:
+		return name + "=" + base64.StdEncoding.EncodeToString(p.Value)
+	}
+	if len(p.Value) == 0 {
+		return name
+	}
+	return name + `="` + escapeCharString(p.Value) + `"`
+}
+
+// escapeValueList escapes items as the contents of a quoted value-list,
+// escaping their commas and backslashes before the character-string
+// escaping (RFC 9460, appendix A.1).
+func escapeValueList(items []string) string {
+	escaped := make([]string, len(items))
+	for i, item := range items {
+		item = strings.ReplaceAll(item, `\`, `\\`)
+		escaped[i] = strings.ReplaceAll(item, ",", `\,`)
+	}
+	return escapeCharString([]byte(strings.Join(escaped, ",")))
+}
+
+// escapeCharString escapes b as the contents of a quoted DNS
+// character-string.
+func escapeCharString(b []byte) string {
+	var sb strings.Builder
+	for _, c := range b {
+		switch {
+		case c == '"' || c == '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case c < 0x20 || c > 0x7e:
+			fmt.Fprintf(&sb, "\\%03d", c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
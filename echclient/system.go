@@ -0,0 +1,45 @@
+package echclient
+
+import "net"
+
+// SystemResolverName selects the resolvers configured in the operating
+// system wherever a resolver URL is accepted.
+const SystemResolverName = "system"
+
+// SystemNameservers returns the addresses of the resolvers configured in
+// the operating system: the nameserver lines of /etc/resolv.conf on Unix and
+// the DNS servers of the adapters that are up on Windows.
+func SystemNameservers() ([]string, error) {
+	servers, err := systemNameservers()
+	if err != nil {
+		return nil, err
+	}
+	if len(servers) == 0 {
+		return nil, ErrNoNameservers
+	}
+	return servers, nil
+}
+
+// NewSystemResolver returns a FallbackResolver sending raw queries to the
+// resolvers of the operating system in order, so that the answers are those
+// an ordinary local client would get.
+func (c *ProbeConfig) NewSystemResolver() (*FallbackResolver, error) {
+	servers, err := SystemNameservers()
+	if err != nil {
+		return nil, err
+	}
+	fr := &FallbackResolver{
+		Timeout: c.dnsTimeout(),
+		Logger:  c.logger(),
+	}
+	for _, server := range servers {
+		fr.Resolvers = append(fr.Resolvers, &Do53Resolver{
+			Addr:      net.JoinHostPort(server, Do53Port),
+			Timeout:   c.dnsTimeout(),
+			Logger:    c.logger(),
+			DNSSEC:    c.validateDNSSEC(),
+			Randomize: c.randomizeDo53(),
+		})
+	}
+	return fr, nil
+}
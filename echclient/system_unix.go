@@ -0,0 +1,34 @@
+//go:build !windows
+
+package echclient
+
+import (
+	"bufio"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// resolvConfPath is the resolver configuration file read on Unix.
+const resolvConfPath = "/etc/resolv.conf"
+
+func systemNameservers() ([]string, error) {
+	f, err := os.Open(resolvConfPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var servers []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "nameserver" {
+			continue
+		}
+		// Like the Go resolver, skip entries that are not IP addresses.
+		if _, err := netip.ParseAddr(fields[1]); err == nil {
+			servers = append(servers, fields[1])
+		}
+	}
+	return servers, scanner.Err()
+}
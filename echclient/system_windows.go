@@ -0,0 +1,62 @@
+//go:build windows
+
+package echclient
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+func systemNameservers() ([]string, error) {
+	adapters, err := adapterAddresses()
+	if err != nil {
+		return nil, err
+	}
+	var servers []string
+	for _, aa := range adapters {
+		if aa.OperStatus != windows.IfOperStatusUp {
+			continue
+		}
+		for dns := aa.FirstDnsServerAddress; dns != nil; dns = dns.Next {
+			ip := dns.Address.IP()
+			// Skip the deprecated site-local anycast resolvers
+			// Windows lists for IPv6 when none are configured.
+			if ip == nil || ip.IsLinkLocalUnicast() || len(ip) == 16 && ip[0] == 0xfe && ip[1] == 0xc0 {
+				continue
+			}
+			servers = append(servers, ip.String())
+		}
+	}
+	return servers, nil
+}
+
+// adapterAddresses returns the list of network adapters, as in the Go
+// standard library.
+func adapterAddresses() ([]*windows.IpAdapterAddresses, error) {
+	var b []byte
+	l := uint32(15000) // recommended initial size
+	for {
+		b = make([]byte, l)
+		err := windows.GetAdaptersAddresses(syscall.AF_UNSPEC, windows.GAA_FLAG_INCLUDE_PREFIX, 0, (*windows.IpAdapterAddresses)(unsafe.Pointer(&b[0])), &l)
+		if err == nil {
+			if l == 0 {
+				return nil, nil
+			}
+			break
+		}
+		if err.(syscall.Errno) != syscall.ERROR_BUFFER_OVERFLOW {
+			return nil, os.NewSyscallError("getadaptersaddresses", err)
+		}
+		if l <= uint32(len(b)) {
+			return nil, os.NewSyscallError("getadaptersaddresses", err)
+		}
+	}
+	var aas []*windows.IpAdapterAddresses
+	for aa := (*windows.IpAdapterAddresses)(unsafe.Pointer(&b[0])); aa != nil; aa = aa.Next {
+		aas = append(aas, aa)
+	}
+	return aas, nil
+}
@@ -0,0 +1,119 @@
+package echclient
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// uriTemplateOps describes the expression operators of RFC 6570 section 3.2,
+// as listed in its appendix A.
+var uriTemplateOps = map[byte]struct {
+	first, sep    string
+	named         bool
+	ifEmpty       string
+	allowReserved bool
+}{
+	0:   {"", ",", false, "", false},
+	'+': {"", ",", false, "", true},
+	'.': {".", ".", false, "", false},
+	'/': {"/", "/", false, "", false},
+	';': {";", ";", true, "", false},
+	'?': {"?", "&", true, "=", false},
+	'&': {"&", "&", true, "=", false},
+	'#': {"#", ",", false, "", true},
+}
+
+// expandURITemplate expands the RFC 6570 template tmpl with string variables.
+// Undefined variables are omitted; list and map values are not supported.
+func expandURITemplate(tmpl string, vars map[string]string) (string, error) {
+	var sb strings.Builder
+	for {
+		start := strings.IndexByte(tmpl, '{')
+		if start < 0 {
+			sb.WriteString(tmpl)
+			return sb.String(), nil
+		}
+		end := strings.IndexByte(tmpl[start:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated expression in URI template %q", tmpl)
+		}
+		sb.WriteString(tmpl[:start])
+		if err := expandExpression(&sb, tmpl[start+1:start+end], vars); err != nil {
+			return "", err
+		}
+		tmpl = tmpl[start+end+1:]
+	}
+}
+
+func expandExpression(sb *strings.Builder, expr string, vars map[string]string) error {
+	var opChar byte
+	if expr != "" && strings.IndexByte("+./;?&#", expr[0]) >= 0 {
+		opChar = expr[0]
+		expr = expr[1:]
+	}
+	op := uriTemplateOps[opChar]
+	first := true
+	for _, spec := range strings.Split(expr, ",") {
+		name, prefix, hasPrefix := strings.Cut(strings.TrimSuffix(spec, "*"), ":")
+		if name == "" {
+			return fmt.Errorf("invalid URI template expression %q", expr)
+		}
+		value, ok := vars[name]
+		if !ok {
+			continue
+		}
+		if hasPrefix {
+			n, err := strconv.Atoi(prefix)
+			if err != nil || n <= 0 {
+				return fmt.Errorf("invalid URI template prefix %q", spec)
+			}
+			if runes := []rune(value); len(runes) > n {
+				value = string(runes[:n])
+			}
+		}
+		if first {
+			sb.WriteString(op.first)
+			first = false
+		} else {
+			sb.WriteString(op.sep)
+		}
+		if op.named {
+			sb.WriteString(name)
+			if value == "" {
+				sb.WriteString(op.ifEmpty)
+				continue
+			}
+			sb.WriteByte('=')
+		}
+		sb.WriteString(pctEncode(value, op.allowReserved))
+	}
+	return nil
+}
+
+// pctEncode percent-encodes every byte of s outside the unreserved set and,
+// if allowReserved, the reserved set.
+func pctEncode(s string, allowReserved bool) string {
+	const unreserved = "-._~"
+	const reserved = ":/?#[]@!$&'()*+,;="
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9',
+			strings.IndexByte(unreserved, c) >= 0,
+			allowReserved && strings.IndexByte(reserved, c) >= 0:
+			sb.WriteByte(c)
+		case allowReserved && c == '%' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]):
+			sb.WriteString(s[i : i+3])
+			i += 2
+		default:
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
@@ -0,0 +1,99 @@
+package echclient
+
+import "testing"
+
+// The vectors are those of RFC 6570, section 3.2, for string variables, and
+// the templates of RFC 8484 DoH endpoints.
+func TestExpandURITemplate(t *testing.T) {
+	vars := map[string]string{
+		"dns":   "AAABAAABAAAAAAAAA3d3dwdleGFtcGxlA2NvbQAAAQAB",
+		"var":   "value",
+		"hello": "Hello World!",
+		"half":  "50%",
+		"path":  "/foo/bar",
+		"empty": "",
+		"x":     "1024",
+		"y":     "768",
+		"utf8":  "héllo",
+	}
+	tests := []struct {
+		tmpl, want string
+	}{
+		{"https://dns.example/dns-query{?dns}", "https://dns.example/dns-query?dns=AAABAAABAAAAAAAAA3d3dwdleGFtcGxlA2NvbQAAAQAB"},
+		{"https://dns.example/dns-query{?undef}", "https://dns.example/dns-query"},
+		{"https://dns.example/q{?dns,ct}", "https://dns.example/q?dns=AAABAAABAAAAAAAAA3d3dwdleGFtcGxlA2NvbQAAAQAB"},
+		{"https://dns.example/q?ct=x{&dns}", "https://dns.example/q?ct=x&dns=AAABAAABAAAAAAAAA3d3dwdleGFtcGxlA2NvbQAAAQAB"},
+
+		// Simple string expansion, section 3.2.2.
+		{"{var}", "value"},
+		{"{hello}", "Hello%20World%21"},
+		{"{half}", "50%25"},
+		{"O{empty}X", "OX"},
+		{"O{undef}X", "OX"},
+		{"{x,y}", "1024,768"},
+		{"{x,hello,y}", "1024,Hello%20World%21,768"},
+		{"?{x,empty}", "?1024,"},
+		{"?{x,undef}", "?1024"},
+		{"?{undef,y}", "?768"},
+		{"{var:3}", "val"},
+		{"{var:30}", "value"},
+		{"{utf8:2}", "h%C3%A9"},
+
+		// Reserved expansion, section 3.2.3.
+		{"{+var}", "value"},
+		{"{+hello}", "Hello%20World!"},
+		{"{+half}", "50%25"},
+		{"{base}index", "index"},
+		{"{+path}/here", "/foo/bar/here"},
+		{"here?ref={+path}", "here?ref=/foo/bar"},
+		{"up{+path}{var}/here", "up/foo/barvalue/here"},
+		{"{+x,hello,y}", "1024,Hello%20World!,768"},
+		{"{+path,x}/here", "/foo/bar,1024/here"},
+		{"{+path:6}/here", "/foo/b/here"},
+
+		// Fragment expansion, section 3.2.4.
+		{"{#var}", "#value"},
+		{"{#hello}", "#Hello%20World!"},
+		{"{#half}", "#50%25"},
+		{"foo{#empty}", "foo#"},
+		{"foo{#undef}", "foo"},
+		{"{#x,hello,y}", "#1024,Hello%20World!,768"},
+		{"{#path:6}/here", "#/foo/b/here"},
+
+		// Label, path segment and path-style parameter expansion,
+		// sections 3.2.5 to 3.2.7.
+		{"{.var}", ".value"},
+		{"X{.empty}", "X."},
+		{"X{.undef}", "X"},
+		{"X{.var:3}", "X.val"},
+		{"{/var}", "/value"},
+		{"{/var,x}/here", "/value/1024/here"},
+		{"{/var:1,var}", "/v/value"},
+		{"{;x,y}", ";x=1024;y=768"},
+		{"{;x,y,empty}", ";x=1024;y=768;empty"},
+		{"{;x,y,undef}", ";x=1024;y=768"},
+		{"{;hello:5}", ";hello=Hello"},
+
+		// Form-style query expansion and continuation, sections 3.2.8 and
+		// 3.2.9.
+		{"{?x,y}", "?x=1024&y=768"},
+		{"{?x,y,empty}", "?x=1024&y=768&empty="},
+		{"{?x,y,undef}", "?x=1024&y=768"},
+		{"{?var:3}", "?var=val"},
+		{"{&x,y,empty}", "&x=1024&y=768&empty="},
+		{"?fixed=yes{&x}", "?fixed=yes&x=1024"},
+		{"{&var:3}", "&var=val"},
+	}
+	for _, tt := range tests {
+		got, err := expandURITemplate(tt.tmpl, vars)
+		if err != nil || got != tt.want {
+			t.Errorf("expandURITemplate(%q) = %q, %v, want %q", tt.tmpl, got, err, tt.want)
+		}
+	}
+
+	for _, tmpl := range []string{"{var", "x{}", "{?dns,}", "{var:0}", "{var:x}", "{var:-1}"} {
+		if got, err := expandURITemplate(tmpl, vars); err == nil {
+			t.Errorf("expandURITemplate(%q) = %q, want an error", tmpl, got)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package echclient
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// webSocketGUID is appended to the key of a WebSocket opening handshake to
+// compute the accept value (RFC 6455, section 1.3).
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// webSocketSchemes maps the WebSocket schemes to the HTTP ones their
+// opening handshake is sent with.
+var webSocketSchemes = map[string]string{
+	"wss": "https",
+	"ws":  "http",
+}
+
+// webSocketToHTTP returns a copy of the ws or wss URL u with the http or
+// https scheme of its opening handshake, and whether u is a WebSocket URL.
+func webSocketToHTTP(u *url.URL) (*url.URL, bool) {
+	scheme, ok := webSocketSchemes[strings.ToLower(u.Scheme)]
+	if !ok {
+		return u, false
+	}
+	rewritten := *u
+	rewritten.Scheme = scheme
+	return &rewritten, true
+}
+
+// setWebSocketHeaders turns req into a WebSocket opening handshake and
+// returns the key it carries.
+func setWebSocketHeaders(req *http.Request) (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	return key, nil
+}
+
+// checkWebSocketUpgrade returns an ErrWebSocketUpgrade error unless resp
+// completes the opening handshake sent with key (RFC 6455, section 4.1).
+func checkWebSocketUpgrade(resp *http.Response, key string) error {
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return fmt.Errorf("%w: status %d", ErrWebSocketUpgrade, resp.StatusCode)
+	}
+	if !strings.EqualFold(resp.Header.Get("Upgrade"), "websocket") {
+		return fmt.Errorf("%w: Upgrade header %q", ErrWebSocketUpgrade, resp.Header.Get("Upgrade"))
+	}
+	sum := sha1.Sum([]byte(key + webSocketGUID))
+	if accept := resp.Header.Get("Sec-WebSocket-Accept"); accept != base64.StdEncoding.EncodeToString(sum[:]) {
+		return fmt.Errorf("%w: Sec-WebSocket-Accept %q does not match the key", ErrWebSocketUpgrade, accept)
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package echclient
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+)
+
+// ConfigSource selects where ECHConfigLists are fetched from in ECHModeDNS.
+type ConfigSource string
+
+const (
+	// ConfigSourceDNS uses the HTTPS RR.
+	ConfigSourceDNS ConfigSource = "dns"
+
+	// ConfigSourceWellKnown uses the origin-svcb well-known URI of
+	// draft-ietf-tls-wkech.
+	ConfigSourceWellKnown ConfigSource = "wellknown"
+
+	// ConfigSourceBoth uses the HTTPS RR, falling back to the well-known
+	// URI if DNS does not yield an ECHConfigList.
+	ConfigSourceBoth ConfigSource = "both"
+)
+
+// WellKnownPath is the path of the draft-ietf-tls-wkech JSON document.
+const WellKnownPath = "/.well-known/origin-svcb"
+
+// WellKnownSVCB is the JSON document served at WellKnownPath.
+type WellKnownSVCB struct {
+	Endpoints []WellKnownEndpoint `json:"endpoints"`
+}
+
+// WellKnownEndpoint describes one alternative endpoint of the origin, in the
+// same terms as an HTTPS RR.
+type WellKnownEndpoint struct {
+	Priority uint16                     `json:"priority"`
+	Target   string                     `json:"target,omitempty"`
+	Params   map[string]json.RawMessage `json:"params,omitempty"`
+}
+
+// ECHConfigList returns the decoded ech parameter of the endpoint.
+func (e *WellKnownEndpoint) ECHConfigList() ([]byte, error) {
+	raw, ok := e.Params["ech"]
+	if !ok {
+		return nil, nil
+	}
+	var b64 string
+	if err := json.Unmarshal(raw, &b64); err != nil {
+		return nil, fmt.Errorf("%w: ech parameter is not a string", ErrMalformedECHConfig)
+	}
+	return base64.StdEncoding.DecodeString(b64)
+}
+
+// ALPN returns the alpn parameter of the endpoint.
+func (e *WellKnownEndpoint) ALPN() []string {
+	var alpn []string
+	json.Unmarshal(e.Params["alpn"], &alpn)
+	return alpn
+}
+
+// FetchWellKnown retrieves and decodes the origin-svcb document of host.
+func (c *ProbeConfig) FetchWellKnown(ctx context.Context, host string) (*WellKnownSVCB, error) {
+	client := &http.Client{
+		Timeout: c.timeout(),
+		Transport: &http.Transport{
+			Proxy: c.proxy(),
+		},
+	}
+	defer client.CloseIdleConnections()
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://"+host+WellKnownPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w for %s: HTTP status %d", ErrNoWellKnown, host, resp.StatusCode)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	c.logger().Debug("well-known response", "host", host, "body", string(data))
+	var doc WellKnownSVCB
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%w for %s: %v", ErrNoWellKnown, host, err)
+	}
+	return &doc, nil
+}
+
+// FetchECHConfigListWellKnown returns the ECHConfigList of the highest
+// priority endpoint in the origin-svcb document of host that carries one.
+func (c *ProbeConfig) FetchECHConfigListWellKnown(ctx context.Context, host string) (*ParsedEchConfig, *WellKnownSVCB, error) {
+	doc, err := c.FetchWellKnown(ctx, host)
+	if err != nil {
+		return nil, nil, err
+	}
+	endpoints := append([]WellKnownEndpoint(nil), doc.Endpoints...)
+	sort.SliceStable(endpoints, func(i, j int) bool {
+		return endpoints[i].Priority < endpoints[j].Priority
+	})
+	for _, e := range endpoints {
+		raw, err := e.ECHConfigList()
+		if err != nil {
+			return nil, doc, err
+		}
+		if len(raw) == 0 {
+			continue
+		}
+		configs, err := ParseECHConfigList(raw)
+		if err != nil {
+			return nil, doc, fmt.Errorf("failed to parse echConfig: %w", err)
+		}
+		return &ParsedEchConfig{Configs: configs, Raw: raw}, doc, nil
+	}
+	return nil, doc, fmt.Errorf("%w in %s of %s", ErrNoECHConfig, WellKnownPath, host)
+}
+
+// fetchFromSource fetches the ECHConfigList of host from the configured
+// ConfigSource, recording the source used and the well-known document in r.
+// DNS is queried at r.QueryName, if set.
+func (c *ProbeConfig) fetchFromSource(ctx context.Context, r *ProbeResult, host string) (*ParsedEchConfig, error) {
+	source := c.configSource()
+	if source != ConfigSourceWellKnown {
+		r.ConfigSource = ConfigSourceDNS
+		qname := host
+		if r.QueryName != "" {
+			qname = r.QueryName
+		}
+		parsed, err := c.FetchECHConfigList(ctx, qname)
+		if err == nil || source == ConfigSourceDNS || !noECHInDNS(err) {
+			return parsed, err
+		}
+		c.logger().Info("no ECHConfigList in DNS, trying well-known", "host", host, "error", err)
+		if parsed != nil {
+			r.HTTPSRecord = parsed.Record
+		}
+	}
+	r.ConfigSource = ConfigSourceWellKnown
+	parsed, doc, err := c.FetchECHConfigListWellKnown(ctx, host)
+	r.WellKnown = doc
+	return parsed, err
+}
+
+// noECHInDNS reports whether err means DNS has no ECHConfigList for the
+// name, as opposed to the lookup failing.
+func noECHInDNS(err error) bool {
+	return errors.Is(err, ErrNoECHConfig) || errors.Is(err, ErrNoHTTPSRecord) || errors.Is(err, ErrDNSStatus)
+}
@@ -0,0 +1,230 @@
+package echclient
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// maxUDPPayload is the EDNS(0) payload size advertised in queries.
+const maxUDPPayload = 1232
+
+// fqdn returns name with a trailing dot.
+func fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
+
+// randomizeCase returns name with the case of each letter flipped at random.
+// Servers copy the question verbatim, so an off-path attacker has to guess
+// the casing as well as the ID and port (draft-vixie-dnsext-dns0x20).
+func randomizeCase(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		if ('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z') && rand.IntN(2) == 1 {
+			b[i] = c ^ 0x20
+		}
+	}
+	return string(b)
+}
+
+// buildQuery returns a wire-format DNS query for name and qtype with
+// recursion desired and an EDNS(0) OPT record. If dnssec is set, the DO and
+// CD bits are set so that the answer carries the RRSIGs needed for local
+// validation and is not filtered by a validating resolver.
+func buildQuery(id uint16, name string, qtype RRType, dnssec bool) ([]byte, error) {
+	qname, err := dnsmessage.NewName(fqdn(name))
+	if err != nil {
+		return nil, err
+	}
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{
+		ID:               id,
+		RecursionDesired: true,
+		CheckingDisabled: dnssec,
+	})
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(dnsmessage.Question{
+		Name:  qname,
+		Type:  dnsmessage.Type(qtype),
+		Class: dnsmessage.ClassINET,
+	}); err != nil {
+		return nil, err
+	}
+	if err := b.StartAdditionals(); err != nil {
+		return nil, err
+	}
+	var opt dnsmessage.ResourceHeader
+	if err := opt.SetEDNS0(maxUDPPayload, dnsmessage.RCodeSuccess, dnssec); err != nil {
+		return nil, err
+	}
+	if err := b.OPTResource(opt, dnsmessage.OPTResource{}); err != nil {
+		return nil, err
+	}
+	return b.Finish()
+}
+
+// parseResponse decodes a wire-format DNS response into the same shape as a
+// DoH JSON answer: A, AAAA, CNAME, NS and SOA data use presentation format
+// and every other type uses the RFC 3597 generic encoding.
+func parseResponse(msg []byte) (*DNSResponse, uint16, error) {
+	var p dnsmessage.Parser
+	hdr, err := p.Start(msg)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %v", ErrDNSResponse, err)
+	}
+	resp := &DNSResponse{
+		Status: int(hdr.RCode),
+		TC:     hdr.Truncated,
+		RD:     hdr.RecursionDesired,
+		RA:     hdr.RecursionAvailable,
+		AD:     hdr.AuthenticData,
+		CD:     hdr.CheckingDisabled,
+	}
+	questions, err := p.AllQuestions()
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %v", ErrDNSResponse, err)
+	}
+	for _, q := range questions {
+		resp.Question = append(resp.Question, DNSQuestion{Name: q.Name.String(), Type: int(q.Type)})
+	}
+	answers, err := p.AllAnswers()
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %v", ErrDNSResponse, err)
+	}
+	resp.Answer = answerSection(answers)
+	authorities, err := p.AllAuthorities()
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %v", ErrDNSResponse, err)
+	}
+	resp.Authority = answerSection(authorities)
+	return resp, hdr.ID, nil
+}
+
+func answerSection(rrs []dnsmessage.Resource) []DNSAnswer {
+	var section []DNSAnswer
+	for _, rr := range rrs {
+		section = append(section, DNSAnswer{
+			Name: rr.Header.Name.String(),
+			Type: int(rr.Header.Type),
+			TTL:  int(rr.Header.TTL),
+			Data: resourceData(rr.Body),
+		})
+	}
+	return section
+}
+
+func resourceData(body dnsmessage.ResourceBody) string {
+	switch rb := body.(type) {
+	case *dnsmessage.AResource:
+		return fmt.Sprintf("%d.%d.%d.%d", rb.A[0], rb.A[1], rb.A[2], rb.A[3])
+	case *dnsmessage.AAAAResource:
+		return netip.AddrFrom16(rb.AAAA).String()
+	case *dnsmessage.CNAMEResource:
+		return rb.CNAME.String()
+	case *dnsmessage.NSResource:
+		return rb.NS.String()
+	case *dnsmessage.SOAResource:
+		return fmt.Sprintf("%s %s %d %d %d %d %d", rb.NS, rb.MBox, rb.Serial, rb.Refresh, rb.Retry, rb.Expire, rb.MinTTL)
+	case *dnsmessage.UnknownResource:
+		return genericData(rb.Data)
+	}
+	return ""
+}
+
+// genericData encodes rdata in the RFC 3597 "\# len hex" form.
+func genericData(rdata []byte) string {
+	return fmt.Sprintf(`\# %d %s`, len(rdata), hex.EncodeToString(rdata))
+}
+
+// newQueryID returns a random DNS message ID.
+func newQueryID() uint16 {
+	return uint16(rand.Uint32())
+}
+
+// exchangeStream sends msg over a stream transport using the two byte length
+// prefix of RFC 1035 section 4.2.2 and returns the response. It honours the
+// deadline of ctx.
+func exchangeStream(ctx context.Context, conn net.Conn, msg []byte) ([]byte, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	buf := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(buf, uint16(len(msg)))
+	copy(buf[2:], msg)
+	if _, err := conn.Write(buf); err != nil {
+		return nil, contextError(ctx, err)
+	}
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, contextError(ctx, err)
+	}
+	resp := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return resp, nil
+}
+
+// contextError returns the error of ctx if it caused err.
+func contextError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// parseReply decodes a wire-format response to a query with the given ID,
+// reporting a non-zero RCODE as a DNSStatusError.
+func parseReply(msg []byte, id uint16, name string) (*DNSResponse, error) {
+	return parseEchoedReply(msg, id, name, "")
+}
+
+// parseEchoedReply is like parseReply but, if qname is not empty, also
+// requires the question of the response to spell qname with exactly the same
+// case, as sent with 0x20 randomization. Matching answers are given back
+// the case of name.
+func parseEchoedReply(msg []byte, id uint16, name, qname string) (*DNSResponse, error) {
+	resp, respID, err := parseResponse(msg)
+	if err != nil {
+		return nil, err
+	}
+	if respID != id {
+		return nil, fmt.Errorf("%w: mismatched ID %d != %d", ErrDNSResponse, respID, id)
+	}
+	if qname != "" {
+		if len(resp.Question) != 1 || resp.Question[0].Name != qname {
+			return nil, fmt.Errorf("%w: question does not echo %s", ErrDNSResponse, qname)
+		}
+		for i := range resp.Question {
+			resp.Question[i].Name = fqdn(name)
+		}
+		for i := range resp.Answer {
+			if resp.Answer[i].Name == qname {
+				resp.Answer[i].Name = fqdn(name)
+			}
+		}
+	}
+	if resp.Status != 0 {
+		return nil, &DNSStatusError{Name: name, Status: resp.Status}
+	}
+	return resp, nil
+}
@@ -0,0 +1,121 @@
+package echclient
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// CrossCheckAnswer is the HTTPS RRset of a host as returned by one
+// resolver.
+type CrossCheckAnswer struct {
+	Resolver     string         `json:"resolver"`
+	AnsweredBy   string         `json:"answered_by,omitempty"`
+	HTTPSRecords []*HttpsRecord `json:"https_records,omitempty"`
+	Error        string         `json:"error,omitempty"`
+	ErrorClass   string         `json:"error_class,omitempty"`
+}
+
+// CrossCheckResult is the outcome of CrossCheck.
+type CrossCheckResult struct {
+	Host    string             `json:"host"`
+	Answers []CrossCheckAnswer `json:"answers"`
+
+	// Inconsistencies describes how the answers differ from the first
+	// one; it is empty when all resolvers agree.
+	Inconsistencies []string `json:"inconsistencies,omitempty"`
+}
+
+// Consistent reports whether all resolvers returned the same answer.
+func (r *CrossCheckResult) Consistent() bool {
+	return len(r.Inconsistencies) == 0
+}
+
+// CrossCheck queries the HTTPS RRset of host from each of resolverURLs
+// concurrently and compares the ECH configs, priorities, target names, ALPNs
+// and address hints they return. Differences suggest split-horizon DNS or
+// injected answers.
+func (c *ProbeConfig) CrossCheck(ctx context.Context, host string, resolverURLs []string) (*CrossCheckResult, error) {
+	resolvers, err := c.newResolvers(resolverURLs)
+	if err != nil {
+		return nil, err
+	}
+	result := &CrossCheckResult{
+		Host:    host,
+		Answers: make([]CrossCheckAnswer, len(resolvers)),
+	}
+	var wg sync.WaitGroup
+	for i, resolver := range resolvers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cfg := *c
+			cfg.Resolver = resolver
+			// Every resolver must be asked, whatever the others said.
+			cfg.Cache = nil
+			cfg.Hooks = nil
+			answer := &result.Answers[i]
+			answer.Resolver = resolver.String()
+			parsed, err := cfg.FetchECHConfigList(ctx, host)
+			if parsed != nil {
+				answer.AnsweredBy = parsed.Resolver
+				answer.HTTPSRecords = parsed.Endpoints
+			}
+			if err != nil && !noECHInDNS(err) {
+				answer.Error = err.Error()
+				answer.ErrorClass = ClassifyError(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	ref := result.Answers[0]
+	refFacets := recordFacets(ref.HTTPSRecords)
+	for _, answer := range result.Answers[1:] {
+		if answer.ErrorClass != ref.ErrorClass {
+			result.Inconsistencies = append(result.Inconsistencies, fmt.Sprintf(
+				"%s failed with %q, %s with %q", answer.Resolver, answer.ErrorClass, ref.Resolver, ref.ErrorClass))
+			continue
+		}
+		facets := recordFacets(answer.HTTPSRecords)
+		for _, facet := range facetNames {
+			if facets[facet] != refFacets[facet] {
+				result.Inconsistencies = append(result.Inconsistencies, fmt.Sprintf(
+					"%s: %s returned %s, %s returned %s", facet, answer.Resolver, facets[facet], ref.Resolver, refFacets[facet]))
+			}
+		}
+	}
+	return result, nil
+}
+
+var facetNames = []string{"priority", "target", "alpn", "ipv4hint", "ipv6hint", "ech"}
+
+// recordFacets summarizes the compared fields of records, independently of
+// their order.
+func recordFacets(records []*HttpsRecord) map[string]string {
+	values := map[string][]string{}
+	for _, r := range records {
+		values["priority"] = append(values["priority"], fmt.Sprint(r.Priority))
+		values["target"] = append(values["target"], r.TargetName)
+		values["alpn"] = append(values["alpn"], strings.Join(r.ALPN(), ","))
+		for _, a := range r.IPv4Hints() {
+			values["ipv4hint"] = append(values["ipv4hint"], a.String())
+		}
+		for _, a := range r.IPv6Hints() {
+			values["ipv6hint"] = append(values["ipv6hint"], a.String())
+		}
+		if ech := r.ECHConfigList(); len(ech) > 0 {
+			values["ech"] = append(values["ech"], base64.StdEncoding.EncodeToString(ech))
+		}
+	}
+	facets := make(map[string]string, len(facetNames))
+	for _, facet := range facetNames {
+		v := values[facet]
+		slices.Sort(v)
+		facets[facet] = "[" + strings.Join(slices.Compact(v), " ") + "]"
+	}
+	return facets
+}
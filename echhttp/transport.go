@@ -0,0 +1,230 @@
+// Package echhttp provides an http.RoundTripper that enables Encrypted Client
+// Hello for every destination host.
+package echhttp
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/hellais/ech/echclient"
+)
+
+// Transport is an http.RoundTripper that looks up the ECHConfigList of each
+// destination host over DoH, caches it, and offers it on every TLS connection
+// to that host.
+type Transport struct {
+	// Base is the transport used to perform requests. It is cloned and its
+	// DialTLSContext is replaced; its DialContext, if any, establishes the
+	// connections. If nil, http.DefaultTransport is used without its
+	// proxy. net/http does not call DialTLSContext for requests going
+	// through a proxy, so RoundTrip fails with ErrProxy for the https
+	// requests the Proxy of Base would send through one.
+	Base *http.Transport
+
+	// TLSClientConfig is cloned for every connection. If nil, the zero
+	// configuration is used.
+	TLSClientConfig *tls.Config
+
+	// ProbeConfig configures the HTTPS RR lookups. If nil, the defaults
+	// are used. If it has a Cache, ECHConfigLists are refreshed when their
+	// TTL expires; otherwise they are kept for the life of the Transport,
+	// and failed lookups for negativeTTL.
+	//
+	// When the lookup finds no ECHConfigList, the dial fails with its
+	// error unless the ECHFallback of ProbeConfig is ECHFallbackPlain,
+	// which connects without ECH, or ECHFallbackGREASE, which offers a
+	// GREASE ECHConfigList. A GREASE handshake is always rejected, so
+	// the connection is then made again with the retry configurations of
+	// the server, or without ECH if it sent none.
+	ProbeConfig *echclient.ProbeConfig
+
+	once sync.Once
+	rt   *http.Transport
+	dial func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	mu      sync.Mutex
+	configs map[string]configEntry
+}
+
+// negativeTTL is how long a failed lookup is cached when ProbeConfig has no
+// DNS cache.
+const negativeTTL = time.Minute
+
+type configEntry struct {
+	raw []byte
+	err error
+	// expires is when a failed lookup is retried.
+	expires time.Time
+}
+
+// ErrProxy is returned by RoundTrip for https requests that Base would send
+// through a proxy, over which ECH cannot be offered.
+var ErrProxy = errors.New("echhttp: ECH cannot be offered through a proxy")
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.once.Do(t.init)
+	if t.rt.Proxy != nil && req.URL.Scheme == "https" {
+		proxyURL, err := t.rt.Proxy(req)
+		if err != nil {
+			return nil, err
+		}
+		if proxyURL != nil {
+			return nil, ErrProxy
+		}
+	}
+	return t.rt.RoundTrip(req)
+}
+
+// CloseIdleConnections closes any idle connections of the underlying
+// transport.
+func (t *Transport) CloseIdleConnections() {
+	t.once.Do(t.init)
+	t.rt.CloseIdleConnections()
+}
+
+func (t *Transport) init() {
+	if t.Base != nil {
+		t.rt = t.Base.Clone()
+	} else {
+		t.rt = http.DefaultTransport.(*http.Transport).Clone()
+		t.rt.Proxy = nil
+	}
+	t.dial = t.rt.DialContext
+	if t.dial == nil {
+		var dialer net.Dialer
+		t.dial = dialer.DialContext
+	}
+	t.rt.DialTLSContext = t.dialTLS
+	t.rt.ForceAttemptHTTP2 = true
+}
+
+// echConfigList returns the cached ECHConfigList published at the HTTPS RR
+// name qname, fetching it if needed. When ProbeConfig has a DNS cache, it is
+// relied upon instead so that the HTTPS RR TTLs are honoured.
+func (t *Transport) echConfigList(ctx context.Context, qname string) ([]byte, error) {
+	if t.ProbeConfig != nil && t.ProbeConfig.Cache != nil {
+		parsed, err := t.ProbeConfig.FetchECHConfigList(ctx, qname)
+		if err != nil {
+			return nil, err
+		}
+		return parsed.Raw, nil
+	}
+
+	t.mu.Lock()
+	entry, ok := t.configs[qname]
+	t.mu.Unlock()
+	if ok && (entry.err == nil || time.Now().Before(entry.expires)) {
+		return entry.raw, entry.err
+	}
+
+	parsed, err := t.ProbeConfig.FetchECHConfigList(ctx, qname)
+	if err != nil && ctx.Err() != nil {
+		// The lookup was cut short by the caller, not answered.
+		return nil, err
+	}
+	if err != nil {
+		entry = configEntry{err: err, expires: time.Now().Add(negativeTTL)}
+	} else {
+		entry = configEntry{raw: parsed.Raw}
+	}
+
+	t.mu.Lock()
+	if t.configs == nil {
+		t.configs = make(map[string]configEntry)
+	}
+	t.configs[qname] = entry
+	t.mu.Unlock()
+	return entry.raw, entry.err
+}
+
+// fallback returns the ECHConfigList to offer to host instead when its
+// lookup failed with err, according to the ECHFallback of ProbeConfig, or
+// err if no fallback applies.
+func (t *Transport) fallback(host string, err error) ([]byte, echclient.ECHFallback, error) {
+	var mode echclient.ECHFallback
+	if t.ProbeConfig != nil {
+		mode = t.ProbeConfig.ECHFallback
+	}
+	if mode != echclient.ECHFallbackGREASE && mode != echclient.ECHFallbackPlain ||
+		!errors.Is(err, echclient.ErrNoECHConfig) && !errors.Is(err, echclient.ErrNoHTTPSRecord) &&
+			!errors.Is(err, echclient.ErrDNSStatus) && !errors.Is(err, echclient.ErrNoWellKnown) &&
+			!errors.Is(err, echclient.ErrNoUsableECHConfig) {
+		return nil, "", err
+	}
+	if mode == echclient.ECHFallbackGREASE {
+		raw, err := echclient.GenerateGREASEECHConfigList(host)
+		return raw, mode, err
+	}
+	return nil, mode, nil
+}
+
+func (t *Transport) dialTLS(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	// The HTTPS RR of origins on other ports than 443 is published at
+	// _port._https.host.
+	qname := echclient.HTTPSQueryName(&url.URL{Scheme: "https", Host: addr})
+	var mode echclient.ECHFallback
+	raw, err := t.echConfigList(ctx, qname)
+	if err != nil {
+		raw, mode, err = t.fallback(host, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var config *tls.Config
+	if t.TLSClientConfig != nil {
+		config = t.TLSClientConfig.Clone()
+	} else {
+		config = &tls.Config{}
+	}
+	if config.ServerName == "" {
+		config.ServerName = host
+	}
+	if config.NextProtos == nil {
+		config.NextProtos = []string{"h2", "http/1.1"}
+	}
+	config.EncryptedClientHelloConfigList = raw
+
+	conn, err := t.handshake(ctx, network, addr, config)
+	var rejection *tls.ECHRejectionError
+	if mode == echclient.ECHFallbackGREASE && errors.As(err, &rejection) {
+		config.EncryptedClientHelloConfigList = rejection.RetryConfigList
+		return t.handshake(ctx, network, addr, config)
+	}
+	return conn, err
+}
+
+// handshake dials addr and performs a TLS handshake with config, within the
+// TLSHandshakeTimeout of Base.
+func (t *Transport) handshake(ctx context.Context, network, addr string, config *tls.Config) (net.Conn, error) {
+	dial := t.dial
+	if t.ProbeConfig != nil && t.ProbeConfig.ResolveAddrs {
+		dial = t.ProbeConfig.DialContext
+	}
+	conn, err := dial(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if t.rt.TLSHandshakeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.rt.TLSHandshakeTimeout)
+		defer cancel()
+	}
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
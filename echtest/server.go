@@ -0,0 +1,351 @@
+// Package echtest provides an in-process DNS-over-HTTPS server answering
+// with canned records, so that code built on echclient can be tested without
+// network access.
+package echtest
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hellais/ech/echclient"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DefaultTTL is the TTL of the records added by the Add helpers.
+const DefaultTTL = 300
+
+// Server is a DoH server over TLS answering both the application/dns-json
+// API and RFC 8484 wire-format GET and POST requests from the records added
+// to it. CNAMEs are followed within the server's data, names without any
+// record are answered with NXDOMAIN and names with records of other types
+// only with an empty NOERROR answer. JSON answers can be truncated with
+// SetTruncated.
+type Server struct {
+	// URL is the DoH endpoint of the server.
+	URL string
+
+	srv *httptest.Server
+
+	mu        sync.Mutex
+	records   map[rrKey][]record
+	rcodes    map[rrKey]dnsmessage.RCode
+	truncated map[rrKey]bool
+	names     map[string]bool
+	queries   int
+}
+
+type rrKey struct {
+	name  string
+	qtype echclient.RRType
+}
+
+type record struct {
+	ttl   uint32
+	rdata []byte
+}
+
+// NewServer starts a Server. It must be closed with Close.
+func NewServer() *Server {
+	s := &Server{
+		records:   map[rrKey][]record{},
+		rcodes:    map[rrKey]dnsmessage.RCode{},
+		truncated: map[rrKey]bool{},
+		names:     map[string]bool{},
+	}
+	s.srv = httptest.NewTLSServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.srv.URL + "/dns-query"
+	return s
+}
+
+// Close shuts the server down.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// Client returns an HTTP client trusting the certificate of the server.
+func (s *Server) Client() *http.Client {
+	return s.srv.Client()
+}
+
+// Resolver returns a DoHResolver querying the server with method, to be
+// used as echclient.ProbeConfig.Resolver.
+func (s *Server) Resolver(method echclient.DoHMethod) *echclient.DoHResolver {
+	return &echclient.DoHResolver{
+		URL:    s.URL,
+		Method: method,
+		Client: s.Client(),
+	}
+}
+
+// Queries returns the number of queries answered so far.
+func (s *Server) Queries() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queries
+}
+
+// Add adds a record of type qtype with the given wire-format RDATA to name.
+func (s *Server) Add(name string, qtype echclient.RRType, ttl uint32, rdata []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := rrKey{canonicalName(name), qtype}
+	s.records[key] = append(s.records[key], record{ttl: ttl, rdata: rdata})
+	s.names[key.name] = true
+}
+
+// AddAddr adds an A or AAAA record for addr to name.
+func (s *Server) AddAddr(name string, addr netip.Addr) {
+	if addr.Is4() {
+		s.Add(name, echclient.TypeA, DefaultTTL, addr.AsSlice())
+		return
+	}
+	s.Add(name, echclient.TypeAAAA, DefaultTTL, addr.AsSlice())
+}
+
+// AddCNAME makes name an alias of target.
+func (s *Server) AddCNAME(name, target string) {
+	s.Add(name, echclient.TypeCNAME, DefaultTTL, wireName(target))
+}
+
+// AddHTTPS adds an HTTPS record to name with the given SvcPriority,
+// TargetName and SvcParams, which are sorted by key.
+func (s *Server) AddHTTPS(name string, priority uint16, target string, params ...echclient.SvcParam) {
+	sorted := slices.Clone(params)
+	slices.SortStableFunc(sorted, func(a, b echclient.SvcParam) int {
+		return cmp.Compare(a.Key, b.Key)
+	})
+	record := &echclient.HttpsRecord{Priority: priority, TargetName: target, Params: sorted}
+	rdata, err := record.Marshal()
+	if err != nil {
+		panic("echtest: " + err.Error())
+	}
+	s.Add(name, echclient.TypeHTTPS, DefaultTTL, rdata)
+}
+
+// AddECH adds a ServiceMode HTTPS record publishing echConfigList to name.
+func (s *Server) AddECH(name string, echConfigList []byte) {
+	s.AddHTTPS(name, 1, ".", echclient.SvcParam{Key: echclient.SvcParamECH, Value: echConfigList})
+}
+
+// SetRCode makes the server answer queries for name and qtype with rcode,
+// for instance dnsmessage.RCodeServerFailure.
+func (s *Server) SetRCode(name string, qtype echclient.RRType, rcode dnsmessage.RCode) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rcodes[rrKey{canonicalName(name), qtype}] = rcode
+}
+
+// SetTruncated makes the server answer JSON queries for name and qtype with
+// the TC bit set and no records, as public JSON APIs relay truncated UDP
+// answers from upstream. Wire-format queries still get the full answer.
+func (s *Server) SetTruncated(name string, qtype echclient.RRType) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.truncated[rrKey{canonicalName(name), qtype}] = true
+}
+
+// maxCNAMEHops bounds the CNAME chains followed in answers.
+const maxCNAMEHops = 8
+
+// answer returns the RCODE and answer section for name and qtype.
+func (s *Server) answer(name string, qtype echclient.RRType) (dnsmessage.RCode, []dnsmessage.Resource) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queries++
+	name = canonicalName(name)
+	if rcode, ok := s.rcodes[rrKey{name, qtype}]; ok {
+		return rcode, nil
+	}
+	if !s.names[name] {
+		return dnsmessage.RCodeNameError, nil
+	}
+	var answers []dnsmessage.Resource
+	for range maxCNAMEHops {
+		rrs, ok := s.records[rrKey{name, qtype}]
+		if !ok && qtype != echclient.TypeCNAME {
+			if cname, ok := s.records[rrKey{name, echclient.TypeCNAME}]; ok {
+				answers = append(answers, resource(name, echclient.TypeCNAME, cname[0]))
+				name = canonicalName(readName(cname[0].rdata))
+				if !s.names[name] {
+					return dnsmessage.RCodeNameError, answers
+				}
+				continue
+			}
+		}
+		for _, rr := range rrs {
+			answers = append(answers, resource(name, qtype, rr))
+		}
+		break
+	}
+	return dnsmessage.RCodeSuccess, answers
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	var msg []byte
+	switch {
+	case req.Method == http.MethodPost:
+		var err error
+		if msg, err = io.ReadAll(req.Body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case req.URL.Query().Has("dns"):
+		var err error
+		if msg, err = base64.RawURLEncoding.DecodeString(req.URL.Query().Get("dns")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		s.serveJSON(w, req)
+		return
+	}
+	resp, err := s.serveWire(msg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/dns-message")
+	w.Write(resp)
+}
+
+func (s *Server) serveJSON(w http.ResponseWriter, req *http.Request) {
+	name := req.URL.Query().Get("name")
+	qtype, err := echclient.ParseRRType(req.URL.Query().Get("type"))
+	if err != nil {
+		// The type may also be given as a number.
+		n, nerr := strconv.ParseUint(req.URL.Query().Get("type"), 10, 16)
+		if nerr != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		qtype = echclient.RRType(n)
+	}
+	rcode, answers := s.answer(name, qtype)
+	resp := echclient.DNSResponse{
+		Status:   int(rcode),
+		RD:       true,
+		RA:       true,
+		Question: []echclient.DNSQuestion{{Name: canonicalName(name), Type: int(qtype)}},
+	}
+	s.mu.Lock()
+	resp.TC = s.truncated[rrKey{canonicalName(name), qtype}]
+	s.mu.Unlock()
+	if resp.TC {
+		answers = nil
+	}
+	for _, rr := range answers {
+		resp.Answer = append(resp.Answer, jsonAnswer(rr))
+	}
+	w.Header().Set("Content-Type", "application/dns-json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) serveWire(msg []byte) ([]byte, error) {
+	var p dnsmessage.Parser
+	hdr, err := p.Start(msg)
+	if err != nil {
+		return nil, err
+	}
+	q, err := p.Question()
+	if err != nil {
+		return nil, err
+	}
+	rcode, answers := s.answer(q.Name.String(), echclient.RRType(q.Type))
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{
+		ID:                 hdr.ID,
+		Response:           true,
+		RecursionDesired:   hdr.RecursionDesired,
+		RecursionAvailable: true,
+		RCode:              rcode,
+	})
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(q); err != nil {
+		return nil, err
+	}
+	if err := b.StartAnswers(); err != nil {
+		return nil, err
+	}
+	for _, rr := range answers {
+		if err := b.UnknownResource(rr.Header, *rr.Body.(*dnsmessage.UnknownResource)); err != nil {
+			return nil, err
+		}
+	}
+	return b.Finish()
+}
+
+func resource(name string, qtype echclient.RRType, rr record) dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{
+			Name:  dnsmessage.MustNewName(name),
+			Type:  dnsmessage.Type(qtype),
+			Class: dnsmessage.ClassINET,
+			TTL:   rr.ttl,
+		},
+		Body: &dnsmessage.UnknownResource{Type: dnsmessage.Type(qtype), Data: rr.rdata},
+	}
+}
+
+// jsonAnswer renders rr the way public DoH JSON APIs do: A, AAAA and CNAME
+// data in presentation format and other types in the RFC 3597 generic form.
+func jsonAnswer(rr dnsmessage.Resource) echclient.DNSAnswer {
+	data := rr.Body.(*dnsmessage.UnknownResource).Data
+	answer := echclient.DNSAnswer{
+		Name: rr.Header.Name.String(),
+		Type: int(rr.Header.Type),
+		TTL:  int(rr.Header.TTL),
+	}
+	switch echclient.RRType(rr.Header.Type) {
+	case echclient.TypeA, echclient.TypeAAAA:
+		addr, _ := netip.AddrFromSlice(data)
+		answer.Data = addr.String()
+	case echclient.TypeCNAME:
+		answer.Data = readName(data)
+	default:
+		answer.Data = fmt.Sprintf(`\# %d %s`, len(data), hex.EncodeToString(data))
+	}
+	return answer
+}
+
+// wireName encodes name as uncompressed wire-format labels.
+func wireName(name string) []byte {
+	var b []byte
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" {
+			continue
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+// readName decodes an uncompressed wire-format name.
+func readName(b []byte) string {
+	var labels []string
+	for len(b) > 0 && b[0] != 0 && int(b[0]) < len(b) {
+		labels = append(labels, string(b[1:1+b[0]]))
+		b = b[1+b[0]:]
+	}
+	return strings.Join(labels, ".") + "."
+}
+
+func canonicalName(name string) string {
+	name = strings.ToLower(name)
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	return name
+}
@@ -0,0 +1,185 @@
+package echtest
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"slices"
+	"testing"
+
+	"github.com/hellais/ech/echclient"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+var methods = []echclient.DoHMethod{echclient.DoHJSON, echclient.DoHGet, echclient.DoHPost}
+
+func testConfigList(t *testing.T) []byte {
+	t.Helper()
+	key, err := echclient.GenerateECHKey(1, echclient.X25519, "public.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := echclient.ECHConfigList{key.Config}.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return list
+}
+
+func TestFetchECHConfigList(t *testing.T) {
+	list := testConfigList(t)
+	s := NewServer()
+	defer s.Close()
+	s.AddECH("example.com", list)
+	s.AddCNAME("www.example.com", "cdn.example.net")
+	s.AddCNAME("cdn.example.net", "edge.example.net")
+	s.AddECH("edge.example.net", list)
+	s.AddHTTPS("alias.example.com", 0, "svc.example.net")
+	s.AddHTTPS("svc.example.net", 0, "backend.example.net")
+	s.AddECH("backend.example.net", list)
+	s.AddAddr("noech.example.com", netip.MustParseAddr("192.0.2.1"))
+
+	tests := []struct {
+		host       string
+		owner      string
+		cnameChain []string
+		aliasChain []string
+		err        error
+	}{
+		{host: "example.com", owner: "example.com."},
+		{host: "WWW.Example.COM", owner: "edge.example.net.", cnameChain: []string{"cdn.example.net.", "edge.example.net."}},
+		{host: "alias.example.com", owner: "backend.example.net.", aliasChain: []string{"svc.example.net.", "backend.example.net."}},
+		{host: "noech.example.com", err: echclient.ErrNoHTTPSRecord},
+		{host: "missing.example.com", err: echclient.ErrNXDomain},
+	}
+	for _, method := range methods {
+		c := &echclient.ProbeConfig{Resolver: s.Resolver(method)}
+		for _, tt := range tests {
+			ech, err := c.FetchECHConfigList(context.Background(), tt.host)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Errorf("%s: FetchECHConfigList(%q) error = %v, want %v", method, tt.host, err, tt.err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("%s: FetchECHConfigList(%q) error = %v", method, tt.host, err)
+				continue
+			}
+			if !bytes.Equal(ech.Raw, list) {
+				t.Errorf("%s: FetchECHConfigList(%q) = %x, want %x", method, tt.host, ech.Raw, list)
+			}
+			if ech.Owner != tt.owner || !slices.Equal(ech.CNAMEChain, tt.cnameChain) || !slices.Equal(ech.AliasChain, tt.aliasChain) {
+				t.Errorf("%s: FetchECHConfigList(%q) owner %q, CNAMEs %q, aliases %q, want %q, %q, %q", method, tt.host,
+					ech.Owner, ech.CNAMEChain, ech.AliasChain, tt.owner, tt.cnameChain, tt.aliasChain)
+			}
+		}
+	}
+}
+
+func TestFetchECHConfigListLoops(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.AddCNAME("a.example.com", "b.example.com")
+	s.AddCNAME("b.example.com", "a.example.com")
+	s.AddHTTPS("c.example.com", 0, "d.example.com")
+	s.AddHTTPS("d.example.com", 0, "c.example.com")
+
+	c := &echclient.ProbeConfig{Resolver: s.Resolver(echclient.DoHJSON)}
+	if _, err := c.FetchECHConfigList(context.Background(), "a.example.com"); !errors.Is(err, echclient.ErrCNAMEChain) {
+		t.Errorf("FetchECHConfigList(CNAME loop) error = %v, want ErrCNAMEChain", err)
+	}
+	if _, err := c.FetchECHConfigList(context.Background(), "c.example.com"); !errors.Is(err, echclient.ErrAliasChain) {
+		t.Errorf("FetchECHConfigList(AliasMode loop) error = %v, want ErrAliasChain", err)
+	}
+}
+
+func TestTruncatedRetry(t *testing.T) {
+	list := testConfigList(t)
+	s := NewServer()
+	defer s.Close()
+	s.AddECH("example.com", list)
+	s.SetTruncated("example.com", echclient.TypeHTTPS)
+
+	c := &echclient.ProbeConfig{Resolver: s.Resolver(echclient.DoHJSON)}
+	ech, err := c.FetchECHConfigList(context.Background(), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ech.Truncated || !bytes.Equal(ech.Raw, list) {
+		t.Errorf("FetchECHConfigList() truncated = %v, config %x, want the full answer retried with POST", ech.Truncated, ech.Raw)
+	}
+	if got := s.Queries(); got != 2 {
+		t.Errorf("server answered %d queries, want 2", got)
+	}
+}
+
+func TestProbeURLFallback(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+	u, err := url.Parse(backend.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(backend.Certificate())
+	s := NewServer()
+	defer s.Close()
+	// The HTTPS query name of a URL on another port than 443 is prefixed
+	// with it.
+	servfail := "https://servfail.example.com:" + u.Port() + "/"
+	su, err := url.Parse(servfail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetRCode(echclient.HTTPSQueryName(su), echclient.TypeHTTPS, dnsmessage.RCodeServerFailure)
+
+	tests := []struct {
+		host       string
+		fallback   echclient.ECHFallback
+		wantStatus string
+		wantErr    error
+	}{
+		{"missing.example.com", echclient.ECHFallbackNone, "NXDOMAIN", echclient.ErrNXDomain},
+		{"missing.example.com", echclient.ECHFallbackPlain, "NXDOMAIN", nil},
+		{"missing.example.com", echclient.ECHFallbackGREASE, "NXDOMAIN", nil},
+		{"servfail.example.com", echclient.ECHFallbackNone, "SERVFAIL", echclient.ErrServFail},
+		{"servfail.example.com", echclient.ECHFallbackPlain, "SERVFAIL", nil},
+	}
+	for _, tt := range tests {
+		c := &echclient.ProbeConfig{
+			Resolver:    s.Resolver(echclient.DoHJSON),
+			ECHFallback: tt.fallback,
+			ConnectIP:   netip.MustParseAddr(u.Hostname()),
+			TLSConfig:   &tls.Config{RootCAs: roots},
+		}
+		r, err := c.ProbeURL(context.Background(), "https://"+tt.host+":"+u.Port()+"/")
+		if r.DNSStatus != tt.wantStatus {
+			t.Errorf("%s with fallback %s: DNSStatus = %q, want %q", tt.host, tt.fallback, r.DNSStatus, tt.wantStatus)
+		}
+		if tt.wantErr != nil {
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("%s with fallback %s: error = %v, want %v", tt.host, tt.fallback, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s with fallback %s: error = %v", tt.host, tt.fallback, err)
+		}
+		// The backend does not support ECH, so GREASE is rejected.
+		if r.ECHRejected != (tt.fallback == echclient.ECHFallbackGREASE) {
+			t.Errorf("%s with fallback %s: ECHRejected = %v", tt.host, tt.fallback, r.ECHRejected)
+		}
+		if r.Fallback != tt.fallback {
+			t.Errorf("%s with fallback %s: Fallback = %q", tt.host, tt.fallback, r.Fallback)
+		}
+	}
+}
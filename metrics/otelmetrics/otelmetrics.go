@@ -0,0 +1,81 @@
+// Package otelmetrics implements echclient.Metrics on top of OpenTelemetry
+// instruments.
+package otelmetrics
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Metrics is an echclient.Metrics backed by OpenTelemetry instruments.
+type Metrics struct {
+	lookups     metric.Int64Counter
+	echResults  metric.Int64Counter
+	errors      metric.Int64Counter
+	dnsLatency  metric.Float64Histogram
+	tlsLatency  metric.Float64Histogram
+	httpLatency metric.Float64Histogram
+}
+
+// New creates the instruments using meter.
+func New(meter metric.Meter) (*Metrics, error) {
+	var (
+		m   Metrics
+		err error
+	)
+	if m.lookups, err = meter.Int64Counter("ech.dns.lookups",
+		metric.WithDescription("DNS queries issued, by resolver, transport, query type and outcome.")); err != nil {
+		return nil, err
+	}
+	if m.echResults, err = meter.Int64Counter("ech.handshakes",
+		metric.WithDescription("Completed probes, by whether ECH was accepted.")); err != nil {
+		return nil, err
+	}
+	if m.errors, err = meter.Int64Counter("ech.probe.errors",
+		metric.WithDescription("Failed probes, by error class.")); err != nil {
+		return nil, err
+	}
+	if m.dnsLatency, err = meter.Float64Histogram("ech.dns.lookup.duration",
+		metric.WithDescription("Latency of DNS queries, by resolver and transport."), metric.WithUnit("s")); err != nil {
+		return nil, err
+	}
+	if m.tlsLatency, err = meter.Float64Histogram("ech.tls.handshake.duration",
+		metric.WithDescription("Latency of TLS handshakes."), metric.WithUnit("s")); err != nil {
+		return nil, err
+	}
+	if m.httpLatency, err = meter.Float64Histogram("ech.http.request.duration",
+		metric.WithDescription("Latency of probe requests."), metric.WithUnit("s")); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (m *Metrics) DNSLookup(resolver, transport, qtype string, err error, rtt time.Duration) {
+	ctx := context.Background()
+	server := metric.WithAttributes(
+		attribute.String("resolver", resolver),
+		attribute.String("transport", transport))
+	m.lookups.Add(ctx, 1, server, metric.WithAttributes(
+		attribute.String("type", qtype),
+		attribute.Bool("success", err == nil)))
+	m.dnsLatency.Record(ctx, rtt.Seconds(), server)
+}
+
+func (m *Metrics) TLSHandshake(d time.Duration) {
+	m.tlsLatency.Record(context.Background(), d.Seconds())
+}
+
+func (m *Metrics) HTTPRequest(d time.Duration) {
+	m.httpLatency.Record(context.Background(), d.Seconds())
+}
+
+func (m *Metrics) ECHResult(accepted bool) {
+	m.echResults.Add(context.Background(), 1, metric.WithAttributes(attribute.Bool("accepted", accepted)))
+}
+
+func (m *Metrics) Error(class string) {
+	m.errors.Add(context.Background(), 1, metric.WithAttributes(attribute.String("class", class)))
+}
@@ -0,0 +1,76 @@
+// Package prommetrics implements echclient.Metrics on top of Prometheus
+// collectors.
+package prommetrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics is an echclient.Metrics backed by Prometheus collectors.
+type Metrics struct {
+	lookups     *prometheus.CounterVec
+	echResults  *prometheus.CounterVec
+	errors      *prometheus.CounterVec
+	dnsLatency  *prometheus.HistogramVec
+	tlsLatency  prometheus.Histogram
+	httpLatency prometheus.Histogram
+}
+
+// New creates the collectors and registers them with reg.
+func New(reg prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		lookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ech_dns_lookups_total",
+			Help: "DNS queries issued, by resolver, transport, query type and outcome.",
+		}, []string{"resolver", "transport", "type", "success"}),
+		echResults: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ech_handshakes_total",
+			Help: "Completed probes, by whether ECH was accepted.",
+		}, []string{"accepted"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ech_probe_errors_total",
+			Help: "Failed probes, by error class.",
+		}, []string{"class"}),
+		dnsLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "ech_dns_lookup_duration_seconds",
+			Help:    "Latency of DNS queries, by resolver and transport.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"resolver", "transport"}),
+		tlsLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "ech_tls_handshake_duration_seconds",
+			Help:    "Latency of TLS handshakes.",
+			Buckets: prometheus.DefBuckets,
+		}),
+		httpLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "ech_http_request_duration_seconds",
+			Help:    "Latency of probe requests.",
+			Buckets: prometheus.DefBuckets,
+		}),
+	}
+	reg.MustRegister(m.lookups, m.echResults, m.errors, m.dnsLatency, m.tlsLatency, m.httpLatency)
+	return m
+}
+
+func (m *Metrics) DNSLookup(resolver, transport, qtype string, err error, rtt time.Duration) {
+	m.lookups.WithLabelValues(resolver, transport, qtype, strconv.FormatBool(err == nil)).Inc()
+	m.dnsLatency.WithLabelValues(resolver, transport).Observe(rtt.Seconds())
+}
+
+func (m *Metrics) TLSHandshake(d time.Duration) {
+	m.tlsLatency.Observe(d.Seconds())
+}
+
+func (m *Metrics) HTTPRequest(d time.Duration) {
+	m.httpLatency.Observe(d.Seconds())
+}
+
+func (m *Metrics) ECHResult(accepted bool) {
+	m.echResults.WithLabelValues(strconv.FormatBool(accepted)).Inc()
+}
+
+func (m *Metrics) Error(class string) {
+	m.errors.WithLabelValues(class).Inc()
+}
@@ -0,0 +1,87 @@
+package sink
+
+import (
+	"encoding/csv"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/hellais/ech/echclient"
+)
+
+var csvHeader = []string{
+	"url", "resolver", "answered_by", "ech_accepted", "tls_version", "cipher_suite", "alpn",
+	"status_code", "body_length", "config_id", "public_name",
+	"dns_ms", "tls_handshake_ms", "http_ms", "total_ms",
+	"error_class", "error",
+}
+
+type csvSink struct {
+	w           io.WriteCloser
+	cw          *csv.Writer
+	wroteHeader bool
+}
+
+// NewCSVSink returns a sink writing one row per result, preceded by a header
+// unless w is a non-empty file, whose rows, appended to by Open, follow the
+// header of an earlier run.
+func NewCSVSink(w io.WriteCloser) OutputSink {
+	s := &csvSink{w: w, cw: csv.NewWriter(w)}
+	if f, ok := w.(*os.File); ok {
+		if fi, err := f.Stat(); err == nil && fi.Mode().IsRegular() && fi.Size() > 0 {
+			s.wroteHeader = true
+		}
+	}
+	return s
+}
+
+func (s *csvSink) WriteResult(r *echclient.ProbeResult) error {
+	if !s.wroteHeader {
+		if err := s.cw.Write(csvHeader); err != nil {
+			return err
+		}
+		s.wroteHeader = true
+	}
+	var configID, publicName string
+	if r.SelectedECHConfig != nil {
+		configID = strconv.Itoa(int(r.SelectedECHConfig.ConfigID))
+		publicName = r.SelectedECHConfig.PublicName
+	}
+	return s.cw.Write([]string{
+		r.URL,
+		r.Resolver,
+		r.AnsweredBy,
+		strconv.FormatBool(r.ECHAccepted),
+		r.TLSVersion,
+		r.CipherSuite,
+		r.ALPN,
+		strconv.Itoa(r.StatusCode),
+		strconv.Itoa(r.BodyLength),
+		configID,
+		publicName,
+		millis(r.Timings.DNS),
+		millis(r.Timings.TLSHandshake),
+		millis(r.Timings.HTTP),
+		millis(r.Timings.Total),
+		r.ErrorClass,
+		r.Error,
+	})
+}
+
+func millis(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+}
+
+func (s *csvSink) Flush() error {
+	s.cw.Flush()
+	return s.cw.Error()
+}
+
+func (s *csvSink) Close() error {
+	if err := s.Flush(); err != nil {
+		s.w.Close()
+		return err
+	}
+	return s.w.Close()
+}
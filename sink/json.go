@@ -0,0 +1,48 @@
+package sink
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+
+	"github.com/hellais/ech/echclient"
+)
+
+type jsonSink struct {
+	w   io.WriteCloser
+	buf *bufio.Writer
+	enc *json.Encoder
+}
+
+// NewJSONSink returns a sink writing each result as indented JSON.
+func NewJSONSink(w io.WriteCloser) OutputSink {
+	s := newJSONSink(w)
+	s.enc.SetIndent("", "  ")
+	return s
+}
+
+// NewJSONLSink returns a sink writing one JSON object per line.
+func NewJSONLSink(w io.WriteCloser) OutputSink {
+	return newJSONSink(w)
+}
+
+func newJSONSink(w io.WriteCloser) *jsonSink {
+	buf := bufio.NewWriter(w)
+	return &jsonSink{w: w, buf: buf, enc: json.NewEncoder(buf)}
+}
+
+func (s *jsonSink) WriteResult(r *echclient.ProbeResult) error {
+	return s.enc.Encode(r)
+}
+
+func (s *jsonSink) Flush() error {
+	return s.buf.Flush()
+}
+
+func (s *jsonSink) Close() error {
+	if err := s.Flush(); err != nil {
+		s.w.Close()
+		return err
+	}
+	return s.w.Close()
+}
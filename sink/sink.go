@@ -0,0 +1,61 @@
+// Package sink writes probe results to durable destinations.
+package sink
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/hellais/ech/echclient"
+)
+
+// OutputSink receives probe results.
+type OutputSink interface {
+	// WriteResult records a single result.
+	WriteResult(r *echclient.ProbeResult) error
+
+	// Flush makes sure buffered results reach the destination.
+	Flush() error
+
+	// Close flushes and releases the destination.
+	Close() error
+}
+
+// Formats lists the names accepted by Open.
+var Formats = []string{"json", "jsonl", "csv", "sqlite"}
+
+// Open returns a sink writing results in format to path. An empty path or
+// "-" selects stdout, which is not supported for sqlite.
+func Open(format, path string) (OutputSink, error) {
+	if format == "sqlite" {
+		if path == "" || path == "-" {
+			return nil, fmt.Errorf("sqlite output requires an output file")
+		}
+		return NewSQLiteSink(path)
+	}
+
+	var w io.WriteCloser = nopCloser{os.Stdout}
+	if path != "" && path != "-" {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		w = f
+	}
+	switch format {
+	case "json":
+		return NewJSONSink(w), nil
+	case "jsonl":
+		return NewJSONLSink(w), nil
+	case "csv":
+		return NewCSVSink(w), nil
+	}
+	w.Close()
+	return nil, fmt.Errorf("unknown output format: %s", format)
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
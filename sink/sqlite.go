@@ -0,0 +1,65 @@
+package sink
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/hellais/ech/echclient"
+	_ "modernc.org/sqlite"
+)
+
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS results (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	measured_at TEXT NOT NULL,
+	url TEXT NOT NULL,
+	resolver TEXT,
+	ech_accepted INTEGER NOT NULL,
+	tls_version TEXT,
+	error_class TEXT,
+	error TEXT,
+	total_ms REAL,
+	result TEXT NOT NULL
+)`
+
+type sqliteSink struct {
+	db *sql.DB
+}
+
+// NewSQLiteSink returns a sink inserting results into the results table of
+// the SQLite database at path, creating it if needed. The full result is
+// stored as JSON alongside a few columns useful for querying.
+func NewSQLiteSink(path string) (OutputSink, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &sqliteSink{db: db}, nil
+}
+
+func (s *sqliteSink) WriteResult(r *echclient.ProbeResult) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`INSERT INTO results
+		(measured_at, url, resolver, ech_accepted, tls_version, error_class, error, total_ms, result)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		time.Now().UTC().Format(time.RFC3339Nano),
+		r.URL, r.Resolver, r.ECHAccepted, r.TLSVersion, r.ErrorClass, r.Error,
+		float64(r.Timings.Total)/float64(time.Millisecond),
+		string(data))
+	return err
+}
+
+func (s *sqliteSink) Flush() error {
+	return nil
+}
+
+func (s *sqliteSink) Close() error {
+	return s.db.Close()
+}